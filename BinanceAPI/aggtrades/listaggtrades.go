@@ -0,0 +1,135 @@
+package aggtrades
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+const (
+	ListAggTradesMaxLimit uint32 = 1000
+
+	// The API rejects a [StartTime, EndTime] window longer than one hour.
+	ListAggTradesMaxWindow = time.Hour
+)
+
+// AggTrade is the aggregation of trades filled at the same time, price and side.
+type AggTrade struct {
+	ID           int64
+	Price        float64
+	Quantity     float64
+	FirstTradeID int64
+	LastTradeID  int64
+	Time         time.Time
+	BuyerMaker   bool // True when the seller was the aggressor.
+}
+
+// ListAggTrades API will return the aggregated trades of the specified symbol in
+// chronological order within [StartTime, EndTime] inclusively, which must not span
+// more than one hour.
+//
+// If limit exceeds 1000 or if limit = 0, then the API returns the first 1000 trades.
+type ListAggTradesParam struct {
+	Symbol    string
+	StartTime time.Time
+	EndTime   time.Time
+	Limit     uint32
+}
+
+func ListAggTrades(ctx context.Context, param ListAggTradesParam) ([]AggTrade, error) {
+	if param.StartTime.After(param.EndTime) {
+		return nil, nil
+	}
+	if param.EndTime.Sub(param.StartTime) > ListAggTradesMaxWindow {
+		return nil, fmt.Errorf("window %v ~ %v longer than %v", param.StartTime, param.EndTime, ListAggTradesMaxWindow)
+	}
+	if param.Limit == 0 || param.Limit >= ListAggTradesMaxLimit {
+		param.Limit = ListAggTradesMaxLimit
+	}
+	return listAggTradesAPI(ctx, &param)
+}
+
+func listAggTradesAPI(ctx context.Context, param *ListAggTradesParam) ([]AggTrade, error) {
+	// Prepare request.
+	const apiURL = common.RootAPIEndPoint + "/fapi/v1/aggTrades"
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+	query := url.Values{}
+	query.Add("symbol", param.Symbol)
+	query.Add("startTime", strconv.FormatInt(param.StartTime.UnixMilli(), 10))
+	query.Add("endTime", strconv.FormatInt(param.EndTime.UnixMilli(), 10))
+	query.Add("limit", strconv.FormatUint(uint64(param.Limit), 10))
+	req.URL.RawQuery = query.Encode()
+
+	// Execute HTTP request.
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get query %q: %w", req.URL.RawQuery, err)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("http status code(%d) status(%q)", rsp.StatusCode, rsp.Status)
+		}
+		return nil, fmt.Errorf("http status code(%d) status(%q) body(%q)", rsp.StatusCode, rsp.Status, body)
+	}
+
+	return parseListAggTradesRsp(rsp.Body)
+}
+
+type aggTradeEntry struct {
+	ID           int64  `json:"a"`
+	Price        string `json:"p"`
+	Quantity     string `json:"q"`
+	FirstTradeID int64  `json:"f"`
+	LastTradeID  int64  `json:"l"`
+	Time         int64  `json:"T"`
+	BuyerMaker   bool   `json:"m"`
+}
+
+func parseListAggTradesRsp(body io.Reader) ([]AggTrade, error) {
+	var entries []aggTradeEntry
+	if err := json.NewDecoder(body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("json decoder decode: %w", err)
+	}
+
+	trades := make([]AggTrade, len(entries))
+	for idx, entry := range entries {
+		price, err := common.ParseFloat64FromAnyString(entry.Price)
+		if err != nil {
+			return nil, fmt.Errorf("parse price field (entry: %+v): %w", entry, err)
+		}
+		qty, err := common.ParseFloat64FromAnyString(entry.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("parse quantity field (entry: %+v): %w", entry, err)
+		}
+		trades[idx] = AggTrade{
+			ID:           entry.ID,
+			Price:        price,
+			Quantity:     qty,
+			FirstTradeID: entry.FirstTradeID,
+			LastTradeID:  entry.LastTradeID,
+			Time:         time.UnixMilli(entry.Time),
+			BuyerMaker:   entry.BuyerMaker,
+		}
+	}
+
+	// Sort by aggregate trade ID, which is also chronological.
+	slices.SortFunc(trades, func(a, b AggTrade) int {
+		return int(a.ID - b.ID)
+	})
+	return trades, nil
+}
@@ -0,0 +1,154 @@
+// Package archive imports the ZIP files of the Binance public data archive,
+// https://data.binance.vision, which hold a day or a month of KLines, aggregated
+// trades or funding rates in a CSV each, next to a CHECKSUM file. Fetching them
+// is far cheaper than paging the REST API; this package reads them from a local
+// directory.
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+type Kind string
+
+const (
+	Kind_KLINES       Kind = "klines"
+	Kind_AGG_TRADES   Kind = "aggTrades"
+	Kind_FUNDING_RATE Kind = "fundingRate"
+)
+
+// priceKLineDirs hold archives named like the KLines ones but of other prices.
+var priceKLineDirs = []string{"markPriceKlines", "indexPriceKlines", "premiumIndexKlines"}
+
+// coinFuturesDir holds the coin-margined futures, under the "futures" folder,
+// which are not traded here.
+const coinFuturesDir = "cm"
+
+var (
+	ErrUnknownFile      = errors.New("not an archive file")
+	ErrNoChecksum       = errors.New("no checksum file")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// File is an archive file, named like "BTCUSDT-1h-2024-01.zip",
+// "BTCUSDT-aggTrades-2024-01-15.zip" or "BTCUSDT-fundingRate-2024-01.zip".
+type File struct {
+	Path     string
+	Market   common.MarketType // From the folders, "spot" or "futures/um"; empty when none says.
+	Symbol   string
+	Kind     Kind
+	Interval common.ListKLinesInterval // For KLines only.
+	Period   string                    // The month, 2024-01, or the day, 2024-01-15.
+}
+
+// ParseName parses the name of the archive file at path, and its market from the
+// folders of the archive's layout the path goes through.
+func ParseName(path string) (File, error) {
+	name, ok := strings.CutSuffix(filepath.Base(path), ".zip")
+	parts := strings.SplitN(name, "-", 3)
+	if !ok || len(parts) != 3 || parts[0] == "" {
+		return File{}, fmt.Errorf("%q: %w", path, ErrUnknownFile)
+	}
+	f := File{Path: path, Market: parseMarket(path), Symbol: parts[0], Period: parts[2]}
+	switch Kind(parts[1]) {
+	case Kind_AGG_TRADES, Kind_FUNDING_RATE:
+		f.Kind = Kind(parts[1])
+	default:
+		interval, err := common.ParseInterval(parts[1])
+		if err != nil {
+			return File{}, fmt.Errorf("%q: %w", path, ErrUnknownFile)
+		}
+		f.Kind, f.Interval = Kind_KLINES, interval
+	}
+	return f, nil
+}
+
+// parseMarket returns the market of the innermost market folder of path, like
+// "spot" or "futures/um".
+func parseMarket(path string) common.MarketType {
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(path)), "/")
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		switch {
+		case dirs[idx] == string(common.MarketType_SPOT):
+			return common.MarketType_SPOT
+		case dirs[idx] == "futures" && idx+1 < len(dirs) && dirs[idx+1] == "um":
+			return common.MarketType_FUTURES
+		}
+	}
+	return ""
+}
+
+// Scan returns the archive files under dir, by market, symbol, kind, interval and
+// period. Other files, like the CHECKSUM ones or the archives of other kinds,
+// are left out, as are the coin-margined futures and the mark, index and premium
+// index price KLines, which can only be told apart by their folder.
+func Scan(dir string) ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if slices.Contains(priceKLineDirs, d.Name()) ||
+				(d.Name() == coinFuturesDir && filepath.Base(filepath.Dir(path)) == "futures") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f, err := ParseName(path); err == nil {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %q: %w", dir, err)
+	}
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(
+			strings.Join([]string{string(a.Market), a.Symbol, string(a.Kind), string(a.Interval), a.Period}, "\x00"),
+			strings.Join([]string{string(b.Market), b.Symbol, string(b.Kind), string(b.Interval), b.Period}, "\x00"))
+	})
+	return files, nil
+}
+
+// VerifyChecksum checks the file against the SHA256 in its path+".CHECKSUM"
+// file, which reads like "<hex digest>  BTCUSDT-1h-2024-01.zip".
+func VerifyChecksum(path string) error {
+	b, err := os.ReadFile(path + ".CHECKSUM")
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%q: %w", path, ErrNoChecksum)
+	}
+	if err != nil {
+		return fmt.Errorf("read checksum: %w", err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum file of %q", path)
+	}
+	want := strings.ToLower(fields[0])
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return fmt.Errorf("hash %q: %w", path, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%q has SHA256 %s, want %s: %w", path, got, want, ErrChecksumMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+module github.com/Makoto2024/BinanceTrader/BinanceAPI/archive
+
+go 1.23.4
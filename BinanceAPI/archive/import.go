@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/funding"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+// Options configures Import.
+type Options struct {
+	// AllowMissingChecksum imports files with no CHECKSUM file. Files failing
+	// their checksum are never imported.
+	AllowMissingChecksum bool
+	// Market of the files to import, futures when empty. Files under the folders
+	// of another market are skipped, as the store keeps one market; files under
+	// none are taken to be of it.
+	Market  common.MarketType
+	Symbols []string // Symbols to import, all when nil.
+}
+
+// FileResult is what became of an archive file.
+type FileResult struct {
+	File
+	Rows int
+	Err  error // Why the file was skipped.
+}
+
+// Report counts the rows Import stored.
+type Report struct {
+	Files        []FileResult
+	KLines       int // Added, or replacing synthetic ones.
+	AggTrades    int
+	FundingRates int
+}
+
+// fileRows is the rows of an archive file.
+type fileRows struct {
+	bars   []klines.KLine
+	trades []aggtrades.AggTrade
+	rates  []funding.FundingRate
+}
+
+// Import verifies and reads the archive files under dir and merges them into
+// store, the aggregated trades and funding rates going to the CSVs under its
+// root. Each file is merged once read, so only one is held in memory. Rows
+// already stored, e.g. downloaded from the REST API, are kept, except synthetic
+// KLines, so overlapping archives can be imported again. A file failing its
+// checksum or parsing is skipped and reported; only store errors stop the import.
+func Import(dir string, store storage.FileStore, opts Options) (*Report, error) {
+	files, err := Scan(dir)
+	if err != nil {
+		return nil, err
+	}
+	market := opts.Market
+	if market == "" {
+		market = common.MarketType_FUTURES
+	}
+	rep := &Report{}
+	var filled []storage.Series // Series whose gaps may have been filled.
+	for _, f := range files {
+		if opts.Symbols != nil && !slices.Contains(opts.Symbols, f.Symbol) {
+			continue
+		}
+		if f.Market != "" && f.Market != market {
+			continue
+		}
+		res := FileResult{File: f}
+		var rows fileRows
+		res.Rows, res.Err = rows.read(f, opts)
+		rep.Files = append(rep.Files, res)
+		if res.Err != nil {
+			continue
+		}
+		added, err := rows.merge(store, f, rep)
+		if err != nil {
+			return rep, err
+		}
+		if s := (storage.Series{Symbol: f.Symbol, Interval: f.Interval}); added > 0 && f.Kind == Kind_KLINES &&
+			(len(filled) == 0 || filled[len(filled)-1] != s) {
+			filled = append(filled, s)
+		}
+	}
+	for _, s := range filled {
+		all, err := store.Range(s.Symbol, s.Interval, time.Time{}, time.Time{})
+		if err != nil {
+			return rep, fmt.Errorf("Range(%s %s): %w", s.Symbol, s.Interval, err)
+		}
+		if err := storage.WriteKLineGaps(store.Root(), s.Symbol, s.Interval, storage.FindKLineGaps(all)); err != nil {
+			return rep, fmt.Errorf("WriteKLineGaps(%s %s): %w", s.Symbol, s.Interval, err)
+		}
+	}
+	return rep, nil
+}
+
+// read verifies f and reads its rows, returning how many it has.
+func (r *fileRows) read(f File, opts Options) (int, error) {
+	if err := VerifyChecksum(f.Path); err != nil && !(opts.AllowMissingChecksum && errors.Is(err, ErrNoChecksum)) {
+		return 0, err
+	}
+	var err error
+	switch f.Kind {
+	case Kind_KLINES:
+		if r.bars, err = ReadKLines(f.Path); err != nil {
+			return 0, fmt.Errorf("ReadKLines: %w", err)
+		}
+		return len(r.bars), nil
+	case Kind_AGG_TRADES:
+		if r.trades, err = ReadAggTrades(f.Path); err != nil {
+			return 0, fmt.Errorf("ReadAggTrades: %w", err)
+		}
+		return len(r.trades), nil
+	case Kind_FUNDING_RATE:
+		if r.rates, err = ReadFundingRates(f.Path, f.Symbol); err != nil {
+			return 0, fmt.Errorf("ReadFundingRates: %w", err)
+		}
+		return len(r.rates), nil
+	}
+	return 0, fmt.Errorf("%q: %w", f.Path, ErrUnknownFile)
+}
+
+// merge stores the rows of f into store and returns how many were added.
+func (r *fileRows) merge(store storage.FileStore, f File, rep *Report) (int, error) {
+	switch f.Kind {
+	case Kind_KLINES:
+		slices.SortStableFunc(r.bars, func(a, b klines.KLine) int {
+			return a.OpenTime.Compare(b.OpenTime)
+		})
+		r.bars = slices.CompactFunc(r.bars, func(a, b klines.KLine) bool {
+			return a.OpenTime.Equal(b.OpenTime)
+		})
+		added, err := storage.Merge(store, f.Symbol, f.Interval, r.bars)
+		if err != nil {
+			return 0, fmt.Errorf("Merge(%s %s): %w", f.Symbol, f.Interval, err)
+		}
+		rep.KLines += added
+		return added, nil
+	case Kind_AGG_TRADES:
+		added, err := storage.MergeAggTradesCSV(store.Root(), f.Symbol, r.trades)
+		if err != nil {
+			return 0, fmt.Errorf("MergeAggTradesCSV(%s): %w", f.Symbol, err)
+		}
+		rep.AggTrades += added
+		return added, nil
+	case Kind_FUNDING_RATE:
+		added, err := storage.MergeFundingRatesCSV(store.Root(), f.Symbol, r.rates)
+		if err != nil {
+			return 0, fmt.Errorf("MergeFundingRatesCSV(%s): %w", f.Symbol, err)
+		}
+		rep.FundingRates += added
+		return added, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,296 @@
+package archive
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+// t0 is when the hourly bars of the tests start, 2024-01-01.
+const t0 = 1704067200000
+
+type checksum int
+
+const (
+	checksum_OK checksum = iota
+	checksum_BAD
+	checksum_NONE
+)
+
+// testFile is an archive file to generate.
+type testFile struct {
+	name     string // Under the archive's folder, e.g. "spot/monthly/klines/BTCUSDT/1h/BTCUSDT-1h-2024-01.zip".
+	lines    []string
+	checksum checksum
+}
+
+// writeArchive writes f as a ZIP holding a CSV, with its CHECKSUM file.
+func writeArchive(t *testing.T, dir string, f testFile) {
+	path := filepath.Join(dir, f.name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(fp)
+	w, err := zw.Create(strings.TrimSuffix(filepath.Base(f.name), ".zip") + ".csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(strings.Join(f.lines, "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.checksum == checksum_NONE {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	if f.checksum == checksum_BAD {
+		sum[0]++
+	}
+	if err := os.WriteFile(path+".CHECKSUM", []byte(hex.EncodeToString(sum[:])+"  "+filepath.Base(f.name)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// klineLine returns the archive line of the hourly bar h hours after t0, closing
+// at close, with timestamps in microseconds if micro.
+func klineLine(h int, close float64, micro bool) string {
+	open, end := int64(t0+h*3600000), int64(t0+(h+1)*3600000-1)
+	if micro {
+		open, end = open*1000, end*1000+999
+	}
+	return fmt.Sprintf("%d,100,110,90,%v,5,%d,500,7,2,200,0", open, close, end)
+}
+
+func klineLines(from, to int, close float64, micro bool) []string {
+	var res []string
+	for h := from; h < to; h++ {
+		res = append(res, klineLine(h, close, micro))
+	}
+	return res
+}
+
+// testBar is the stored bar h hours after t0.
+func testBar(h int, close float64, synthetic bool) klines.KLine {
+	open := time.UnixMilli(t0 + int64(h)*3600000)
+	return klines.KLine{
+		OpenTime: open, CloseTime: open.Add(time.Hour - time.Millisecond),
+		OpenPrice: 100, HighPrice: 110, LowPrice: 90, ClosePrice: close, Volume: 5, Synthetic: synthetic,
+	}
+}
+
+const klineHeader = "open_time,open,high,low,close,volume,close_time,quote_volume,count,taker_buy_volume,taker_buy_quote_volume,ignore"
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []testFile
+		stored     []klines.KLine
+		opts       Options
+		wantErrs   []error // Of the files, in Scan order.
+		wantReport Report  // Files left out.
+		wantBars   []string
+		wantTrades []int64
+	}{
+		{
+			name:     "checksum mismatch",
+			files:    []testFile{{"BTCUSDT-1h-2024-01.zip", klineLines(0, 3, 101, false), checksum_BAD}},
+			wantErrs: []error{ErrChecksumMismatch},
+		},
+		{
+			name:     "missing checksum",
+			files:    []testFile{{"BTCUSDT-1h-2024-01.zip", klineLines(0, 3, 101, false), checksum_NONE}},
+			wantErrs: []error{ErrNoChecksum},
+		},
+		{
+			name:       "missing checksum allowed",
+			files:      []testFile{{"BTCUSDT-1h-2024-01.zip", klineLines(0, 3, 101, false), checksum_NONE}},
+			opts:       Options{AllowMissingChecksum: true},
+			wantErrs:   []error{nil},
+			wantReport: Report{KLines: 3},
+			wantBars:   []string{"0 101", "1 101", "2 101"},
+		},
+		{
+			name: "mismatch not allowed by missing checksum option",
+			files: []testFile{
+				{"BTCUSDT-1h-2024-01-01.zip", klineLines(0, 2, 101, false), checksum_BAD},
+				{"BTCUSDT-1h-2024-01-02.zip", klineLines(24, 25, 101, false), checksum_OK},
+			},
+			opts:       Options{AllowMissingChecksum: true},
+			wantErrs:   []error{ErrChecksumMismatch, nil},
+			wantReport: Report{KLines: 1},
+			wantBars:   []string{"24 101"},
+		},
+		{
+			name: "header",
+			files: []testFile{
+				{"BTCUSDT-1h-2024-01.zip", append([]string{klineHeader}, klineLines(0, 2, 101, false)...), checksum_OK},
+			},
+			wantErrs:   []error{nil},
+			wantReport: Report{KLines: 2},
+			wantBars:   []string{"0 101", "1 101"},
+		},
+		{
+			name:       "microseconds",
+			files:      []testFile{{"BTCUSDT-1h-2025-01.zip", klineLines(0, 2, 101, true), checksum_OK}},
+			wantErrs:   []error{nil},
+			wantReport: Report{KLines: 2},
+			wantBars:   []string{"0 101", "1 101"},
+		},
+		{
+			name: "monthly and daily overlap",
+			files: []testFile{
+				{"BTCUSDT-1h-2024-01.zip", klineLines(0, 4, 101, false), checksum_OK},
+				{"BTCUSDT-1h-2024-01-01.zip", klineLines(2, 6, 102, false), checksum_OK},
+			},
+			wantErrs:   []error{nil, nil},
+			wantReport: Report{KLines: 6},
+			wantBars:   []string{"0 101", "1 101", "2 101", "3 101", "4 102", "5 102"},
+		},
+		{
+			name:   "over stored bars",
+			stored: []klines.KLine{testBar(0, 200, false), testBar(1, 200, true), testBar(3, 200, false)},
+			files: []testFile{
+				{"BTCUSDT-1h-2024-01.zip", klineLines(0, 4, 101, false), checksum_OK},
+			},
+			wantErrs: []error{nil},
+			// The real stored bars are kept, the synthetic one replaced.
+			wantReport: Report{KLines: 2},
+			wantBars:   []string{"0 200", "1 101", "2 101", "3 200"},
+		},
+		{
+			name: "agg trades",
+			files: []testFile{
+				{"BTCUSDT-aggTrades-2024-01.zip", []string{
+					fmt.Sprintf("1,100,1,1,1,%d,true", t0),
+					fmt.Sprintf("2,101,1,2,3,%d,false", t0+1000),
+				}, checksum_OK},
+				{"BTCUSDT-aggTrades-2024-01-01.zip", []string{
+					"agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker",
+					fmt.Sprintf("2,101,1,2,3,%d,false", (t0+1000)*1000),
+					fmt.Sprintf("3,102,1,4,4,%d,true", (t0+2000)*1000),
+				}, checksum_OK},
+			},
+			wantErrs:   []error{nil, nil},
+			wantReport: Report{AggTrades: 3},
+			wantTrades: []int64{1, 2, 3},
+		},
+		{
+			name: "markets",
+			files: []testFile{
+				{"spot/monthly/klines/BTCUSDT/1h/BTCUSDT-1h-2024-01.zip", klineLines(0, 3, 50, false), checksum_OK},
+				{"futures/um/monthly/klines/BTCUSDT/1h/BTCUSDT-1h-2024-01.zip", klineLines(1, 3, 101, false), checksum_OK},
+				{"futures/cm/monthly/klines/BTCUSDT/1h/BTCUSDT-1h-2024-01.zip", klineLines(0, 3, 70, false), checksum_OK},
+			},
+			wantErrs:   []error{nil},
+			wantReport: Report{KLines: 2},
+			wantBars:   []string{"1 101", "2 101"},
+		},
+		{
+			name: "spot market",
+			files: []testFile{
+				{"data/spot/daily/klines/BTCUSDT/1h/BTCUSDT-1h-2024-01-01.zip", klineLines(0, 3, 50, false), checksum_OK},
+				{"data/futures/um/daily/klines/BTCUSDT/1h/BTCUSDT-1h-2024-01-01.zip", klineLines(1, 3, 101, false), checksum_OK},
+			},
+			opts:       Options{Market: common.MarketType_SPOT},
+			wantErrs:   []error{nil},
+			wantReport: Report{KLines: 3},
+			wantBars:   []string{"0 50", "1 50", "2 50"},
+		},
+		{
+			name: "funding rates",
+			files: []testFile{
+				{"BTCUSDT-fundingRate-2024-01.zip", []string{
+					"calc_time,funding_interval_hours,last_funding_rate",
+					fmt.Sprintf("%d,8,0.0001", t0),
+					fmt.Sprintf("%d,8,-0.0002", t0+8*3600000),
+				}, checksum_OK},
+			},
+			wantErrs:   []error{nil},
+			wantReport: Report{FundingRates: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, root := t.TempDir(), t.TempDir()
+			for _, f := range tt.files {
+				writeArchive(t, dir, f)
+			}
+			store := storage.NewCSVStore(root)
+			if tt.stored != nil {
+				if err := store.Append("BTCUSDT", common.ListKLinesInterval_1h, tt.stored); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			rep, err := Import(dir, store, tt.opts)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if len(rep.Files) != len(tt.wantErrs) {
+				t.Fatalf("%d files imported, want %d: %+v", len(rep.Files), len(tt.wantErrs), rep.Files)
+			}
+			for idx, res := range rep.Files {
+				if want := tt.wantErrs[idx]; !errors.Is(res.Err, want) || (want == nil) != (res.Err == nil) {
+					t.Errorf("file %s: error %v, want %v", filepath.Base(res.Path), res.Err, want)
+				}
+			}
+			if rep.KLines != tt.wantReport.KLines || rep.AggTrades != tt.wantReport.AggTrades ||
+				rep.FundingRates != tt.wantReport.FundingRates {
+				t.Errorf("report %+v, want %+v", rep, tt.wantReport)
+			}
+
+			bars, err := store.Range("BTCUSDT", common.ListKLinesInterval_1h, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatalf("Range: %v", err)
+			}
+			var gotBars []string
+			for _, l := range bars {
+				if l.Synthetic {
+					t.Errorf("bar at %v still synthetic", l.OpenTime)
+				}
+				gotBars = append(gotBars, fmt.Sprintf("%d %v", (l.OpenTime.UnixMilli()-t0)/3600000, l.ClosePrice))
+			}
+			if fmt.Sprint(gotBars) != fmt.Sprint(tt.wantBars) {
+				t.Errorf("stored bars %v, want %v", gotBars, tt.wantBars)
+			}
+
+			trades, err := storage.LoadAggTradesCSV(root, "BTCUSDT")
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("LoadAggTradesCSV: %v", err)
+			}
+			var gotTrades []int64
+			for _, tr := range trades {
+				if want := time.UnixMilli(t0 + (tr.ID-1)*1000); !tr.Time.Equal(want) {
+					t.Errorf("trade %d at %v, want %v", tr.ID, tr.Time, want)
+				}
+				gotTrades = append(gotTrades, tr.ID)
+			}
+			if fmt.Sprint(gotTrades) != fmt.Sprint(tt.wantTrades) {
+				t.Errorf("stored trades %v, want %v", gotTrades, tt.wantTrades)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/funding"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// readCSV calls visit with every record of the CSV in the ZIP file at path. Older
+// archives have no header line, newer ones do; it is skipped.
+func readCSV(path string, visit func(record []string) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("open zip %q: %w", path, err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 {
+		return fmt.Errorf("zip %q has %d files, want 1", path, len(zr.File))
+	}
+	fp, err := zr.File[0].Open()
+	if err != nil {
+		return fmt.Errorf("open %q in zip: %w", zr.File[0].Name, err)
+	}
+	defer fp.Close()
+
+	cr := csv.NewReader(fp)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read one CSV record: %w", err)
+		}
+		if line == 1 && len(record) > 0 {
+			if _, err := strconv.ParseInt(record[0], 10, 64); err != nil {
+				continue // Header.
+			}
+		}
+		if err := visit(record); err != nil {
+			return fmt.Errorf("line %d %v: %w", line, record, err)
+		}
+	}
+}
+
+// parseTime parses a timestamp, in milliseconds, or in microseconds as in the
+// spot archives since 2025.
+func parseTime(s string) (time.Time, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if v >= 1e15 {
+		return time.UnixMicro(v), nil
+	}
+	return time.UnixMilli(v), nil
+}
+
+// parseFields parses the columns of record in order into dst, which holds
+// *float64, *int64 and *time.Time.
+func parseFields(record []string, dst ...any) error {
+	if len(record) < len(dst) {
+		return fmt.Errorf("expect at least %d column but get %d", len(dst), len(record))
+	}
+	for idx, d := range dst {
+		var err error
+		switch d := d.(type) {
+		case *float64:
+			*d, err = strconv.ParseFloat(record[idx], 64)
+		case *int64:
+			*d, err = strconv.ParseInt(record[idx], 10, 64)
+		case *time.Time:
+			*d, err = parseTime(record[idx])
+		}
+		if err != nil {
+			return fmt.Errorf("parse column %d %q: %w", idx, record[idx], err)
+		}
+	}
+	return nil
+}
+
+// ReadKLines reads the KLines of a KLines archive, whose columns are open time,
+// open, high, low, close, volume, close time, quote asset volume, trade count,
+// then the taker buy volumes, which are left out.
+func ReadKLines(path string) ([]klines.KLine, error) {
+	var res []klines.KLine
+	err := readCSV(path, func(record []string) error {
+		var l klines.KLine
+		var tradeNum int64
+		if err := parseFields(record, &l.OpenTime, &l.OpenPrice, &l.HighPrice, &l.LowPrice, &l.ClosePrice,
+			&l.Volume, &l.CloseTime, &l.QuoteAssetVolume, &tradeNum); err != nil {
+			return err
+		}
+		l.TradeNum = float64(tradeNum)
+		res = append(res, l)
+		return nil
+	})
+	return res, err
+}
+
+// ReadAggTrades reads the trades of an aggTrades archive, whose columns are ID,
+// price, quantity, first and last trade ID, time and whether the buyer was the
+// maker. Spot archives add whether it was the best price match.
+func ReadAggTrades(path string) ([]aggtrades.AggTrade, error) {
+	var res []aggtrades.AggTrade
+	err := readCSV(path, func(record []string) error {
+		var t aggtrades.AggTrade
+		if err := parseFields(record, &t.ID, &t.Price, &t.Quantity, &t.FirstTradeID, &t.LastTradeID, &t.Time); err != nil {
+			return err
+		}
+		if len(record) < 7 {
+			return fmt.Errorf("no buyer maker column")
+		}
+		var err error
+		if t.BuyerMaker, err = strconv.ParseBool(record[6]); err != nil {
+			return fmt.Errorf("parse buyer maker column %q: %w", record[6], err)
+		}
+		res = append(res, t)
+		return nil
+	})
+	return res, err
+}
+
+// ReadFundingRates reads the rates of a fundingRate archive, whose columns are
+// funding time, funding interval in hours and rate. It has no mark price.
+func ReadFundingRates(path, symbol string) ([]funding.FundingRate, error) {
+	var res []funding.FundingRate
+	err := readCSV(path, func(record []string) error {
+		r := funding.FundingRate{Symbol: symbol}
+		var intervalHours int64
+		if err := parseFields(record, &r.FundingTime, &intervalHours, &r.FundingRate); err != nil {
+			return err
+		}
+		res = append(res, r)
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,403 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+	ErrInvalidOrder  = errors.New("invalid order")
+	ErrOrderNotFound = errors.New("order not found")
+)
+
+type SimOrder struct {
+	orders.Order
+	ID             int64
+	Status         common.OrderStatus
+	Triggered      bool // Whether the StopPrice of a STOP order has been reached.
+	CreateTime     time.Time
+	FilledQuantity float64
+}
+
+// Remaining returns the quantity still waiting to be filled.
+func (o *SimOrder) Remaining() float64 {
+	return o.Quantity - o.FilledQuantity
+}
+
+type Fill struct {
+	OrderID     int64
+	Symbol      string
+	Side        common.OrderSide
+	Time        time.Time
+	Price       float64
+	Quantity    float64
+	Fee         float64
+	Maker       bool
+	RealizedPnL float64 // PnL realized by closing part of the position, before fee.
+	Liquidation bool    // Forced close by the liquidation engine, OrderID is 0.
+}
+
+type BrokerConfig struct {
+	Symbol      string
+	InitialCash float64
+	MakerFeeBps float64        // Fee of passive fills in basis points of the notional.
+	TakerFeeBps float64        // Fee of aggressive fills in basis points of the notional.
+	Intrabar    IntrabarPath   // Only used by the default OHLC fill model.
+	Futures     *FuturesConfig // Nil for spot style accounting where trades exchange the full notional.
+
+	// NewFillModel makes the fill model of each broker built from the config, nil
+	// for OHLCFillModel with Intrabar. Models like BookFillModel keep per-order
+	// state, so it must return a new one per call.
+	NewFillModel func() FillModel
+}
+
+// SimBroker fills orders of one symbol against historical bars. Orders submitted
+// while handling a bar are matched from the next bar on, so a strategy never trades
+// on prices it has not seen yet.
+type SimBroker struct {
+	cfg       BrokerConfig
+	fillModel FillModel
+
+	now       time.Time
+	lastPrice float64
+	nextID    int64
+	open      []*SimOrder
+	cash      float64 // Wallet balance for futures.
+	position  orders.Position
+	fills     []Fill
+
+	fillHandlers map[int]func(Fill)
+	nextHandler  int
+
+	// Futures only.
+	isolatedMargin float64
+	fundingIdx     int
+	funding        []FundingPayment
+	liquidations   []Liquidation
+}
+
+func NewSimBroker(cfg BrokerConfig) (*SimBroker, error) {
+	leverage := 1.0
+	if cfg.Futures != nil {
+		if err := cfg.Futures.validate(); err != nil {
+			return nil, err
+		}
+		leverage = cfg.Futures.Leverage
+	}
+	var fillModel FillModel = &OHLCFillModel{Intrabar: cfg.Intrabar}
+	if cfg.NewFillModel != nil {
+		fillModel = cfg.NewFillModel()
+	}
+	return &SimBroker{
+		cfg:          cfg,
+		fillModel:    fillModel,
+		nextID:       1,
+		fillHandlers: map[int]func(Fill){},
+		cash:         cfg.InitialCash,
+		position:     orders.Position{Symbol: cfg.Symbol, Leverage: leverage},
+	}, nil
+}
+
+// Now returns the close time of the bar being handled.
+func (b *SimBroker) Now() time.Time { return b.now }
+
+// LastPrice returns the close price of the bar being handled.
+func (b *SimBroker) LastPrice() float64 { return b.lastPrice }
+
+func (b *SimBroker) Cash() float64 { return b.cash }
+
+func (b *SimBroker) Position() orders.Position { return b.position }
+
+// Equity returns the cash plus the position marked at the last price. For futures
+// it is the wallet balance plus isolated margin plus unrealized PnL.
+func (b *SimBroker) Equity() float64 {
+	if b.isFutures() {
+		return b.cash + b.isolatedMargin + b.unrealizedPnL(b.lastPrice)
+	}
+	return b.cash + b.position.Quantity*b.lastPrice
+}
+
+func (b *SimBroker) Fills() []Fill { return b.fills }
+
+func (b *SimBroker) FundingPayments() []FundingPayment { return b.funding }
+
+func (b *SimBroker) Liquidations() []Liquidation { return b.liquidations }
+
+func (b *SimBroker) OpenOrders() []SimOrder {
+	res := make([]SimOrder, len(b.open))
+	for idx, o := range b.open {
+		res[idx] = *o
+	}
+	return res
+}
+
+// Submit queues an order and returns its ID.
+func (b *SimBroker) Submit(o orders.Order) (int64, error) {
+	if o.Symbol == "" {
+		o.Symbol = b.cfg.Symbol
+	}
+	if err := validateOrder(&o, b.cfg.Symbol); err != nil {
+		return 0, err
+	}
+	so := &SimOrder{Order: o, ID: b.nextID, Status: common.OrderStatus_NEW, CreateTime: b.now}
+	b.nextID++
+	b.open = append(b.open, so)
+	return so.ID, nil
+}
+
+func validateOrder(o *orders.Order, symbol string) error {
+	if o.Symbol != symbol {
+		return fmt.Errorf("symbol %q but broker trades %q: %w", o.Symbol, symbol, ErrInvalidOrder)
+	}
+	if !(o.Quantity > 0) {
+		return fmt.Errorf("quantity %v: %w", o.Quantity, ErrInvalidOrder)
+	}
+	switch o.Type {
+	case common.OrderType_MARKET:
+	case common.OrderType_LIMIT:
+		if !(o.Price > 0) {
+			return fmt.Errorf("limit price %v: %w", o.Price, ErrInvalidOrder)
+		}
+	case common.OrderType_STOP_MARKET:
+		if !(o.StopPrice > 0) {
+			return fmt.Errorf("stop price %v: %w", o.StopPrice, ErrInvalidOrder)
+		}
+	case common.OrderType_STOP:
+		if !(o.Price > 0 && o.StopPrice > 0) {
+			return fmt.Errorf("limit price %v stop price %v: %w", o.Price, o.StopPrice, ErrInvalidOrder)
+		}
+	default:
+		return fmt.Errorf("order type %q: %w", o.Type, ErrInvalidOrder)
+	}
+	return nil
+}
+
+func (b *SimBroker) Cancel(id int64) error {
+	for idx, o := range b.open {
+		if o.ID == id {
+			o.Status = common.OrderStatus_CANCELED
+			b.open = slices.Delete(b.open, idx, idx+1)
+			return nil
+		}
+	}
+	return fmt.Errorf("order %d: %w", id, ErrOrderNotFound)
+}
+
+// Amend changes the quantity and limit price of an open LIMIT or STOP order. The
+// quantity includes what has been filled already and must exceed it.
+func (b *SimBroker) Amend(id int64, quantity, price float64) error {
+	idx := slices.IndexFunc(b.open, func(o *SimOrder) bool { return o.ID == id })
+	if idx < 0 {
+		return fmt.Errorf("order %d: %w", id, ErrOrderNotFound)
+	}
+	o := b.open[idx]
+	if o.Type != common.OrderType_LIMIT && o.Type != common.OrderType_STOP {
+		return fmt.Errorf("amend %s order: %w", o.Type, ErrInvalidOrder)
+	}
+	if !(quantity > o.FilledQuantity) || !(price > 0) {
+		return fmt.Errorf("quantity %v (filled %v) price %v: %w", quantity, o.FilledQuantity, price, ErrInvalidOrder)
+	}
+	o.Quantity, o.Price = quantity, price
+	return nil
+}
+
+func (b *SimBroker) CancelAll() {
+	for _, o := range b.open {
+		o.Status = common.OrderStatus_CANCELED
+	}
+	b.open = nil
+}
+
+// ProcessBar settles funding, matches all open orders against the bar, checks for
+// liquidation, then marks the position at the close. The engine calls it for every
+// bar; live simulations (e.g. paper trading) call it with short synthetic bars.
+func (b *SimBroker) ProcessBar(bar *klines.KLine) {
+	fillNum := len(b.fills)
+	defer b.notifyFills(fillNum)
+	b.now = bar.CloseTime
+	for _, m := range b.fillModel.Match(bar, b.open, b.position.Quantity) {
+		if b.isFutures() {
+			b.applyFunding(bar, m.Time)
+		}
+		o := m.Order
+		if o.Status != common.OrderStatus_NEW && o.Status != common.OrderStatus_PARTIALLY_FILLED {
+			continue // Already done with by an earlier match within the bar.
+		}
+		qty := math.Min(m.Quantity, o.Remaining())
+		if o.ReduceOnly {
+			// Reduce only orders are capped to the position and cancelled once it is gone.
+			if b.position.Quantity*o.Side.Sign() >= 0 {
+				b.removeOpen(o.ID)
+				o.Status = common.OrderStatus_CANCELED
+				continue
+			}
+			qty = math.Min(qty, math.Abs(b.position.Quantity))
+		}
+		if qty <= 0 {
+			continue
+		}
+		if !b.execute(o, qty, m.Price, m.Maker, m.Time) {
+			b.removeOpen(o.ID)
+			o.Status = common.OrderStatus_REJECTED
+			continue
+		}
+		o.FilledQuantity += qty
+		// Tolerate float dust left over by partial fills.
+		if o.Remaining() <= o.Quantity*1e-9 {
+			b.removeOpen(o.ID)
+			o.Status = common.OrderStatus_FILLED
+		} else {
+			o.Status = common.OrderStatus_PARTIALLY_FILLED
+		}
+	}
+	if b.isFutures() {
+		b.applyFunding(bar, bar.CloseTime)
+		b.checkLiquidation(bar)
+	}
+	b.lastPrice = bar.ClosePrice
+}
+
+// SubscribeFills calls handle with every fill from now on, after the bar producing
+// it has been processed. Call the returned function to unsubscribe.
+func (b *SimBroker) SubscribeFills(handle func(Fill)) func() {
+	key := b.nextHandler
+	b.nextHandler++
+	b.fillHandlers[key] = handle
+	return func() { delete(b.fillHandlers, key) }
+}
+
+func (b *SimBroker) notifyFills(from int) {
+	for _, f := range b.fills[from:] {
+		for _, key := range slices.Sorted(maps.Keys(b.fillHandlers)) {
+			if handle, ok := b.fillHandlers[key]; ok {
+				handle(f)
+			}
+		}
+	}
+}
+
+func (b *SimBroker) removeOpen(id int64) {
+	b.open = slices.DeleteFunc(b.open, func(o *SimOrder) bool { return o.ID == id })
+}
+
+func (b *SimBroker) feeBps(maker bool) float64 {
+	if maker {
+		return b.cfg.MakerFeeBps
+	}
+	return b.cfg.TakerFeeBps
+}
+
+// execute books a fill of quantity of the order at price and reports whether it
+// went through.
+func (b *SimBroker) execute(o *SimOrder, quantity, price float64, maker bool, t time.Time) bool {
+	if b.isFutures() {
+		return b.executeFutures(o, quantity, price, maker, t)
+	}
+	qty := o.Side.Sign() * quantity
+	fee := quantity * price * b.feeBps(maker) / 1e4
+	realized := b.applyToPosition(qty, price)
+	b.cash -= qty*price + fee
+	b.fills = append(b.fills, Fill{
+		OrderID:     o.ID,
+		Symbol:      o.Symbol,
+		Side:        o.Side,
+		Time:        t,
+		Price:       price,
+		Quantity:    quantity,
+		Fee:         fee,
+		Maker:       maker,
+		RealizedPnL: realized,
+	})
+	return true
+}
+
+// applyToPosition updates the position and its average entry price with a signed
+// trade and returns the realized PnL of the closed part.
+func (b *SimBroker) applyToPosition(qty, price float64) float64 {
+	p := &b.position
+	var realized float64
+	if p.Quantity != 0 && p.Quantity*qty < 0 {
+		closed := math.Min(math.Abs(qty), math.Abs(p.Quantity))
+		if p.Quantity > 0 {
+			realized = closed * (price - p.EntryPrice)
+		} else {
+			realized = closed * (p.EntryPrice - price)
+		}
+	}
+	newQty := p.Quantity + qty
+	switch {
+	case newQty == 0:
+		p.EntryPrice = 0
+	case p.Quantity*newQty < 0 || p.Quantity == 0:
+		p.EntryPrice = price // Opened or flipped.
+	case math.Abs(newQty) > math.Abs(p.Quantity):
+		p.EntryPrice = (p.EntryPrice*p.Quantity + price*qty) / newQty
+	}
+	p.Quantity = newQty
+	return realized
+}
+
+// BrokerState is everything needed to resume a broker, e.g. after a restart of a
+// paper trading process. Fills are included so reports stay complete.
+type BrokerState struct {
+	Now            time.Time
+	LastPrice      float64
+	NextID         int64
+	Cash           float64
+	Position       orders.Position
+	IsolatedMargin float64
+	OpenOrders     []SimOrder
+	Fills          []Fill
+	Funding        []FundingPayment
+	Liquidations   []Liquidation
+}
+
+func (b *SimBroker) State() BrokerState {
+	return BrokerState{
+		Now:            b.now,
+		LastPrice:      b.lastPrice,
+		NextID:         b.nextID,
+		Cash:           b.cash,
+		Position:       b.position,
+		IsolatedMargin: b.isolatedMargin,
+		OpenOrders:     b.OpenOrders(),
+		Fills:          slices.Clone(b.fills),
+		Funding:        slices.Clone(b.funding),
+		Liquidations:   slices.Clone(b.liquidations),
+	}
+}
+
+// Restore replaces the broker's state with s. Funding events up to s.Now are
+// considered settled.
+func (b *SimBroker) Restore(s BrokerState) {
+	b.now = s.Now
+	b.lastPrice = s.LastPrice
+	b.nextID = s.NextID
+	b.cash = s.Cash
+	b.position = s.Position
+	b.isolatedMargin = s.IsolatedMargin
+	b.open = make([]*SimOrder, len(s.OpenOrders))
+	for idx := range s.OpenOrders {
+		o := s.OpenOrders[idx]
+		b.open[idx] = &o
+	}
+	b.fills = slices.Clone(s.Fills)
+	b.funding = slices.Clone(s.Funding)
+	b.liquidations = slices.Clone(s.Liquidations)
+	b.fundingIdx = 0
+	if b.isFutures() {
+		rates := b.cfg.Futures.FundingRates
+		for b.fundingIdx < len(rates) && !rates[b.fundingIdx].FundingTime.After(s.Now) {
+			b.fundingIdx++
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package backtest
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+// Strategy is called once per bar after the bar has closed. Orders it submits to the
+// engine's broker are matched from the next bar on.
+type Strategy interface {
+	OnBar(ctx context.Context, bar klines.KLine) error
+}
+
+// Warmer is implemented by strategies that need history before their first bar,
+// e.g. to fill their indicator windows.
+type Warmer interface {
+	// Warmup feeds closed history bars in order, without trading.
+	Warmup(bars []klines.KLine)
+}
+
+type EquityPoint struct {
+	Time     time.Time // Close time of the bar.
+	Price    float64   // Close price of the bar.
+	Cash     float64
+	Position float64 // Signed position quantity.
+	Equity   float64
+}
+
+type Result struct {
+	Start         time.Time // Open time of the first bar.
+	InitialEquity float64
+	Fills         []Fill
+	EquityCurve   []EquityPoint
+	Funding       []FundingPayment // Futures only.
+	Liquidations  []Liquidation    // Futures only.
+}
+
+type Engine struct {
+	broker *SimBroker
+}
+
+func NewEngine(cfg BrokerConfig) (*Engine, error) {
+	b, err := NewSimBroker(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("NewSimBroker: %w", err)
+	}
+	return &Engine{broker: b}, nil
+}
+
+// Broker returns the simulated broker a strategy should submit its orders to.
+func (e *Engine) Broker() *SimBroker {
+	return e.broker
+}
+
+// Run feeds the bars in chronological order to the strategy.
+func (e *Engine) Run(ctx context.Context, bars []klines.KLine, s Strategy) (*Result, error) {
+	res := &Result{InitialEquity: e.broker.Equity(), EquityCurve: make([]EquityPoint, 0, len(bars))}
+	if len(bars) > 0 {
+		res.Start = bars[0].OpenTime
+	}
+	for idx := range bars {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bar := &bars[idx]
+		if idx > 0 && !bar.OpenTime.After(bars[idx-1].OpenTime) {
+			return nil, fmt.Errorf("bar %d open time %v is not after %v", idx, bar.OpenTime, bars[idx-1].OpenTime)
+		}
+		e.broker.ProcessBar(bar)
+		res.EquityCurve = append(res.EquityCurve, e.equityPoint(bar))
+		if err := s.OnBar(ctx, *bar); err != nil {
+			return nil, fmt.Errorf("OnBar(%v): %w", bar.OpenTime, err)
+		}
+	}
+	res.Fills = e.broker.Fills()
+	res.Funding = e.broker.FundingPayments()
+	res.Liquidations = e.broker.Liquidations()
+	return res, nil
+}
+
+func (e *Engine) equityPoint(bar *klines.KLine) EquityPoint {
+	return EquityPoint{
+		Time:     bar.CloseTime,
+		Price:    bar.ClosePrice,
+		Cash:     e.broker.Cash(),
+		Position: e.broker.Position().Quantity,
+		Equity:   e.broker.Equity(),
+	}
+}
+
+// RunCSV loads the bars from a KLine CSV written by the storage package and runs them.
+func (e *Engine) RunCSV(ctx context.Context, path string, s Strategy) (*Result, error) {
+	bars, err := storage.LoadKLinesCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadKLinesCSV(%q): %w", path, err)
+	}
+	return e.Run(ctx, bars, s)
+}
+
+var EquityCurveCSVHeader = []string{"Time", "Price", "Cash", "Position", "Equity"}
+
+// WriteEquityCurveCSV writes the equity curve with times in unix milliseconds, the
+// same representation the KLine CSVs use.
+func WriteEquityCurveCSV(w io.Writer, curve []EquityPoint) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(EquityCurveCSVHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, p := range curve {
+		record := []string{
+			strconv.FormatInt(p.Time.UnixMilli(), 10),
+			strconv.FormatFloat(p.Price, 'g', -1, 64),
+			strconv.FormatFloat(p.Cash, 'g', -1, 64),
+			strconv.FormatFloat(p.Position, 'g', -1, 64),
+			strconv.FormatFloat(p.Equity, 'g', -1, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
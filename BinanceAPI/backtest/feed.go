@@ -0,0 +1,168 @@
+package backtest
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+type SeriesKey struct {
+	Symbol   string
+	Interval common.ListKLinesInterval
+}
+
+func (k SeriesKey) String() string {
+	return fmt.Sprintf("%s_%s", k.Symbol, k.Interval)
+}
+
+type Series struct {
+	SeriesKey
+	Bars []klines.KLine // Chronological.
+}
+
+type FeedBar struct {
+	SeriesKey
+	Bar klines.KLine
+}
+
+// Feed merges several series by close time. A bar is only emitted once it has
+// closed, so a 1d bar shows up together with the last 4h bar of that day and never
+// before. Series that start later (e.g. a symbol listed later) simply join the feed
+// when their first bar closes.
+type Feed struct {
+	series []Series
+	next   []int // Index of the next bar to emit per series.
+	latest map[SeriesKey]klines.KLine
+}
+
+func NewFeed(series ...Series) (*Feed, error) {
+	seen := map[SeriesKey]bool{}
+	for _, s := range series {
+		if seen[s.SeriesKey] {
+			return nil, fmt.Errorf("duplicated series %v", s.SeriesKey)
+		}
+		seen[s.SeriesKey] = true
+		for idx := 1; idx < len(s.Bars); idx++ {
+			if !s.Bars[idx].CloseTime.After(s.Bars[idx-1].CloseTime) {
+				return nil, fmt.Errorf("series %v bar %d close time %v is not after %v",
+					s.SeriesKey, idx, s.Bars[idx].CloseTime, s.Bars[idx-1].CloseTime)
+			}
+		}
+	}
+	return &Feed{
+		series: series,
+		next:   make([]int, len(series)),
+		latest: map[SeriesKey]klines.KLine{},
+	}, nil
+}
+
+// LoadFeed loads each series from the KLine CSVs under root.
+func LoadFeed(root string, keys ...SeriesKey) (*Feed, error) {
+	return LoadFeedFrom(storage.NewCSVStore(root), keys...)
+}
+
+// LoadFeedFrom loads each whole series from store. An empty series is an error
+// since it is most likely a typo.
+func LoadFeedFrom(store storage.KLineStore, keys ...SeriesKey) (*Feed, error) {
+	series := make([]Series, 0, len(keys))
+	for _, key := range keys {
+		bars, err := store.Range(key.Symbol, key.Interval, time.Time{}, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("Range(%v): %w", key, err)
+		}
+		if len(bars) == 0 {
+			return nil, fmt.Errorf("no bars stored for %v", key)
+		}
+		series = append(series, Series{SeriesKey: key, Bars: bars})
+	}
+	return NewFeed(series...)
+}
+
+// Next returns all bars closing at the earliest pending close time. Bars of higher
+// intervals come first, so a strategy handling a 4h bar already sees the 1d bar
+// that closed at the same moment. Ties keep the order the series were given in.
+func (f *Feed) Next() ([]FeedBar, bool) {
+	var closeTime time.Time
+	found := false
+	for idx, s := range f.series {
+		if f.next[idx] >= len(s.Bars) {
+			continue
+		}
+		t := s.Bars[f.next[idx]].CloseTime
+		if !found || t.Before(closeTime) {
+			closeTime, found = t, true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	var bars []FeedBar
+	for idx, s := range f.series {
+		if f.next[idx] >= len(s.Bars) || !s.Bars[f.next[idx]].CloseTime.Equal(closeTime) {
+			continue
+		}
+		bar := s.Bars[f.next[idx]]
+		f.next[idx]++
+		f.latest[s.SeriesKey] = bar
+		bars = append(bars, FeedBar{SeriesKey: s.SeriesKey, Bar: bar})
+	}
+	slices.SortStableFunc(bars, func(a, b FeedBar) int {
+		return -cmp.Compare(common.IntervalDuration(a.Interval), common.IntervalDuration(b.Interval))
+	})
+	return bars, true
+}
+
+// Latest returns the last closed bar emitted for the series, false if the series
+// has not emitted anything yet (e.g. the symbol is not listed yet).
+func (f *Feed) Latest(key SeriesKey) (klines.KLine, bool) {
+	bar, ok := f.latest[key]
+	return bar, ok
+}
+
+// FeedStrategy receives every group of bars closing at the same time.
+type FeedStrategy interface {
+	OnBars(ctx context.Context, bars []FeedBar) error
+}
+
+// RunFeed drives the strategy with the feed while the broker fills orders against
+// the bars of the trade series, which must be one of the feed's series.
+func (e *Engine) RunFeed(ctx context.Context, feed *Feed, trade SeriesKey, s FeedStrategy) (*Result, error) {
+	if !slices.ContainsFunc(feed.series, func(s Series) bool { return s.SeriesKey == trade }) {
+		return nil, fmt.Errorf("trade series %v not in feed", trade)
+	}
+	res := &Result{InitialEquity: e.broker.Equity()}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bars, ok := feed.Next()
+		if !ok {
+			break
+		}
+		for idx := range bars {
+			if bars[idx].SeriesKey != trade {
+				continue
+			}
+			bar := &bars[idx].Bar
+			if res.Start.IsZero() {
+				res.Start = bar.OpenTime
+			}
+			e.broker.ProcessBar(bar)
+			res.EquityCurve = append(res.EquityCurve, e.equityPoint(bar))
+		}
+		if err := s.OnBars(ctx, bars); err != nil {
+			return nil, fmt.Errorf("OnBars(%v): %w", bars[0].Bar.CloseTime, err)
+		}
+	}
+	res.Fills = e.broker.Fills()
+	res.Funding = e.broker.FundingPayments()
+	res.Liquidations = e.broker.Liquidations()
+	return res, nil
+}
@@ -0,0 +1,143 @@
+package backtest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+var feedStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// feedSeries returns n bars of the interval opening from feedStart + offset.
+func feedSeries(symbol string, interval common.ListKLinesInterval, offset time.Duration, n int) Series {
+	d := common.IntervalDuration(interval)
+	s := Series{SeriesKey: SeriesKey{Symbol: symbol, Interval: interval}}
+	for idx := range n {
+		open := feedStart.Add(offset + time.Duration(idx)*d)
+		s.Bars = append(s.Bars, klines.KLine{OpenTime: open, CloseTime: open.Add(d - time.Millisecond)})
+	}
+	return s
+}
+
+// drain lists the groups of the feed as "<hours since feedStart>: <series>...".
+func drain(f *Feed) []string {
+	var res []string
+	for {
+		bars, ok := f.Next()
+		if !ok {
+			return res
+		}
+		keys := make([]string, len(bars))
+		for idx, b := range bars {
+			keys[idx] = b.SeriesKey.String()
+		}
+		hours := int(bars[0].Bar.CloseTime.Add(time.Millisecond).Sub(feedStart) / time.Hour)
+		res = append(res, fmt.Sprintf("%d: %s", hours, strings.Join(keys, " ")))
+	}
+}
+
+func TestFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		series []Series
+		want   []string
+	}{
+		{
+			name: "close time order",
+			series: []Series{
+				feedSeries("BTCUSDT", common.ListKLinesInterval_2h, 0, 2),
+				feedSeries("ETHUSDT", common.ListKLinesInterval_1h, 0, 3),
+			},
+			want: []string{"1: ETHUSDT_1h", "2: BTCUSDT_2h ETHUSDT_1h", "3: ETHUSDT_1h", "4: BTCUSDT_2h"},
+		},
+		{
+			name: "higher intervals first",
+			series: []Series{
+				feedSeries("BTCUSDT", common.ListKLinesInterval_1h, 0, 8),
+				feedSeries("BTCUSDT", common.ListKLinesInterval_8h, 0, 1),
+				feedSeries("BTCUSDT", common.ListKLinesInterval_4h, 0, 2),
+			},
+			want: []string{
+				"1: BTCUSDT_1h", "2: BTCUSDT_1h", "3: BTCUSDT_1h", "4: BTCUSDT_4h BTCUSDT_1h",
+				"5: BTCUSDT_1h", "6: BTCUSDT_1h", "7: BTCUSDT_1h", "8: BTCUSDT_8h BTCUSDT_4h BTCUSDT_1h",
+			},
+		},
+		{
+			name: "equal intervals keep the given order",
+			series: []Series{
+				feedSeries("XRPUSDT", common.ListKLinesInterval_1h, 0, 2),
+				feedSeries("BTCUSDT", common.ListKLinesInterval_1h, 0, 2),
+			},
+			want: []string{"1: XRPUSDT_1h BTCUSDT_1h", "2: XRPUSDT_1h BTCUSDT_1h"},
+		},
+		{
+			name: "late listed symbol",
+			series: []Series{
+				feedSeries("BTCUSDT", common.ListKLinesInterval_1h, 0, 4),
+				feedSeries("NEWUSDT", common.ListKLinesInterval_1h, 2*time.Hour, 3),
+			},
+			want: []string{"1: BTCUSDT_1h", "2: BTCUSDT_1h", "3: BTCUSDT_1h NEWUSDT_1h", "4: BTCUSDT_1h NEWUSDT_1h", "5: NEWUSDT_1h"},
+		},
+		{
+			name: "late listed symbol mid bar of a higher interval",
+			series: []Series{
+				feedSeries("BTCUSDT", common.ListKLinesInterval_4h, 0, 2),
+				feedSeries("NEWUSDT", common.ListKLinesInterval_1h, 3*time.Hour, 2),
+			},
+			want: []string{"4: BTCUSDT_4h NEWUSDT_1h", "5: NEWUSDT_1h", "8: BTCUSDT_4h"},
+		},
+		{
+			name: "empty series",
+			series: []Series{
+				feedSeries("BTCUSDT", common.ListKLinesInterval_1h, 0, 1),
+				feedSeries("NEWUSDT", common.ListKLinesInterval_1h, 0, 0),
+			},
+			want: []string{"1: BTCUSDT_1h"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFeed(tt.series...)
+			if err != nil {
+				t.Fatalf("NewFeed: %v", err)
+			}
+			if got := drain(f); !slices.Equal(got, tt.want) {
+				t.Errorf("groups:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestFeedLatest(t *testing.T) {
+	btc := feedSeries("BTCUSDT", common.ListKLinesInterval_1h, 0, 3)
+	late := feedSeries("NEWUSDT", common.ListKLinesInterval_1h, 2*time.Hour, 1)
+	f, err := NewFeed(btc, late)
+	if err != nil {
+		t.Fatalf("NewFeed: %v", err)
+	}
+	for idx := range 3 {
+		f.Next()
+		if bar, ok := f.Latest(btc.SeriesKey); !ok || !bar.OpenTime.Equal(btc.Bars[idx].OpenTime) {
+			t.Errorf("after %d groups: Latest(BTCUSDT) = %v, %v, want bar %d", idx+1, bar.OpenTime, ok, idx)
+		}
+		if _, ok := f.Latest(late.SeriesKey); ok != (idx == 2) {
+			t.Errorf("after %d groups: Latest(NEWUSDT) found %v, want %v", idx+1, ok, idx == 2)
+		}
+	}
+}
+
+func TestNewFeedErrors(t *testing.T) {
+	btc := feedSeries("BTCUSDT", common.ListKLinesInterval_1h, 0, 3)
+	unordered := feedSeries("ETHUSDT", common.ListKLinesInterval_1h, 0, 3)
+	unordered.Bars[1], unordered.Bars[2] = unordered.Bars[2], unordered.Bars[1]
+	for name, series := range map[string][]Series{"duplicated": {btc, btc}, "unordered": {btc, unordered}} {
+		if _, err := NewFeed(series...); err == nil {
+			t.Errorf("NewFeed of %s series succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package backtest
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/depth"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// FillModel decides how the open orders fill within one bar.
+type FillModel interface {
+	// Match returns the fills of the open orders within the bar in the order they
+	// happen. Position is the signed position quantity at the bar open. A model may
+	// set the Triggered flag of stop orders but must not change anything else.
+	Match(bar *klines.KLine, open []*SimOrder, position float64) []Match
+}
+
+type Match struct {
+	Order    *SimOrder
+	Time     time.Time
+	Price    float64
+	Quantity float64 // The broker caps it at the order's remaining quantity.
+	Maker    bool
+}
+
+// IntrabarPath decides in which order the OHLC prices of a bar are assumed to be
+// visited, which matters when several orders could fill within the same bar.
+type IntrabarPath int
+
+const (
+	IntrabarPath_OHLC        IntrabarPath = iota // Open -> High -> Low -> Close.
+	IntrabarPath_OLHC                            // Open -> Low -> High -> Close.
+	IntrabarPath_Nearest                         // Visit whichever extreme is closer to the open first.
+	IntrabarPath_Pessimistic                     // Visit the extreme hurting the current position first.
+)
+
+// OHLCFillModel fills every order in full at the first point of the assumed
+// intrabar path where it becomes executable, without market impact.
+type OHLCFillModel struct {
+	Intrabar IntrabarPath
+}
+
+// pathPoints returns the prices visited within the bar according to the intrabar assumption.
+func (m *OHLCFillModel) pathPoints(bar *klines.KLine, position float64) []float64 {
+	highFirst := []float64{bar.OpenPrice, bar.HighPrice, bar.LowPrice, bar.ClosePrice}
+	lowFirst := []float64{bar.OpenPrice, bar.LowPrice, bar.HighPrice, bar.ClosePrice}
+	switch m.Intrabar {
+	case IntrabarPath_OLHC:
+		return lowFirst
+	case IntrabarPath_Nearest:
+		if bar.HighPrice-bar.OpenPrice < bar.OpenPrice-bar.LowPrice {
+			return highFirst
+		}
+		return lowFirst
+	case IntrabarPath_Pessimistic:
+		if position < 0 {
+			return highFirst
+		}
+		return lowFirst
+	default:
+		return highFirst
+	}
+}
+
+func (m *OHLCFillModel) Match(bar *klines.KLine, open []*SimOrder, position float64) []Match {
+	pts := m.pathPoints(bar, position)
+	var matches []pathMatch
+	for _, o := range open {
+		if pm, ok := matchOnPath(o, pts); ok {
+			matches = append(matches, pm)
+		}
+	}
+	// Execute in the order the fills happen along the path; ties keep submission order.
+	slices.SortStableFunc(matches, func(x, y pathMatch) int {
+		switch {
+		case x.pos < y.pos:
+			return -1
+		case x.pos > y.pos:
+			return 1
+		}
+		return 0
+	})
+	res := make([]Match, len(matches))
+	for idx, pm := range matches {
+		res[idx] = Match{
+			Order:    pm.order,
+			Time:     bar.OpenTime,
+			Price:    pm.price,
+			Quantity: pm.order.Remaining(),
+			Maker:    pm.maker,
+		}
+	}
+	return res
+}
+
+// pathMatch is where along the intrabar path an order gets filled. Pos is the
+// segment index plus the fraction travelled within the segment.
+type pathMatch struct {
+	order *SimOrder
+	pos   float64
+	price float64
+	maker bool
+}
+
+// crossAt returns the fraction of segment a -> b at which the price reaches level
+// moving in the given direction (up: price >= level, down: price <= level).
+func crossAt(a, b, level float64, up bool) (float64, bool) {
+	if up && a < level && b >= level || !up && a > level && b <= level {
+		return (level - a) / (b - a), true
+	}
+	return 0, false
+}
+
+// matchOnPath walks the intrabar path and reports the first point the order fills.
+func matchOnPath(o *SimOrder, pts []float64) (pathMatch, bool) {
+	buy := o.Side == common.OrderSide_BUY
+	triggered := o.Triggered || o.Type == common.OrderType_MARKET || o.Type == common.OrderType_LIMIT
+
+	// tryFill checks whether a triggered order fills immediately at price p reached at
+	// position pos, which is always a taker fill.
+	tryFill := func(pos, p float64) (pathMatch, bool) {
+		switch o.Type {
+		case common.OrderType_MARKET, common.OrderType_STOP_MARKET:
+			return pathMatch{order: o, pos: pos, price: p}, true
+		default:
+			if buy && p <= o.Price || !buy && p >= o.Price {
+				return pathMatch{order: o, pos: pos, price: p}, true
+			}
+		}
+		return pathMatch{}, false
+	}
+
+	// At the open.
+	if !triggered && (buy && pts[0] >= o.StopPrice || !buy && pts[0] <= o.StopPrice) {
+		triggered = true
+	}
+	if triggered {
+		if m, ok := tryFill(0, pts[0]); ok {
+			return m, true
+		}
+	}
+	for seg := 0; seg+1 < len(pts); seg++ {
+		a, c := pts[seg], pts[seg+1]
+		if !triggered {
+			frac, ok := crossAt(a, c, o.StopPrice, buy)
+			if !ok {
+				continue
+			}
+			triggered = true
+			a = o.StopPrice
+			if m, ok := tryFill(float64(seg)+frac, a); ok {
+				return m, true
+			}
+			// A stop limit order rests as a limit order from the trigger point on.
+			if frac2, ok := crossAt(a, c, o.Price, !buy); ok {
+				pos := float64(seg) + frac + (1-frac)*frac2
+				return pathMatch{order: o, pos: pos, price: o.Price, maker: true}, true
+			}
+			continue
+		}
+		if o.Type == common.OrderType_LIMIT || o.Type == common.OrderType_STOP {
+			if frac, ok := crossAt(a, c, o.Price, !buy); ok {
+				return pathMatch{order: o, pos: float64(seg) + frac, price: o.Price, maker: true}, true
+			}
+		}
+	}
+	o.Triggered = triggered
+	return pathMatch{}, false
+}
+
+// BookFillModel replays the recorded aggregated trades of each bar:
+//
+//   - Market orders, triggered stop market orders and marketable limit orders walk
+//     the levels of the latest recorded order book snapshot, so large orders pay
+//     slippage and may fill at several prices.
+//   - Resting limit orders join the back of the queue at their price level, as
+//     seen in the snapshot when they are first matched. Trades at the limit price
+//     on the opposite side first consume the queue ahead, then fill the order,
+//     possibly partially. Trades through the limit price fill it directly.
+//   - Stop orders trigger on the first trade reaching the stop price.
+//
+// Bars without recorded trades are delegated to the fallback model, which is the
+// OHLC model by default.
+type BookFillModel struct {
+	trades    []aggtrades.AggTrade
+	snapshots []depth.Snapshot
+	fallback  FillModel
+
+	queue map[int64]queuePos // Of each resting limit order.
+}
+
+// queuePos is the quantity resting ahead of an order at its price level. Amending
+// the price of an order sends it to the back of the new level.
+type queuePos struct {
+	price float64
+	ahead float64
+}
+
+// NewBookFillModel takes trades and snapshots in chronological order. They are
+// never modified, so several models may share them.
+func NewBookFillModel(trades []aggtrades.AggTrade, snapshots []depth.Snapshot, fallback FillModel) *BookFillModel {
+	if fallback == nil {
+		fallback = &OHLCFillModel{}
+	}
+	return &BookFillModel{
+		trades:    slices.Clip(trades),
+		snapshots: slices.Clip(snapshots),
+		fallback:  fallback,
+		queue:     map[int64]queuePos{},
+	}
+}
+
+// AddTrades appends trades newer than the ones already held, which lets a live
+// simulation feed the model as trades arrive.
+func (m *BookFillModel) AddTrades(trades ...aggtrades.AggTrade) {
+	m.trades = append(m.trades, trades...)
+}
+
+// AddSnapshot appends a snapshot newer than the ones already held.
+func (m *BookFillModel) AddSnapshot(s depth.Snapshot) {
+	m.snapshots = append(m.snapshots, s)
+}
+
+// Prune drops trades before t and all snapshots but the latest one taken at or
+// before t, bounding the memory of long live simulations.
+func (m *BookFillModel) Prune(t time.Time) {
+	idx, _ := slices.BinarySearchFunc(m.trades, t, func(a aggtrades.AggTrade, t time.Time) int { return a.Time.Compare(t) })
+	m.trades = m.trades[idx:]
+	idx, found := slices.BinarySearchFunc(m.snapshots, t, func(s depth.Snapshot, t time.Time) int { return s.Time.Compare(t) })
+	if !found && idx > 0 {
+		idx--
+	}
+	m.snapshots = m.snapshots[idx:]
+}
+
+// snapshotAt returns the latest snapshot taken at or before t.
+func (m *BookFillModel) snapshotAt(t time.Time) *depth.Snapshot {
+	idx, found := slices.BinarySearchFunc(m.snapshots, t, func(s depth.Snapshot, t time.Time) int {
+		return s.Time.Compare(t)
+	})
+	if found {
+		return &m.snapshots[idx]
+	}
+	if idx == 0 {
+		return nil
+	}
+	return &m.snapshots[idx-1]
+}
+
+// tradesWithin returns the trades within [from, to].
+func (m *BookFillModel) tradesWithin(from, to time.Time) []aggtrades.AggTrade {
+	cmpTime := func(a aggtrades.AggTrade, t time.Time) int { return a.Time.Compare(t) }
+	lo, _ := slices.BinarySearchFunc(m.trades, from, cmpTime)
+	hi, _ := slices.BinarySearchFunc(m.trades, to.Add(time.Millisecond), cmpTime)
+	return m.trades[lo:hi]
+}
+
+// walkBook sweeps the opposite side of the book for qty, never beyond limit when
+// limit > 0. Without a snapshot the whole quantity fills at refPrice. When the
+// recorded book is exhausted the rest of a market order fills at the last level.
+func (m *BookFillModel) walkBook(o *SimOrder, qty, limit, refPrice float64, t time.Time) []Match {
+	buy := o.Side == common.OrderSide_BUY
+	s := m.snapshotAt(t)
+	if s == nil || len(s.Asks) == 0 && buy || len(s.Bids) == 0 && !buy {
+		if limit > 0 && (buy && refPrice > limit || !buy && refPrice < limit) {
+			return nil
+		}
+		return []Match{{Order: o, Time: t, Price: refPrice, Quantity: qty}}
+	}
+	levels := s.Bids
+	if buy {
+		levels = s.Asks
+	}
+	var res []Match
+	for _, l := range levels {
+		if qty <= 0 || limit > 0 && (buy && l.Price > limit || !buy && l.Price < limit) {
+			return res
+		}
+		take := math.Min(qty, l.Quantity)
+		res = append(res, Match{Order: o, Time: t, Price: l.Price, Quantity: take})
+		qty -= take
+	}
+	if qty > 0 && limit <= 0 {
+		res = append(res, Match{Order: o, Time: t, Price: levels[len(levels)-1].Price, Quantity: qty})
+	}
+	return res
+}
+
+// queued reports whether the order already rests in the queue of its price level.
+func (m *BookFillModel) queued(o *SimOrder) bool {
+	q, ok := m.queue[o.ID]
+	return ok && q.price == o.Price
+}
+
+// initQueue places a newly seen limit order at the back of its price level.
+func (m *BookFillModel) initQueue(o *SimOrder, t time.Time) {
+	if m.queued(o) {
+		return
+	}
+	ahead := 0.0
+	if s := m.snapshotAt(t); s != nil {
+		levels := s.Asks
+		if o.Side == common.OrderSide_BUY {
+			levels = s.Bids
+		}
+		for _, l := range levels {
+			if l.Price == o.Price {
+				ahead = l.Quantity
+				break
+			}
+		}
+	}
+	m.queue[o.ID] = queuePos{price: o.Price, ahead: ahead}
+}
+
+func (m *BookFillModel) Match(bar *klines.KLine, open []*SimOrder, position float64) []Match {
+	// Forget orders that are no longer open.
+	for id := range m.queue {
+		if !slices.ContainsFunc(open, func(o *SimOrder) bool { return o.ID == id }) {
+			delete(m.queue, id)
+		}
+	}
+	trades := m.tradesWithin(bar.OpenTime, bar.CloseTime)
+	if len(trades) == 0 {
+		return m.fallback.Match(bar, open, position)
+	}
+
+	remaining := make(map[int64]float64, len(open))
+	var res []Match
+	take := func(matches []Match) {
+		for _, mt := range matches {
+			remaining[mt.Order.ID] -= mt.Quantity
+		}
+		res = append(res, matches...)
+	}
+
+	// At the open: market orders and marketable limit orders take liquidity.
+	for _, o := range open {
+		remaining[o.ID] = o.Remaining()
+		switch o.Type {
+		case common.OrderType_MARKET:
+			take(m.walkBook(o, remaining[o.ID], 0, trades[0].Price, bar.OpenTime))
+		case common.OrderType_LIMIT:
+			if !m.queued(o) {
+				take(m.walkBook(o, remaining[o.ID], o.Price, trades[0].Price, bar.OpenTime))
+				m.initQueue(o, bar.OpenTime)
+			}
+		}
+	}
+
+	for _, t := range trades {
+		left := t.Quantity // Volume of this trade still available to our resting orders.
+		for _, o := range open {
+			if remaining[o.ID] <= 0 {
+				continue
+			}
+			buy := o.Side == common.OrderSide_BUY
+			if (o.Type == common.OrderType_STOP || o.Type == common.OrderType_STOP_MARKET) && !o.Triggered {
+				if !(buy && t.Price >= o.StopPrice || !buy && t.Price <= o.StopPrice) {
+					continue
+				}
+				o.Triggered = true
+				if o.Type == common.OrderType_STOP_MARKET {
+					take(m.walkBook(o, remaining[o.ID], 0, t.Price, t.Time))
+					continue
+				}
+				take(m.walkBook(o, remaining[o.ID], o.Price, t.Price, t.Time))
+				m.initQueue(o, t.Time)
+				continue
+			}
+			if o.Type != common.OrderType_LIMIT && o.Type != common.OrderType_STOP || left <= 0 {
+				continue
+			}
+			m.initQueue(o, t.Time)
+			var fillable float64
+			switch {
+			case buy && t.Price < o.Price || !buy && t.Price > o.Price:
+				fillable = left // Traded through our price.
+			case t.Price == o.Price && t.BuyerMaker == buy:
+				// Traded at our price against our side: the queue ahead goes first.
+				q := m.queue[o.ID]
+				fillable = math.Max(0, left-q.ahead)
+				q.ahead = math.Max(0, q.ahead-left)
+				m.queue[o.ID] = q
+			}
+			if fillable <= 0 {
+				continue
+			}
+			qty := math.Min(fillable, remaining[o.ID])
+			left -= qty
+			take([]Match{{Order: o, Time: t.Time, Price: o.Price, Quantity: qty, Maker: true}})
+		}
+	}
+	return res
+}
@@ -0,0 +1,297 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/funding"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// MaintenanceTier is one bracket of the USDⓈ-M maintenance margin table. A position
+// whose notional is at most NotionalCap (and above the previous tier's cap) needs
+// notional * MaintenanceMarginRate - MaintenanceAmount of maintenance margin.
+type MaintenanceTier struct {
+	NotionalCap           float64
+	MaintenanceMarginRate float64
+	MaintenanceAmount     float64
+}
+
+// DefaultMaintenanceTiers are the BTCUSDT brackets at the time of writing. Other
+// symbols have their own table, see /fapi/v1/leverageBracket.
+var DefaultMaintenanceTiers = []MaintenanceTier{
+	{NotionalCap: 50_000, MaintenanceMarginRate: 0.004, MaintenanceAmount: 0},
+	{NotionalCap: 600_000, MaintenanceMarginRate: 0.005, MaintenanceAmount: 50},
+	{NotionalCap: 3_000_000, MaintenanceMarginRate: 0.0065, MaintenanceAmount: 950},
+	{NotionalCap: 12_000_000, MaintenanceMarginRate: 0.01, MaintenanceAmount: 11_450},
+	{NotionalCap: 70_000_000, MaintenanceMarginRate: 0.02, MaintenanceAmount: 131_450},
+	{NotionalCap: 100_000_000, MaintenanceMarginRate: 0.025, MaintenanceAmount: 481_450},
+	{NotionalCap: 230_000_000, MaintenanceMarginRate: 0.05, MaintenanceAmount: 2_981_450},
+	{NotionalCap: 480_000_000, MaintenanceMarginRate: 0.1, MaintenanceAmount: 14_481_450},
+	{NotionalCap: 600_000_000, MaintenanceMarginRate: 0.125, MaintenanceAmount: 26_481_450},
+	{NotionalCap: 800_000_000, MaintenanceMarginRate: 0.15, MaintenanceAmount: 41_481_450},
+	{NotionalCap: 1_200_000_000, MaintenanceMarginRate: 0.25, MaintenanceAmount: 121_481_450},
+	{NotionalCap: 1_800_000_000, MaintenanceMarginRate: 0.5, MaintenanceAmount: 421_481_450},
+}
+
+// FuturesConfig turns the broker into a USDⓈ-M perpetual account: trades only move
+// margin instead of the full notional, positions pay or receive funding, and get
+// liquidated once the margin balance falls below the maintenance margin.
+type FuturesConfig struct {
+	MarginType       common.MarginType
+	Leverage         float64
+	MaintenanceTiers []MaintenanceTier // Sorted by NotionalCap; DefaultMaintenanceTiers if empty.
+	FundingRates     []funding.FundingRate
+	LiquidationFee   float64 // Clearance fee as a fraction of the liquidated notional.
+}
+
+func (c *FuturesConfig) validate() error {
+	if c.MarginType != common.MarginType_ISOLATED && c.MarginType != common.MarginType_CROSSED {
+		return fmt.Errorf("margin type %q: %w", c.MarginType, ErrInvalidConfig)
+	}
+	if !(c.Leverage >= 1) {
+		return fmt.Errorf("leverage %v: %w", c.Leverage, ErrInvalidConfig)
+	}
+	return nil
+}
+
+func (c *FuturesConfig) tier(notional float64) MaintenanceTier {
+	tiers := c.MaintenanceTiers
+	if len(tiers) == 0 {
+		tiers = DefaultMaintenanceTiers
+	}
+	for _, t := range tiers {
+		if notional <= t.NotionalCap {
+			return t
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// MaintenanceMargin returns the maintenance margin of a position with the notional.
+func (c *FuturesConfig) MaintenanceMargin(notional float64) float64 {
+	t := c.tier(notional)
+	return math.Max(0, notional*t.MaintenanceMarginRate-t.MaintenanceAmount)
+}
+
+type FundingPayment struct {
+	Time      time.Time
+	Rate      float64
+	MarkPrice float64
+	Position  float64 // Signed position quantity at funding time.
+	Amount    float64 // Positive when received, negative when paid.
+}
+
+type Liquidation struct {
+	Time     time.Time
+	Price    float64
+	Position float64 // Signed position quantity that got liquidated.
+	Loss     float64 // Realized PnL plus clearance fee, as a positive number.
+}
+
+func (b *SimBroker) isFutures() bool { return b.cfg.Futures != nil }
+
+func (b *SimBroker) isIsolated() bool {
+	return b.isFutures() && b.cfg.Futures.MarginType == common.MarginType_ISOLATED
+}
+
+func (b *SimBroker) unrealizedPnL(price float64) float64 {
+	return b.position.Quantity * (price - b.position.EntryPrice)
+}
+
+// marginBalance is the balance backing the position: the isolated margin for
+// isolated positions, the whole wallet for cross positions, plus unrealized PnL.
+func (b *SimBroker) marginBalance(price float64) float64 {
+	if b.isIsolated() {
+		return b.isolatedMargin + b.unrealizedPnL(price)
+	}
+	return b.cash + b.unrealizedPnL(price)
+}
+
+// availableMargin is what can back new positions at price.
+func (b *SimBroker) availableMargin(price float64) float64 {
+	if b.isIsolated() {
+		return b.cash
+	}
+	initial := math.Abs(b.position.Quantity) * b.position.EntryPrice / b.cfg.Futures.Leverage
+	return b.cash + b.unrealizedPnL(price) - initial
+}
+
+// AvailableBalance returns what can back new orders at the last price: the cash
+// for spot style accounting, the available margin for futures.
+func (b *SimBroker) AvailableBalance() float64 {
+	if !b.isFutures() {
+		return b.cash
+	}
+	return b.availableMargin(b.lastPrice)
+}
+
+// MarginRatio returns maintenance margin / margin balance at the last price. The
+// position gets liquidated when it reaches 1.
+func (b *SimBroker) MarginRatio() float64 {
+	if !b.isFutures() || b.position.Quantity == 0 {
+		return 0
+	}
+	balance := b.marginBalance(b.lastPrice)
+	if balance <= 0 {
+		return math.Inf(1)
+	}
+	return b.cfg.Futures.MaintenanceMargin(b.position.Notional(b.lastPrice)) / balance
+}
+
+// LiquidationPrice solves balance + q * (P - E) = |q| * P * rate - amount for P,
+// picking the maintenance tier that matches the notional at P. It returns 0 when
+// there is no position.
+func (b *SimBroker) LiquidationPrice() float64 {
+	if !b.isFutures() || b.position.Quantity == 0 {
+		return 0
+	}
+	q, e := b.position.Quantity, b.position.EntryPrice
+	balance := b.isolatedMargin
+	if !b.isIsolated() {
+		balance = b.cash
+	}
+	t := b.cfg.Futures.tier(b.position.Notional(e))
+	var p float64
+	// A few rounds are enough for the tier to settle since tiers are monotonic.
+	for range 4 {
+		p = (q*e - balance - t.MaintenanceAmount) / (q - math.Abs(q)*t.MaintenanceMarginRate)
+		next := b.cfg.Futures.tier(math.Abs(q * p))
+		if next == t {
+			break
+		}
+		t = next
+	}
+	return math.Max(0, p)
+}
+
+// applyFunding settles the funding events of the bar up to until, using the
+// position held then. ProcessBar calls it before every fill, so an event is
+// charged to the position at its time rather than the one at the bar open.
+func (b *SimBroker) applyFunding(bar *klines.KLine, until time.Time) {
+	rates := b.cfg.Futures.FundingRates
+	if until.After(bar.CloseTime) {
+		until = bar.CloseTime
+	}
+	for b.fundingIdx < len(rates) && !rates[b.fundingIdx].FundingTime.After(until) {
+		r := rates[b.fundingIdx]
+		b.fundingIdx++
+		if r.FundingTime.Before(bar.OpenTime) || b.position.Quantity == 0 {
+			continue // Before the first bar or no position to settle.
+		}
+		mark := r.MarkPrice
+		if mark <= 0 {
+			mark = bar.OpenPrice
+		}
+		amount := -b.position.Quantity * mark * r.FundingRate
+		if b.isIsolated() {
+			b.isolatedMargin += amount
+		} else {
+			b.cash += amount
+		}
+		b.funding = append(b.funding, FundingPayment{
+			Time:      r.FundingTime,
+			Rate:      r.FundingRate,
+			MarkPrice: mark,
+			Position:  b.position.Quantity,
+			Amount:    amount,
+		})
+	}
+}
+
+// checkLiquidation liquidates the position if the adverse extreme of the bar
+// reaches the liquidation price. The position is closed at the liquidation price,
+// or at the open when the bar gapped through it.
+func (b *SimBroker) checkLiquidation(bar *klines.KLine) {
+	if b.position.Quantity == 0 {
+		return
+	}
+	liq := b.LiquidationPrice()
+	var price float64
+	switch {
+	case b.position.Quantity > 0 && bar.LowPrice <= liq:
+		price = math.Min(liq, bar.OpenPrice)
+	case b.position.Quantity < 0 && bar.HighPrice >= liq:
+		price = math.Max(liq, bar.OpenPrice)
+	default:
+		return
+	}
+	qty := b.position.Quantity
+	fee := b.position.Notional(price) * b.cfg.Futures.LiquidationFee
+	realized := b.applyToPosition(-qty, price)
+	// Whatever is left of the backing balance after the loss and fee stays with the
+	// trader; a negative remainder is absorbed by the insurance fund.
+	if b.isIsolated() {
+		b.cash += math.Max(0, b.isolatedMargin+realized-fee)
+		b.isolatedMargin = 0
+	} else {
+		b.cash = math.Max(0, b.cash+realized-fee)
+	}
+	b.CancelAll()
+	side := common.OrderSide_SELL
+	if qty < 0 {
+		side = common.OrderSide_BUY
+	}
+	b.fills = append(b.fills, Fill{
+		Symbol:      b.cfg.Symbol,
+		Side:        side,
+		Time:        bar.OpenTime,
+		Price:       price,
+		Quantity:    math.Abs(qty),
+		Fee:         fee,
+		RealizedPnL: realized,
+		Liquidation: true,
+	})
+	b.liquidations = append(b.liquidations, Liquidation{
+		Time:     bar.OpenTime,
+		Price:    price,
+		Position: qty,
+		Loss:     fee - realized,
+	})
+}
+
+// executeFutures books a fill of quantity of the order at price as a margin trade
+// and reports false when the account lacks the initial margin for it.
+func (b *SimBroker) executeFutures(o *SimOrder, quantity, price float64, maker bool, t time.Time) bool {
+	qty := o.Side.Sign() * quantity
+	fee := quantity * price * b.feeBps(maker) / 1e4
+	before := b.position.Quantity
+	after := before + qty
+
+	// Split the trade into the part closing the current position and the part opening new exposure.
+	opened := math.Abs(after)
+	if before*after > 0 {
+		opened = math.Max(0, math.Abs(after)-math.Abs(before))
+	}
+	closed := math.Abs(qty) - opened
+	initial := opened * price / b.cfg.Futures.Leverage
+	if opened > 0 && initial+fee > b.availableMargin(price) {
+		return false
+	}
+
+	if b.isIsolated() && before != 0 && closed > 0 {
+		release := b.isolatedMargin * closed / math.Abs(before)
+		b.isolatedMargin -= release
+		b.cash += release
+	}
+	realized := b.applyToPosition(qty, price)
+	b.cash += realized - fee
+	if b.isIsolated() {
+		b.cash -= initial
+		b.isolatedMargin += initial
+	}
+	b.fills = append(b.fills, Fill{
+		OrderID:     o.ID,
+		Symbol:      o.Symbol,
+		Side:        o.Side,
+		Time:        t,
+		Price:       price,
+		Quantity:    quantity,
+		Fee:         fee,
+		Maker:       maker,
+		RealizedPnL: realized,
+	})
+	return true
+}
@@ -0,0 +1,83 @@
+package backtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/funding"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+func dayBar(openTime time.Time) *klines.KLine {
+	return &klines.KLine{
+		OpenTime:   openTime,
+		CloseTime:  openTime.Add(24*time.Hour - time.Millisecond),
+		OpenPrice:  100,
+		HighPrice:  101,
+		LowPrice:   99,
+		ClosePrice: 100,
+		Volume:     10,
+	}
+}
+
+// TestFundingWithinDailyBar checks the funding events of a 1d bar are charged to
+// the position held at their time, not the one at the bar open.
+func TestFundingWithinDailyBar(t *testing.T) {
+	day0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day1 := day0.Add(24 * time.Hour)
+	var rates []funding.FundingRate
+	for h := 0; h < 24; h += 8 {
+		rates = append(rates, funding.FundingRate{
+			Symbol: "BTCUSDT", FundingTime: day1.Add(time.Duration(h) * time.Hour), FundingRate: 0.0001, MarkPrice: 100,
+		})
+	}
+	tests := []struct {
+		name  string
+		start float64          // Position held before day1.
+		side  common.OrderSide // Of the market order filled at the open of day1.
+		qty   float64
+		want  []string // Funding payments as "hour position".
+	}{
+		// Filled at the 00:00 open, after the 00:00 event.
+		{name: "open", side: common.OrderSide_BUY, qty: 1, want: []string{"8 1", "16 1"}},
+		{name: "close", start: 1, side: common.OrderSide_SELL, qty: 1, want: []string{"0 1"}},
+		{name: "flip", start: 1, side: common.OrderSide_SELL, qty: 2, want: []string{"0 1", "8 -1", "16 -1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewSimBroker(BrokerConfig{
+				Symbol:      "BTCUSDT",
+				InitialCash: 10000,
+				Futures:     &FuturesConfig{MarginType: common.MarginType_CROSSED, Leverage: 10, FundingRates: rates},
+			})
+			if err != nil {
+				t.Fatalf("NewSimBroker: %v", err)
+			}
+			market := func(side common.OrderSide, qty float64) {
+				if _, err := b.Submit(orders.Order{Symbol: "BTCUSDT", Side: side, Type: common.OrderType_MARKET, Quantity: qty}); err != nil {
+					t.Fatalf("Submit: %v", err)
+				}
+			}
+			if tt.start != 0 {
+				market(common.OrderSide_BUY, tt.start)
+			}
+			b.ProcessBar(dayBar(day0))
+			market(tt.side, tt.qty)
+			b.ProcessBar(dayBar(day1))
+
+			var got []string
+			for _, p := range b.FundingPayments() {
+				got = append(got, fmt.Sprintf("%d %v", int(p.Time.Sub(day1).Hours()), p.Position))
+				if want := -p.Position * 100 * 0.0001; p.Amount != want {
+					t.Errorf("payment at %v of %v, want %v", p.Time, p.Amount, want)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("funding payments %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
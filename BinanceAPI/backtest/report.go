@@ -0,0 +1,397 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+const year = 365 * 24 * time.Hour
+
+// Trade is one round trip, from leaving flat to being flat again (or flipping sides).
+type Trade struct {
+	Symbol     string
+	Side       common.OrderSide // BUY for long, SELL for short.
+	EntryTime  time.Time
+	ExitTime   time.Time
+	Quantity   float64 // Max absolute position held during the trade.
+	EntryPrice float64 // Average entry price.
+	ExitPrice  float64 // Average exit price.
+	Fees       float64
+	Funding    float64 // Positive when received.
+	PnL        float64 // Realized PnL net of fees and funding.
+	Liquidated bool
+}
+
+type Report struct {
+	Start                time.Time // Open time of the first bar.
+	End                  time.Time // Close time of the last bar.
+	InitialEquity        float64
+	FinalEquity          float64
+	TotalReturn          float64
+	AnnualizedReturn     float64
+	AnnualizedVolatility float64
+	Sharpe               float64 // Risk free rate is taken as 0.
+	Sortino              float64
+	Calmar               float64
+	MaxDrawdown          float64 // As a positive fraction of the peak equity.
+	MaxDrawdownDuration  time.Duration
+	TradeNum             int
+	WinRate              float64
+	ProfitFactor         float64 // Gross profit / gross loss of trades; +Inf without losing trades.
+	Exposure             float64 // Fraction of bars ending with an open position.
+	Turnover             float64 // Traded notional / average equity.
+	FeesPaid             float64
+	FundingPaid          float64 // Net funding paid, negative when received.
+	Trades               []Trade
+}
+
+// NewReport computes the report from the engine's equity curve and fills.
+func NewReport(res *Result) *Report {
+	r := &Report{InitialEquity: res.InitialEquity, FinalEquity: res.InitialEquity}
+	curve := res.EquityCurve
+	if len(curve) == 0 {
+		return r
+	}
+	// A curve of n bars spans n bar durations, not n-1.
+	r.Start = res.Start
+	if r.Start.IsZero() {
+		r.Start = curve[0].Time
+	}
+	r.End = curve[len(curve)-1].Time
+	r.FinalEquity = curve[len(curve)-1].Equity
+	if r.InitialEquity > 0 {
+		r.TotalReturn = r.FinalEquity/r.InitialEquity - 1
+		if years := float64(r.End.Sub(r.Start)) / float64(year); years > 0 && r.FinalEquity > 0 {
+			r.AnnualizedReturn = math.Pow(r.FinalEquity/r.InitialEquity, 1/years) - 1
+		}
+	}
+
+	r.computeRiskMetrics(res)
+	r.computeDrawdown(res)
+	r.Trades = buildTrades(res)
+	r.computeTradeMetrics(res)
+	return r
+}
+
+// barsPerYear estimates how many bars make a year from the median bar spacing.
+func barsPerYear(curve []EquityPoint) float64 {
+	if len(curve) < 2 {
+		return 0
+	}
+	gaps := make([]time.Duration, 0, len(curve)-1)
+	for idx := 1; idx < len(curve); idx++ {
+		gaps = append(gaps, curve[idx].Time.Sub(curve[idx-1].Time))
+	}
+	slices.Sort(gaps)
+	median := gaps[len(gaps)/2]
+	if median <= 0 {
+		return 0
+	}
+	return float64(year) / float64(median)
+}
+
+func (r *Report) computeRiskMetrics(res *Result) {
+	curve := res.EquityCurve
+	prev := res.InitialEquity
+	var rets []float64
+	for _, p := range curve {
+		if prev > 0 {
+			rets = append(rets, p.Equity/prev-1)
+		}
+		prev = p.Equity
+	}
+	if len(rets) < 2 {
+		return
+	}
+	var mean float64
+	for _, v := range rets {
+		mean += v
+	}
+	mean /= float64(len(rets))
+	var variance, downside float64
+	for _, v := range rets {
+		variance += (v - mean) * (v - mean)
+		if v < 0 {
+			downside += v * v
+		}
+	}
+	std := math.Sqrt(variance / float64(len(rets)-1))
+	downsideDev := math.Sqrt(downside / float64(len(rets)))
+
+	annual := math.Sqrt(barsPerYear(curve))
+	r.AnnualizedVolatility = std * annual
+	if std > 0 {
+		r.Sharpe = mean / std * annual
+	}
+	if downsideDev > 0 {
+		r.Sortino = mean / downsideDev * annual
+	}
+}
+
+func (r *Report) computeDrawdown(res *Result) {
+	peak, peakTime := res.InitialEquity, res.EquityCurve[0].Time
+	for _, p := range res.EquityCurve {
+		if p.Equity >= peak {
+			peak, peakTime = p.Equity, p.Time
+			continue
+		}
+		if peak > 0 {
+			r.MaxDrawdown = math.Max(r.MaxDrawdown, 1-p.Equity/peak)
+		}
+		if d := p.Time.Sub(peakTime); d > r.MaxDrawdownDuration {
+			r.MaxDrawdownDuration = d
+		}
+	}
+	if r.MaxDrawdown > 0 {
+		r.Calmar = r.AnnualizedReturn / r.MaxDrawdown
+	}
+}
+
+func (r *Report) computeTradeMetrics(res *Result) {
+	var grossProfit, grossLoss, traded, equitySum float64
+	wins := 0
+	for _, t := range r.Trades {
+		if t.PnL > 0 {
+			wins++
+			grossProfit += t.PnL
+		} else {
+			grossLoss -= t.PnL
+		}
+	}
+	r.TradeNum = len(r.Trades)
+	if r.TradeNum > 0 {
+		r.WinRate = float64(wins) / float64(r.TradeNum)
+		if grossLoss > 0 {
+			r.ProfitFactor = grossProfit / grossLoss
+		} else {
+			r.ProfitFactor = math.Inf(1)
+		}
+	}
+
+	for _, f := range res.Fills {
+		r.FeesPaid += f.Fee
+		traded += f.Price * f.Quantity
+	}
+	for _, f := range res.Funding {
+		r.FundingPaid -= f.Amount
+	}
+	exposed := 0
+	for _, p := range res.EquityCurve {
+		if p.Position != 0 {
+			exposed++
+		}
+		equitySum += p.Equity
+	}
+	r.Exposure = float64(exposed) / float64(len(res.EquityCurve))
+	if avg := equitySum / float64(len(res.EquityCurve)); avg > 0 {
+		r.Turnover = traded / avg
+	}
+}
+
+// buildTrades replays the fills into round trips. Funding payments are attributed
+// to the trade open at their time.
+func buildTrades(res *Result) []Trade {
+	var trades []Trade
+	var cur *Trade
+	var pos, entryCost, exitValue, exitQty float64
+	fundingIdx := 0
+
+	takeFunding := func(until time.Time) {
+		for fundingIdx < len(res.Funding) && !res.Funding[fundingIdx].Time.After(until) {
+			if cur != nil {
+				cur.Funding += res.Funding[fundingIdx].Amount
+				cur.PnL += res.Funding[fundingIdx].Amount
+			}
+			fundingIdx++
+		}
+	}
+	closeTrade := func(t time.Time) {
+		cur.ExitTime = t
+		if exitQty > 0 {
+			cur.ExitPrice = exitValue / exitQty
+		}
+		trades = append(trades, *cur)
+		cur, entryCost, exitValue, exitQty = nil, 0, 0, 0
+	}
+
+	for _, f := range res.Fills {
+		takeFunding(f.Time)
+		qty := f.Side.Sign() * f.Quantity
+		remaining := qty
+		if cur != nil && pos*qty < 0 {
+			closing := math.Min(math.Abs(qty), math.Abs(pos))
+			exitValue += closing * f.Price
+			exitQty += closing
+			share := closing / math.Abs(qty)
+			cur.Fees += f.Fee * share
+			cur.PnL += f.RealizedPnL - f.Fee*share
+			cur.Liquidated = cur.Liquidated || f.Liquidation
+			pos += math.Copysign(closing, qty)
+			remaining = qty - math.Copysign(closing, qty)
+			if pos == 0 {
+				closeTrade(f.Time)
+			}
+		}
+		if remaining == 0 {
+			continue
+		}
+		fee := f.Fee * math.Abs(remaining) / math.Abs(qty)
+		if cur == nil {
+			side := common.OrderSide_BUY
+			if remaining < 0 {
+				side = common.OrderSide_SELL
+			}
+			cur = &Trade{Symbol: f.Symbol, Side: side, EntryTime: f.Time}
+		}
+		pos += remaining
+		entryCost += math.Abs(remaining) * f.Price
+		cur.Fees += fee
+		cur.PnL -= fee
+		cur.Quantity = math.Max(cur.Quantity, math.Abs(pos))
+		cur.EntryPrice = entryCost / (math.Abs(pos) + exitQty)
+	}
+	if cur != nil {
+		if len(res.EquityCurve) > 0 {
+			takeFunding(res.EquityCurve[len(res.EquityCurve)-1].Time)
+		}
+		trades = append(trades, *cur) // Still open, ExitTime stays zero.
+	}
+	return trades
+}
+
+// WriteJSON writes the whole report including the trade log.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// JSON has no infinity, report an unbounded profit factor as 0 like a missing value.
+	cp := *r
+	if math.IsInf(cp.ProfitFactor, 0) {
+		cp.ProfitFactor = 0
+	}
+	return enc.Encode(&cp)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (r *Report) metrics() [][2]string {
+	return [][2]string{
+		{"Start", r.Start.UTC().Format(time.RFC3339)},
+		{"End", r.End.UTC().Format(time.RFC3339)},
+		{"InitialEquity", formatFloat(r.InitialEquity)},
+		{"FinalEquity", formatFloat(r.FinalEquity)},
+		{"TotalReturn", formatFloat(r.TotalReturn)},
+		{"AnnualizedReturn", formatFloat(r.AnnualizedReturn)},
+		{"AnnualizedVolatility", formatFloat(r.AnnualizedVolatility)},
+		{"Sharpe", formatFloat(r.Sharpe)},
+		{"Sortino", formatFloat(r.Sortino)},
+		{"Calmar", formatFloat(r.Calmar)},
+		{"MaxDrawdown", formatFloat(r.MaxDrawdown)},
+		{"MaxDrawdownDuration", r.MaxDrawdownDuration.String()},
+		{"TradeNum", strconv.Itoa(r.TradeNum)},
+		{"WinRate", formatFloat(r.WinRate)},
+		{"ProfitFactor", formatFloat(r.ProfitFactor)},
+		{"Exposure", formatFloat(r.Exposure)},
+		{"Turnover", formatFloat(r.Turnover)},
+		{"FeesPaid", formatFloat(r.FeesPaid)},
+		{"FundingPaid", formatFloat(r.FundingPaid)},
+	}
+}
+
+// WriteMetricsCSV writes one "Metric,Value" row per metric.
+func (r *Report) WriteMetricsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Metric", "Value"}); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, m := range r.metrics() {
+		if err := cw.Write(m[:]); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var TradeCSVHeader = []string{
+	"Symbol",
+	"Side",
+	"EntryTime",
+	"ExitTime",
+	"Quantity",
+	"EntryPrice",
+	"ExitPrice",
+	"Fees",
+	"Funding",
+	"PnL",
+	"Liquidated",
+}
+
+// WriteTradesCSV writes the trade log with times in unix milliseconds; an open
+// trade has an empty exit time.
+func (r *Report) WriteTradesCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(TradeCSVHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, t := range r.Trades {
+		exitTime := ""
+		if !t.ExitTime.IsZero() {
+			exitTime = strconv.FormatInt(t.ExitTime.UnixMilli(), 10)
+		}
+		record := []string{
+			t.Symbol,
+			string(t.Side),
+			strconv.FormatInt(t.EntryTime.UnixMilli(), 10),
+			exitTime,
+			formatFloat(t.Quantity),
+			formatFloat(t.EntryPrice),
+			formatFloat(t.ExitPrice),
+			formatFloat(t.Fees),
+			formatFloat(t.Funding),
+			formatFloat(t.PnL),
+			strconv.FormatBool(t.Liquidated),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteText writes a human readable summary.
+func (r *Report) WriteText(w io.Writer) error {
+	const dateFmt = "2006-01-02 15:04"
+	lines := []string{
+		fmt.Sprintf("Period            %s ~ %s", r.Start.UTC().Format(dateFmt), r.End.UTC().Format(dateFmt)),
+		fmt.Sprintf("Equity            %.2f -> %.2f", r.InitialEquity, r.FinalEquity),
+		fmt.Sprintf("Total return      %.2f%%", r.TotalReturn*100),
+		fmt.Sprintf("Annualized return %.2f%%", r.AnnualizedReturn*100),
+		fmt.Sprintf("Volatility        %.2f%%", r.AnnualizedVolatility*100),
+		fmt.Sprintf("Sharpe            %.2f", r.Sharpe),
+		fmt.Sprintf("Sortino           %.2f", r.Sortino),
+		fmt.Sprintf("Calmar            %.2f", r.Calmar),
+		fmt.Sprintf("Max drawdown      %.2f%% over %v", r.MaxDrawdown*100, r.MaxDrawdownDuration),
+		fmt.Sprintf("Trades            %d (win rate %.2f%%, profit factor %.2f)", r.TradeNum, r.WinRate*100, r.ProfitFactor),
+		fmt.Sprintf("Exposure          %.2f%%", r.Exposure*100),
+		fmt.Sprintf("Turnover          %.2fx", r.Turnover),
+		fmt.Sprintf("Fees paid         %.2f", r.FeesPaid),
+		fmt.Sprintf("Funding paid      %.2f", r.FundingPaid),
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
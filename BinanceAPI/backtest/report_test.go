@@ -0,0 +1,103 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+// TestNewReport checks the report of a hand made run of four bars a quarter of a
+// year long each, so the run spans exactly one year from the first open.
+func TestNewReport(t *testing.T) {
+	const quarter = year / 4
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	closeOf := func(idx int) time.Time { return t0.Add(time.Duration(idx+1)*quarter - time.Millisecond) }
+	res := &Result{
+		Start:         t0,
+		InitialEquity: 100,
+		EquityCurve: []EquityPoint{
+			{Time: closeOf(0), Price: 125, Position: 1, Equity: 125},
+			{Time: closeOf(1), Price: 100, Position: 0, Equity: 100},
+			{Time: closeOf(2), Price: 125, Position: -1, Equity: 125},
+			{Time: closeOf(3), Price: 100, Position: -1, Equity: 150},
+		},
+		Fills: []Fill{
+			{Symbol: "BTCUSDT", Side: common.OrderSide_BUY, Time: t0, Price: 100, Quantity: 1, Fee: 0.1},
+			{Symbol: "BTCUSDT", Side: common.OrderSide_SELL, Time: closeOf(0), Price: 125, Quantity: 1, Fee: 0.1, RealizedPnL: 25},
+			{Symbol: "BTCUSDT", Side: common.OrderSide_SELL, Time: closeOf(1), Price: 100, Quantity: 1, Fee: 0.1},
+		},
+		Funding: []FundingPayment{
+			{Time: closeOf(0).Add(-time.Hour), Position: 1, Amount: -0.5},
+			{Time: closeOf(2).Add(-time.Hour), Position: -1, Amount: 0.05},
+		},
+	}
+	r := NewReport(res)
+
+	// Returns of 25%, -20%, 25% and 20% per bar, at 4 bars a year.
+	rets := []float64{0.25, -0.2, 0.25, 0.2}
+	var mean, variance, downside float64
+	for _, v := range rets {
+		mean += v / 4
+	}
+	for _, v := range rets {
+		variance += (v - mean) * (v - mean) / 3
+		if v < 0 {
+			downside += v * v / 4
+		}
+	}
+	std := math.Sqrt(variance)
+	metrics := []struct {
+		name      string
+		got, want float64
+	}{
+		{"TotalReturn", r.TotalReturn, 0.5},
+		{"AnnualizedReturn", r.AnnualizedReturn, 0.5},
+		{"AnnualizedVolatility", r.AnnualizedVolatility, std * 2},
+		{"Sharpe", r.Sharpe, mean / std * 2},
+		{"Sortino", r.Sortino, mean / math.Sqrt(downside) * 2},
+		{"MaxDrawdown", r.MaxDrawdown, 0.2},
+		{"Calmar", r.Calmar, 0.5 / 0.2},
+		{"WinRate", r.WinRate, 0.5},
+		{"ProfitFactor", r.ProfitFactor, 24.3 / 0.05},
+		{"Exposure", r.Exposure, 0.75},
+		{"FeesPaid", r.FeesPaid, 0.3},
+		{"FundingPaid", r.FundingPaid, 0.45},
+	}
+	for _, m := range metrics {
+		if math.Abs(m.got-m.want) > 1e-6*math.Max(1, math.Abs(m.want)) {
+			t.Errorf("%s = %v, want %v", m.name, m.got, m.want)
+		}
+	}
+	if !r.Start.Equal(t0) || !r.End.Equal(closeOf(3)) {
+		t.Errorf("period %v ~ %v, want %v ~ %v", r.Start, r.End, t0, closeOf(3))
+	}
+	if r.MaxDrawdownDuration != quarter {
+		t.Errorf("MaxDrawdownDuration = %v, want %v", r.MaxDrawdownDuration, quarter)
+	}
+
+	want := []Trade{
+		{
+			Symbol: "BTCUSDT", Side: common.OrderSide_BUY, EntryTime: t0, ExitTime: closeOf(0),
+			Quantity: 1, EntryPrice: 100, ExitPrice: 125, Fees: 0.2, Funding: -0.5, PnL: 24.3,
+		},
+		{
+			Symbol: "BTCUSDT", Side: common.OrderSide_SELL, EntryTime: closeOf(1),
+			Quantity: 1, EntryPrice: 100, Fees: 0.1, Funding: 0.05, PnL: -0.05,
+		},
+	}
+	if len(r.Trades) != len(want) {
+		t.Fatalf("trades %+v, want %+v", r.Trades, want)
+	}
+	for idx, got := range r.Trades {
+		w := want[idx]
+		if math.Abs(got.PnL-w.PnL) > 1e-9 || math.Abs(got.Fees-w.Fees) > 1e-9 || math.Abs(got.Funding-w.Funding) > 1e-9 {
+			t.Errorf("trade %d: %+v, want %+v", idx, got, w)
+		}
+		got.PnL, got.Fees, got.Funding = w.PnL, w.Fees, w.Funding
+		if got != w {
+			t.Errorf("trade %d: %+v, want %+v", idx, got, w)
+		}
+	}
+}
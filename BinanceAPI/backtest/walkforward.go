@@ -0,0 +1,342 @@
+package backtest
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// Params is one point of the parameter space, keyed by parameter name.
+type Params map[string]float64
+
+func (p Params) String() string {
+	keys := slices.Sorted(maps.Keys(p))
+	parts := make([]string, len(keys))
+	for idx, k := range keys {
+		parts[idx] = fmt.Sprintf("%s=%v", k, p[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParamRange lists the values a parameter takes in a grid search.
+type ParamRange struct {
+	Name   string
+	Values []float64
+}
+
+// GridSearch returns the cartesian product of all ranges.
+func GridSearch(ranges ...ParamRange) []Params {
+	res := []Params{{}}
+	for _, r := range ranges {
+		next := make([]Params, 0, len(res)*len(r.Values))
+		for _, p := range res {
+			for _, v := range r.Values {
+				cp := maps.Clone(p)
+				cp[r.Name] = v
+				next = append(next, cp)
+			}
+		}
+		res = next
+	}
+	return res
+}
+
+// ParamBound is the [Min, Max] interval a parameter is sampled from in a random search.
+type ParamBound struct {
+	Name    string
+	Min     float64
+	Max     float64
+	Integer bool // Round the sampled value, e.g. for window lengths.
+}
+
+// RandomSearch samples n points uniformly from the bounds. The same seed always
+// yields the same points.
+func RandomSearch(n int, seed int64, bounds ...ParamBound) []Params {
+	rng := rand.New(rand.NewSource(seed))
+	res := make([]Params, n)
+	for idx := range res {
+		p := Params{}
+		for _, b := range bounds {
+			v := b.Min + rng.Float64()*(b.Max-b.Min)
+			if b.Integer {
+				v = math.Round(v)
+			}
+			p[b.Name] = v
+		}
+		res[idx] = p
+	}
+	return res
+}
+
+// StrategyFactory builds a fresh strategy trading on the broker with the params.
+type StrategyFactory func(b *SimBroker, p Params) (Strategy, error)
+
+// WalkForwardConfig splits the history into rolling folds of InSample followed by
+// OutOfSample, advancing by Step. The defaults mirror the 20 / 3 day split of
+// MLTrader/prepare_dataset/prepare.py.
+type WalkForwardConfig struct {
+	Broker      BrokerConfig
+	InSample    time.Duration           // Defaults to 20 days.
+	OutOfSample time.Duration           // Defaults to 3 days.
+	Step        time.Duration           // Defaults to OutOfSample, i.e. non-overlapping test windows.
+	WarmupBars  int                     // Bars before each window fed to a Warmer strategy, defaults to 500.
+	Workers     int                     // Defaults to the number of CPUs.
+	Objective   func(r *Report) float64 // Higher is better, defaults to the Sharpe ratio.
+}
+
+func (c *WalkForwardConfig) setDefaults() {
+	if c.InSample <= 0 {
+		c.InSample = 20 * 24 * time.Hour
+	}
+	if c.OutOfSample <= 0 {
+		c.OutOfSample = 3 * 24 * time.Hour
+	}
+	if c.Step <= 0 {
+		c.Step = c.OutOfSample
+	}
+	if c.WarmupBars <= 0 {
+		c.WarmupBars = 500
+	}
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if c.Objective == nil {
+		c.Objective = func(r *Report) float64 { return r.Sharpe }
+	}
+}
+
+type FoldResult struct {
+	Index            int
+	InSampleStart    time.Time
+	OutOfSampleStart time.Time
+	OutOfSampleEnd   time.Time
+	Best             Params
+	InSampleScore    float64
+	OutOfSampleScore float64
+	InSample         *Report // Report of the best params in sample.
+	OutOfSample      *Report // Report of the best params out of sample.
+}
+
+type fold struct {
+	idx                 int
+	isStart, oosStart   time.Time
+	oosEnd              time.Time
+	inSample, outSample []klines.KLine
+	isWarmup, oosWarmup []klines.KLine // The bars right before each window.
+}
+
+// barsWithin returns the bars opening within [from, to).
+func barsWithin(bars []klines.KLine, from, to time.Time) []klines.KLine {
+	lo, _ := slices.BinarySearchFunc(bars, from, func(l klines.KLine, t time.Time) int { return l.OpenTime.Compare(t) })
+	hi, _ := slices.BinarySearchFunc(bars, to, func(l klines.KLine, t time.Time) int { return l.OpenTime.Compare(t) })
+	return bars[lo:hi]
+}
+
+// barsBefore returns the last n bars opening before t.
+func barsBefore(bars []klines.KLine, t time.Time, n int) []klines.KLine {
+	hi, _ := slices.BinarySearchFunc(bars, t, func(l klines.KLine, t time.Time) int { return l.OpenTime.Compare(t) })
+	return bars[max(hi-n, 0):hi]
+}
+
+func makeFolds(bars []klines.KLine, cfg *WalkForwardConfig) []fold {
+	if len(bars) == 0 {
+		return nil
+	}
+	var folds []fold
+	last := bars[len(bars)-1].OpenTime
+	for start := bars[0].OpenTime; !start.Add(cfg.InSample + cfg.OutOfSample).After(last.Add(time.Millisecond)); start = start.Add(cfg.Step) {
+		oosStart := start.Add(cfg.InSample)
+		oosEnd := oosStart.Add(cfg.OutOfSample)
+		folds = append(folds, fold{
+			idx:       len(folds),
+			isStart:   start,
+			oosStart:  oosStart,
+			oosEnd:    oosEnd,
+			inSample:  barsWithin(bars, start, oosStart),
+			outSample: barsWithin(bars, oosStart, oosEnd),
+			isWarmup:  barsBefore(bars, start, cfg.WarmupBars),
+			oosWarmup: barsBefore(bars, oosStart, cfg.WarmupBars),
+		})
+	}
+	return folds
+}
+
+// runOne backtests the params over the bars and returns the report. A Warmer
+// strategy first gets the warmup bars, so that it can trade from the first bar.
+func runOne(
+	ctx context.Context, cfg *WalkForwardConfig, warmup, bars []klines.KLine, p Params, factory StrategyFactory,
+) (*Report, error) {
+	e, err := NewEngine(cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	s, err := factory(e.Broker(), p)
+	if err != nil {
+		return nil, fmt.Errorf("factory(%v): %w", p, err)
+	}
+	if w, ok := s.(Warmer); ok && len(warmup) > 0 {
+		w.Warmup(warmup)
+	}
+	res, err := e.Run(ctx, bars, s)
+	if err != nil {
+		return nil, fmt.Errorf("Run(%v): %w", p, err)
+	}
+	return NewReport(res), nil
+}
+
+// parallel runs job(0) ... job(n-1) on the given number of workers and returns the
+// first error, cancelling the remaining jobs.
+func parallel(ctx context.Context, workers, n int, job func(ctx context.Context, idx int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				if err := job(ctx, idx); err != nil {
+					once.Do(func() { firstErr = err; cancel() })
+				}
+			}
+		}()
+	}
+LOOP:
+	for idx := range n {
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+			break LOOP
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// WalkForward picks the best candidate on each in-sample window and reports how it
+// does on the following out-of-sample window, which a Warmer strategy enters with
+// the tail of the in-sample bars as history. Every (fold, candidate) backtest runs
+// in parallel across cfg.Workers goroutines.
+func WalkForward(
+	ctx context.Context, bars []klines.KLine, cfg WalkForwardConfig,
+	candidates []Params, factory StrategyFactory,
+) ([]FoldResult, error) {
+	cfg.setDefaults()
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate params: %w", ErrInvalidConfig)
+	}
+	folds := makeFolds(bars, &cfg)
+	if len(folds) == 0 {
+		return nil, fmt.Errorf("%d bars do not cover one %v + %v fold: %w",
+			len(bars), cfg.InSample, cfg.OutOfSample, ErrInvalidConfig)
+	}
+
+	// In sample: every candidate on every fold.
+	inSample := make([]*Report, len(folds)*len(candidates))
+	err := parallel(ctx, cfg.Workers, len(inSample), func(ctx context.Context, idx int) error {
+		f, p := &folds[idx/len(candidates)], candidates[idx%len(candidates)]
+		r, err := runOne(ctx, &cfg, f.isWarmup, f.inSample, p, factory)
+		if err != nil {
+			return fmt.Errorf("fold %d in sample: %w", f.idx, err)
+		}
+		inSample[idx] = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]FoldResult, len(folds))
+	for fi, f := range folds {
+		res := &results[fi]
+		*res = FoldResult{
+			Index:            f.idx,
+			InSampleStart:    f.isStart,
+			OutOfSampleStart: f.oosStart,
+			OutOfSampleEnd:   f.oosEnd,
+			InSampleScore:    math.Inf(-1),
+		}
+		for ci, p := range candidates {
+			r := inSample[fi*len(candidates)+ci]
+			if score := cfg.Objective(r); score > res.InSampleScore || res.Best == nil {
+				res.Best, res.InSampleScore, res.InSample = p, score, r
+			}
+		}
+	}
+
+	// Out of sample: only the winner of each fold.
+	err = parallel(ctx, cfg.Workers, len(folds), func(ctx context.Context, idx int) error {
+		r, err := runOne(ctx, &cfg, folds[idx].oosWarmup, folds[idx].outSample, results[idx].Best, factory)
+		if err != nil {
+			return fmt.Errorf("fold %d out of sample: %w", idx, err)
+		}
+		results[idx].OutOfSample = r
+		results[idx].OutOfSampleScore = cfg.Objective(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+var FoldCSVHeader = []string{
+	"Fold",
+	"InSampleStart",
+	"OutOfSampleStart",
+	"OutOfSampleEnd",
+	"Params",
+	"InSampleScore",
+	"OutOfSampleScore",
+	"InSampleReturn",
+	"OutOfSampleReturn",
+	"OutOfSampleMaxDrawdown",
+	"OutOfSampleTradeNum",
+}
+
+// WriteFoldsCSV writes one row per fold; a large gap between the in-sample and
+// out-of-sample columns is the sign of overfitting.
+func WriteFoldsCSV(w io.Writer, folds []FoldResult) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(FoldCSVHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, f := range folds {
+		record := []string{
+			fmt.Sprint(f.Index),
+			f.InSampleStart.UTC().Format(time.RFC3339),
+			f.OutOfSampleStart.UTC().Format(time.RFC3339),
+			f.OutOfSampleEnd.UTC().Format(time.RFC3339),
+			f.Best.String(),
+			formatFloat(f.InSampleScore),
+			formatFloat(f.OutOfSampleScore),
+			formatFloat(f.InSample.TotalReturn),
+			formatFloat(f.OutOfSample.TotalReturn),
+			formatFloat(f.OutOfSample.MaxDrawdown),
+			fmt.Sprint(f.OutOfSample.TradeNum),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
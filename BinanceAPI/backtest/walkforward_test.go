@@ -0,0 +1,223 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/depth"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// testMarket returns hourly bars with the trades and snapshots they were built from.
+func testMarket(start time.Time, hours int) ([]klines.KLine, []aggtrades.AggTrade, []depth.Snapshot) {
+	var bars []klines.KLine
+	var trades []aggtrades.AggTrade
+	var snapshots []depth.Snapshot
+	for h := range hours {
+		open := start.Add(time.Duration(h) * time.Hour)
+		bar := klines.KLine{OpenTime: open, CloseTime: open.Add(time.Hour - time.Millisecond)}
+		for m := 0; m < 60; m += 10 {
+			t := open.Add(time.Duration(m) * time.Minute)
+			price := math.Round(100 + 5*math.Sin(float64(h*6+m/10)/7))
+			snapshots = append(snapshots, depth.Snapshot{
+				Time: t,
+				Bids: []depth.PriceLevel{{Price: price - 1, Quantity: 2}, {Price: price - 2, Quantity: 5}},
+				Asks: []depth.PriceLevel{{Price: price + 1, Quantity: 2}, {Price: price + 2, Quantity: 5}},
+			})
+			trades = append(trades, aggtrades.AggTrade{
+				ID: int64(len(trades)), Price: price, Quantity: 3, Time: t.Add(time.Second), BuyerMaker: m%20 == 0,
+			})
+			if m == 0 {
+				bar.OpenPrice, bar.HighPrice, bar.LowPrice = price, price, price
+			}
+			bar.HighPrice = math.Max(bar.HighPrice, price)
+			bar.LowPrice = math.Min(bar.LowPrice, price)
+			bar.ClosePrice = price
+			bar.Volume += 3
+		}
+		bars = append(bars, bar)
+	}
+	return bars, trades, snapshots
+}
+
+// quoteStrategy rests a limit order one tick away from the close, buying when flat
+// and selling when long.
+type quoteStrategy struct {
+	b   *SimBroker
+	qty float64
+}
+
+func (s *quoteStrategy) OnBar(ctx context.Context, bar klines.KLine) error {
+	s.b.CancelAll()
+	o := orders.Order{Symbol: "BTCUSDT", Type: common.OrderType_LIMIT, Quantity: s.qty}
+	if s.b.Position().Quantity <= 0 {
+		o.Side, o.Price = common.OrderSide_BUY, bar.ClosePrice-1
+	} else {
+		o.Side, o.Price, o.Quantity = common.OrderSide_SELL, bar.ClosePrice+1, s.b.Position().Quantity
+	}
+	_, err := s.b.Submit(o)
+	return err
+}
+
+// TestWalkForwardBookFillModel runs parallel backtests with a stateful fill model,
+// which must neither race nor leak queue positions across runs: the folds come
+// out the same whatever the number of workers.
+func TestWalkForwardBookFillModel(t *testing.T) {
+	bars, trades, snapshots := testMarket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 10*24)
+	candidates := GridSearch(ParamRange{Name: "qty", Values: []float64{0.5, 1, 2, 4}})
+	factory := func(b *SimBroker, p Params) (Strategy, error) {
+		return &quoteStrategy{b: b, qty: p["qty"]}, nil
+	}
+	run := func(workers int) ([]string, int) {
+		cfg := WalkForwardConfig{
+			Broker: BrokerConfig{
+				Symbol:      "BTCUSDT",
+				InitialCash: 10000,
+				NewFillModel: func() FillModel {
+					return NewBookFillModel(trades, snapshots, nil)
+				},
+			},
+			InSample:    3 * 24 * time.Hour,
+			OutOfSample: 24 * time.Hour,
+			Workers:     workers,
+		}
+		folds, err := WalkForward(context.Background(), bars, cfg, candidates, factory)
+		if err != nil {
+			t.Fatalf("WalkForward(workers=%d): %v", workers, err)
+		}
+		res, tradeNum := make([]string, len(folds)), 0
+		for idx, f := range folds {
+			tradeNum += f.InSample.TradeNum + f.OutOfSample.TradeNum
+			res[idx] = fmt.Sprintf("%d %v in(%v %d) out(%v %d)", f.Index, f.Best,
+				f.InSample.FinalEquity, f.InSample.TradeNum, f.OutOfSample.FinalEquity, f.OutOfSample.TradeNum)
+		}
+		return res, tradeNum
+	}
+
+	want, tradeNum := run(1)
+	if tradeNum == 0 {
+		t.Fatalf("no fold traded, the fill model is not exercised: %v", want)
+	}
+	for range 3 {
+		got, _ := run(8)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("8 workers:\n%v\nwant as 1 worker:\n%v", got, want)
+		}
+	}
+}
+
+// smaStrategy goes long while the fast SMA of the close is above the slow one and
+// flat otherwise.
+type smaStrategy struct {
+	b          *SimBroker
+	fast, slow int
+	closes     []float64
+}
+
+func (s *smaStrategy) push(bar klines.KLine) {
+	s.closes = append(s.closes, bar.ClosePrice)
+	if len(s.closes) > s.slow {
+		s.closes = s.closes[len(s.closes)-s.slow:]
+	}
+}
+
+func (s *smaStrategy) OnBar(ctx context.Context, bar klines.KLine) error {
+	s.push(bar)
+	if len(s.closes) < s.slow {
+		return nil
+	}
+	var fast, slow float64
+	for idx, c := range s.closes {
+		slow += c / float64(s.slow)
+		if idx >= s.slow-s.fast {
+			fast += c / float64(s.fast)
+		}
+	}
+	pos := s.b.Position().Quantity
+	o := orders.Order{Symbol: "BTCUSDT", Type: common.OrderType_MARKET}
+	switch {
+	case fast > slow && pos <= 0:
+		o.Side, o.Quantity = common.OrderSide_BUY, 1-pos
+	case fast <= slow && pos > 0:
+		o.Side, o.Quantity = common.OrderSide_SELL, pos
+	default:
+		return nil
+	}
+	_, err := s.b.Submit(o)
+	return err
+}
+
+// warmSMAStrategy also takes warmup bars.
+type warmSMAStrategy struct{ smaStrategy }
+
+func (s *warmSMAStrategy) Warmup(bars []klines.KLine) {
+	for _, bar := range bars {
+		s.push(bar)
+	}
+}
+
+// TestWalkForwardWarmup checks that a Warmer strategy enters each out-of-sample
+// window with the in-sample history, so it trades from the first bar on.
+func TestWalkForwardWarmup(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var bars []klines.KLine
+	for h := range 4 * 24 {
+		open := start.Add(time.Duration(h) * time.Hour)
+		price := 100 + float64(h)
+		bars = append(bars, klines.KLine{
+			OpenTime: open, CloseTime: open.Add(time.Hour - time.Millisecond),
+			OpenPrice: price, HighPrice: price + 1, LowPrice: price - 1, ClosePrice: price + 0.5, Volume: 10,
+		})
+	}
+	const slow = 10
+	cases := []struct {
+		name    string
+		factory StrategyFactory
+		// Bars of the out-of-sample window before the first entry.
+		wantDelay int
+	}{
+		{
+			name: "warmer",
+			factory: func(b *SimBroker, p Params) (Strategy, error) {
+				return &warmSMAStrategy{smaStrategy{b: b, fast: 3, slow: slow}}, nil
+			},
+			wantDelay: 1,
+		},
+		{
+			name: "no warmup",
+			factory: func(b *SimBroker, p Params) (Strategy, error) {
+				return &smaStrategy{b: b, fast: 3, slow: slow}, nil
+			},
+			wantDelay: slow,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := WalkForwardConfig{
+				Broker:      BrokerConfig{Symbol: "BTCUSDT", InitialCash: 1e6},
+				InSample:    2 * 24 * time.Hour,
+				OutOfSample: 24 * time.Hour,
+			}
+			folds, err := WalkForward(context.Background(), bars, cfg, []Params{{}}, c.factory)
+			if err != nil {
+				t.Fatalf("WalkForward: %v", err)
+			}
+			for _, f := range folds {
+				trades := f.OutOfSample.Trades
+				if len(trades) == 0 {
+					t.Fatalf("fold %d: no out-of-sample trade", f.Index)
+				}
+				want := f.OutOfSampleStart.Add(time.Duration(c.wantDelay) * time.Hour)
+				if got := trades[0].EntryTime; !got.Equal(want) {
+					t.Errorf("fold %d: first entry at %v, want %v", f.Index, got.UTC(), want.UTC())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/stream"
+)
+
+const (
+	// Listen keys expire after 60 minutes without keepalive.
+	listenKeyKeepAlive = 30 * time.Minute
+	reconnectDelay     = time.Second
+	maxReconnectDelay  = time.Minute
+)
+
+// Binance trades on the Binance USDⓈ-M futures account of the credentials, in
+// one-way position mode. Fills are only delivered while Run is running.
+type Binance struct {
+	creds common.Credentials
+	asset string
+	// streamOrderUpdates is stream.StreamOrderUpdates, replaced by offline tests.
+	streamOrderUpdates func(ctx context.Context, listenKey string, handle func(stream.OrderUpdate) error) error
+
+	mu           sync.Mutex
+	fillHandlers map[int]func(Fill)
+	nextHandler  int
+}
+
+func NewBinance(creds common.Credentials, asset string) *Binance {
+	if asset == "" {
+		asset = "USDT"
+	}
+	return &Binance{
+		creds:              creds,
+		asset:              asset,
+		streamOrderUpdates: stream.StreamOrderUpdates,
+		fillHandlers:       map[int]func(Fill){},
+	}
+}
+
+func (b *Binance) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	info, err := orders.PlaceOrder(ctx, b.creds, o)
+	if err != nil {
+		return 0, fmt.Errorf("PlaceOrder: %w", err)
+	}
+	return info.OrderID, nil
+}
+
+func (b *Binance) AmendOrder(ctx context.Context, symbol string, id int64, quantity, price float64) error {
+	// The API wants the side of the order to modify.
+	open, err := orders.ListOpenOrders(ctx, b.creds, symbol)
+	if err != nil {
+		return fmt.Errorf("ListOpenOrders: %w", err)
+	}
+	idx := slices.IndexFunc(open, func(o orders.OrderInfo) bool { return o.OrderID == id })
+	if idx < 0 {
+		return fmt.Errorf("order %d of %q not open", id, symbol)
+	}
+	if _, err := orders.ModifyOrder(ctx, b.creds, symbol, id, open[idx].Side, quantity, price); err != nil {
+		return fmt.Errorf("ModifyOrder: %w", err)
+	}
+	return nil
+}
+
+func (b *Binance) CancelOrder(ctx context.Context, symbol string, id int64) error {
+	if _, err := orders.CancelOrder(ctx, b.creds, symbol, id); err != nil {
+		return fmt.Errorf("CancelOrder: %w", err)
+	}
+	return nil
+}
+
+func (b *Binance) OpenOrders(ctx context.Context, symbol string) ([]OpenOrder, error) {
+	open, err := orders.ListOpenOrders(ctx, b.creds, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("ListOpenOrders: %w", err)
+	}
+	res := make([]OpenOrder, len(open))
+	for idx, o := range open {
+		res[idx] = OpenOrder{
+			Order: orders.Order{
+				ClientOrderID: o.ClientOrderID,
+				Symbol:        o.Symbol,
+				Side:          o.Side,
+				Type:          o.Type,
+				Quantity:      o.Quantity,
+				Price:         o.Price,
+				StopPrice:     o.StopPrice,
+				ReduceOnly:    o.ReduceOnly,
+			},
+			ID:             o.OrderID,
+			Status:         o.Status,
+			FilledQuantity: o.ExecutedQuantity,
+		}
+	}
+	return res, nil
+}
+
+func (b *Binance) Position(ctx context.Context, symbol string) (orders.Position, error) {
+	positions, err := orders.ListPositions(ctx, b.creds, symbol)
+	if err != nil {
+		return orders.Position{}, fmt.Errorf("ListPositions: %w", err)
+	}
+	for _, p := range positions {
+		if p.Symbol == symbol {
+			return p, nil
+		}
+	}
+	return orders.Position{Symbol: symbol}, nil
+}
+
+func (b *Binance) Balance(ctx context.Context) (Balance, error) {
+	balances, err := orders.ListBalances(ctx, b.creds)
+	if err != nil {
+		return Balance{}, fmt.Errorf("ListBalances: %w", err)
+	}
+	for _, bal := range balances {
+		if bal.Asset == b.asset {
+			return Balance{
+				Wallet:    bal.Balance,
+				Equity:    bal.Balance + bal.UnrealizedPnL,
+				Available: bal.AvailableBalance,
+			}, nil
+		}
+	}
+	return Balance{}, fmt.Errorf("asset %q not in the futures wallet: %w", b.asset, ErrUnknownAsset)
+}
+
+func (b *Binance) SubscribeFills(handle func(Fill)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := b.nextHandler
+	b.nextHandler++
+	b.fillHandlers[key] = handle
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.fillHandlers, key)
+	}
+}
+
+func (b *Binance) notifyFill(f Fill) {
+	b.mu.Lock()
+	handlers := make([]func(Fill), 0, len(b.fillHandlers))
+	for _, key := range slices.Sorted(maps.Keys(b.fillHandlers)) {
+		handlers = append(handlers, b.fillHandlers[key])
+	}
+	b.mu.Unlock()
+	for _, handle := range handlers {
+		handle(f)
+	}
+}
+
+// Run listens to the user data stream and delivers fills until ctx is done. It
+// reconnects when the stream drops and keeps the listen key alive. It only gives
+// up when the exchange rejects starting the stream, e.g. for bad credentials;
+// other failures to start it are retried with a growing delay.
+func (b *Binance) Run(ctx context.Context) error {
+	failures := 0 // To start the stream in a row.
+	for {
+		err := b.listen(ctx)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var startErr *startError
+		if !errors.As(err, &startErr) {
+			failures = 0
+		} else if startErr.fatal() {
+			return err
+		} else {
+			failures++
+		}
+		delay := reconnectDelay
+		for range min(failures, 8) - 1 {
+			delay = min(2*delay, maxReconnectDelay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// startError means the user data stream could not be started.
+type startError struct{ err error }
+
+// fatal reports whether the exchange rejected the request, which retrying does
+// not fix. Rate limits and IP bans are lifted in time.
+func (e *startError) fatal() bool {
+	var httpErr *orders.HTTPError
+	return errors.As(e.err, &httpErr) && httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 &&
+		httpErr.StatusCode != http.StatusTooManyRequests && httpErr.StatusCode != http.StatusTeapot
+}
+
+func (e *startError) Error() string { return fmt.Sprintf("StartUserDataStream: %v", e.err) }
+func (e *startError) Unwrap() error { return e.err }
+
+func (b *Binance) listen(ctx context.Context) error {
+	listenKey, err := orders.StartUserDataStream(ctx, b.creds)
+	if err != nil {
+		return &startError{err: err}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		ticker := time.NewTicker(listenKeyKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// The listen key may expire without it, reconnect with a new one.
+				if err := orders.KeepAliveUserDataStream(ctx, b.creds); err != nil {
+					cancel(fmt.Errorf("KeepAliveUserDataStream: %w", err))
+					return
+				}
+			}
+		}
+	}()
+	err = b.streamOrderUpdates(ctx, listenKey, func(u stream.OrderUpdate) error {
+		if u.ExecutionType != "TRADE" {
+			return nil
+		}
+		b.notifyFill(Fill{
+			OrderID:     u.OrderID,
+			Symbol:      u.Symbol,
+			Side:        u.Side,
+			Time:        u.TradeTime,
+			Price:       u.LastFilledPrice,
+			Quantity:    u.LastFilledQuantity,
+			Fee:         u.Commission,
+			Maker:       u.Maker,
+			RealizedPnL: u.RealizedPnL,
+		})
+		return nil
+	})
+	if cause := context.Cause(ctx); cause != nil {
+		return cause
+	}
+	return err
+}
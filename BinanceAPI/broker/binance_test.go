@@ -0,0 +1,364 @@
+package broker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/brokertest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/stream"
+)
+
+const fakePrice = 100
+
+// fakeOrder is an order as the futures REST API reports it.
+type fakeOrder struct {
+	OrderID     int64  `json:"orderId"`
+	Symbol      string `json:"symbol"`
+	Side        string `json:"side"`
+	Type        string `json:"type"`
+	Status      string `json:"status"`
+	Price       string `json:"price"`
+	OrigQty     string `json:"origQty"`
+	ExecutedQty string `json:"executedQty"`
+	ReduceOnly  bool   `json:"reduceOnly"`
+}
+
+// fakeExchange serves the futures REST endpoints the Binance broker calls for a
+// one-way account trading at a fixed price. Market orders fill at once and their
+// fills are sent to the user data stream.
+type fakeExchange struct {
+	mu       sync.Mutex
+	nextID   int64
+	open     map[int64]*fakeOrder
+	position float64
+	wallet   float64
+	updates  chan stream.OrderUpdate
+	// listenKeyFailures are the failures of the next listen key requests: an HTTP
+	// status, or 0 to drop the connection.
+	listenKeyFailures []int
+	listenKeyStarts   int
+}
+
+func newFakeExchange() *fakeExchange {
+	return &fakeExchange{open: map[int64]*fakeOrder{}, wallet: 10000, updates: make(chan stream.OrderUpdate, 100)}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, http.StatusBadRequest, map[string]any{"code": code, "msg": msg})
+}
+
+func (e *fakeExchange) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-MBX-APIKEY") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"code": -2015, "msg": "Invalid API-key."})
+		return
+	}
+	q := r.URL.Query()
+	if r.URL.Path != "/fapi/v1/listenKey" && q.Get("signature") == "" {
+		writeAPIError(w, -1022, "Signature for this request is not valid.")
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch r.Method + " " + r.URL.Path {
+	case "POST /fapi/v1/listenKey":
+		e.listenKeyStarts++
+		if len(e.listenKeyFailures) > 0 {
+			status := e.listenKeyFailures[0]
+			e.listenKeyFailures = e.listenKeyFailures[1:]
+			if status == 0 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			writeJSON(w, status, map[string]any{"code": -1000, "msg": http.StatusText(status)})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"listenKey": "fake"})
+	case "PUT /fapi/v1/listenKey":
+		writeJSON(w, http.StatusOK, map[string]string{})
+	case "POST /fapi/v1/order":
+		e.placeOrder(w, q)
+	case "PUT /fapi/v1/order":
+		o, ok := e.findOrder(w, q)
+		if !ok {
+			return
+		}
+		qty, _ := strconv.ParseFloat(q.Get("quantity"), 64)
+		price, _ := strconv.ParseFloat(q.Get("price"), 64)
+		if !(qty > 0 && price > 0) {
+			writeAPIError(w, -4003, "Quantity or price less than or equal to zero.")
+			return
+		}
+		o.OrigQty, o.Price = formatFloat(qty), formatFloat(price)
+		writeJSON(w, http.StatusOK, o)
+	case "DELETE /fapi/v1/order":
+		o, ok := e.findOrder(w, q)
+		if !ok {
+			return
+		}
+		delete(e.open, o.OrderID)
+		o.Status = string(common.OrderStatus_CANCELED)
+		writeJSON(w, http.StatusOK, o)
+	case "GET /fapi/v1/openOrders":
+		res := []*fakeOrder{}
+		for _, o := range e.open {
+			if o.Symbol == q.Get("symbol") {
+				res = append(res, o)
+			}
+		}
+		slices.SortFunc(res, func(a, b *fakeOrder) int { return int(a.OrderID - b.OrderID) })
+		writeJSON(w, http.StatusOK, res)
+	case "GET /fapi/v2/positionRisk":
+		writeJSON(w, http.StatusOK, []map[string]string{{
+			"symbol":      q.Get("symbol"),
+			"positionAmt": formatFloat(e.position),
+			"entryPrice":  formatFloat(fakePrice),
+			"leverage":    "20",
+		}})
+	case "GET /fapi/v2/balance":
+		writeJSON(w, http.StatusOK, []map[string]string{{
+			"asset":            "USDT",
+			"balance":          formatFloat(e.wallet),
+			"crossUnPnl":       "0",
+			"availableBalance": formatFloat(e.wallet - math.Abs(e.position)*fakePrice/20),
+		}})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]any{"code": -5000, "msg": "Path not found."})
+	}
+}
+
+func (e *fakeExchange) findOrder(w http.ResponseWriter, q url.Values) (*fakeOrder, bool) {
+	id, _ := strconv.ParseInt(q.Get("orderId"), 10, 64)
+	o, ok := e.open[id]
+	if !ok || o.Symbol != q.Get("symbol") {
+		writeAPIError(w, -2011, "Unknown order sent.")
+		return nil, false
+	}
+	return o, true
+}
+
+func (e *fakeExchange) placeOrder(w http.ResponseWriter, q url.Values) {
+	side := common.OrderSide(q.Get("side"))
+	qty, _ := strconv.ParseFloat(q.Get("quantity"), 64)
+	if !(qty > 0) {
+		writeAPIError(w, -4003, "Quantity less than or equal to zero.")
+		return
+	}
+	e.nextID++
+	o := &fakeOrder{
+		OrderID:     e.nextID,
+		Symbol:      q.Get("symbol"),
+		Side:        string(side),
+		Type:        q.Get("type"),
+		Status:      string(common.OrderStatus_NEW),
+		Price:       "0",
+		OrigQty:     formatFloat(qty),
+		ExecutedQty: "0",
+		ReduceOnly:  q.Get("reduceOnly") == "true",
+	}
+	switch common.OrderType(o.Type) {
+	case common.OrderType_MARKET:
+		delta := side.Sign() * qty
+		if o.ReduceOnly && math.Abs(e.position+delta) > math.Abs(e.position) {
+			writeAPIError(w, -2022, "ReduceOnly Order is rejected.")
+			return
+		}
+		e.position += delta
+		o.Status, o.ExecutedQty = string(common.OrderStatus_FILLED), o.OrigQty
+		e.updates <- stream.OrderUpdate{
+			Symbol:             o.Symbol,
+			OrderID:            o.OrderID,
+			Side:               side,
+			Type:               common.OrderType_MARKET,
+			ExecutionType:      "TRADE",
+			Status:             common.OrderStatus_FILLED,
+			LastFilledQuantity: qty,
+			LastFilledPrice:    fakePrice,
+			FilledQuantity:     qty,
+			TradeTime:          time.Now(),
+		}
+	case common.OrderType_LIMIT:
+		price, _ := strconv.ParseFloat(q.Get("price"), 64)
+		if !(price > 0) {
+			writeAPIError(w, -4014, "Price not increased by tick size.")
+			return
+		}
+		o.Price = formatFloat(price)
+		e.open[o.OrderID] = o
+	default:
+		writeAPIError(w, -1116, "Invalid orderType.")
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// streamOrderUpdates stands in for the user data stream of the exchange.
+func (e *fakeExchange) streamOrderUpdates(ctx context.Context, listenKey string, handle func(stream.OrderUpdate) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case u := <-e.updates:
+			if err := handle(u); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// redirectTransport sends every request to the test server, whatever its URL.
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return t.base.RoundTrip(req)
+}
+
+// startFakeExchange serves a fake exchange in place of the Binance endpoints
+// until the test ends.
+func startFakeExchange(t *testing.T) *fakeExchange {
+	e := newFakeExchange()
+	srv := httptest.NewServer(e)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parse server url: %v", err)
+	}
+	orig := http.DefaultTransport
+	http.DefaultTransport = &redirectTransport{target: target, base: srv.Client().Transport}
+	t.Cleanup(func() {
+		http.DefaultTransport = orig
+		srv.Close()
+	})
+	return e
+}
+
+func TestBinanceConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) *brokertest.Harness {
+		e := startFakeExchange(t)
+		b := broker.NewBinance(common.Credentials{APIKey: "key", SecretKey: "secret"}, "")
+		broker.SetOrderUpdateStream(b, e.streamOrderUpdates)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- b.Run(ctx) }()
+		t.Cleanup(func() {
+			cancel()
+			if err := <-done; !errors.Is(err, context.Canceled) {
+				t.Errorf("Run: %v", err)
+			}
+		})
+		return &brokertest.Harness{
+			Broker:     b,
+			Symbol:     "BTCUSDT",
+			Quantity:   0.01,
+			Price:      func() float64 { return fakePrice },
+			RoundPrice: func(p float64) float64 { return math.Round(p*10) / 10 },
+			Advance:    func() { time.Sleep(10 * time.Millisecond) },
+		}
+	})
+}
+
+func TestBinanceBalanceUnknownAsset(t *testing.T) {
+	startFakeExchange(t)
+	b := broker.NewBinance(common.Credentials{APIKey: "key", SecretKey: "secret"}, "BNB")
+	if bal, err := b.Balance(context.Background()); !errors.Is(err, broker.ErrUnknownAsset) {
+		t.Fatalf("Balance() = %+v, %v, want ErrUnknownAsset", bal, err)
+	}
+}
+
+func TestBinanceStreamStartFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		fail  int // Status of the first listen key request, 0 to drop the connection.
+		fatal bool
+	}{
+		{"server error", http.StatusServiceUnavailable, false},
+		{"connection dropped", 0, false},
+		{"rate limited", http.StatusTooManyRequests, false},
+		{"unauthorized", http.StatusUnauthorized, true},
+		{"bad request", http.StatusBadRequest, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := startFakeExchange(t)
+			e.listenKeyFailures = []int{tt.fail}
+			b := broker.NewBinance(common.Credentials{APIKey: "key", SecretKey: "secret"}, "")
+			broker.SetOrderUpdateStream(b, e.streamOrderUpdates)
+			fills := make(chan broker.Fill, 1)
+			defer b.SubscribeFills(func(f broker.Fill) { fills <- f })()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- b.Run(ctx) }()
+
+			if tt.fatal {
+				select {
+				case err := <-done:
+					var httpErr *orders.HTTPError
+					if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.fail {
+						t.Fatalf("Run: %v, want the HTTP %d error", err, tt.fail)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Run still retrying after HTTP %d", tt.fail)
+				}
+				return
+			}
+			// Fills are delivered once the stream is started again.
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				e.mu.Lock()
+				started := e.listenKeyStarts >= 2
+				e.mu.Unlock()
+				if started {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("stream not started again")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			id, err := b.SubmitOrder(ctx, orders.Order{
+				Symbol: "BTCUSDT", Side: common.OrderSide_BUY, Type: common.OrderType_MARKET, Quantity: 0.01,
+			})
+			if err != nil {
+				t.Fatalf("SubmitOrder: %v", err)
+			}
+			select {
+			case f := <-fills:
+				if f.OrderID != id {
+					t.Fatalf("fill of order %d, want %d", f.OrderID, id)
+				}
+			case err := <-done:
+				t.Fatalf("Run: %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no fill delivered")
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/paper"
+)
+
+var (
+	ErrInvalidMode   = errors.New("invalid mode")
+	ErrUnknownSymbol = errors.New("unknown symbol")
+	ErrUnknownAsset  = errors.New("unknown asset")
+)
+
+// Mode selects where orders go.
+type Mode string
+
+const (
+	Mode_BACKTEST Mode = "BACKTEST" // Simulated fills against historical bars.
+	Mode_PAPER    Mode = "PAPER"    // Simulated fills against the live market.
+	Mode_LIVE     Mode = "LIVE"     // Real orders on Binance futures.
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Mode_BACKTEST, Mode_PAPER, Mode_LIVE:
+		return m, nil
+	}
+	return "", fmt.Errorf("mode %q: %w", s, ErrInvalidMode)
+}
+
+// OpenOrder is an order that may still fill.
+type OpenOrder struct {
+	orders.Order
+	ID             int64
+	Status         common.OrderStatus
+	FilledQuantity float64
+}
+
+type Fill struct {
+	OrderID     int64
+	Symbol      string
+	Side        common.OrderSide
+	Time        time.Time
+	Price       float64
+	Quantity    float64
+	Fee         float64
+	Maker       bool
+	RealizedPnL float64
+}
+
+type Balance struct {
+	Wallet    float64 // Cash, or the wallet balance for futures.
+	Equity    float64 // Wallet plus unrealized PnL.
+	Available float64 // What can back new orders.
+}
+
+// Broker is what strategies trade through, so the same strategy code runs in a
+// backtest, on paper and live. Order IDs are assigned by the broker.
+type Broker interface {
+	SubmitOrder(ctx context.Context, o orders.Order) (int64, error)
+	// AmendOrder changes the quantity and limit price of an open limit order.
+	AmendOrder(ctx context.Context, symbol string, id int64, quantity, price float64) error
+	CancelOrder(ctx context.Context, symbol string, id int64) error
+	OpenOrders(ctx context.Context, symbol string) ([]OpenOrder, error)
+	// Position returns a zero position rather than an error when there is none.
+	Position(ctx context.Context, symbol string) (orders.Position, error)
+	Balance(ctx context.Context) (Balance, error)
+	// SubscribeFills calls handle with every fill from now on, possibly from another
+	// goroutine. Call the returned function to unsubscribe.
+	SubscribeFills(handle func(Fill)) func()
+}
+
+// Runner is implemented by brokers that must stay connected to the exchange, e.g.
+// to receive market data or fills. Run blocks until ctx is done or it fails.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+var (
+	_ Broker = (*Sim)(nil)
+	_ Broker = (*Paper)(nil)
+	_ Broker = (*Binance)(nil)
+	_ Runner = (*Paper)(nil)
+	_ Runner = (*Binance)(nil)
+)
+
+type Config struct {
+	Mode  Mode
+	Paper paper.Config // Only used by Mode_PAPER.
+
+	// Only used by Mode_LIVE. Credentials are read from the environment when empty.
+	Credentials common.Credentials
+	Asset       string // Margin asset the balance is reported in, defaults to USDT.
+}
+
+// New returns the paper or live broker selected by cfg.Mode. Both are Runners.
+// Backtests get their broker from backtest.Engine and wrap it with NewSim.
+func New(cfg Config) (Broker, error) {
+	switch cfg.Mode {
+	case Mode_PAPER:
+		p, err := paper.New(cfg.Paper)
+		if err != nil {
+			return nil, fmt.Errorf("paper.New: %w", err)
+		}
+		return NewPaper(p), nil
+	case Mode_LIVE:
+		creds := cfg.Credentials
+		if creds.APIKey == "" {
+			var err error
+			if creds, err = common.CredentialsFromEnv(); err != nil {
+				return nil, err
+			}
+		}
+		return NewBinance(creds, cfg.Asset), nil
+	case Mode_BACKTEST:
+		return nil, fmt.Errorf("wrap the backtest engine's broker with NewSim: %w", ErrInvalidMode)
+	}
+	return nil, fmt.Errorf("mode %q: %w", cfg.Mode, ErrInvalidMode)
+}
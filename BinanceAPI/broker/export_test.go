@@ -0,0 +1,12 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/stream"
+)
+
+// SetOrderUpdateStream replaces the user data stream of b so it runs offline.
+func SetOrderUpdateStream(b *Binance, f func(ctx context.Context, listenKey string, handle func(stream.OrderUpdate) error) error) {
+	b.streamOrderUpdates = f
+}
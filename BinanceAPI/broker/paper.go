@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/paper"
+)
+
+// Paper trades on a paper.Broker.
+type Paper struct {
+	p *paper.Broker
+}
+
+func NewPaper(p *paper.Broker) *Paper {
+	return &Paper{p: p}
+}
+
+// Run streams the market data the simulated fills are based on.
+func (p *Paper) Run(ctx context.Context) error {
+	return p.p.Run(ctx, "", nil)
+}
+
+func (p *Paper) checkSymbol(ctx context.Context, symbol string) error {
+	pos, err := p.p.Position(ctx)
+	if err != nil {
+		return err
+	}
+	return checkSymbol(symbol, pos.Symbol)
+}
+
+func (p *Paper) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	return p.p.SubmitOrder(ctx, o)
+}
+
+func (p *Paper) AmendOrder(ctx context.Context, symbol string, id int64, quantity, price float64) error {
+	if err := p.checkSymbol(ctx, symbol); err != nil {
+		return err
+	}
+	return p.p.AmendOrder(ctx, id, quantity, price)
+}
+
+func (p *Paper) CancelOrder(ctx context.Context, symbol string, id int64) error {
+	if err := p.checkSymbol(ctx, symbol); err != nil {
+		return err
+	}
+	return p.p.CancelOrder(ctx, id)
+}
+
+func (p *Paper) OpenOrders(ctx context.Context, symbol string) ([]OpenOrder, error) {
+	if err := p.checkSymbol(ctx, symbol); err != nil {
+		return nil, err
+	}
+	sos, err := p.p.OpenOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toOpenOrders(sos), nil
+}
+
+func (p *Paper) Position(ctx context.Context, symbol string) (orders.Position, error) {
+	if err := p.checkSymbol(ctx, symbol); err != nil {
+		return orders.Position{}, err
+	}
+	return p.p.Position(ctx)
+}
+
+func (p *Paper) Balance(ctx context.Context) (Balance, error) {
+	var res Balance
+	var err error
+	if res.Wallet, err = p.p.Balance(ctx); err != nil {
+		return Balance{}, err
+	}
+	if res.Equity, err = p.p.Equity(ctx); err != nil {
+		return Balance{}, err
+	}
+	if res.Available, err = p.p.AvailableBalance(ctx); err != nil {
+		return Balance{}, err
+	}
+	return res, nil
+}
+
+func (p *Paper) SubscribeFills(handle func(Fill)) func() {
+	return p.p.SubscribeFills(func(f backtest.Fill) { handle(toFill(f)) })
+}
@@ -0,0 +1,42 @@
+package broker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/brokertest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/paper"
+)
+
+func TestPaperConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) *brokertest.Harness {
+		p, err := paper.New(paper.Config{Symbol: "BTCUSDT", InitialBalance: 10000})
+		if err != nil {
+			t.Fatalf("paper.New: %v", err)
+		}
+		// Trades stand in for the live stream, one second apart.
+		tradeTime := time.UnixMilli(1700000000000)
+		var tradeID int64
+		advance := func() {
+			tradeID++
+			p.OnTrade(aggtrades.AggTrade{
+				ID: tradeID, Price: 100, Quantity: 1, FirstTradeID: tradeID, LastTradeID: tradeID,
+				Time: tradeTime, BuyerMaker: tradeID%2 == 0,
+			})
+			tradeTime = tradeTime.Add(time.Second)
+			if err := p.Step(); err != nil {
+				t.Fatalf("Step: %v", err)
+			}
+		}
+		advance()
+		return &brokertest.Harness{
+			Broker:   broker.NewPaper(p),
+			Symbol:   "BTCUSDT",
+			Quantity: 0.01,
+			Price:    func() float64 { return 100 },
+			Advance:  advance,
+		}
+	})
+}
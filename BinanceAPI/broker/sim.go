@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+func toFill(f backtest.Fill) Fill {
+	return Fill{
+		OrderID:     f.OrderID,
+		Symbol:      f.Symbol,
+		Side:        f.Side,
+		Time:        f.Time,
+		Price:       f.Price,
+		Quantity:    f.Quantity,
+		Fee:         f.Fee,
+		Maker:       f.Maker,
+		RealizedPnL: f.RealizedPnL,
+	}
+}
+
+func toOpenOrders(sos []backtest.SimOrder) []OpenOrder {
+	res := make([]OpenOrder, len(sos))
+	for idx, o := range sos {
+		res[idx] = OpenOrder{Order: o.Order, ID: o.ID, Status: o.Status, FilledQuantity: o.FilledQuantity}
+	}
+	return res
+}
+
+func checkSymbol(symbol, traded string) error {
+	if symbol != traded {
+		return fmt.Errorf("symbol %q but broker trades %q: %w", symbol, traded, ErrUnknownSymbol)
+	}
+	return nil
+}
+
+// Sim trades on a backtest.SimBroker. Like the SimBroker, it is not safe for
+// concurrent use and fills are delivered while the engine processes a bar.
+type Sim struct {
+	sim *backtest.SimBroker
+}
+
+func NewSim(sim *backtest.SimBroker) *Sim {
+	return &Sim{sim: sim}
+}
+
+func (s *Sim) symbol() string { return s.sim.Position().Symbol }
+
+func (s *Sim) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	return s.sim.Submit(o)
+}
+
+func (s *Sim) AmendOrder(ctx context.Context, symbol string, id int64, quantity, price float64) error {
+	if err := checkSymbol(symbol, s.symbol()); err != nil {
+		return err
+	}
+	return s.sim.Amend(id, quantity, price)
+}
+
+func (s *Sim) CancelOrder(ctx context.Context, symbol string, id int64) error {
+	if err := checkSymbol(symbol, s.symbol()); err != nil {
+		return err
+	}
+	return s.sim.Cancel(id)
+}
+
+func (s *Sim) OpenOrders(ctx context.Context, symbol string) ([]OpenOrder, error) {
+	if err := checkSymbol(symbol, s.symbol()); err != nil {
+		return nil, err
+	}
+	return toOpenOrders(s.sim.OpenOrders()), nil
+}
+
+func (s *Sim) Position(ctx context.Context, symbol string) (orders.Position, error) {
+	if err := checkSymbol(symbol, s.symbol()); err != nil {
+		return orders.Position{}, err
+	}
+	return s.sim.Position(), nil
+}
+
+func (s *Sim) Balance(ctx context.Context) (Balance, error) {
+	return Balance{Wallet: s.sim.Cash(), Equity: s.sim.Equity(), Available: s.sim.AvailableBalance()}, nil
+}
+
+func (s *Sim) SubscribeFills(handle func(Fill)) func() {
+	return s.sim.SubscribeFills(func(f backtest.Fill) { handle(toFill(f)) })
+}
@@ -0,0 +1,41 @@
+package broker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/brokertest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+func TestSimConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) *brokertest.Harness {
+		sim, err := backtest.NewSimBroker(backtest.BrokerConfig{Symbol: "BTCUSDT", InitialCash: 10000})
+		if err != nil {
+			t.Fatalf("NewSimBroker: %v", err)
+		}
+		openTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		advance := func() {
+			sim.ProcessBar(&klines.KLine{
+				OpenTime:   openTime,
+				CloseTime:  openTime.Add(time.Hour - time.Millisecond),
+				OpenPrice:  100,
+				HighPrice:  101,
+				LowPrice:   99,
+				ClosePrice: 100,
+				Volume:     10,
+			})
+			openTime = openTime.Add(time.Hour)
+		}
+		advance()
+		return &brokertest.Harness{
+			Broker:   broker.NewSim(sim),
+			Symbol:   "BTCUSDT",
+			Quantity: 0.01,
+			Price:    sim.LastPrice,
+			Advance:  advance,
+		}
+	})
+}
@@ -0,0 +1,285 @@
+// Package brokertest is the conformance suite every broker.Broker implementation
+// must pass. Call Run from a test of the implementation:
+//
+//	func TestConformance(t *testing.T) {
+//		brokertest.Run(t, func(t *testing.T) *brokertest.Harness { ... })
+//	}
+package brokertest
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// Harness drives one implementation through the suite.
+type Harness struct {
+	Broker   broker.Broker
+	Symbol   string
+	Quantity float64 // Smallest order quantity the symbol accepts at Price.
+
+	// Price returns the current market price.
+	Price func() float64
+	// RoundPrice rounds to the tick size of the symbol, nil when any price is fine.
+	RoundPrice func(float64) float64
+	// Advance lets the market move on so market orders fill and their fills get
+	// delivered, e.g. by processing a bar or by waiting.
+	Advance func()
+	// MaxAdvances bounds how often Advance is called waiting for a fill,
+	// defaults to 20.
+	MaxAdvances int
+}
+
+func (h *Harness) price(ratio float64) float64 {
+	p := h.Price() * ratio
+	if h.RoundPrice != nil {
+		p = h.RoundPrice(p)
+	}
+	return p
+}
+
+// fillRecorder collects fills delivered from any goroutine.
+type fillRecorder struct {
+	mu    sync.Mutex
+	fills []broker.Fill
+}
+
+func (r *fillRecorder) add(f broker.Fill) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fills = append(r.fills, f)
+}
+
+// filled returns the quantity filled of the order so far.
+func (r *fillRecorder) filled(id int64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var qty float64
+	for _, f := range r.fills {
+		if f.OrderID == id {
+			qty += f.Quantity
+		}
+	}
+	return qty
+}
+
+func (r *fillRecorder) num() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.fills)
+}
+
+// Run runs every case as a subtest with a fresh harness. Cases leave the account
+// flat and without open orders when they pass.
+func Run(t *testing.T, newHarness func(t *testing.T) *Harness) {
+	for _, c := range []struct {
+		name string
+		run  func(t *testing.T, h *Harness)
+	}{
+		{"RejectsInvalidOrder", testRejectsInvalidOrder},
+		{"RestingLimitOrder", testRestingLimitOrder},
+		{"CancelUnknownOrder", testCancelUnknownOrder},
+		{"MarketOrderRoundTrip", testMarketOrderRoundTrip},
+		{"Balance", testBalance},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			h := newHarness(t)
+			if h.MaxAdvances <= 0 {
+				h.MaxAdvances = 20
+			}
+			c.run(t, h)
+		})
+	}
+}
+
+func findOrder(t *testing.T, h *Harness, id int64) (broker.OpenOrder, bool) {
+	t.Helper()
+	open, err := h.Broker.OpenOrders(context.Background(), h.Symbol)
+	if err != nil {
+		t.Fatalf("OpenOrders: %v", err)
+	}
+	idx := slices.IndexFunc(open, func(o broker.OpenOrder) bool { return o.ID == id })
+	if idx < 0 {
+		return broker.OpenOrder{}, false
+	}
+	return open[idx], true
+}
+
+func testRejectsInvalidOrder(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	for _, o := range []orders.Order{
+		{Symbol: h.Symbol, Side: common.OrderSide_BUY, Type: common.OrderType_MARKET, Quantity: 0},
+		{Symbol: h.Symbol, Side: common.OrderSide_BUY, Type: common.OrderType_LIMIT, Quantity: h.Quantity, Price: -1},
+	} {
+		if id, err := h.Broker.SubmitOrder(ctx, o); err == nil {
+			h.Broker.CancelOrder(ctx, h.Symbol, id)
+			t.Errorf("SubmitOrder(%+v) succeeded, want error", o)
+		}
+	}
+}
+
+func testRestingLimitOrder(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	// Far enough below the market to never fill during the test.
+	price := h.price(0.8)
+	id, err := h.Broker.SubmitOrder(ctx, orders.Order{
+		Symbol:   h.Symbol,
+		Side:     common.OrderSide_BUY,
+		Type:     common.OrderType_LIMIT,
+		Quantity: h.Quantity,
+		Price:    price,
+	})
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+	defer h.Broker.CancelOrder(ctx, h.Symbol, id)
+
+	o, ok := findOrder(t, h, id)
+	if !ok {
+		t.Fatalf("order %d not open after submit", id)
+	}
+	if o.Status != common.OrderStatus_NEW || o.Side != common.OrderSide_BUY || o.Quantity != h.Quantity || o.Price != price {
+		t.Errorf("open order %+v, want NEW BUY %v @ %v", o, h.Quantity, price)
+	}
+
+	newQty, newPrice := 2*h.Quantity, h.price(0.79)
+	if err := h.Broker.AmendOrder(ctx, h.Symbol, id, newQty, newPrice); err != nil {
+		t.Fatalf("AmendOrder: %v", err)
+	}
+	if o, ok := findOrder(t, h, id); !ok || o.Quantity != newQty || o.Price != newPrice {
+		t.Errorf("amended order %+v (open %v), want %v @ %v", o, ok, newQty, newPrice)
+	}
+
+	if err := h.Broker.CancelOrder(ctx, h.Symbol, id); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	if _, ok := findOrder(t, h, id); ok {
+		t.Errorf("order %d still open after cancel", id)
+	}
+	if err := h.Broker.CancelOrder(ctx, h.Symbol, id); err == nil {
+		t.Errorf("second CancelOrder(%d) succeeded, want error", id)
+	}
+}
+
+func testCancelUnknownOrder(t *testing.T, h *Harness) {
+	if err := h.Broker.CancelOrder(context.Background(), h.Symbol, math.MaxInt64); err == nil {
+		t.Errorf("CancelOrder of unknown order succeeded, want error")
+	}
+}
+
+// waitFilled advances the market until the order is fully filled.
+func waitFilled(t *testing.T, h *Harness, rec *fillRecorder, id int64) {
+	t.Helper()
+	for range h.MaxAdvances {
+		if rec.filled(id) >= h.Quantity*(1-1e-9) {
+			return
+		}
+		h.Advance()
+	}
+	t.Fatalf("order %d filled %v of %v after %d advances", id, rec.filled(id), h.Quantity, h.MaxAdvances)
+}
+
+func testMarketOrderRoundTrip(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	before, err := h.Broker.Position(ctx, h.Symbol)
+	if err != nil {
+		t.Fatalf("Position: %v", err)
+	}
+	rec := &fillRecorder{}
+	unsubscribe := h.Broker.SubscribeFills(rec.add)
+
+	buyID, err := h.Broker.SubmitOrder(ctx, orders.Order{
+		Symbol:   h.Symbol,
+		Side:     common.OrderSide_BUY,
+		Type:     common.OrderType_MARKET,
+		Quantity: h.Quantity,
+	})
+	if err != nil {
+		t.Fatalf("SubmitOrder buy: %v", err)
+	}
+	waitFilled(t, h, rec, buyID)
+	pos, err := h.Broker.Position(ctx, h.Symbol)
+	if err != nil {
+		t.Fatalf("Position: %v", err)
+	}
+	if got, want := pos.Quantity, before.Quantity+h.Quantity; math.Abs(got-want) > h.Quantity*1e-6 {
+		t.Errorf("position %v after buying %v, want %v", got, h.Quantity, want)
+	}
+
+	sellID, err := h.Broker.SubmitOrder(ctx, orders.Order{
+		Symbol:     h.Symbol,
+		Side:       common.OrderSide_SELL,
+		Type:       common.OrderType_MARKET,
+		Quantity:   h.Quantity,
+		ReduceOnly: true,
+	})
+	if err != nil {
+		t.Fatalf("SubmitOrder sell: %v", err)
+	}
+	waitFilled(t, h, rec, sellID)
+	pos, err = h.Broker.Position(ctx, h.Symbol)
+	if err != nil {
+		t.Fatalf("Position: %v", err)
+	}
+	if math.Abs(pos.Quantity-before.Quantity) > h.Quantity*1e-6 {
+		t.Errorf("position %v after the round trip, want %v", pos.Quantity, before.Quantity)
+	}
+	rec.mu.Lock()
+	for _, f := range rec.fills {
+		if f.Symbol != h.Symbol || !(f.Price > 0) || f.Time.IsZero() {
+			t.Errorf("malformed fill %+v", f)
+		}
+	}
+	rec.mu.Unlock()
+
+	// No fill reaches an unsubscribed handler.
+	unsubscribe()
+	n := rec.num()
+	other := &fillRecorder{}
+	defer h.Broker.SubscribeFills(other.add)()
+	id, err := h.Broker.SubmitOrder(ctx, orders.Order{
+		Symbol:   h.Symbol,
+		Side:     common.OrderSide_BUY,
+		Type:     common.OrderType_MARKET,
+		Quantity: h.Quantity,
+	})
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+	waitFilled(t, h, other, id)
+	if rec.num() != n {
+		t.Errorf("%d fills delivered after unsubscribing", rec.num()-n)
+	}
+	if _, err := h.Broker.SubmitOrder(ctx, orders.Order{
+		Symbol:     h.Symbol,
+		Side:       common.OrderSide_SELL,
+		Type:       common.OrderType_MARKET,
+		Quantity:   h.Quantity,
+		ReduceOnly: true,
+	}); err != nil {
+		t.Fatalf("SubmitOrder close: %v", err)
+	}
+	// Give the closing order a chance to fill before the next case.
+	for range h.MaxAdvances {
+		if p, err := h.Broker.Position(ctx, h.Symbol); err == nil && math.Abs(p.Quantity-before.Quantity) <= h.Quantity*1e-6 {
+			break
+		}
+		h.Advance()
+	}
+}
+
+func testBalance(t *testing.T, h *Harness) {
+	bal, err := h.Broker.Balance(context.Background())
+	if err != nil {
+		t.Fatalf("Balance: %v", err)
+	}
+	if !(bal.Wallet > 0) || !(bal.Equity > 0) || bal.Available > bal.Equity*(1+1e-9) {
+		t.Errorf("balance %+v, want positive wallet and equity, available <= equity", bal)
+	}
+}
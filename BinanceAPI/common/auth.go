@@ -0,0 +1,53 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by CredentialsFromEnv.
+const (
+	APIKeyEnv    = "BINANCE_API_KEY"
+	SecretKeyEnv = "BINANCE_SECRET_KEY"
+)
+
+// RecvWindow bounds how long after its timestamp a signed request is accepted.
+const RecvWindow = 5 * time.Second
+
+// Credentials of an API key, needed by the account and trading endpoints.
+type Credentials struct {
+	APIKey    string
+	SecretKey string
+}
+
+func CredentialsFromEnv() (Credentials, error) {
+	c := Credentials{APIKey: os.Getenv(APIKeyEnv), SecretKey: os.Getenv(SecretKeyEnv)}
+	if c.APIKey == "" || c.SecretKey == "" {
+		return Credentials{}, errors.New("env " + APIKeyEnv + " or " + SecretKeyEnv + " not set")
+	}
+	return c, nil
+}
+
+// SetAPIKey adds the API key header, which is all the user data stream endpoints need.
+func (c Credentials) SetAPIKey(req *http.Request) {
+	req.Header.Set("X-MBX-APIKEY", c.APIKey)
+}
+
+// Sign adds the API key header, the timestamp and the HMAC SHA256 signature of the
+// query to the request, replacing its query.
+func (c Credentials) Sign(req *http.Request, query url.Values) {
+	query.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	query.Set("recvWindow", strconv.FormatInt(RecvWindow.Milliseconds(), 10))
+	payload := query.Encode()
+	mac := hmac.New(sha256.New, []byte(c.SecretKey))
+	mac.Write([]byte(payload))
+	req.URL.RawQuery = payload + "&signature=" + hex.EncodeToString(mac.Sum(nil))
+	c.SetAPIKey(req)
+}
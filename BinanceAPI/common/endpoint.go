@@ -1,5 +1,7 @@
 package common
 
 const (
-	RootAPIEndPoint = "https://testnet.binancefuture.com"
+	RootAPIEndPoint     = "https://testnet.binancefuture.com"
+	RootStreamEndPoint  = "wss://stream.binancefuture.com"
+	SpotRootAPIEndPoint = "https://testnet.binance.vision"
 )
@@ -19,6 +19,7 @@ const (
 	ListKLinesInterval_1d  ListKLinesInterval = "1d"  // 1 day
 	ListKLinesInterval_3d  ListKLinesInterval = "3d"  // 3 days
 	ListKLinesInterval_1w  ListKLinesInterval = "1w"  // 1 week
+	ListKLinesInterval_1M  ListKLinesInterval = "1M"  // 1 month
 )
 
 var listKLinesIntervalToDuration = map[ListKLinesInterval]time.Duration{
@@ -36,8 +37,61 @@ var listKLinesIntervalToDuration = map[ListKLinesInterval]time.Duration{
 	ListKLinesInterval_1d:  24 * time.Hour,
 	ListKLinesInterval_3d:  3 * 24 * time.Hour,
 	ListKLinesInterval_1w:  7 * 24 * time.Hour,
+	ListKLinesInterval_1M:  31 * 24 * time.Hour,
 }
 
+// IntervalDuration returns the length of the interval's bars, 0 for an unknown
+// interval. Months vary, so 1M gives the longest one; step through bars with
+// NextOpenTime and count them with BarsBetween instead of using it.
 func IntervalDuration(i ListKLinesInterval) time.Duration {
 	return listKLinesIntervalToDuration[i]
 }
+
+type MarketType string
+
+const (
+	MarketType_FUTURES MarketType = "futures" // USDⓈ-M perpetual contracts.
+	MarketType_SPOT    MarketType = "spot"
+)
+
+type OrderSide string
+
+const (
+	OrderSide_BUY  OrderSide = "BUY"
+	OrderSide_SELL OrderSide = "SELL"
+)
+
+// Sign returns +1 for BUY and -1 for SELL, which is handy for signed position math.
+func (s OrderSide) Sign() float64 {
+	if s == OrderSide_SELL {
+		return -1
+	}
+	return 1
+}
+
+type OrderType string
+
+const (
+	OrderType_MARKET      OrderType = "MARKET"
+	OrderType_LIMIT       OrderType = "LIMIT"
+	OrderType_STOP_MARKET OrderType = "STOP_MARKET" // Market order triggered at StopPrice.
+	OrderType_STOP        OrderType = "STOP"        // Limit order triggered at StopPrice.
+)
+
+type MarginType string
+
+const (
+	MarginType_ISOLATED MarginType = "ISOLATED"
+	MarginType_CROSSED  MarginType = "CROSSED"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatus_NEW              OrderStatus = "NEW"
+	OrderStatus_PARTIALLY_FILLED OrderStatus = "PARTIALLY_FILLED"
+	OrderStatus_FILLED           OrderStatus = "FILLED"
+	OrderStatus_CANCELED         OrderStatus = "CANCELED"
+	OrderStatus_REJECTED         OrderStatus = "REJECTED" // Simulated only: not enough margin when it would have filled.
+	OrderStatus_EXPIRED          OrderStatus = "EXPIRED"
+)
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bars follow Binance's UTC calendar: days open at midnight, weeks on Monday and
+// months on the 1st. Shorter intervals, and 3d, are aligned to the epoch.
+
+var (
+	ErrNotAligned = errors.New("not a bar open time")
+)
+
+// weekOrigin is the first Monday after the epoch.
+var weekOrigin = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+// AlignDown returns the open time of the interval's bar containing t.
+func AlignDown(i ListKLinesInterval, t time.Time) time.Time {
+	if i == ListKLinesInterval_1M {
+		u := t.UTC()
+		return time.Date(u.Year(), u.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	var origin int64
+	if i == ListKLinesInterval_1w {
+		origin = weekOrigin
+	}
+	dur := IntervalDuration(i).Milliseconds()
+	if dur == 0 {
+		return t
+	}
+	ms := t.UnixMilli() - origin
+	ms -= (ms%dur + dur) % dur // Rounds down before the origin too.
+	return time.UnixMilli(ms + origin)
+}
+
+// NextOpenTime returns the open time of the bar after the one containing t.
+func NextOpenTime(i ListKLinesInterval, t time.Time) time.Time {
+	start := AlignDown(i, t)
+	if i == ListKLinesInterval_1M {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.Add(IntervalDuration(i))
+}
+
+// AddBars returns the open time of the bar n bars after the one containing t, or
+// before it when n is negative.
+func AddBars(i ListKLinesInterval, t time.Time, n int) time.Time {
+	start := AlignDown(i, t)
+	if i == ListKLinesInterval_1M {
+		return start.AddDate(0, n, 0)
+	}
+	return start.Add(time.Duration(n) * IntervalDuration(i))
+}
+
+// BarsBetween returns how many of the interval's bars open within [from, to].
+func BarsBetween(i ListKLinesInterval, from, to time.Time) int {
+	first := AlignDown(i, from)
+	if first.Before(from) {
+		first = NextOpenTime(i, first)
+	}
+	if to.Before(first) {
+		return 0
+	}
+	last := AlignDown(i, to)
+	if i == ListKLinesInterval_1M {
+		return (last.Year()-first.Year())*12 + int(last.Month()-first.Month()) + 1
+	}
+	dur := IntervalDuration(i)
+	if dur == 0 {
+		return 0
+	}
+	return int(last.Sub(first)/dur) + 1
+}
+
+// IsAligned reports whether a bar of the interval opens at t.
+func IsAligned(i ListKLinesInterval, t time.Time) bool {
+	return AlignDown(i, t).Equal(t)
+}
+
+// CheckAligned returns ErrNotAligned if no bar of the interval opens at t, e.g. for
+// the start time of a download.
+func CheckAligned(i ListKLinesInterval, t time.Time) error {
+	if IntervalDuration(i) == 0 {
+		return fmt.Errorf("unknown interval %q", i)
+	}
+	if !IsAligned(i, t) {
+		return fmt.Errorf("%v for %s bars, which open at %v: %w", t, i, AlignDown(i, t), ErrNotAligned)
+	}
+	return nil
+}
+
+// Intervals returns the known intervals, shortest first.
+func Intervals() []ListKLinesInterval {
+	res := make([]ListKLinesInterval, 0, len(listKLinesIntervalToDuration))
+	for i := range listKLinesIntervalToDuration {
+		res = append(res, i)
+	}
+	slices.SortFunc(res, func(a, b ListKLinesInterval) int {
+		return int((IntervalDuration(a) - IntervalDuration(b)) / time.Minute)
+	})
+	return res
+}
+
+// ParseInterval returns the interval named s, e.g. "4h". Names are case
+// sensitive as "1m" is a minute and "1M" a month.
+func ParseInterval(s string) (ListKLinesInterval, error) {
+	i := ListKLinesInterval(s)
+	if IntervalDuration(i) == 0 {
+		names := make([]string, 0, len(listKLinesIntervalToDuration))
+		for _, i := range Intervals() {
+			names = append(names, string(i))
+		}
+		return "", fmt.Errorf("unknown interval %q, want one of %s", s, strings.Join(names, ", "))
+	}
+	return i, nil
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddBars(t *testing.T) {
+	date := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		interval ListKLinesInterval
+		t        time.Time
+		n        int
+		want     time.Time
+	}{
+		{ListKLinesInterval_1h, date(3, 1, 5).Add(time.Minute), -3, date(3, 1, 2)},
+		{ListKLinesInterval_1d, date(3, 1, 5), 1, date(3, 2, 0)},
+		{ListKLinesInterval_1w, date(3, 6, 0), -1, date(2, 26, 0)},
+		{ListKLinesInterval_1M, date(3, 15, 0), -1, date(2, 1, 0)},
+		{ListKLinesInterval_1M, date(3, 31, 23), -2, date(1, 1, 0)},
+		{ListKLinesInterval_1M, date(1, 31, 0), 1, date(2, 1, 0)},
+		{ListKLinesInterval_1M, date(12, 1, 0), 2, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := AddBars(tt.interval, tt.t, tt.n); !got.Equal(tt.want) {
+			t.Errorf("AddBars(%s, %v, %d) = %v, want %v", tt.interval, tt.t, tt.n, got.UTC(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/resample"
+)
+
+func crosscheck(args []string) error {
+	fs := flag.NewFlagSet("crosscheck", flag.ExitOnError)
+	root := fs.String("root", defaultRoot, "price_data directory to read")
+	formatName := fs.String("format", "csv", "format to read: "+formatNames())
+	symbols := fs.String("symbols", "", "comma separated symbols, all when empty")
+	from := fs.String("from", "4h", "interval to resample")
+	to := fs.String("to", "12h,1d", "comma separated intervals to compare the resampled bars with")
+	priceTol := fs.Float64("price_tolerance", resample.DefaultTolerance.Price, "largest relative price difference accepted")
+	volumeTol := fs.Float64("volume_tolerance", resample.DefaultTolerance.Volume, "largest relative volume and trade count difference accepted")
+	maxShown := fs.Int("max_mismatches", 10, "mismatches printed per series")
+	fs.Parse(args)
+	f, ok := formats[*formatName]
+	if !ok {
+		return fmt.Errorf("unknown format %q, want one of %s", *formatName, formatNames())
+	}
+	tol := resample.Tolerance{Price: *priceTol, Volume: *volumeTol}
+
+	all, err := selectSeries(*root, f, splitList(*symbols), []string{*from})
+	if err != nil {
+		return err
+	}
+	store := f.open(*root)
+	failed, checked := 0, 0
+	for _, s := range all {
+		bars, err := store.Range(s.Symbol, s.Interval, time.Time{}, time.Time{})
+		if err != nil {
+			return fmt.Errorf("Range(%s %s): %w", s.Symbol, s.Interval, err)
+		}
+		for _, higher := range splitList(*to) {
+			interval := common.ListKLinesInterval(higher)
+			downloaded, err := store.Range(s.Symbol, interval, time.Time{}, time.Time{})
+			if err != nil {
+				return fmt.Errorf("Range(%s %s): %w", s.Symbol, interval, err)
+			}
+			if len(downloaded) == 0 {
+				continue
+			}
+			rep, err := resample.Check(bars, s.Interval, downloaded, interval, tol)
+			if err != nil {
+				return fmt.Errorf("Check(%s %s -> %s): %w", s.Symbol, s.Interval, interval, err)
+			}
+			checked++
+			fmt.Printf("%-10s %4s -> %-4s %6d compared %4d only resampled %4d only downloaded %5d mismatches\n",
+				s.Symbol, s.Interval, interval, rep.Compared, rep.OnlyResampled, rep.OnlyDownloaded, len(rep.Mismatches))
+			if rep.OK() {
+				continue
+			}
+			failed++
+			for _, m := range rep.Mismatches[:min(len(rep.Mismatches), *maxShown)] {
+				fmt.Printf("  %s\n", m.String())
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d series pairs disagree", failed, checked)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/archive"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+func importArchive(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("archive", "", "directory holding the downloaded archive ZIP and CHECKSUM files")
+	root := fs.String("root", "", "price_data directory to write, defaults to "+defaultRoot+", or its spot folder for spot")
+	market := fs.String("market", string(common.MarketType_FUTURES), "market of the archives to import, futures or spot; the others are skipped")
+	formatName := fs.String("format", "csv", "format to write the KLines in: "+formatNames())
+	symbols := fs.String("symbols", "", "comma separated symbols, all when empty")
+	allowMissing := fs.Bool("allow_missing_checksum", false, "import archives with no CHECKSUM file too")
+	fs.Parse(args)
+	if *dir == "" {
+		return fmt.Errorf("-archive is required")
+	}
+	if m := common.MarketType(*market); m != common.MarketType_FUTURES && m != common.MarketType_SPOT {
+		return fmt.Errorf("unknown market %q, want %s or %s", *market, common.MarketType_FUTURES, common.MarketType_SPOT)
+	}
+	if *root == "" {
+		*root = defaultRoot
+		if common.MarketType(*market) == common.MarketType_SPOT {
+			*root = filepath.Join(defaultRoot, "spot")
+		}
+	}
+	f, ok := formats[*formatName]
+	if !ok {
+		return fmt.Errorf("unknown format %q, want one of %s", *formatName, formatNames())
+	}
+	store, ok := f.open(*root).(storage.FileStore)
+	if !ok {
+		return fmt.Errorf("format %s keeps no files", *formatName)
+	}
+
+	rep, err := archive.Import(*dir, store, archive.Options{
+		AllowMissingChecksum: *allowMissing,
+		Market:               common.MarketType(*market),
+		Symbols:              splitList(*symbols),
+	})
+	if rep != nil {
+		for _, res := range rep.Files {
+			status := fmt.Sprintf("%d rows", res.Rows)
+			if res.Err != nil {
+				status = "skipped: " + res.Err.Error()
+			}
+			fmt.Printf("%-10s %-11s %-4s %-10s %s\n", res.Symbol, res.Kind, res.Interval, res.Period, status)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Import: %w", err)
+	}
+	skipped := 0
+	for _, res := range rep.Files {
+		if res.Err != nil {
+			skipped++
+		}
+	}
+	fmt.Printf("imported %d files: %d klines, %d agg trades and %d funding rates new to %s\n",
+		len(rep.Files)-skipped, rep.KLines, rep.AggTrades, rep.FundingRates, *root)
+	if skipped > 0 {
+		return fmt.Errorf("%d of %d archive files skipped", skipped, len(rep.Files))
+	}
+	return nil
+}
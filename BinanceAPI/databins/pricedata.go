@@ -0,0 +1,199 @@
+// price_data_main maintains the price_data tree of KLines:
+//
+//	price_data_main convert -from csv -to parquet [-root DIR] [-dst DIR] [-symbols BTCUSDT,ETHUSDT] [-intervals 1h,4h]
+//	price_data_main migrate [-root DIR] [-db FILE]
+//	price_data_main verify [-root DIR] [-symbols ...] [-intervals ...] [-out FILE] [-strict]
+//	price_data_main crosscheck [-root DIR] [-symbols ...] [-from 4h] [-to 12h,1d]
+//	price_data_main import -archive DIR [-root DIR] [-format csv] [-symbols ...] [-allow_missing_checksum]
+//
+// convert copies every series stored in one format into another, next to the
+// source files unless -dst is given. The formats are csv, binary, binary_flate
+// and parquet.
+//
+// migrate loads the CSV tree, KLines, funding rates and aggregated trades, into
+// an SQLite database. It can be rerun to pick up new rows.
+//
+// verify checks every KLine file, CSV, binary or Parquet, for schema errors, bars
+// out of order, duplicated, misaligned or with inconsistent close times, prices
+// and volumes, and for gaps. It prints a JSON report and exits with 1 when a
+// file has an issue other than gaps, or gaps too with -strict.
+//
+// crosscheck resamples the bars of one interval into higher ones and compares
+// them with the bars downloaded at those intervals, exiting with 1 when they
+// differ beyond the tolerances.
+//
+// import merges the KLines, aggregated trades and funding rates of the Binance
+// public data archive ZIPs found under -archive, once checked against their
+// CHECKSUM files, keeping the rows already stored. It exits with 1 when an
+// archive was skipped.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/sqlitestore"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+const (
+	defaultRoot = "../../price_data"
+)
+
+// format is a KLine storage format with the extension of its files.
+type format struct {
+	ext  string
+	open func(root string) storage.KLineStore
+}
+
+var formats = map[string]format{
+	"csv": {".csv", func(root string) storage.KLineStore {
+		return storage.NewCSVStore(root)
+	}},
+	"binary": {".klb", func(root string) storage.KLineStore {
+		return storage.NewBinaryStore(root, storage.Compression_NONE)
+	}},
+	"binary_flate": {".klb", func(root string) storage.KLineStore {
+		return storage.NewBinaryStore(root, storage.Compression_FLATE)
+	}},
+	"parquet": {".parquet", func(root string) storage.KLineStore {
+		return storage.NewParquetStore(root, klines.ListKLinesEndpoint)
+	}},
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// splitList splits a comma separated flag, nil meaning everything.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// selectSeries finds the series of the format under root, keeping those of the
+// symbols and intervals, all when empty.
+func selectSeries(root string, f format, symbols, intervals []string) ([]storage.Series, error) {
+	all, err := storage.ListSeries(root, f.ext)
+	if err != nil {
+		return nil, fmt.Errorf("ListSeries(%q): %w", root, err)
+	}
+	return slices.DeleteFunc(all, func(s storage.Series) bool {
+		return (symbols != nil && !slices.Contains(symbols, s.Symbol)) ||
+			(intervals != nil && !slices.Contains(intervals, string(s.Interval)))
+	}), nil
+}
+
+func convert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	root := fs.String("root", defaultRoot, "price_data directory to read")
+	dst := fs.String("dst", "", "price_data directory to write, defaults to -root")
+	from := fs.String("from", "csv", "format to read: "+formatNames())
+	to := fs.String("to", "parquet", "format to write: "+formatNames())
+	symbols := fs.String("symbols", "", "comma separated symbols, all when empty")
+	intervals := fs.String("intervals", "", "comma separated intervals, all when empty")
+	fs.Parse(args)
+	if *dst == "" {
+		*dst = *root
+	}
+	src, ok := formats[*from]
+	if !ok {
+		return fmt.Errorf("unknown format %q, want one of %s", *from, formatNames())
+	}
+	dstFormat, ok := formats[*to]
+	if !ok {
+		return fmt.Errorf("unknown format %q, want one of %s", *to, formatNames())
+	}
+	if src.ext == dstFormat.ext && *root == *dst {
+		return fmt.Errorf("converting %s to %s in place would overwrite the source", *from, *to)
+	}
+
+	all, err := selectSeries(*root, src, splitList(*symbols), splitList(*intervals))
+	if err != nil {
+		return err
+	}
+	srcStore, dstStore := src.open(*root), dstFormat.open(*dst)
+	for _, s := range all {
+		n, err := storage.Copy(dstStore, srcStore, s.Symbol, s.Interval)
+		if err != nil {
+			return fmt.Errorf("Copy(%s %s): %w", s.Symbol, s.Interval, err)
+		}
+		srcStats, err := srcStore.Stats(s.Symbol, s.Interval)
+		if err != nil {
+			return fmt.Errorf("Stats(%s %s): %w", s.Symbol, s.Interval, err)
+		}
+		dstStats, err := dstStore.Stats(s.Symbol, s.Interval)
+		if err != nil {
+			return fmt.Errorf("Stats(%s %s): %w", s.Symbol, s.Interval, err)
+		}
+		fmt.Printf("%-10s %-4s %8d bars %12d -> %12d bytes\n", s.Symbol, s.Interval, n, srcStats.SizeBytes, dstStats.SizeBytes)
+	}
+	fmt.Printf("converted %d series from %s to %s\n", len(all), *from, *to)
+	return nil
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	root := fs.String("root", defaultRoot, "price_data directory to read")
+	dbPath := fs.String("db", "", "SQLite database to write, defaults to <root>/market.db")
+	fs.Parse(args)
+	if *dbPath == "" {
+		*dbPath = filepath.Join(*root, "market.db")
+	}
+
+	db, err := sqlitestore.Open(*dbPath)
+	if err != nil {
+		return fmt.Errorf("sqlitestore.Open: %w", err)
+	}
+	defer db.Close()
+	rep, err := db.MigrateCSV(*root)
+	if err != nil {
+		return fmt.Errorf("MigrateCSV: %w", err)
+	}
+	fmt.Printf("migrated %d series, %d klines, %d funding rates and %d agg trades into %s\n",
+		rep.Series, rep.KLines, rep.FundingRates, rep.AggTrades, *dbPath)
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: price_data_main convert|migrate|verify|crosscheck|import [flags]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "convert":
+		err = convert(os.Args[2:])
+	case "migrate":
+		err = migrate(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "crosscheck":
+		err = crosscheck(os.Args[2:])
+	case "import":
+		err = importArchive(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+// verifySummary is the report verify prints as JSON.
+type verifySummary struct {
+	Root    string
+	Files   int
+	Failed  int
+	Issues  map[storage.IssueKind]int `json:",omitempty"`
+	Reports []*storage.VerifyReport
+	Skipped []string `json:",omitempty"` // Files which are not KLine series, e.g. funding rates.
+}
+
+// verifyFile checks one KLine file, which is a series of the format with ext.
+func verifyFile(root, ext string, s storage.Series) (*storage.VerifyReport, error) {
+	switch ext {
+	case ".csv":
+		return storage.VerifyKLineCSV(storage.KLineCSVPath(root, s.Symbol, s.Interval), s.Symbol, s.Interval)
+	case ".klb":
+		path := storage.KLineBinaryPath(root, s.Symbol, s.Interval)
+		// The compression only matters when writing.
+		bars, err := storage.NewBinaryStore(root, storage.Compression_NONE).Range(s.Symbol, s.Interval, time.Time{}, time.Time{})
+		return storage.VerifyKLines(path, s.Symbol, s.Interval, bars, err), nil
+	case ".parquet":
+		path := storage.KLineParquetPath(root, s.Symbol, s.Interval)
+		bars, err := storage.NewParquetStore(root, klines.ListKLinesEndpoint).Range(s.Symbol, s.Interval, time.Time{}, time.Time{})
+		return storage.VerifyKLines(path, s.Symbol, s.Interval, bars, err), nil
+	}
+	return nil, fmt.Errorf("no KLine format with extension %q", ext)
+}
+
+// otherFiles lists the files under root not in checked.
+func otherFiles(root string, checked map[string]bool) ([]string, error) {
+	var res []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !checked[path] {
+			res = append(res, path)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	root := fs.String("root", defaultRoot, "price_data directory to verify")
+	symbols := fs.String("symbols", "", "comma separated symbols, all when empty")
+	intervals := fs.String("intervals", "", "comma separated intervals, all when empty")
+	out := fs.String("out", "", "file to write the JSON report to, stdout when empty")
+	strict := fs.Bool("strict", false, "fail on gaps too")
+	fs.Parse(args)
+
+	sum := verifySummary{Root: *root}
+	checked := map[string]bool{}
+	exts := []string{".csv", ".klb", ".parquet"}
+	for _, ext := range exts {
+		all, err := selectSeries(*root, format{ext: ext}, splitList(*symbols), splitList(*intervals))
+		if err != nil {
+			return err
+		}
+		for _, s := range all {
+			rep, err := verifyFile(*root, ext, s)
+			if err != nil {
+				return fmt.Errorf("verify %s %s%s: %w", s.Symbol, s.Interval, ext, err)
+			}
+			checked[rep.Path] = true
+			sum.Files++
+			if rep.Failed(*strict) {
+				sum.Failed++
+			}
+			for kind, n := range rep.Counts {
+				if sum.Issues == nil {
+					sum.Issues = map[storage.IssueKind]int{}
+				}
+				sum.Issues[kind] += n
+			}
+			sum.Reports = append(sum.Reports, rep)
+		}
+	}
+	if *symbols == "" && *intervals == "" {
+		others, err := otherFiles(*root, checked)
+		if err != nil {
+			return fmt.Errorf("walk %q: %w", *root, err)
+		}
+		sum.Skipped = others
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		fp, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("create %q: %w", *out, err)
+		}
+		defer fp.Close()
+		w = fp
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&sum); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	if sum.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", sum.Failed, sum.Files)
+	}
+	return nil
+}
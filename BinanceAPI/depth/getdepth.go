@@ -0,0 +1,117 @@
+package depth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+// Valid values of the limit parameter; the API rejects anything else.
+var GetDepthLimits = []uint32{5, 10, 20, 50, 100, 500, 1000}
+
+type PriceLevel struct {
+	Price    float64
+	Quantity float64
+}
+
+// Snapshot is the order book at one point in time. Bids are sorted from the highest
+// price, asks from the lowest.
+type Snapshot struct {
+	LastUpdateID int64
+	Time         time.Time
+	Bids         []PriceLevel
+	Asks         []PriceLevel
+}
+
+type GetDepthParam struct {
+	Symbol string
+	Limit  uint32 // One of GetDepthLimits, 0 means the API default of 500.
+}
+
+func GetDepth(ctx context.Context, param GetDepthParam) (*Snapshot, error) {
+	// Prepare request.
+	const apiURL = common.RootAPIEndPoint + "/fapi/v1/depth"
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+	query := url.Values{}
+	query.Add("symbol", param.Symbol)
+	if param.Limit != 0 {
+		query.Add("limit", strconv.FormatUint(uint64(param.Limit), 10))
+	}
+	req.URL.RawQuery = query.Encode()
+
+	// Execute HTTP request.
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get query %q: %w", req.URL.RawQuery, err)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("http status code(%d) status(%q)", rsp.StatusCode, rsp.Status)
+		}
+		return nil, fmt.Errorf("http status code(%d) status(%q) body(%q)", rsp.StatusCode, rsp.Status, body)
+	}
+
+	return parseGetDepthRsp(rsp.Body)
+}
+
+type depthRsp struct {
+	LastUpdateID    int64      `json:"lastUpdateId"`
+	TransactionTime int64      `json:"T"`
+	Bids            [][]string `json:"bids"`
+	Asks            [][]string `json:"asks"`
+}
+
+func parseGetDepthRsp(body io.Reader) (*Snapshot, error) {
+	var rsp depthRsp
+	if err := json.NewDecoder(body).Decode(&rsp); err != nil {
+		return nil, fmt.Errorf("json decoder decode: %w", err)
+	}
+	bids, err := ParsePriceLevels(rsp.Bids)
+	if err != nil {
+		return nil, fmt.Errorf("parse bids: %w", err)
+	}
+	asks, err := ParsePriceLevels(rsp.Asks)
+	if err != nil {
+		return nil, fmt.Errorf("parse asks: %w", err)
+	}
+	return &Snapshot{
+		LastUpdateID: rsp.LastUpdateID,
+		Time:         time.UnixMilli(rsp.TransactionTime),
+		Bids:         bids,
+		Asks:         asks,
+	}, nil
+}
+
+// ParsePriceLevels parses the [price, quantity] string pairs used by the REST API
+// and the depth streams.
+func ParsePriceLevels(entries [][]string) ([]PriceLevel, error) {
+	levels := make([]PriceLevel, len(entries))
+	for idx, entry := range entries {
+		if len(entry) < 2 {
+			return nil, errors.New("expect [price, quantity] level")
+		}
+		var err error
+		if levels[idx].Price, err = common.ParseFloat64FromAnyString(entry[0]); err != nil {
+			return nil, fmt.Errorf("parse price of level %d: %w", idx, err)
+		}
+		if levels[idx].Quantity, err = common.ParseFloat64FromAnyString(entry[1]); err != nil {
+			return nil, fmt.Errorf("parse quantity of level %d: %w", idx, err)
+		}
+	}
+	return levels, nil
+}
@@ -0,0 +1,122 @@
+package funding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+const (
+	ListFundingRatesMaxLimit uint32 = 1000
+
+	// Binance settles funding every 8 hours at 00:00, 08:00 and 16:00 UTC.
+	FundingInterval = 8 * time.Hour
+)
+
+type FundingRate struct {
+	Symbol      string
+	FundingTime time.Time
+	FundingRate float64 // Positive means longs pay shorts.
+	MarkPrice   float64 // Mark price at funding time, 0 if the exchange did not report it.
+}
+
+// ListFundingRates API will return the funding rate history of the specified symbol
+// in chronological order within [StartTime, EndTime] inclusively.
+//
+// If limit exceeds 1000 or if limit = 0, then the API returns the first 1000 entries.
+type ListFundingRatesParam struct {
+	Symbol    string
+	StartTime time.Time
+	EndTime   time.Time
+	Limit     uint32
+}
+
+func ListFundingRates(ctx context.Context, param ListFundingRatesParam) ([]FundingRate, error) {
+	if param.StartTime.After(param.EndTime) {
+		return nil, nil
+	}
+	if param.Limit == 0 || param.Limit >= ListFundingRatesMaxLimit {
+		param.Limit = ListFundingRatesMaxLimit
+	}
+	return listFundingRatesAPI(ctx, &param)
+}
+
+func listFundingRatesAPI(ctx context.Context, param *ListFundingRatesParam) ([]FundingRate, error) {
+	// Prepare request.
+	const apiURL = common.RootAPIEndPoint + "/fapi/v1/fundingRate"
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+	query := url.Values{}
+	query.Add("symbol", param.Symbol)
+	query.Add("startTime", strconv.FormatInt(param.StartTime.UnixMilli(), 10))
+	query.Add("endTime", strconv.FormatInt(param.EndTime.UnixMilli(), 10))
+	query.Add("limit", strconv.FormatUint(uint64(param.Limit), 10))
+	req.URL.RawQuery = query.Encode()
+
+	// Execute HTTP request.
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get query %q: %w", req.URL.RawQuery, err)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("http status code(%d) status(%q)", rsp.StatusCode, rsp.Status)
+		}
+		return nil, fmt.Errorf("http status code(%d) status(%q) body(%q)", rsp.StatusCode, rsp.Status, body)
+	}
+
+	return parseListFundingRatesRsp(rsp.Body)
+}
+
+type fundingRateEntry struct {
+	Symbol      string `json:"symbol"`
+	FundingTime int64  `json:"fundingTime"`
+	FundingRate string `json:"fundingRate"`
+	MarkPrice   string `json:"markPrice"`
+}
+
+func parseListFundingRatesRsp(body io.Reader) ([]FundingRate, error) {
+	var entries []fundingRateEntry
+	if err := json.NewDecoder(body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("json decoder decode: %w", err)
+	}
+
+	rates := make([]FundingRate, len(entries))
+	for idx, entry := range entries {
+		rate, err := common.ParseFloat64FromAnyString(entry.FundingRate)
+		if err != nil {
+			return nil, fmt.Errorf("parse funding rate field (entry: %+v): %w", entry, err)
+		}
+		rates[idx] = FundingRate{
+			Symbol:      entry.Symbol,
+			FundingTime: time.UnixMilli(entry.FundingTime),
+			FundingRate: rate,
+		}
+		// Old entries do not carry a mark price.
+		if entry.MarkPrice != "" {
+			if rates[idx].MarkPrice, err = common.ParseFloat64FromAnyString(entry.MarkPrice); err != nil {
+				return nil, fmt.Errorf("parse mark price field (entry: %+v): %w", entry, err)
+			}
+		}
+	}
+
+	// Sort by funding time.
+	slices.SortFunc(rates, func(a, b FundingRate) int {
+		return a.FundingTime.Compare(b.FundingTime)
+	})
+	return rates, nil
+}
@@ -0,0 +1,275 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+var (
+	ErrCorrupt = errors.New("corrupt journal")
+	ErrClosed  = errors.New("journal closed")
+
+	errIncomplete = errors.New("incomplete entry")
+)
+
+type Kind string
+
+const (
+	Kind_START          Kind = "START"          // A runner session starts, Note holds the config.
+	Kind_STOP           Kind = "STOP"           // A runner session shuts down.
+	Kind_WARMUP         Kind = "WARMUP"         // Bars fed to the strategy without trading.
+	Kind_BAR            Kind = "BAR"            // A closed bar handed to the strategy.
+	Kind_SIGNAL         Kind = "SIGNAL"         // A strategy decision and the values behind it.
+	Kind_ORDER_REQUEST  Kind = "ORDER_REQUEST"  // Written before the order is sent.
+	Kind_ORDER_ACK      Kind = "ORDER_ACK"      // The exchange accepted the order as OrderID.
+	Kind_ORDER_REJECT   Kind = "ORDER_REJECT"   // The exchange rejected the order.
+	Kind_RISK_REJECTION Kind = "RISK_REJECTION" // The risk manager stopped the order.
+	Kind_CANCEL         Kind = "CANCEL"         // Result of cancelling OrderID.
+	Kind_AMEND          Kind = "AMEND"          // Result of amending OrderID to Quantity @ Price.
+	Kind_FILL           Kind = "FILL"
+	Kind_POSITION       Kind = "POSITION"    // Result of a position query.
+	Kind_OPEN_ORDERS    Kind = "OPEN_ORDERS" // Result of an open orders query.
+	Kind_BALANCE        Kind = "BALANCE"     // Result of a balance query.
+)
+
+// Signal is what a strategy decided and why, e.g. {"LONG", {"fast": 101, "slow": 99}}.
+type Signal struct {
+	Name   string
+	Values map[string]float64 `json:",omitempty"`
+}
+
+// Entry is one journal record. Only the fields of its Kind are set.
+type Entry struct {
+	Seq        int64 // Starts at 1 and increases by 1 per entry.
+	Time       time.Time
+	Kind       Kind
+	Symbol     string             `json:",omitempty"`
+	Bar        *klines.KLine      `json:",omitempty"`
+	Bars       []klines.KLine     `json:",omitempty"`
+	Signal     *Signal            `json:",omitempty"`
+	Order      *orders.Order      `json:",omitempty"`
+	OrderID    int64              `json:",omitempty"`
+	Quantity   float64            `json:",omitempty"`
+	Price      float64            `json:",omitempty"`
+	Fill       *broker.Fill       `json:",omitempty"`
+	Position   *orders.Position   `json:",omitempty"`
+	OpenOrders []broker.OpenOrder `json:",omitempty"`
+	Balance    *broker.Balance    `json:",omitempty"`
+	Error      string             `json:",omitempty"`
+	Note       string             `json:",omitempty"`
+}
+
+// Appender is where entries go: a file Writer, or Memory during a replay.
+type Appender interface {
+	// Append sets the Seq and, if zero, the Time of e before storing it.
+	Append(e *Entry) error
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeEntry returns one journal line: the CRC-32C of the JSON in hex, a space,
+// the JSON and a newline.
+func encodeEntry(e *Entry) ([]byte, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal entry: %w", err)
+	}
+	line := make([]byte, 0, len(payload)+10)
+	line = fmt.Appendf(line, "%08x ", crc32.Checksum(payload, castagnoli))
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeEntry(line []byte) (*Entry, error) {
+	sum, payload, ok := bytes.Cut(line, []byte(" "))
+	if !ok || len(sum) != 8 {
+		return nil, errors.New("no checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("parse checksum %q: %w", sum, err)
+	}
+	if got := crc32.Checksum(payload, castagnoli); uint32(want) != got {
+		return nil, fmt.Errorf("checksum %08x want %08x", got, want)
+	}
+	var e Entry
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("json unmarshal entry: %w", err)
+	}
+	return &e, nil
+}
+
+// Reader reads and verifies the entries of a journal.
+type Reader struct {
+	br      *bufio.Reader
+	line    int
+	offset  int64 // End of the last good entry.
+	lastSeq int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// Next returns the next entry, io.EOF at the end of the journal, or an error
+// wrapping ErrCorrupt when a checksum does not match, the sequence has a gap or
+// the last line is incomplete.
+func (r *Reader) Next() (*Entry, error) {
+	line, err := r.br.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, io.EOF
+	}
+	r.line++
+	if err == io.EOF {
+		return nil, fmt.Errorf("line %d: %w: %w", r.line, errIncomplete, ErrCorrupt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read line %d: %w", r.line, err)
+	}
+	e, err := decodeEntry(bytes.TrimSuffix(line, []byte("\n")))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v: %w", r.line, err, ErrCorrupt)
+	}
+	if e.Seq != r.lastSeq+1 {
+		return nil, fmt.Errorf("line %d: seq %d after %d: %w", r.line, e.Seq, r.lastSeq, ErrCorrupt)
+	}
+	r.lastSeq = e.Seq
+	r.offset += int64(len(line))
+	return e, nil
+}
+
+// ReadFile returns all entries of the journal at path.
+func ReadFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+	r := NewReader(f)
+	var res []Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", path, err)
+		}
+		res = append(res, *e)
+	}
+}
+
+// Writer appends entries to a journal file, syncing every entry to disk. It is safe
+// for concurrent use. After a failed write every Append returns the same error.
+type Writer struct {
+	mu      sync.Mutex
+	f       *os.File
+	nextSeq int64
+	err     error
+}
+
+// Open opens the journal at path for appending, creating it if needed. An
+// incomplete last entry left by a crash is cut off; any other corruption is an
+// error, as appending to it would hide the damage.
+func Open(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	r := NewReader(f)
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, errIncomplete) {
+				// A torn write of the last entry.
+				if err := f.Truncate(r.offset); err != nil {
+					f.Close()
+					return nil, fmt.Errorf("truncate torn entry: %w", err)
+				}
+				break
+			}
+			f.Close()
+			return nil, fmt.Errorf("read %q: %w", path, err)
+		}
+	}
+	if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("seek journal end: %w", err)
+	}
+	return &Writer{f: f, nextSeq: r.lastSeq + 1}, nil
+}
+
+func (w *Writer) Append(e *Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	e.Seq = w.nextSeq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := encodeEntry(e)
+	if err != nil {
+		return err // The journal itself is fine.
+	}
+	if _, err := w.f.Write(line); err != nil {
+		w.err = fmt.Errorf("write entry: %w", err)
+		return w.err
+	}
+	if err := w.f.Sync(); err != nil {
+		w.err = fmt.Errorf("sync journal: %w", err)
+		return w.err
+	}
+	w.nextSeq++
+	return nil
+}
+
+// Err returns the error that broke the journal, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = ErrClosed
+	}
+	return w.f.Close()
+}
+
+// Memory keeps the entries in memory, e.g. to compare a replay with the journal.
+type Memory struct {
+	mu      sync.Mutex
+	Entries []Entry
+}
+
+func (m *Memory) Append(e *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.Seq = int64(len(m.Entries)) + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	m.Entries = append(m.Entries, *e)
+	return nil
+}
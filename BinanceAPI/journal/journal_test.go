@@ -0,0 +1,127 @@
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJournal writes a journal of three entries and returns its path and lines.
+func testJournal(t *testing.T) (string, [][]byte) {
+	path := filepath.Join(t.TempDir(), "journal.log")
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, kind := range []Kind{Kind_START, Kind_SIGNAL, Kind_STOP} {
+		e := &Entry{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Kind: kind, Note: "note"}
+		if err := w.Append(e); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, bytes.SplitAfter(b, []byte("\n"))[:3]
+}
+
+func TestJournalCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(lines [][]byte) []byte
+		wantErr bool // Of Open; ReadFile fails on any corruption.
+		wantNum int  // Entries kept by Open.
+	}{
+		{
+			name:    "intact",
+			corrupt: func(lines [][]byte) []byte { return bytes.Join(lines, nil) },
+			wantNum: 3,
+		},
+		{
+			name: "flipped byte",
+			corrupt: func(lines [][]byte) []byte {
+				line := bytes.Clone(lines[1])
+				line[len(line)-5] ^= 1 // In the JSON, so only the checksum catches it.
+				return bytes.Join([][]byte{lines[0], line, lines[2]}, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name: "flipped byte in the last entry",
+			corrupt: func(lines [][]byte) []byte {
+				line := bytes.Clone(lines[2])
+				line[len(line)-5] ^= 1
+				return bytes.Join([][]byte{lines[0], lines[1], line}, nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "dropped line",
+			corrupt: func(lines [][]byte) []byte { return bytes.Join([][]byte{lines[0], lines[2]}, nil) },
+			wantErr: true,
+		},
+		{
+			name: "torn last entry",
+			corrupt: func(lines [][]byte) []byte {
+				return bytes.Join([][]byte{lines[0], lines[1], lines[2][:len(lines[2])/2]}, nil)
+			},
+			wantNum: 2,
+		},
+		{
+			name: "torn last entry without its newline",
+			corrupt: func(lines [][]byte) []byte {
+				return bytes.Join([][]byte{lines[0], lines[1], lines[2][:len(lines[2])-1]}, nil)
+			},
+			wantNum: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, lines := testJournal(t)
+			content := tt.corrupt(lines)
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ReadFile(path)
+			if corrupt := tt.wantErr || tt.wantNum < 3; corrupt != errors.Is(err, ErrCorrupt) {
+				t.Errorf("ReadFile: %v, want ErrCorrupt %v", err, corrupt)
+			}
+
+			w, err := Open(path)
+			if tt.wantErr {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Fatalf("Open: %v, want %v", err, ErrCorrupt)
+				}
+				if b, _ := os.ReadFile(path); !bytes.Equal(b, content) {
+					t.Errorf("Open changed the corrupt journal")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			e := &Entry{Kind: Kind_START}
+			if err := w.Append(e); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			if want := int64(tt.wantNum + 1); e.Seq != want {
+				t.Errorf("appended seq %d, want %d", e.Seq, want)
+			}
+			w.Close()
+			entries, err := ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile after Append: %v", err)
+			}
+			if len(entries) != tt.wantNum+1 {
+				t.Errorf("%d entries, want %d", len(entries), tt.wantNum+1)
+			}
+		})
+	}
+}
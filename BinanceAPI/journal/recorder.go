@@ -0,0 +1,104 @@
+package journal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// Recorder is a broker.Broker journaling every call it forwards and every fill of
+// the wrapped broker. Journal failures never fail the calls; check Err.
+type Recorder struct {
+	b           broker.Broker
+	j           Appender
+	unsubscribe func()
+
+	mu  sync.Mutex
+	err error // Of the first entry that could not be journaled.
+}
+
+func NewRecorder(b broker.Broker, j Appender) *Recorder {
+	r := &Recorder{b: b, j: j}
+	r.unsubscribe = b.SubscribeFills(func(f broker.Fill) {
+		r.append(&Entry{Kind: Kind_FILL, Symbol: f.Symbol, Fill: &f})
+	})
+	return r
+}
+
+func (r *Recorder) append(e *Entry) {
+	if err := r.j.Append(e); err != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.err == nil {
+			r.err = fmt.Errorf("journal %s entry: %w", e.Kind, err)
+		}
+	}
+}
+
+// Err returns the first journal failure. The journal misses entries from then on,
+// so replaying it is no longer faithful.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close stops journaling fills.
+func (r *Recorder) Close() {
+	r.unsubscribe()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (r *Recorder) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	r.append(&Entry{Kind: Kind_ORDER_REQUEST, Symbol: o.Symbol, Order: &o})
+	id, err := r.b.SubmitOrder(ctx, o)
+	if err != nil {
+		r.append(&Entry{Kind: Kind_ORDER_REJECT, Symbol: o.Symbol, Error: err.Error()})
+		return 0, err
+	}
+	r.append(&Entry{Kind: Kind_ORDER_ACK, Symbol: o.Symbol, OrderID: id})
+	return id, nil
+}
+
+func (r *Recorder) AmendOrder(ctx context.Context, symbol string, id int64, quantity, price float64) error {
+	err := r.b.AmendOrder(ctx, symbol, id, quantity, price)
+	r.append(&Entry{Kind: Kind_AMEND, Symbol: symbol, OrderID: id, Quantity: quantity, Price: price, Error: errString(err)})
+	return err
+}
+
+func (r *Recorder) CancelOrder(ctx context.Context, symbol string, id int64) error {
+	err := r.b.CancelOrder(ctx, symbol, id)
+	r.append(&Entry{Kind: Kind_CANCEL, Symbol: symbol, OrderID: id, Error: errString(err)})
+	return err
+}
+
+func (r *Recorder) OpenOrders(ctx context.Context, symbol string) ([]broker.OpenOrder, error) {
+	open, err := r.b.OpenOrders(ctx, symbol)
+	r.append(&Entry{Kind: Kind_OPEN_ORDERS, Symbol: symbol, OpenOrders: open, Error: errString(err)})
+	return open, err
+}
+
+func (r *Recorder) Position(ctx context.Context, symbol string) (orders.Position, error) {
+	pos, err := r.b.Position(ctx, symbol)
+	r.append(&Entry{Kind: Kind_POSITION, Symbol: symbol, Position: &pos, Error: errString(err)})
+	return pos, err
+}
+
+func (r *Recorder) Balance(ctx context.Context) (broker.Balance, error) {
+	bal, err := r.b.Balance(ctx)
+	r.append(&Entry{Kind: Kind_BALANCE, Balance: &bal, Error: errString(err)})
+	return bal, err
+}
+
+func (r *Recorder) SubscribeFills(handle func(broker.Fill)) func() {
+	return r.b.SubscribeFills(handle)
+}
@@ -0,0 +1,53 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// failingAppender fails every Append after the first n.
+type failingAppender struct {
+	Memory
+	n int
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (a *failingAppender) Append(e *Entry) error {
+	if len(a.Entries) >= a.n {
+		return errDiskFull
+	}
+	return a.Memory.Append(e)
+}
+
+func TestRecorderErr(t *testing.T) {
+	ctx := context.Background()
+	rp := NewReplayer()
+	rp.Expect([]Entry{
+		{Seq: 1, Kind: Kind_POSITION, Symbol: "BTCUSDT", Position: &orders.Position{Symbol: "BTCUSDT"}},
+		{Seq: 2, Kind: Kind_POSITION, Symbol: "BTCUSDT", Position: &orders.Position{Symbol: "BTCUSDT"}},
+	})
+	j := &failingAppender{n: 1}
+	rec := NewRecorder(rp, j)
+	defer rec.Close()
+
+	if _, err := rec.Position(ctx, "BTCUSDT"); err != nil {
+		t.Fatalf("Position: %v", err)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Err() = %v after a journaled call, want nil", err)
+	}
+	// The journal failing does not fail the call, but is reported.
+	if _, err := rec.Position(ctx, "BTCUSDT"); err != nil {
+		t.Fatalf("Position: %v", err)
+	}
+	if err := rec.Err(); !errors.Is(err, errDiskFull) {
+		t.Fatalf("Err() = %v, want %v", err, errDiskFull)
+	}
+	if len(j.Entries) != 1 {
+		t.Errorf("%d entries journaled, want 1", len(j.Entries))
+	}
+}
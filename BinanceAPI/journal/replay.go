@@ -0,0 +1,226 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+var (
+	ErrDiverged = errors.New("replay diverged from journal")
+)
+
+// IsCall reports whether entries of the kind record a broker call, which a
+// Replayer answers from the journal.
+func IsCall(k Kind) bool {
+	switch k {
+	case Kind_ORDER_REQUEST, Kind_ORDER_ACK, Kind_ORDER_REJECT, Kind_CANCEL, Kind_AMEND,
+		Kind_POSITION, Kind_OPEN_ORDERS, Kind_BALANCE:
+		return true
+	}
+	return false
+}
+
+// IsDecision reports whether entries of the kind record what the strategy and the
+// order manager decided, which a replay must reproduce exactly.
+func IsDecision(k Kind) bool {
+	switch k {
+	case Kind_SIGNAL, Kind_ORDER_REQUEST, Kind_RISK_REJECTION, Kind_CANCEL, Kind_AMEND:
+		return true
+	}
+	return false
+}
+
+// Divergence is a point where the replay did something else than the journal.
+type Divergence struct {
+	Seq  int64  // Of the journal entry, 0 when the replay did more than recorded.
+	Want string // What the journal recorded.
+	Got  string // What the replay did.
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("seq %d: want %s, got %s", d.Seq, d.Want, d.Got)
+}
+
+// Replayer is a broker.Broker answering every call with the result recorded in
+// the journal, so the code under replay sees exactly what it saw live. It only
+// checks the kind of each call; CompareDecisions checks their content. The calls
+// expected next are set with Expect; fills are delivered with DeliverFill. It is
+// not safe for concurrent use.
+type Replayer struct {
+	calls       []Entry
+	next        int
+	divergences []Divergence
+
+	fillHandlers map[int]func(broker.Fill)
+	nextHandler  int
+}
+
+func NewReplayer() *Replayer {
+	return &Replayer{fillHandlers: map[int]func(broker.Fill){}}
+}
+
+// Expect sets the call entries the next step of the replay must consume, in order.
+// Calls left over from the previous step are reported as divergences.
+func (r *Replayer) Expect(calls []Entry) {
+	r.Finish()
+	r.calls, r.next = calls, 0
+}
+
+// Finish reports the calls of the current step that were not made.
+func (r *Replayer) Finish() {
+	for _, e := range r.calls[r.next:] {
+		r.divergences = append(r.divergences, Divergence{Seq: e.Seq, Want: describe(&e), Got: "nothing"})
+	}
+	r.calls, r.next = nil, 0
+}
+
+func (r *Replayer) Divergences() []Divergence {
+	return r.divergences
+}
+
+// DeliverFill calls the fill handlers with a recorded fill.
+func (r *Replayer) DeliverFill(f broker.Fill) {
+	for _, key := range slices.Sorted(maps.Keys(r.fillHandlers)) {
+		if handle, ok := r.fillHandlers[key]; ok {
+			handle(f)
+		}
+	}
+}
+
+// describe renders what a call or decision entry says, leaving out Seq, Time and
+// the query results.
+func describe(e *Entry) string {
+	data, err := json.Marshal(struct {
+		Kind     Kind
+		Symbol   string        `json:",omitempty"`
+		Signal   *Signal       `json:",omitempty"`
+		Order    *orders.Order `json:",omitempty"`
+		OrderID  int64         `json:",omitempty"`
+		Quantity float64       `json:",omitempty"`
+		Price    float64       `json:",omitempty"`
+		Error    string        `json:",omitempty"`
+	}{e.Kind, e.Symbol, e.Signal, e.Order, e.OrderID, e.Quantity, e.Price, e.Error})
+	if err != nil {
+		return string(e.Kind)
+	}
+	return string(data)
+}
+
+// pop consumes the next expected call, which must be of one of the kinds.
+func (r *Replayer) pop(got *Entry, kinds ...Kind) (*Entry, error) {
+	if r.next >= len(r.calls) {
+		r.divergences = append(r.divergences, Divergence{Want: "nothing", Got: describe(got)})
+		return nil, fmt.Errorf("unexpected %s: %w", got.Kind, ErrDiverged)
+	}
+	e := &r.calls[r.next]
+	if !slices.Contains(kinds, e.Kind) {
+		r.divergences = append(r.divergences, Divergence{Seq: e.Seq, Want: describe(e), Got: describe(got)})
+		return nil, fmt.Errorf("seq %d is %s, not %s: %w", e.Seq, e.Kind, got.Kind, ErrDiverged)
+	}
+	r.next++
+	return e, nil
+}
+
+func recordedErr(e *Entry) error {
+	if e.Error == "" {
+		return nil
+	}
+	return errors.New(e.Error)
+}
+
+func (r *Replayer) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	got := &Entry{Kind: Kind_ORDER_REQUEST, Symbol: o.Symbol, Order: &o}
+	if _, err := r.pop(got, Kind_ORDER_REQUEST); err != nil {
+		return 0, err
+	}
+	rsp, err := r.pop(&Entry{Kind: Kind_ORDER_ACK, Symbol: o.Symbol}, Kind_ORDER_ACK, Kind_ORDER_REJECT)
+	if err != nil {
+		return 0, err
+	}
+	return rsp.OrderID, recordedErr(rsp)
+}
+
+func (r *Replayer) AmendOrder(ctx context.Context, symbol string, id int64, quantity, price float64) error {
+	got := &Entry{Kind: Kind_AMEND, Symbol: symbol, OrderID: id, Quantity: quantity, Price: price}
+	e, err := r.pop(got, Kind_AMEND)
+	if err != nil {
+		return err
+	}
+	return recordedErr(e)
+}
+
+func (r *Replayer) CancelOrder(ctx context.Context, symbol string, id int64) error {
+	got := &Entry{Kind: Kind_CANCEL, Symbol: symbol, OrderID: id}
+	e, err := r.pop(got, Kind_CANCEL)
+	if err != nil {
+		return err
+	}
+	return recordedErr(e)
+}
+
+func (r *Replayer) OpenOrders(ctx context.Context, symbol string) ([]broker.OpenOrder, error) {
+	e, err := r.pop(&Entry{Kind: Kind_OPEN_ORDERS, Symbol: symbol}, Kind_OPEN_ORDERS)
+	if err != nil {
+		return nil, err
+	}
+	return e.OpenOrders, recordedErr(e)
+}
+
+func (r *Replayer) Position(ctx context.Context, symbol string) (orders.Position, error) {
+	e, err := r.pop(&Entry{Kind: Kind_POSITION, Symbol: symbol}, Kind_POSITION)
+	if err != nil {
+		return orders.Position{}, err
+	}
+	if e.Position == nil {
+		return orders.Position{}, recordedErr(e)
+	}
+	return *e.Position, recordedErr(e)
+}
+
+func (r *Replayer) Balance(ctx context.Context) (broker.Balance, error) {
+	e, err := r.pop(&Entry{Kind: Kind_BALANCE}, Kind_BALANCE)
+	if err != nil {
+		return broker.Balance{}, err
+	}
+	if e.Balance == nil {
+		return broker.Balance{}, recordedErr(e)
+	}
+	return *e.Balance, recordedErr(e)
+}
+
+func (r *Replayer) SubscribeFills(handle func(broker.Fill)) func() {
+	key := r.nextHandler
+	r.nextHandler++
+	r.fillHandlers[key] = handle
+	return func() { delete(r.fillHandlers, key) }
+}
+
+// CompareDecisions lines up the decision entries of the journal with those of a
+// replay and returns where they differ.
+func CompareDecisions(recorded, replayed []Entry) []Divergence {
+	filter := func(entries []Entry) []Entry {
+		return slices.DeleteFunc(slices.Clone(entries), func(e Entry) bool { return !IsDecision(e.Kind) })
+	}
+	want, got := filter(recorded), filter(replayed)
+	var res []Divergence
+	for idx := range max(len(want), len(got)) {
+		switch {
+		case idx >= len(got):
+			res = append(res, Divergence{Seq: want[idx].Seq, Want: describe(&want[idx]), Got: "nothing"})
+		case idx >= len(want):
+			res = append(res, Divergence{Want: "nothing", Got: describe(&got[idx])})
+		default:
+			if w, g := describe(&want[idx]), describe(&got[idx]); w != g {
+				res = append(res, Divergence{Seq: want[idx].Seq, Want: w, Got: g})
+			}
+		}
+	}
+	return res
+}
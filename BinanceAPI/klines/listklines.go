@@ -13,12 +13,44 @@ import (
 	"time"
 
 	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
 )
 
 const (
-	ListKLinesMaxLimit uint32 = 1500
+	ListKLinesMaxLimit     uint32 = 1500
+	ListSpotKLinesMaxLimit uint32 = 1000
+	// ListKLinesEndpoint is where ListKLines gets the KLines of perpetual contracts.
+	ListKLinesEndpoint = common.RootAPIEndPoint + "/fapi/v1/continuousKlines"
+	// ListSpotKLinesEndpoint is where ListKLines gets the KLines of spot pairs.
+	ListSpotKLinesEndpoint = common.SpotRootAPIEndPoint + "/api/v3/klines"
 )
 
+// MaxLimit returns how many KLines one ListKLines call returns at most in the
+// market.
+func MaxLimit(market common.MarketType) uint32 {
+	if market == common.MarketType_SPOT {
+		return ListSpotKLinesMaxLimit
+	}
+	return ListKLinesMaxLimit
+}
+
+// RequestWeight returns the request weight of a ListKLines call returning limit
+// KLines in the market.
+func RequestWeight(market common.MarketType, limit uint32) int {
+	switch {
+	case market == common.MarketType_SPOT:
+		return 2
+	case limit < 100:
+		return 1
+	case limit < 500:
+		return 2
+	case limit <= 1000:
+		return 5
+	default:
+		return 10
+	}
+}
+
 type KLine struct {
 	OpenTime         time.Time
 	CloseTime        time.Time
@@ -29,42 +61,57 @@ type KLine struct {
 	Volume           float64 // Number of BTC when referring to BTC/USDT.
 	QuoteAssetVolume float64 // Number of USDT when referring to BTC/USDT.
 	TradeNum         float64 // Number of trades.
+	// Synthetic marks a bar the exchange did not return, filled in as a flat bar
+	// at the previous close with no volume to keep the series consecutive.
+	Synthetic bool
 }
 
 // ListKLines API will return the KLines of the specified ticker in chronological order
 // within [StartTime, EndTime] inclusively.
 //
-// If limit exceeds 1500 or if limit = 0, then the API returns the first 1500 KLines.
-// Otherwise, the API will return the first "limit" number of KLines.
+// If limit exceeds MaxLimit (1500, or 1000 for spot) or if limit = 0, then the API
+// returns the first MaxLimit KLines. Otherwise, the API will return the first
+// "limit" number of KLines.
 type ListKLinesParam struct {
 	TickerSymbol string
 	Interval     common.ListKLinesInterval
 	StartTime    time.Time
 	EndTime      time.Time
 	Limit        uint32
+	Market       common.MarketType // Perpetual contracts when empty.
+	// Limiter, when set, holds the call back until its weight is available and
+	// learns the used weight from the response.
+	Limiter *ratelimit.Limiter
 }
 
 func ListKLines(ctx context.Context, param ListKLinesParam) ([]KLine, error) {
 	if param.StartTime.After(param.EndTime) {
 		return nil, nil
 	}
-	if param.Limit == 0 || param.Limit >= ListKLinesMaxLimit {
-		param.Limit = ListKLinesMaxLimit
+	if maxLimit := MaxLimit(param.Market); param.Limit == 0 || param.Limit >= maxLimit {
+		param.Limit = maxLimit
 	}
 	return listKLineAPI(ctx, &param)
 }
 
 func listKLineAPI(ctx context.Context, param *ListKLinesParam) ([]KLine, error) {
 	// Prepare request.
-	const apiURL = common.RootAPIEndPoint + "/fapi/v1/continuousKlines"
+	apiURL := ListKLinesEndpoint
+	if param.Market == common.MarketType_SPOT {
+		apiURL = ListSpotKLinesEndpoint
+	}
 	client := http.Client{}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("new request url %q: %w", apiURL, err)
 	}
 	query := url.Values{}
-	query.Add("pair", param.TickerSymbol)
-	query.Add("contractType", "PERPETUAL")
+	if param.Market == common.MarketType_SPOT {
+		query.Add("symbol", param.TickerSymbol)
+	} else {
+		query.Add("pair", param.TickerSymbol)
+		query.Add("contractType", "PERPETUAL")
+	}
 	query.Add("interval", string(param.Interval))
 	query.Add("startTime", strconv.FormatInt(param.StartTime.UnixMilli(), 10))
 	query.Add("endTime", strconv.FormatInt(param.EndTime.UnixMilli(), 10))
@@ -72,11 +119,17 @@ func listKLineAPI(ctx context.Context, param *ListKLinesParam) ([]KLine, error)
 	req.URL.RawQuery = query.Encode()
 
 	// Execute HTTP request.
+	if err := param.Limiter.Wait(ctx, RequestWeight(param.Market, param.Limit)); err != nil {
+		return nil, fmt.Errorf("Limiter.Wait: %w", err)
+	}
 	rsp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http get query %q: %w", req.URL.RawQuery, err)
 	}
 	defer rsp.Body.Close()
+	if err := param.Limiter.Observe(rsp); err != nil {
+		return nil, err
+	}
 
 	if rsp.StatusCode != http.StatusOK {
 		body, err := io.ReadAll(rsp.Body)
@@ -0,0 +1,57 @@
+package klines
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+)
+
+// ServerTime returns the exchange's clock, which decides when KLines close.
+// limiter may be nil.
+func ServerTime(ctx context.Context, market common.MarketType, limiter *ratelimit.Limiter) (time.Time, error) {
+	// Prepare request.
+	apiURL := common.RootAPIEndPoint + "/fapi/v1/time"
+	if market == common.MarketType_SPOT {
+		apiURL = common.SpotRootAPIEndPoint + "/api/v3/time"
+	}
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+
+	// Execute HTTP request.
+	if err := limiter.Wait(ctx, 1); err != nil {
+		return time.Time{}, fmt.Errorf("Limiter.Wait: %w", err)
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("http get %q: %w", apiURL, err)
+	}
+	defer rsp.Body.Close()
+	if err := limiter.Observe(rsp); err != nil {
+		return time.Time{}, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("http status code(%d) status(%q)", rsp.StatusCode, rsp.Status)
+		}
+		return time.Time{}, fmt.Errorf("http status code(%d) status(%q) body(%q)", rsp.StatusCode, rsp.Status, body)
+	}
+
+	var entry struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&entry); err != nil {
+		return time.Time{}, fmt.Errorf("json decoder decode: %w", err)
+	}
+	return time.UnixMilli(entry.ServerTime), nil
+}
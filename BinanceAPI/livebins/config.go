@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/paper"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/risk"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// ShutdownPolicy decides what happens to the exposure on SIGINT/SIGTERM.
+type ShutdownPolicy string
+
+const (
+	ShutdownPolicy_LEAVE_ORDERS  ShutdownPolicy = "LEAVE_ORDERS"  // Orders keep working while the runner is down.
+	ShutdownPolicy_CANCEL_ORDERS ShutdownPolicy = "CANCEL_ORDERS" // Cancel the runner's open orders, keep the position.
+	ShutdownPolicy_FLATTEN       ShutdownPolicy = "FLATTEN"       // Cancel the runner's open orders and close the position.
+)
+
+type StrategyConfig struct {
+	Name   string
+	Params backtest.Params
+}
+
+// Config is the JSON file passed with -config.
+type Config struct {
+	Symbol     string
+	Interval   common.ListKLinesInterval
+	Mode       broker.Mode // PAPER or LIVE.
+	Strategy   StrategyConfig
+	WarmupBars int // Closed bars fed to the strategy before trading, defaults to 500.
+
+	Paper paper.Config // Mode PAPER only. Symbol is taken from above.
+	Asset string       // Mode LIVE only, the margin asset. Credentials come from the environment.
+
+	Risk     risk.Limits
+	Shutdown ShutdownPolicy // Defaults to CANCEL_ORDERS.
+
+	// StatePath is where the runner keeps what it needs to resume after a restart.
+	StatePath string
+	// JournalPath is the append-only journal of every bar, signal, order, fill and
+	// risk rejection, defaults to StatePath + ".journal".
+	JournalPath string
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("json unmarshal config %q: %w", path, err)
+	}
+	if cfg.Symbol == "" {
+		return nil, fmt.Errorf("no symbol: %w", ErrInvalidConfig)
+	}
+	if common.IntervalDuration(cfg.Interval) == 0 {
+		return nil, fmt.Errorf("interval %q: %w", cfg.Interval, ErrInvalidConfig)
+	}
+	if cfg.Mode != broker.Mode_PAPER && cfg.Mode != broker.Mode_LIVE {
+		return nil, fmt.Errorf("mode %q, want PAPER or LIVE: %w", cfg.Mode, ErrInvalidConfig)
+	}
+	if cfg.StatePath == "" {
+		return nil, fmt.Errorf("no state path: %w", ErrInvalidConfig)
+	}
+	if cfg.JournalPath == "" {
+		cfg.JournalPath = cfg.StatePath + ".journal"
+	}
+	if cfg.WarmupBars <= 0 {
+		cfg.WarmupBars = 500
+	}
+	switch cfg.Shutdown {
+	case "":
+		cfg.Shutdown = ShutdownPolicy_CANCEL_ORDERS
+	case ShutdownPolicy_LEAVE_ORDERS, ShutdownPolicy_CANCEL_ORDERS, ShutdownPolicy_FLATTEN:
+	default:
+		return nil, fmt.Errorf("shutdown policy %q: %w", cfg.Shutdown, ErrInvalidConfig)
+	}
+	cfg.Paper.Symbol = cfg.Symbol
+	return &cfg, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/journal"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/risk"
+)
+
+// riskGate is the broker the strategy sees: every order goes through the risk
+// manager first, and the IDs of the orders it places are tracked so the runner
+// knows which open orders are its own after a restart.
+type riskGate struct {
+	broker.Broker
+	risk    *risk.Manager
+	symbol  string
+	journal journal.Appender
+
+	mu       sync.Mutex
+	orderIDs []int64
+}
+
+func (g *riskGate) ownOrders() []int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.orderIDs)
+}
+
+func (g *riskGate) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	if err := g.risk.Check(o); err != nil {
+		log.Printf("risk rejected %s %s %v: %v", o.Side, o.Type, o.Quantity, err)
+		g.journal.Append(&journal.Entry{Kind: journal.Kind_RISK_REJECTION, Symbol: o.Symbol, Order: &o, Error: err.Error()})
+		return 0, fmt.Errorf("risk Check: %w", err)
+	}
+	id, err := g.Broker.SubmitOrder(ctx, o)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("order %d placed: %s %s %v @ %v", id, o.Side, o.Type, o.Quantity, o.Price)
+	g.mu.Lock()
+	g.orderIDs = append(g.orderIDs, id)
+	g.mu.Unlock()
+	return id, nil
+}
+
+func (g *riskGate) CancelOrder(ctx context.Context, symbol string, id int64) error {
+	if err := g.Broker.CancelOrder(ctx, symbol, id); err != nil {
+		return err
+	}
+	g.forget(id)
+	return nil
+}
+
+func (g *riskGate) forget(id int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.orderIDs = slices.DeleteFunc(g.orderIDs, func(x int64) bool { return x == id })
+}
+
+// sync refreshes the risk manager's view of the account and drops the tracked
+// orders that are no longer open.
+func (g *riskGate) sync(ctx context.Context) error {
+	pos, err := g.Broker.Position(ctx, g.symbol)
+	if err != nil {
+		return fmt.Errorf("Position: %w", err)
+	}
+	open, err := g.Broker.OpenOrders(ctx, g.symbol)
+	if err != nil {
+		return fmt.Errorf("OpenOrders: %w", err)
+	}
+	bal, err := g.Broker.Balance(ctx)
+	if err != nil {
+		return fmt.Errorf("Balance: %w", err)
+	}
+	g.risk.SetPosition(pos)
+	g.risk.SetOpenOrders(g.symbol, len(open))
+	g.risk.SetEquity(bal.Equity)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.orderIDs = slices.DeleteFunc(g.orderIDs, func(id int64) bool {
+		return !slices.ContainsFunc(open, func(o broker.OpenOrder) bool { return o.ID == id })
+	})
+	return nil
+}
+
+// CancelAllOpenOrders implements risk.Executor. It only cancels the orders of the
+// runner, leaving the ones placed by hand or by other runners alone.
+func (g *riskGate) CancelAllOpenOrders(ctx context.Context, symbol string) error {
+	open, err := g.Broker.OpenOrders(ctx, symbol)
+	if err != nil {
+		return fmt.Errorf("OpenOrders: %w", err)
+	}
+	own := g.ownOrders()
+	var errs []error
+	for _, o := range open {
+		if !slices.Contains(own, o.ID) {
+			continue
+		}
+		if err := g.CancelOrder(ctx, symbol, o.ID); err != nil {
+			errs = append(errs, fmt.Errorf("CancelOrder(%d): %w", o.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ClosePosition implements risk.Executor. It bypasses the risk checks since it
+// only ever reduces exposure.
+func (g *riskGate) ClosePosition(ctx context.Context, pos orders.Position) error {
+	if pos.Quantity == 0 {
+		return nil
+	}
+	side := common.OrderSide_SELL
+	if pos.Quantity < 0 {
+		side = common.OrderSide_BUY
+	}
+	_, err := g.Broker.SubmitOrder(ctx, orders.Order{
+		Symbol:     pos.Symbol,
+		Side:       side,
+		Type:       common.OrderType_MARKET,
+		Quantity:   math.Abs(pos.Quantity),
+		ReduceOnly: true,
+	})
+	return err
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/journal"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// isBoundary reports whether the entry starts a step of the replay. A step owns
+// the entries up to the next boundary: the broker calls it made and the fills
+// that arrived meanwhile.
+func isBoundary(k journal.Kind) bool {
+	switch k {
+	case journal.Kind_START, journal.Kind_STOP, journal.Kind_WARMUP, journal.Kind_BAR:
+		return true
+	}
+	return false
+}
+
+// replayer drives a runner through a journal, answering its broker calls with the
+// recorded results.
+type replayer struct {
+	ctx      context.Context
+	now      time.Time // Of the entry being replayed, the clock of the risk manager.
+	r        *runner
+	rp       *journal.Replayer
+	replayed *journal.Memory
+	closers  []func()
+
+	divergences []journal.Divergence // Of the broker calls of the sessions replayed so far.
+}
+
+// start rebuilds the session the START entry recorded.
+func (p *replayer) start(e *journal.Entry) error {
+	p.close()
+	var cfg Config
+	if err := json.Unmarshal([]byte(e.Note), &cfg); err != nil {
+		return fmt.Errorf("json unmarshal config of seq %d: %w", e.Seq, err)
+	}
+	cfg.StatePath = ""
+	p.rp = journal.NewReplayer()
+	rec := journal.NewRecorder(p.rp, p.replayed)
+	p.closers = append(p.closers, rec.Close)
+	r, err := newRunner(&cfg, rec, p.replayed)
+	if err != nil {
+		return err
+	}
+	r.gate.risk.SetClock(func() time.Time { return p.now })
+	p.closers = append(p.closers, r.gate.SubscribeFills(func(f broker.Fill) { r.onFill(p.ctx, f) }))
+	p.r = r
+	return nil
+}
+
+func (p *replayer) close() {
+	if p.rp != nil {
+		p.rp.Finish()
+		p.divergences = append(p.divergences, p.rp.Divergences()...)
+		p.rp = nil
+	}
+	for _, c := range p.closers {
+		c()
+	}
+	p.closers = nil
+}
+
+// step replays one boundary entry with its window. Without trading, the broker
+// calls of the window are ignored and only the bars reach the strategy.
+func (p *replayer) step(e *journal.Entry, window []journal.Entry, trading bool) {
+	p.now = e.Time
+	if !trading {
+		switch e.Kind {
+		case journal.Kind_WARMUP:
+			p.warmup(e.Bars)
+		case journal.Kind_BAR:
+			p.warmup([]klines.KLine{*e.Bar})
+		}
+		return
+	}
+	var calls []journal.Entry
+	for _, w := range window {
+		if journal.IsCall(w.Kind) {
+			calls = append(calls, w)
+		}
+	}
+	p.rp.Expect(calls)
+	switch e.Kind {
+	case journal.Kind_START:
+		if err := p.r.recover(p.ctx); err != nil {
+			log.Printf("seq %d: recover: %v", e.Seq, err)
+		}
+	case journal.Kind_WARMUP:
+		p.warmup(e.Bars)
+	case journal.Kind_BAR:
+		p.r.onBar(p.ctx, *e.Bar)
+	case journal.Kind_STOP:
+		p.r.shutdown()
+	}
+	// Live, fills arrive concurrently with the calls; the replay delivers them at
+	// the end of the step, before checking the calls, as handling a fill may make
+	// some, e.g. when it trips the kill switch.
+	for _, w := range window {
+		if w.Kind == journal.Kind_FILL && w.Fill != nil {
+			p.now = w.Time
+			p.rp.DeliverFill(*w.Fill)
+		}
+	}
+	p.rp.Finish()
+}
+
+func (p *replayer) warmup(bars []klines.KLine) {
+	if len(bars) == 0 {
+		return
+	}
+	p.r.strategy.Warmup(bars)
+	p.r.state.LastBarOpenTime = bars[len(bars)-1].OpenTime
+}
+
+// replay runs the strategy of the journal at path through its recorded bars and
+// broker results, and reports where its decisions differ from the recorded ones.
+// With a non-zero day only that UTC day is traded; earlier bars warm up the
+// strategy.
+func replay(path string, day time.Time) error {
+	entries, err := journal.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("journal.ReadFile: %w", err)
+	}
+	p := &replayer{ctx: context.Background(), replayed: &journal.Memory{}}
+	defer p.close()
+	var recorded []journal.Entry
+	bars := 0
+	idx := 0
+	for idx < len(entries) && !isBoundary(entries[idx].Kind) {
+		idx++ // Fills of a session whose START was lost.
+	}
+LOOP:
+	for idx < len(entries) {
+		e := &entries[idx]
+		end := idx + 1
+		for end < len(entries) && !isBoundary(entries[end].Kind) {
+			end++
+		}
+		window := entries[idx+1 : end]
+		idx = end
+
+		trading := true
+		if !day.IsZero() {
+			if !e.Time.Before(day.AddDate(0, 0, 1)) {
+				break LOOP
+			}
+			trading = !e.Time.Before(day)
+		}
+		if e.Kind == journal.Kind_START {
+			if err := p.start(e); err != nil {
+				return err
+			}
+		}
+		if p.r == nil {
+			continue // No session before the first START.
+		}
+		p.step(e, window, trading)
+		if !trading {
+			continue
+		}
+		if e.Kind == journal.Kind_BAR {
+			bars++
+		}
+		for _, w := range append([]journal.Entry{*e}, window...) {
+			if journal.IsDecision(w.Kind) {
+				recorded = append(recorded, w)
+			}
+		}
+	}
+
+	p.close()
+	divergences := append(p.divergences, journal.CompareDecisions(recorded, p.replayed.Entries)...)
+	for _, d := range divergences {
+		fmt.Println(d)
+	}
+	fmt.Printf("replayed %d bars, %d recorded decisions, %d divergences\n", bars, len(recorded), len(divergences))
+	if len(divergences) > 0 {
+		return journal.ErrDiverged
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+// run_strategy_main trades one strategy on paper or live until SIGINT/SIGTERM:
+//
+//	run_strategy_main -config strategy.json
+//
+// See Config for the file format. On restart it recovers the position and the
+// open orders from the broker and its state file before resuming, as well as the
+// daily PnL and a tripped kill switch, which -reset_kill_switch re-arms.
+// Everything it sees and decides goes to a journal, which can be replayed offline
+// to check that the strategy still makes the same decisions:
+//
+//	run_strategy_main -replay strategy.json.journal [-day 2024-01-02]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/journal"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/risk"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/stream"
+)
+
+const (
+	reconnectDelay  = 5 * time.Second
+	shutdownTimeout = 30 * time.Second
+)
+
+// runnerState is the content of Config.StatePath.
+type runnerState struct {
+	Symbol          string
+	LastBarOpenTime time.Time // Of the last bar handed to the strategy.
+	OrderIDs        []int64   // Open orders placed by the runner.
+	Risk            risk.State
+}
+
+func loadState(path string) (*runnerState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &runnerState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	var s runnerState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("json unmarshal state %q: %w", path, err)
+	}
+	return &s, nil
+}
+
+// saveState writes a temporary file first so a crash never leaves a truncated state.
+func saveState(path string, s *runnerState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename state: %w", err)
+	}
+	return nil
+}
+
+// closedBars returns the closed bars opening within [from, now], at most limit of
+// the latest ones.
+func closedBars(ctx context.Context, cfg *Config, from time.Time, limit int) ([]klines.KLine, error) {
+	now := time.Now()
+	if earliest := common.AddBars(cfg.Interval, now, -(limit + 1)); from.Before(earliest) {
+		from = earliest
+	}
+	var res []klines.KLine
+	for !from.After(now) {
+		bars, err := klines.ListKLines(ctx, klines.ListKLinesParam{
+			TickerSymbol: cfg.Symbol,
+			Interval:     cfg.Interval,
+			StartTime:    from,
+			EndTime:      now,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ListKLines: %w", err)
+		}
+		if len(bars) == 0 {
+			break
+		}
+		res = append(res, bars...)
+		from = common.NextOpenTime(cfg.Interval, bars[len(bars)-1].OpenTime)
+	}
+	// The last bar is usually still open.
+	for len(res) > 0 && res[len(res)-1].CloseTime.After(now) {
+		res = res[:len(res)-1]
+	}
+	if len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res, nil
+}
+
+type runner struct {
+	cfg      *Config
+	gate     *riskGate
+	strategy Strategy
+	state    *runnerState
+	journal  journal.Appender
+	warm     bool // Whether the strategy got its warmup bars.
+
+	stateMu sync.Mutex // Guards state, which fills save too.
+}
+
+// newRunner wires the strategy to the broker through the risk manager. Every
+// decision goes to j.
+func newRunner(cfg *Config, b broker.Broker, j journal.Appender) (*runner, error) {
+	gate := &riskGate{Broker: b, symbol: cfg.Symbol, journal: j}
+	gate.risk = risk.NewManager(cfg.Risk, gate)
+	factory, ok := strategies[cfg.Strategy.Name]
+	if !ok {
+		return nil, fmt.Errorf("strategy %q: %w", cfg.Strategy.Name, ErrInvalidConfig)
+	}
+	strategy, err := factory(gate, cfg.Symbol, cfg.Strategy.Params, j)
+	if err != nil {
+		return nil, fmt.Errorf("strategy %q: %w", cfg.Strategy.Name, err)
+	}
+	return &runner{cfg: cfg, gate: gate, strategy: strategy, state: &runnerState{}, journal: j}, nil
+}
+
+// onFill keeps the daily PnL of the risk manager up to date.
+func (r *runner) onFill(ctx context.Context, f broker.Fill) {
+	log.Printf("fill order %d: %s %v @ %v fee %v pnl %v", f.OrderID, f.Side, f.Quantity, f.Price, f.Fee, f.RealizedPnL)
+	if err := r.gate.risk.RecordPnL(ctx, f.RealizedPnL-f.Fee); err != nil {
+		log.Printf("RecordPnL: %v", err)
+	}
+	r.save()
+}
+
+// recover syncs with the broker and reports what changed while the runner was down.
+func (r *runner) recover(ctx context.Context) error {
+	if r.state.Symbol != "" && r.state.Symbol != r.cfg.Symbol {
+		return fmt.Errorf("state %q is of symbol %q, not %q: %w", r.cfg.StatePath, r.state.Symbol, r.cfg.Symbol, ErrInvalidConfig)
+	}
+	r.state.Symbol = r.cfg.Symbol
+	r.gate.orderIDs = r.state.OrderIDs
+	r.gate.risk.Restore(r.state.Risk)
+	if tripped, reason := r.gate.risk.Tripped(); tripped {
+		log.Printf("kill switch tripped before the restart: %s; run with -reset_kill_switch to re-arm it", reason)
+	}
+	if err := r.gate.sync(ctx); err != nil {
+		return err
+	}
+	pos, err := r.gate.Position(ctx, r.cfg.Symbol)
+	if err != nil {
+		return fmt.Errorf("Position: %w", err)
+	}
+	open, err := r.gate.OpenOrders(ctx, r.cfg.Symbol)
+	if err != nil {
+		return fmt.Errorf("OpenOrders: %w", err)
+	}
+	own := r.gate.ownOrders()
+	log.Printf("recovered position %v @ %v, %d open orders (%d own), %d own orders done while down",
+		pos.Quantity, pos.EntryPrice, len(open), len(own), len(r.state.OrderIDs)-len(own))
+	if !r.state.LastBarOpenTime.IsZero() {
+		log.Printf("last bar handled before the restart opened at %v", r.state.LastBarOpenTime.UTC())
+	}
+	return nil
+}
+
+func (r *runner) save() {
+	if r.cfg.StatePath == "" {
+		return // Replaying.
+	}
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	r.state.OrderIDs = r.gate.ownOrders()
+	r.state.Risk = r.gate.risk.State()
+	if err := saveState(r.cfg.StatePath, r.state); err != nil {
+		log.Printf("saveState: %v", err)
+	}
+}
+
+// catchUp feeds the bars closed since the last handled one to the strategy
+// without trading on them, as they are stale by now. The strategy state is not
+// persisted, so the first call also feeds the WarmupBars latest bars, even the
+// ones handled before a restart.
+func (r *runner) catchUp(ctx context.Context) error {
+	from := common.NextOpenTime(r.cfg.Interval, r.state.LastBarOpenTime)
+	if !r.warm {
+		from = time.Time{}
+	}
+	bars, err := closedBars(ctx, r.cfg, from, r.cfg.WarmupBars)
+	if err != nil {
+		return err
+	}
+	if len(bars) == 0 {
+		return nil
+	}
+	if !r.warm && !r.state.LastBarOpenTime.IsZero() {
+		var missed int
+		for _, bar := range bars {
+			if bar.OpenTime.After(r.state.LastBarOpenTime) {
+				missed++
+			}
+		}
+		log.Printf("%d bars closed since the last one handled at %v, not traded", missed, r.state.LastBarOpenTime.UTC())
+	}
+	r.journal.Append(&journal.Entry{Kind: journal.Kind_WARMUP, Symbol: r.cfg.Symbol, Bars: bars})
+	r.strategy.Warmup(bars)
+	r.warm = true
+	r.setLastBar(bars[len(bars)-1].OpenTime)
+	log.Printf("warmed up with %d bars until %v", len(bars), r.state.LastBarOpenTime.UTC())
+	return nil
+}
+
+func (r *runner) setLastBar(openTime time.Time) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	r.state.LastBarOpenTime = openTime
+}
+
+func (r *runner) onBar(ctx context.Context, bar klines.KLine) {
+	if !bar.OpenTime.After(r.state.LastBarOpenTime) {
+		return
+	}
+	r.setLastBar(bar.OpenTime)
+	r.journal.Append(&journal.Entry{Kind: journal.Kind_BAR, Symbol: r.cfg.Symbol, Bar: &bar})
+	r.gate.risk.SetMarkPrice(r.cfg.Symbol, bar.ClosePrice)
+	if err := r.gate.sync(ctx); err != nil {
+		log.Printf("skip bar %v: %v", bar.OpenTime.UTC(), err)
+		return
+	}
+	if tripped, reason := r.gate.risk.Tripped(); tripped {
+		log.Printf("skip bar %v: kill switch tripped: %s", bar.OpenTime.UTC(), reason)
+		return
+	}
+	if err := r.strategy.OnBar(ctx, bar); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("strategy OnBar(%v): %v", bar.OpenTime.UTC(), err)
+	}
+	r.save()
+	if err := r.journalErr(); err != nil {
+		log.Printf("journal broken: %v", err)
+	}
+}
+
+// journalErr returns why the journal misses entries, if it does.
+func (r *runner) journalErr() error {
+	if rec, ok := r.gate.Broker.(*journal.Recorder); ok && rec.Err() != nil {
+		return rec.Err()
+	}
+	if w, ok := r.journal.(*journal.Writer); ok {
+		return w.Err()
+	}
+	return nil
+}
+
+// trade streams the closed bars into the strategy until ctx is done, catching up
+// on missed bars after every reconnect.
+func (r *runner) trade(ctx context.Context) {
+	for {
+		if err := r.catchUp(ctx); err != nil {
+			log.Printf("catch up: %v", err)
+		}
+		err := stream.StreamKLines(ctx, r.cfg.Symbol, r.cfg.Interval, func(ev stream.KLineEvent) error {
+			if ev.Closed {
+				r.onBar(ctx, ev.KLine)
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("kline stream: %v, reconnecting", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// shutdown applies the shutdown policy with a fresh context since ctx is done.
+func (r *runner) shutdown() {
+	r.journal.Append(&journal.Entry{Kind: journal.Kind_STOP, Symbol: r.cfg.Symbol, Note: string(r.cfg.Shutdown)})
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	switch r.cfg.Shutdown {
+	case ShutdownPolicy_CANCEL_ORDERS, ShutdownPolicy_FLATTEN:
+		if err := r.gate.CancelAllOpenOrders(ctx, r.cfg.Symbol); err != nil {
+			log.Printf("cancel open orders: %v", err)
+		}
+	}
+	if r.cfg.Shutdown == ShutdownPolicy_FLATTEN {
+		pos, err := r.gate.Position(ctx, r.cfg.Symbol)
+		if err == nil {
+			err = r.gate.ClosePosition(ctx, pos)
+		}
+		if err != nil {
+			log.Printf("close position: %v", err)
+		}
+	}
+	r.save()
+	if err := r.journalErr(); err != nil {
+		log.Printf("journal broken: %v", err)
+	}
+	log.Printf("shut down with policy %s", r.cfg.Shutdown)
+}
+
+// run trades until ctx is done. resetKillSwitch re-arms a kill switch tripped
+// before the restart.
+func run(ctx context.Context, cfg *Config, resetKillSwitch bool) error {
+	b, err := broker.New(broker.Config{Mode: cfg.Mode, Paper: cfg.Paper, Asset: cfg.Asset})
+	if err != nil {
+		return fmt.Errorf("broker.New: %w", err)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	runnerDone := make(chan struct{})
+	if br, ok := b.(broker.Runner); ok {
+		go func() {
+			defer close(runnerDone)
+			if err := br.Run(ctx); err != nil && ctx.Err() == nil {
+				cancel(fmt.Errorf("broker Run: %w", err))
+			}
+		}()
+	} else {
+		close(runnerDone)
+	}
+
+	j, err := journal.Open(cfg.JournalPath)
+	if err != nil {
+		return fmt.Errorf("journal.Open: %w", err)
+	}
+	defer j.Close()
+	cfgJSON, _ := json.Marshal(cfg)
+	if err := j.Append(&journal.Entry{Kind: journal.Kind_START, Symbol: cfg.Symbol, Note: string(cfgJSON)}); err != nil {
+		return fmt.Errorf("journal Append: %w", err)
+	}
+	rec := journal.NewRecorder(b, j)
+	defer rec.Close()
+
+	r, err := newRunner(cfg, rec, j)
+	if err != nil {
+		return err
+	}
+	if r.state, err = loadState(cfg.StatePath); err != nil {
+		return err
+	}
+	if err := r.recover(ctx); err != nil {
+		return fmt.Errorf("recover: %w", err)
+	}
+	if resetKillSwitch {
+		r.gate.risk.Reset()
+		log.Printf("kill switch re-armed")
+	}
+
+	defer r.gate.SubscribeFills(func(f broker.Fill) { r.onFill(ctx, f) })()
+
+	r.trade(ctx)
+	r.shutdown()
+	cancel(nil)
+	<-runnerDone
+	if err := context.Cause(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "", "path of the JSON config")
+	resetKillSwitch := flag.Bool("reset_kill_switch", false, "re-arm the kill switch tripped before the restart; the daily loss limit still applies")
+	replayPath := flag.String("replay", "", "path of a journal to replay instead of trading")
+	replayDay := flag.String("day", "", "with -replay, the UTC day (YYYY-MM-DD) to replay; earlier bars only warm up")
+	flag.Parse()
+	if *replayPath != "" {
+		var day time.Time
+		if *replayDay != "" {
+			var err error
+			if day, err = time.Parse(time.DateOnly, *replayDay); err != nil {
+				log.Fatalf("bad -day %q: %v", *replayDay, err)
+			}
+		}
+		if err := replay(*replayPath, day); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		return
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("loadConfig(%q): %v", *configPath, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, cfg, *resetKillSwitch); err != nil {
+		log.Fatalf("run: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/journal"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/risk"
+)
+
+// startRunner starts a runner as run does, from the state file of cfg.
+func startRunner(t *testing.T, cfg *Config) *runner {
+	sim, err := backtest.NewSimBroker(backtest.BrokerConfig{Symbol: cfg.Symbol, InitialCash: 10000})
+	if err != nil {
+		t.Fatalf("NewSimBroker: %v", err)
+	}
+	r, err := newRunner(cfg, broker.NewSim(sim), &journal.Memory{})
+	if err != nil {
+		t.Fatalf("newRunner: %v", err)
+	}
+	if r.state, err = loadState(cfg.StatePath); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	r.gate.risk.SetMarkPrice(cfg.Symbol, 100)
+	return r
+}
+
+// TestRestartKeepsRiskState checks a restart neither re-arms the kill switch nor
+// forgets the losses of the day.
+func TestRestartKeepsRiskState(t *testing.T) {
+	cfg := &Config{
+		Symbol:    "BTCUSDT",
+		Interval:  common.ListKLinesInterval_1h,
+		Strategy:  StrategyConfig{Name: "sma_cross", Params: backtest.Params{"Fast": 2, "Slow": 3, "Quantity": 1}},
+		Risk:      risk.Limits{MaxDailyLoss: 100},
+		StatePath: filepath.Join(t.TempDir(), "state.json"),
+	}
+	ctx := context.Background()
+	buy := orders.Order{Symbol: "BTCUSDT", Side: common.OrderSide_BUY, Type: common.OrderType_MARKET, Quantity: 1}
+
+	r := startRunner(t, cfg)
+	r.onFill(ctx, broker.Fill{Symbol: "BTCUSDT", RealizedPnL: -60})
+	r = startRunner(t, cfg)
+	if err := r.gate.risk.Check(buy); err != nil {
+		t.Fatalf("Check after a loss within the limit: %v", err)
+	}
+	r.onFill(ctx, broker.Fill{Symbol: "BTCUSDT", RealizedPnL: -50})
+	if tripped, _ := r.gate.risk.Tripped(); !tripped {
+		t.Fatalf("daily loss of 110 over two runs did not trip the kill switch")
+	}
+
+	r = startRunner(t, cfg)
+	if tripped, reason := r.gate.risk.Tripped(); !tripped || reason == "" {
+		t.Fatalf("Tripped() = %v, %q after restart, want tripped with its reason", tripped, reason)
+	}
+	if err := r.gate.risk.Check(buy); !errors.Is(err, risk.ErrKillSwitchTripped) {
+		t.Fatalf("Check after restart = %v, want ErrKillSwitchTripped", err)
+	}
+
+	// Re-armed, the day's losses still block new exposure until the next UTC day.
+	r.gate.risk.Reset()
+	if err := r.gate.risk.Check(buy); !errors.Is(err, risk.ErrMaxDailyLoss) {
+		t.Fatalf("Check after reset = %v, want ErrMaxDailyLoss", err)
+	}
+	r.gate.risk.SetClock(func() time.Time { return time.Now().Add(24 * time.Hour) })
+	if err := r.gate.risk.Check(buy); err != nil {
+		t.Fatalf("Check the next day: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/broker"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/journal"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// Strategy trades one symbol through a broker.Broker. It must derive everything
+// from the bars and the broker, so that it resumes correctly after a restart.
+type Strategy interface {
+	// Warmup feeds closed history bars in order, without trading.
+	Warmup(bars []klines.KLine)
+	OnBar(ctx context.Context, bar klines.KLine) error
+}
+
+// strategyFactory builds a strategy. Strategies journal their signals to j.
+type strategyFactory func(b broker.Broker, symbol string, p backtest.Params, j journal.Appender) (Strategy, error)
+
+var strategies = map[string]strategyFactory{
+	"sma_cross": newSMACross,
+}
+
+// smaCross holds +Quantity while the fast SMA of the close is above the slow one
+// and -Quantity otherwise.
+type smaCross struct {
+	b          broker.Broker
+	symbol     string
+	fast, slow int
+	quantity   float64
+	journal    journal.Appender
+	closes     []float64
+}
+
+func newSMACross(b broker.Broker, symbol string, p backtest.Params, j journal.Appender) (Strategy, error) {
+	s := &smaCross{b: b, symbol: symbol, fast: int(p["Fast"]), slow: int(p["Slow"]), quantity: p["Quantity"], journal: j}
+	if s.fast <= 0 || s.slow <= s.fast || !(s.quantity > 0) {
+		return nil, fmt.Errorf("params %v, want 0 < Fast < Slow and Quantity > 0: %w", p, ErrInvalidConfig)
+	}
+	return s, nil
+}
+
+func (s *smaCross) push(bar klines.KLine) {
+	s.closes = append(s.closes, bar.ClosePrice)
+	if len(s.closes) > s.slow {
+		s.closes = s.closes[len(s.closes)-s.slow:]
+	}
+}
+
+func (s *smaCross) Warmup(bars []klines.KLine) {
+	for _, bar := range bars {
+		s.push(bar)
+	}
+}
+
+func sma(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func (s *smaCross) OnBar(ctx context.Context, bar klines.KLine) error {
+	s.push(bar)
+	if len(s.closes) < s.slow {
+		return nil
+	}
+	fast, slow := sma(s.closes[len(s.closes)-s.fast:]), sma(s.closes)
+	target, signal := -s.quantity, "SHORT"
+	if fast > slow {
+		target, signal = s.quantity, "LONG"
+	}
+	s.journal.Append(&journal.Entry{
+		Kind:   journal.Kind_SIGNAL,
+		Symbol: s.symbol,
+		Signal: &journal.Signal{Name: signal, Values: map[string]float64{"fast": fast, "slow": slow}},
+	})
+	pos, err := s.b.Position(ctx, s.symbol)
+	if err != nil {
+		return fmt.Errorf("Position: %w", err)
+	}
+	diff := target - pos.Quantity
+	if math.Abs(diff) < s.quantity*1e-9 {
+		return nil
+	}
+	side := common.OrderSide_BUY
+	if diff < 0 {
+		side = common.OrderSide_SELL
+	}
+	_, err = s.b.SubmitOrder(ctx, orders.Order{
+		Symbol:   s.symbol,
+		Side:     side,
+		Type:     common.OrderType_MARKET,
+		Quantity: math.Abs(diff),
+	})
+	return err
+}
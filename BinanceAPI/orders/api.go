@@ -0,0 +1,341 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+// OrderInfo is an order as reported by the exchange.
+type OrderInfo struct {
+	OrderID          int64
+	ClientOrderID    string
+	Symbol           string
+	Side             common.OrderSide
+	Type             common.OrderType
+	Status           common.OrderStatus
+	Price            float64
+	StopPrice        float64
+	Quantity         float64
+	ExecutedQuantity float64
+	AvgPrice         float64
+	ReduceOnly       bool
+	UpdateTime       time.Time
+}
+
+// Balance of one asset of the futures wallet.
+type Balance struct {
+	Asset            string
+	Balance          float64 // Wallet balance.
+	UnrealizedPnL    float64 // Of the cross positions.
+	AvailableBalance float64
+}
+
+// HTTPError is a response of the API with a status other than 200.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte // Nil if it could not be read.
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == nil {
+		return fmt.Sprintf("http status code(%d) status(%q)", e.StatusCode, e.Status)
+	}
+	return fmt.Sprintf("http status code(%d) status(%q) body(%q)", e.StatusCode, e.Status, e.Body)
+}
+
+// doSigned sends a signed request and decodes the JSON response into out.
+func doSigned(ctx context.Context, creds common.Credentials, method, path string, query url.Values, out any) error {
+	apiURL := common.RootAPIEndPoint + path
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	creds.Sign(req, query)
+	return do(&client, req, out)
+}
+
+func do(client *http.Client, req *http.Request, out any) error {
+	rsp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http %s %q: %w", req.Method, req.URL.Path, err)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			body = nil
+		}
+		return &HTTPError{StatusCode: rsp.StatusCode, Status: rsp.Status, Body: body}
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(out); err != nil {
+		return fmt.Errorf("json decoder decode: %w", err)
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type orderEntry struct {
+	OrderID       int64  `json:"orderId"`
+	ClientOrderID string `json:"clientOrderId"`
+	Symbol        string `json:"symbol"`
+	Side          string `json:"side"`
+	Type          string `json:"type"`
+	Status        string `json:"status"`
+	Price         string `json:"price"`
+	StopPrice     string `json:"stopPrice"`
+	OrigQty       string `json:"origQty"`
+	ExecutedQty   string `json:"executedQty"`
+	AvgPrice      string `json:"avgPrice"`
+	ReduceOnly    bool   `json:"reduceOnly"`
+	UpdateTime    int64  `json:"updateTime"`
+}
+
+func (e *orderEntry) toOrderInfo() (*OrderInfo, error) {
+	info := &OrderInfo{
+		OrderID:       e.OrderID,
+		ClientOrderID: e.ClientOrderID,
+		Symbol:        e.Symbol,
+		Side:          common.OrderSide(e.Side),
+		Type:          common.OrderType(e.Type),
+		Status:        common.OrderStatus(e.Status),
+		ReduceOnly:    e.ReduceOnly,
+		UpdateTime:    time.UnixMilli(e.UpdateTime),
+	}
+	for _, f := range []struct {
+		name string
+		src  string
+		dst  *float64
+	}{
+		{"price", e.Price, &info.Price},
+		{"stop price", e.StopPrice, &info.StopPrice},
+		{"quantity", e.OrigQty, &info.Quantity},
+		{"executed quantity", e.ExecutedQty, &info.ExecutedQuantity},
+		{"average price", e.AvgPrice, &info.AvgPrice},
+	} {
+		if f.src == "" {
+			continue // Not every endpoint reports every field.
+		}
+		v, err := common.ParseFloat64FromAnyString(f.src)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s field (order %d): %w", f.name, e.OrderID, err)
+		}
+		*f.dst = v
+	}
+	return info, nil
+}
+
+// PlaceOrder places the order in one-way position mode.
+func PlaceOrder(ctx context.Context, creds common.Credentials, o Order) (*OrderInfo, error) {
+	query := url.Values{}
+	query.Add("symbol", o.Symbol)
+	query.Add("side", string(o.Side))
+	query.Add("type", string(o.Type))
+	query.Add("quantity", formatFloat(o.Quantity))
+	if o.Type == common.OrderType_LIMIT || o.Type == common.OrderType_STOP {
+		query.Add("price", formatFloat(o.Price))
+		query.Add("timeInForce", "GTC")
+	}
+	if o.Type == common.OrderType_STOP_MARKET || o.Type == common.OrderType_STOP {
+		query.Add("stopPrice", formatFloat(o.StopPrice))
+	}
+	if o.ReduceOnly {
+		query.Add("reduceOnly", "true")
+	}
+	if o.ClientOrderID != "" {
+		query.Add("newClientOrderId", o.ClientOrderID)
+	}
+	query.Add("newOrderRespType", "RESULT")
+	var entry orderEntry
+	if err := doSigned(ctx, creds, http.MethodPost, "/fapi/v1/order", query, &entry); err != nil {
+		return nil, err
+	}
+	return entry.toOrderInfo()
+}
+
+// ModifyOrder changes the quantity and price of an open LIMIT order, keeping its
+// place in the queue if only the quantity decreases.
+func ModifyOrder(
+	ctx context.Context, creds common.Credentials,
+	symbol string, orderID int64, side common.OrderSide, quantity, price float64,
+) (*OrderInfo, error) {
+	query := url.Values{}
+	query.Add("symbol", symbol)
+	query.Add("orderId", strconv.FormatInt(orderID, 10))
+	query.Add("side", string(side))
+	query.Add("quantity", formatFloat(quantity))
+	query.Add("price", formatFloat(price))
+	var entry orderEntry
+	if err := doSigned(ctx, creds, http.MethodPut, "/fapi/v1/order", query, &entry); err != nil {
+		return nil, err
+	}
+	return entry.toOrderInfo()
+}
+
+func CancelOrder(ctx context.Context, creds common.Credentials, symbol string, orderID int64) (*OrderInfo, error) {
+	query := url.Values{}
+	query.Add("symbol", symbol)
+	query.Add("orderId", strconv.FormatInt(orderID, 10))
+	var entry orderEntry
+	if err := doSigned(ctx, creds, http.MethodDelete, "/fapi/v1/order", query, &entry); err != nil {
+		return nil, err
+	}
+	return entry.toOrderInfo()
+}
+
+func CancelAllOpenOrders(ctx context.Context, creds common.Credentials, symbol string) error {
+	query := url.Values{}
+	query.Add("symbol", symbol)
+	var rsp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := doSigned(ctx, creds, http.MethodDelete, "/fapi/v1/allOpenOrders", query, &rsp); err != nil {
+		return err
+	}
+	if rsp.Code != http.StatusOK {
+		return fmt.Errorf("code(%d) msg(%q)", rsp.Code, rsp.Msg)
+	}
+	return nil
+}
+
+// ListOpenOrders returns the open orders of the symbol, or of all symbols when
+// symbol is empty.
+func ListOpenOrders(ctx context.Context, creds common.Credentials, symbol string) ([]OrderInfo, error) {
+	query := url.Values{}
+	if symbol != "" {
+		query.Add("symbol", symbol)
+	}
+	var entries []orderEntry
+	if err := doSigned(ctx, creds, http.MethodGet, "/fapi/v1/openOrders", query, &entries); err != nil {
+		return nil, err
+	}
+	res := make([]OrderInfo, len(entries))
+	for idx := range entries {
+		info, err := entries[idx].toOrderInfo()
+		if err != nil {
+			return nil, err
+		}
+		res[idx] = *info
+	}
+	return res, nil
+}
+
+type positionEntry struct {
+	Symbol      string `json:"symbol"`
+	PositionAmt string `json:"positionAmt"`
+	EntryPrice  string `json:"entryPrice"`
+	Leverage    string `json:"leverage"`
+}
+
+// ListPositions returns the position of the symbol, or of all symbols when symbol
+// is empty, in one-way position mode.
+func ListPositions(ctx context.Context, creds common.Credentials, symbol string) ([]Position, error) {
+	query := url.Values{}
+	if symbol != "" {
+		query.Add("symbol", symbol)
+	}
+	var entries []positionEntry
+	if err := doSigned(ctx, creds, http.MethodGet, "/fapi/v2/positionRisk", query, &entries); err != nil {
+		return nil, err
+	}
+	res := make([]Position, len(entries))
+	for idx, entry := range entries {
+		res[idx].Symbol = entry.Symbol
+		for _, f := range []struct {
+			name string
+			src  string
+			dst  *float64
+		}{
+			{"position amount", entry.PositionAmt, &res[idx].Quantity},
+			{"entry price", entry.EntryPrice, &res[idx].EntryPrice},
+			{"leverage", entry.Leverage, &res[idx].Leverage},
+		} {
+			v, err := common.ParseFloat64FromAnyString(f.src)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s field (entry: %+v): %w", f.name, entry, err)
+			}
+			*f.dst = v
+		}
+	}
+	return res, nil
+}
+
+type balanceEntry struct {
+	Asset            string `json:"asset"`
+	Balance          string `json:"balance"`
+	CrossUnPnl       string `json:"crossUnPnl"`
+	AvailableBalance string `json:"availableBalance"`
+}
+
+func ListBalances(ctx context.Context, creds common.Credentials) ([]Balance, error) {
+	var entries []balanceEntry
+	if err := doSigned(ctx, creds, http.MethodGet, "/fapi/v2/balance", nil, &entries); err != nil {
+		return nil, err
+	}
+	res := make([]Balance, len(entries))
+	for idx, entry := range entries {
+		res[idx].Asset = entry.Asset
+		for _, f := range []struct {
+			name string
+			src  string
+			dst  *float64
+		}{
+			{"balance", entry.Balance, &res[idx].Balance},
+			{"cross unrealized PnL", entry.CrossUnPnl, &res[idx].UnrealizedPnL},
+			{"available balance", entry.AvailableBalance, &res[idx].AvailableBalance},
+		} {
+			v, err := common.ParseFloat64FromAnyString(f.src)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s field (entry: %+v): %w", f.name, entry, err)
+			}
+			*f.dst = v
+		}
+	}
+	return res, nil
+}
+
+// StartUserDataStream returns the listen key of the account's user data stream. The
+// key expires 60 minutes after the last keepalive.
+func StartUserDataStream(ctx context.Context, creds common.Credentials) (string, error) {
+	var rsp struct {
+		ListenKey string `json:"listenKey"`
+	}
+	if err := doListenKey(ctx, creds, http.MethodPost, &rsp); err != nil {
+		return "", err
+	}
+	return rsp.ListenKey, nil
+}
+
+// KeepAliveUserDataStream extends the validity of the listen key by 60 minutes.
+func KeepAliveUserDataStream(ctx context.Context, creds common.Credentials) error {
+	var rsp struct{}
+	return doListenKey(ctx, creds, http.MethodPut, &rsp)
+}
+
+func doListenKey(ctx context.Context, creds common.Credentials, method string, out any) error {
+	const apiURL = common.RootAPIEndPoint + "/fapi/v1/listenKey"
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+	creds.SetAPIKey(req)
+	return do(&client, req, out)
+}
@@ -0,0 +1,39 @@
+package orders
+
+import (
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+// Order is a request to place one order on a USDⓈ-M futures symbol.
+type Order struct {
+	ClientOrderID string
+	Symbol        string
+	Side          common.OrderSide
+	Type          common.OrderType
+	Quantity      float64 // Always positive, direction is given by Side.
+	Price         float64 // Limit price, only used by LIMIT and STOP orders.
+	StopPrice     float64 // Trigger price, only used by STOP and STOP_MARKET orders.
+	ReduceOnly    bool
+}
+
+// SignedQuantity returns the quantity with the sign of the order side.
+func (o *Order) SignedQuantity() float64 {
+	return o.Side.Sign() * o.Quantity
+}
+
+// Position is the net position held on one symbol.
+type Position struct {
+	Symbol     string
+	Quantity   float64 // Positive for long, negative for short.
+	EntryPrice float64
+	Leverage   float64
+}
+
+// Notional returns the absolute position value at the given price.
+func (p *Position) Notional(price float64) float64 {
+	n := p.Quantity * price
+	if n < 0 {
+		return -n
+	}
+	return n
+}
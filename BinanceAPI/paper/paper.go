@@ -0,0 +1,431 @@
+package paper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/backtest"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/depth"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/stream"
+)
+
+type Config struct {
+	Symbol         string
+	InitialBalance float64
+	MakerFeeBps    float64
+	TakerFeeBps    float64
+	Futures        *backtest.FuturesConfig // Nil for spot style accounting.
+
+	// StatePath is the JSON file orders, positions and fills are persisted to after
+	// every change. An existing file is resumed from. Empty disables persistence.
+	StatePath string
+
+	StepInterval time.Duration // How often the stream trades are matched, defaults to 1s.
+	DepthLevels  int           // One of stream.DepthStreamLevels, defaults to 20.
+}
+
+// Broker simulates an account trading one symbol on live market data: the
+// aggregated trades and the partial book depth of the symbol are streamed into a
+// backtest.BookFillModel, which fills the simulated orders every StepInterval with
+// the same queue and slippage logic as in backtests. Steps follow the exchange
+// time of the trades, not the local clock, which may be skewed or see trades
+// late. It is safe for concurrent use.
+type Broker struct {
+	cfg Config
+
+	mu        sync.Mutex
+	sim       *backtest.SimBroker
+	model     *backtest.BookFillModel
+	lastStep  time.Time            // Start of the next step, zero until the first trade.
+	lastTrade time.Time            // Of the newest trade received.
+	stepTrade []aggtrades.AggTrade // Trades not stepped through yet.
+
+	handlerMu    sync.Mutex
+	fillHandlers map[int]func(backtest.Fill)
+	nextHandler  int
+}
+
+// state is the layout of Config.StatePath.
+type state struct {
+	Symbol   string
+	LastStep time.Time
+	Broker   backtest.BrokerState
+}
+
+func New(cfg Config) (*Broker, error) {
+	if cfg.StepInterval <= 0 {
+		cfg.StepInterval = time.Second
+	}
+	if cfg.DepthLevels == 0 {
+		cfg.DepthLevels = 20
+	}
+	model := backtest.NewBookFillModel(nil, nil, nil)
+	sim, err := backtest.NewSimBroker(backtest.BrokerConfig{
+		Symbol:       cfg.Symbol,
+		InitialCash:  cfg.InitialBalance,
+		MakerFeeBps:  cfg.MakerFeeBps,
+		TakerFeeBps:  cfg.TakerFeeBps,
+		Futures:      cfg.Futures,
+		NewFillModel: func() backtest.FillModel { return model },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("NewSimBroker: %w", err)
+	}
+	b := &Broker{
+		cfg:          cfg,
+		sim:          sim,
+		model:        model,
+		fillHandlers: map[int]func(backtest.Fill){},
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Broker) load() error {
+	if b.cfg.StatePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(b.cfg.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state: %w", err)
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("json unmarshal state %q: %w", b.cfg.StatePath, err)
+	}
+	if s.Symbol != b.cfg.Symbol {
+		return fmt.Errorf("state %q is of symbol %q, not %q", b.cfg.StatePath, s.Symbol, b.cfg.Symbol)
+	}
+	b.sim.Restore(s.Broker)
+	// Trades missed while the process was down are not replayed: steps start
+	// again at the first trade received.
+	return nil
+}
+
+// save writes the state to a temporary file first so a crash never leaves a
+// truncated state behind.
+func (b *Broker) save() error {
+	if b.cfg.StatePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state{Symbol: b.cfg.Symbol, LastStep: b.lastStep, Broker: b.sim.State()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.cfg.StatePath), 0755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	tmp := b.cfg.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, b.cfg.StatePath); err != nil {
+		return fmt.Errorf("rename state: %w", err)
+	}
+	return nil
+}
+
+// OnTrade feeds one live trade to the fill model.
+func (b *Broker) OnTrade(t aggtrades.AggTrade) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.model.AddTrades(t)
+	b.stepTrade = append(b.stepTrade, t)
+	if b.lastStep.IsZero() {
+		b.lastStep = t.Time
+	}
+	if t.Time.After(b.lastTrade) {
+		b.lastTrade = t.Time
+	}
+}
+
+// OnDepth feeds one live book snapshot to the fill model.
+func (b *Broker) OnDepth(s *depth.Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.model.AddSnapshot(*s)
+}
+
+// Step matches the open orders against the trades received since the previous
+// step and persists the result. Trades of the newest millisecond are left for the
+// next step, as more of them may still be on their way.
+func (b *Broker) Step() error {
+	return b.stepThrough(false)
+}
+
+// stepThrough steps up to the newest trade received, inclusive when all, and
+// notifies the fills.
+func (b *Broker) stepThrough(all bool) error {
+	b.mu.Lock()
+	fillNum := len(b.sim.Fills())
+	end := b.lastTrade
+	if !all {
+		end = end.Add(-time.Millisecond)
+	}
+	err := b.step(end)
+	fills := slices.Clone(b.sim.Fills()[fillNum:])
+	b.mu.Unlock()
+	b.notifyFills(fills)
+	return err
+}
+
+// SubscribeFills calls handle with every simulated fill from now on. Handles are
+// called without any lock held, so they may call back into the broker. Call the
+// returned function to unsubscribe.
+func (b *Broker) SubscribeFills(handle func(backtest.Fill)) func() {
+	b.handlerMu.Lock()
+	defer b.handlerMu.Unlock()
+	key := b.nextHandler
+	b.nextHandler++
+	b.fillHandlers[key] = handle
+	return func() {
+		b.handlerMu.Lock()
+		defer b.handlerMu.Unlock()
+		delete(b.fillHandlers, key)
+	}
+}
+
+func (b *Broker) notifyFills(fills []backtest.Fill) {
+	if len(fills) == 0 {
+		return
+	}
+	b.handlerMu.Lock()
+	handlers := make([]func(backtest.Fill), 0, len(b.fillHandlers))
+	for _, key := range slices.Sorted(maps.Keys(b.fillHandlers)) {
+		handlers = append(handlers, b.fillHandlers[key])
+	}
+	b.handlerMu.Unlock()
+	for _, f := range fills {
+		for _, handle := range handlers {
+			handle(f)
+		}
+	}
+}
+
+// step matches the trades within [lastStep, end] as one bar.
+func (b *Broker) step(end time.Time) error {
+	if b.lastStep.IsZero() || end.Before(b.lastStep) {
+		return nil
+	}
+	bar := klines.KLine{
+		OpenTime:  b.lastStep,
+		CloseTime: end,
+		OpenPrice: b.sim.LastPrice(),
+	}
+	var tradeNum int
+	later := b.stepTrade[:0]
+	for _, t := range b.stepTrade {
+		if t.Time.After(end) {
+			later = append(later, t)
+			continue
+		}
+		if tradeNum == 0 {
+			bar.OpenPrice, bar.HighPrice, bar.LowPrice = t.Price, t.Price, t.Price
+		}
+		tradeNum++
+		bar.HighPrice = max(bar.HighPrice, t.Price)
+		bar.LowPrice = min(bar.LowPrice, t.Price)
+		bar.ClosePrice = t.Price
+		bar.Volume += t.Quantity
+		bar.QuoteAssetVolume += t.Quantity * t.Price
+		bar.TradeNum += float64(t.LastTradeID - t.FirstTradeID + 1)
+	}
+	if tradeNum == 0 {
+		bar.HighPrice, bar.LowPrice, bar.ClosePrice = bar.OpenPrice, bar.OpenPrice, bar.OpenPrice
+	}
+	b.stepTrade = later
+	b.lastStep = end.Add(time.Millisecond)
+
+	// No price to match against yet.
+	if bar.OpenPrice == 0 {
+		return nil
+	}
+	openNum, fillNum, fundingNum := len(b.sim.OpenOrders()), len(b.sim.Fills()), len(b.sim.FundingPayments())
+	b.sim.ProcessBar(&bar)
+	b.model.Prune(b.lastStep)
+	// Skip the write when nothing but the mark price could have changed.
+	if openNum == 0 && len(b.sim.Fills()) == fillNum && len(b.sim.FundingPayments()) == fundingNum {
+		return nil
+	}
+	return b.save()
+}
+
+const reconnectDelay = 5 * time.Second
+
+// strategyError tells a strategy failure apart from the stream dropping.
+type strategyError struct{ err error }
+
+func (e *strategyError) Error() string { return e.err.Error() }
+
+// Run streams the live trades and book of the symbol into the broker and steps it
+// every StepInterval until ctx is done, the strategy fails or persisting fails. When
+// strategy is not nil, it is called with every closed bar of the interval, like in
+// a backtest.
+func (b *Broker) Run(ctx context.Context, interval common.ListKLinesInterval, strategy backtest.Strategy) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Streams reconnect when they drop; only the strategy failing stops Run.
+	var wg sync.WaitGroup
+	goStream := func(name string, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := f()
+				var strategyErr *strategyError
+				if errors.As(err, &strategyErr) {
+					cancel(fmt.Errorf("%s stream: %w", name, strategyErr.err))
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(reconnectDelay):
+				}
+			}
+		}()
+	}
+	goStream("aggTrade", func() error {
+		return stream.StreamAggTrades(ctx, b.cfg.Symbol, func(t aggtrades.AggTrade) error {
+			b.OnTrade(t)
+			return nil
+		})
+	})
+	goStream("depth", func() error {
+		return stream.StreamDepth(ctx, b.cfg.Symbol, b.cfg.DepthLevels, func(s *depth.Snapshot) error {
+			b.OnDepth(s)
+			return nil
+		})
+	})
+	if strategy != nil {
+		goStream("kline", func() error {
+			return stream.StreamKLines(ctx, b.cfg.Symbol, interval, func(ev stream.KLineEvent) error {
+				if !ev.Closed {
+					return nil
+				}
+				if err := strategy.OnBar(ctx, ev.KLine); err != nil {
+					return &strategyError{err: err}
+				}
+				return nil
+			})
+		})
+	}
+
+	ticker := time.NewTicker(b.cfg.StepInterval)
+	defer ticker.Stop()
+	var err error
+LOOP:
+	for {
+		select {
+		case <-ticker.C:
+			if err = b.Step(); err != nil {
+				break LOOP
+			}
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+			break LOOP
+		}
+	}
+	cancel(err)
+	wg.Wait()
+	return err
+}
+
+// SubmitOrder places a simulated order and returns its ID. It is matched against
+// the trades arriving after the call: the ones received so far happened before it
+// reached the exchange.
+func (b *Broker) SubmitOrder(ctx context.Context, o orders.Order) (int64, error) {
+	if err := b.stepThrough(true); err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id, err := b.sim.Submit(o)
+	if err != nil {
+		return 0, err
+	}
+	return id, b.save()
+}
+
+func (b *Broker) CancelOrder(ctx context.Context, id int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.sim.Cancel(id); err != nil {
+		return err
+	}
+	return b.save()
+}
+
+// AmendOrder changes the quantity and limit price of an open LIMIT or STOP order.
+func (b *Broker) AmendOrder(ctx context.Context, id int64, quantity, price float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.sim.Amend(id, quantity, price); err != nil {
+		return err
+	}
+	return b.save()
+}
+
+func (b *Broker) CancelAllOpenOrders(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sim.CancelAll()
+	return b.save()
+}
+
+func (b *Broker) OpenOrders(ctx context.Context) ([]backtest.SimOrder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sim.OpenOrders(), nil
+}
+
+func (b *Broker) Position(ctx context.Context) (orders.Position, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sim.Position(), nil
+}
+
+// Balance returns the cash, or the wallet balance for futures.
+func (b *Broker) Balance(ctx context.Context) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sim.Cash(), nil
+}
+
+// AvailableBalance returns what can back new orders.
+func (b *Broker) AvailableBalance(ctx context.Context) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sim.AvailableBalance(), nil
+}
+
+// Equity marks the position at the last traded price.
+func (b *Broker) Equity(ctx context.Context) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sim.Equity(), nil
+}
+
+func (b *Broker) Fills(ctx context.Context) ([]backtest.Fill, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sim.State().Fills, nil
+}
@@ -0,0 +1,93 @@
+package paper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// TestStepFollowsTradeTime feeds trades whose exchange time is far from the local
+// clock, as with clock skew or a stream lagging behind, and expects them to fill
+// the resting order all the same.
+func TestStepFollowsTradeTime(t *testing.T) {
+	for _, skew := range []time.Duration{-time.Hour, 0, time.Hour} {
+		t.Run(skew.String(), func(t *testing.T) {
+			b, err := New(Config{Symbol: "BTCUSDT", InitialBalance: 10000})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			start := time.Now().Add(skew).Truncate(time.Millisecond)
+			nextID := int64(0)
+			trade := func(offset time.Duration, price float64, buyerMaker bool) {
+				nextID++
+				b.OnTrade(aggtrades.AggTrade{
+					ID: nextID, Price: price, Quantity: 1, FirstTradeID: nextID, LastTradeID: nextID,
+					Time: start.Add(offset), BuyerMaker: buyerMaker,
+				})
+			}
+			trade(0, 100, false)
+			trade(time.Second, 100, false)
+
+			ctx := context.Background()
+			id, err := b.SubmitOrder(ctx, orders.Order{
+				Symbol: "BTCUSDT", Side: common.OrderSide_BUY, Type: common.OrderType_LIMIT, Quantity: 1, Price: 99,
+			})
+			if err != nil {
+				t.Fatalf("SubmitOrder: %v", err)
+			}
+			// Trades through the limit price, the last one left for a later step.
+			trade(2*time.Second, 98, true)
+			trade(3*time.Second, 98, true)
+			if err := b.Step(); err != nil {
+				t.Fatalf("Step: %v", err)
+			}
+			fills, err := b.Fills(ctx)
+			if err != nil {
+				t.Fatalf("Fills: %v", err)
+			}
+			if len(fills) != 1 || fills[0].OrderID != id || fills[0].Price > 99 ||
+				fills[0].Time.Before(start.Add(time.Second)) || fills[0].Time.After(start.Add(2*time.Second)) {
+				t.Fatalf("fills %+v, want order %d filled at 99 or better by %v", fills, id, start.Add(2*time.Second))
+			}
+		})
+	}
+}
+
+// TestStepKeepsNewestMillisecond checks a trade of the newest millisecond
+// received is not dropped when more trades of it arrive after a step.
+func TestStepKeepsNewestMillisecond(t *testing.T) {
+	b, err := New(Config{Symbol: "BTCUSDT", InitialBalance: 10000})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t0 := time.UnixMilli(1700000000000)
+	b.OnTrade(aggtrades.AggTrade{ID: 1, Price: 100, Quantity: 1, Time: t0})
+	ctx := context.Background()
+	if _, err := b.SubmitOrder(ctx, orders.Order{
+		Symbol: "BTCUSDT", Side: common.OrderSide_SELL, Type: common.OrderType_LIMIT, Quantity: 2, Price: 101,
+	}); err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+	t1 := t0.Add(time.Second)
+	b.OnTrade(aggtrades.AggTrade{ID: 2, Price: 102, Quantity: 1, Time: t1})
+	if err := b.Step(); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	// A second trade of the same millisecond arrives late.
+	b.OnTrade(aggtrades.AggTrade{ID: 3, Price: 102, Quantity: 1, Time: t1})
+	b.OnTrade(aggtrades.AggTrade{ID: 4, Price: 100, Quantity: 1, Time: t1.Add(time.Second)})
+	if err := b.Step(); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	pos, err := b.Position(ctx)
+	if err != nil {
+		t.Fatalf("Position: %v", err)
+	}
+	if pos.Quantity != -2 {
+		t.Fatalf("position %v, want -2 filled by both trades at %v", pos.Quantity, t1)
+	}
+}
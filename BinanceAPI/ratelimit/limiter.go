@@ -0,0 +1,134 @@
+// Package ratelimit keeps REST calls under Binance's request weight limit, which
+// is counted per IP over fixed one minute windows.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// UsedWeightHeader is the weight used by the IP in the current window, as
+	// counted by the exchange.
+	UsedWeightHeader = "X-MBX-USED-WEIGHT-1M"
+	// FuturesWeightLimit is the request weight the futures API allows per minute.
+	FuturesWeightLimit = 2400
+	// SpotWeightLimit is the request weight the spot API allows per minute.
+	SpotWeightLimit = 6000
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrTooHeavy    = errors.New("weight exceeds the limit")
+)
+
+// Limiter admits requests while the weight used in the current minute stays
+// within the limit. It is shared by every goroutine calling the same API, and a
+// nil Limiter admits everything.
+type Limiter struct {
+	limit int
+
+	mu          sync.Mutex
+	window      time.Time // Start of the current minute.
+	used        int
+	pausedUntil time.Time // Set by the exchange returning 429 or 418.
+	waited      time.Duration
+}
+
+// New returns a limiter admitting limit weight per minute. Keep it below the
+// exchange's limit when other processes share the IP.
+func New(limit int) *Limiter {
+	return &Limiter{limit: limit}
+}
+
+// rollLocked starts a new window once the current one is over.
+func (l *Limiter) rollLocked(now time.Time) {
+	if window := now.Truncate(time.Minute); window.After(l.window) {
+		l.window = window
+		l.used = 0
+	}
+}
+
+// reserve takes weight from the current window, or returns how long to wait
+// before trying again.
+func (l *Limiter) reserve(weight int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	l.rollLocked(now)
+	if l.used+weight > l.limit {
+		return l.window.Add(time.Minute).Sub(now)
+	}
+	l.used += weight
+	return 0
+}
+
+// Wait blocks until weight can be used in the current window, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, weight int) error {
+	if l == nil {
+		return nil
+	}
+	if weight > l.limit {
+		return fmt.Errorf("weight %d with limit %d: %w", weight, l.limit, ErrTooHeavy)
+	}
+	for {
+		d := l.reserve(weight)
+		if d == 0 {
+			return nil
+		}
+		l.mu.Lock()
+		l.waited += d
+		l.mu.Unlock()
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Observe updates the limiter from a response of the API: the weight the
+// exchange counted, which includes other clients on the IP, and the pause it
+// asks for with Retry-After when rate limiting. It returns ErrRateLimited for
+// such responses, which are worth retrying after Wait.
+func (l *Limiter) Observe(rsp *http.Response) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollLocked(now)
+	if used, err := strconv.Atoi(rsp.Header.Get(UsedWeightHeader)); err == nil {
+		l.used = max(l.used, used)
+	}
+	if rsp.StatusCode != http.StatusTooManyRequests && rsp.StatusCode != http.StatusTeapot {
+		return nil
+	}
+	pause := time.Minute // Until the window is over at least.
+	if secs, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil {
+		pause = time.Duration(secs) * time.Second
+	}
+	l.pausedUntil = now.Add(pause)
+	return fmt.Errorf("http status code(%d), paused for %v: %w", rsp.StatusCode, pause, ErrRateLimited)
+}
+
+// Waited returns how long Wait calls were held up in total.
+func (l *Limiter) Waited() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waited
+}
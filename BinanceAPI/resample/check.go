@@ -0,0 +1,107 @@
+package resample
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// Tolerance is the largest relative difference accepted between a resampled and a
+// downloaded value. The CSVs keep 7 significant digits, so summing rounded
+// volumes drifts a little from the rounded total.
+type Tolerance struct {
+	Price  float64
+	Volume float64 // For the volumes and the trade count.
+}
+
+var DefaultTolerance = Tolerance{Price: 1e-9, Volume: 1e-5}
+
+// Mismatch is a field of a bar differing beyond tolerance.
+type Mismatch struct {
+	OpenTime   time.Time
+	Field      string
+	Resampled  float64
+	Downloaded float64
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("%s %s resampled %v downloaded %v", m.OpenTime.UTC().Format(time.DateTime), m.Field, m.Resampled, m.Downloaded)
+}
+
+// CheckReport compares the bars resampled from one interval with the bars
+// downloaded at a higher one.
+type CheckReport struct {
+	From, To common.ListKLinesInterval
+	Compared int
+	// Bars with no counterpart, e.g. a downloaded bar whose source bars are partly
+	// missing, or the last bar still open when downloaded.
+	OnlyResampled  int
+	OnlyDownloaded int
+	Mismatches     []Mismatch
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// relDiff is the difference of a and b relative to the larger of them.
+func relDiff(a, b float64) float64 {
+	if a == b {
+		return 0
+	}
+	return math.Abs(a-b) / max(math.Abs(a), math.Abs(b))
+}
+
+// Check resamples the bars of interval from and compares them with the bars
+// downloaded at interval to, both in order. Bars synthetic on either side are
+// not compared, having no exchange data to agree with.
+func Check(bars []klines.KLine, from common.ListKLinesInterval, downloaded []klines.KLine, to common.ListKLinesInterval, tol Tolerance) (*CheckReport, error) {
+	resampled, err := Resample(bars, from, to)
+	if err != nil {
+		return nil, err
+	}
+	rep := &CheckReport{From: from, To: to}
+	idx, jdx := 0, 0
+	for idx < len(resampled) && jdx < len(downloaded) {
+		r, d := &resampled[idx], &downloaded[jdx]
+		switch {
+		case r.OpenTime.Before(d.OpenTime):
+			rep.OnlyResampled++
+			idx++
+			continue
+		case d.OpenTime.Before(r.OpenTime):
+			rep.OnlyDownloaded++
+			jdx++
+			continue
+		}
+		idx++
+		jdx++
+		if r.Synthetic || d.Synthetic {
+			continue
+		}
+		rep.Compared++
+		for _, f := range []struct {
+			name string
+			r, d float64
+			tol  float64
+		}{
+			{"OpenPrice", r.OpenPrice, d.OpenPrice, tol.Price},
+			{"ClosePrice", r.ClosePrice, d.ClosePrice, tol.Price},
+			{"HighPrice", r.HighPrice, d.HighPrice, tol.Price},
+			{"LowPrice", r.LowPrice, d.LowPrice, tol.Price},
+			{"Volume", r.Volume, d.Volume, tol.Volume},
+			{"QuoteAssetVolume", r.QuoteAssetVolume, d.QuoteAssetVolume, tol.Volume},
+			{"TradeNum", r.TradeNum, d.TradeNum, tol.Volume},
+		} {
+			if !(relDiff(f.r, f.d) <= f.tol) {
+				rep.Mismatches = append(rep.Mismatches, Mismatch{OpenTime: r.OpenTime, Field: f.name, Resampled: f.r, Downloaded: f.d})
+			}
+		}
+	}
+	rep.OnlyResampled += len(resampled) - idx
+	rep.OnlyDownloaded += len(downloaded) - jdx
+	return rep, nil
+}
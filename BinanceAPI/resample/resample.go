@@ -0,0 +1,90 @@
+// Package resample aggregates KLines into higher intervals and checks the result
+// against the KLines downloaded at those intervals.
+package resample
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+var (
+	ErrNotMultiple = errors.New("interval is not a multiple of the source interval")
+	ErrNotInOrder  = errors.New("klines not in order")
+)
+
+// divides reports whether bars of interval from tile every bar of interval to.
+// Months only split into whole days.
+func divides(from, to common.ListKLinesInterval) bool {
+	fromDur, toDur := common.IntervalDuration(from), common.IntervalDuration(to)
+	if to == common.ListKLinesInterval_1M {
+		toDur = 24 * time.Hour
+	}
+	return from != common.ListKLinesInterval_1M && fromDur <= toDur && toDur%fromDur == 0
+}
+
+// Validate returns an error unless bars of interval from can be resampled into
+// bars of interval to.
+func Validate(from, to common.ListKLinesInterval) error {
+	if common.IntervalDuration(from) == 0 || common.IntervalDuration(to) == 0 {
+		return fmt.Errorf("unknown interval %q or %q", from, to)
+	}
+	if !divides(from, to) {
+		return fmt.Errorf("%s to %s: %w", from, to, ErrNotMultiple)
+	}
+	return nil
+}
+
+// Resample aggregates bars of interval from, in order, into bars of interval to:
+// open is the first open, close the last close, high the max, low the min, and
+// volumes and trade counts are summed. A bar missing any of its source bars is
+// left out, as it would not match the exchange's. A bar is synthetic when any of
+// its source bars is, as the flat filler bars would skew its prices and volumes.
+func Resample(bars []klines.KLine, from, to common.ListKLinesInterval) ([]klines.KLine, error) {
+	if err := Validate(from, to); err != nil {
+		return nil, err
+	}
+
+	var res []klines.KLine
+	var cur klines.KLine
+	n := 0
+	flush := func() {
+		if n == common.BarsBetween(from, cur.OpenTime, cur.CloseTime) {
+			res = append(res, cur)
+		}
+		n = 0
+	}
+	for idx := range bars {
+		l := &bars[idx]
+		if idx > 0 && !l.OpenTime.After(bars[idx-1].OpenTime) {
+			return nil, fmt.Errorf("bar %d opens at %v, not after %v: %w",
+				idx, l.OpenTime, bars[idx-1].OpenTime, ErrNotInOrder)
+		}
+		start := common.AlignDown(to, l.OpenTime)
+		if n > 0 && !start.Equal(cur.OpenTime) {
+			flush()
+		}
+		if n == 0 {
+			cur = klines.KLine{
+				OpenTime:  start,
+				CloseTime: common.NextOpenTime(to, start).Add(-time.Millisecond),
+				OpenPrice: l.OpenPrice,
+				HighPrice: l.HighPrice,
+				LowPrice:  l.LowPrice,
+			}
+		}
+		cur.ClosePrice = l.ClosePrice
+		cur.HighPrice = max(cur.HighPrice, l.HighPrice)
+		cur.LowPrice = min(cur.LowPrice, l.LowPrice)
+		cur.Volume += l.Volume
+		cur.QuoteAssetVolume += l.QuoteAssetVolume
+		cur.TradeNum += l.TradeNum
+		cur.Synthetic = cur.Synthetic || l.Synthetic
+		n++
+	}
+	flush()
+	return res, nil
+}
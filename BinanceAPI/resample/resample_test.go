@@ -0,0 +1,44 @@
+package resample
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+func TestResampleSynthetic(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bar := func(idx int, synthetic bool) klines.KLine {
+		open := t0.Add(time.Duration(idx) * time.Hour)
+		return klines.KLine{
+			OpenTime: open, CloseTime: open.Add(time.Hour - time.Millisecond),
+			OpenPrice: 100, HighPrice: 101, LowPrice: 99, ClosePrice: 100, Volume: 1, Synthetic: synthetic,
+		}
+	}
+	tests := []struct {
+		name      string
+		synthetic []bool // Of the four 1h bars of a 4h bar.
+		want      bool
+	}{
+		{"all real", []bool{false, false, false, false}, false},
+		{"one filled", []bool{false, true, false, false}, true},
+		{"all filled", []bool{true, true, true, true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bars []klines.KLine
+			for idx, synthetic := range tt.synthetic {
+				bars = append(bars, bar(idx, synthetic))
+			}
+			got, err := Resample(bars, common.ListKLinesInterval_1h, common.ListKLinesInterval_4h)
+			if err != nil {
+				t.Fatalf("Resample: %v", err)
+			}
+			if len(got) != 1 || got[0].Synthetic != tt.want {
+				t.Fatalf("Resample = %+v, want one bar with Synthetic %v", got, tt.want)
+			}
+		})
+	}
+}
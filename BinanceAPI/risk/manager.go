@@ -0,0 +1,342 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+var (
+	ErrKillSwitchTripped = errors.New("kill switch tripped")
+	ErrInvalidOrder      = errors.New("invalid order")
+	ErrNoMarkPrice       = errors.New("no mark price")
+	ErrMaxNotional       = errors.New("max notional exceeded")
+	ErrMaxLeverage       = errors.New("max leverage exceeded")
+	ErrMaxOpenOrders     = errors.New("max open orders exceeded")
+	ErrMaxDailyLoss      = errors.New("max daily loss exceeded")
+	ErrPriceBand         = errors.New("price outside band")
+	ErrFatFinger         = errors.New("fat finger")
+)
+
+// Limits configures the pre-trade checks. A zero value disables the corresponding check.
+type Limits struct {
+	MaxNotionalPerSymbol map[string]float64 // Max absolute position value per symbol, overrides DefaultMaxNotional.
+	DefaultMaxNotional   float64            // Max absolute position value for symbols not in MaxNotionalPerSymbol.
+	MaxLeverage          float64            // Max total position value / equity.
+	MaxOpenOrders        int                // Max number of open orders across all symbols.
+	MaxDailyLoss         float64            // Max loss (positive number) within one UTC day; trips the kill switch.
+	PriceBandRatio       float64            // Max |price - mark| / mark for LIMIT and STOP orders, e.g. 0.05 for 5%.
+	MaxOrderQuantity     map[string]float64 // Fat finger check on single order quantity per symbol.
+	MaxOrderNotional     float64            // Fat finger check on single order value.
+}
+
+func (l *Limits) maxNotional(symbol string) float64 {
+	if v, ok := l.MaxNotionalPerSymbol[symbol]; ok {
+		return v
+	}
+	return l.DefaultMaxNotional
+}
+
+// Executor is what the kill switch uses to unwind exposure on the exchange.
+type Executor interface {
+	CancelAllOpenOrders(ctx context.Context, symbol string) error
+	ClosePosition(ctx context.Context, pos orders.Position) error
+}
+
+// Manager checks every order against Limits before it is sent out. The caller keeps
+// the manager's view of the account up to date with the Set*/Record* methods.
+type Manager struct {
+	limits Limits
+	exec   Executor
+	now    func() time.Time
+
+	mu         sync.Mutex
+	markPrices map[string]float64
+	positions  map[string]orders.Position
+	openOrders map[string]int
+	equity     float64
+	day        time.Time // UTC midnight of the day dailyPnL belongs to.
+	dailyPnL   float64
+	tripped    bool
+	tripReason string
+}
+
+func NewManager(limits Limits, exec Executor) *Manager {
+	return &Manager{
+		limits:     limits,
+		exec:       exec,
+		now:        time.Now,
+		markPrices: map[string]float64{},
+		positions:  map[string]orders.Position{},
+		openOrders: map[string]int{},
+	}
+}
+
+// SetClock replaces the clock used for the daily loss window, e.g. with the bar
+// time during a backtest.
+func (m *Manager) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+func (m *Manager) SetMarkPrice(symbol string, price float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.markPrices[symbol] = price
+}
+
+func (m *Manager) SetPosition(pos orders.Position) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pos.Quantity == 0 {
+		delete(m.positions, pos.Symbol)
+		return
+	}
+	m.positions[pos.Symbol] = pos
+}
+
+func (m *Manager) SetOpenOrders(symbol string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n <= 0 {
+		delete(m.openOrders, symbol)
+		return
+	}
+	m.openOrders[symbol] = n
+}
+
+func (m *Manager) SetEquity(equity float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.equity = equity
+}
+
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// rollDayLocked resets the daily PnL when the clock enters a new UTC day.
+func (m *Manager) rollDayLocked() {
+	if day := utcDay(m.now()); !day.Equal(m.day) {
+		m.day = day
+		m.dailyPnL = 0
+	}
+}
+
+// RecordPnL adds realized (or marked-to-market) PnL to today's total and trips the
+// kill switch once the daily loss limit is breached.
+func (m *Manager) RecordPnL(ctx context.Context, pnl float64) error {
+	m.mu.Lock()
+	m.rollDayLocked()
+	m.dailyPnL += pnl
+	breached := m.limits.MaxDailyLoss > 0 && -m.dailyPnL >= m.limits.MaxDailyLoss
+	dailyPnL := m.dailyPnL
+	m.mu.Unlock()
+
+	if !breached {
+		return nil
+	}
+	return m.Trip(ctx, fmt.Sprintf("daily pnl %.2f: %v", dailyPnL, ErrMaxDailyLoss))
+}
+
+// Check returns nil if the order passes all limits, or an error wrapping one of the
+// Err* values above describing the first violated limit.
+func (m *Manager) Check(o orders.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tripped {
+		return fmt.Errorf("%s: %w", m.tripReason, ErrKillSwitchTripped)
+	}
+	if o.Symbol == "" || o.Quantity <= 0 || math.IsNaN(o.Quantity) {
+		return fmt.Errorf("symbol %q quantity %v: %w", o.Symbol, o.Quantity, ErrInvalidOrder)
+	}
+	mark, ok := m.markPrices[o.Symbol]
+	if !ok || mark <= 0 {
+		return fmt.Errorf("symbol %q: %w", o.Symbol, ErrNoMarkPrice)
+	}
+	pos := m.positions[o.Symbol]
+	newPos := pos
+	newPos.Quantity += o.SignedQuantity()
+	// Flipping to the other side opens a new position, whatever its size.
+	reducing := newPos.Quantity == 0 ||
+		(math.Signbit(newPos.Quantity) == math.Signbit(pos.Quantity) && math.Abs(newPos.Quantity) < math.Abs(pos.Quantity))
+	if o.ReduceOnly && !reducing {
+		return fmt.Errorf("reduce only order would grow position %v -> %v: %w",
+			pos.Quantity, newPos.Quantity, ErrInvalidOrder)
+	}
+
+	// Daily loss. Orders reducing exposure are always allowed so that positions can be closed.
+	m.rollDayLocked()
+	if m.limits.MaxDailyLoss > 0 && -m.dailyPnL >= m.limits.MaxDailyLoss && !reducing {
+		return fmt.Errorf("daily pnl %.2f limit %.2f: %w", m.dailyPnL, -m.limits.MaxDailyLoss, ErrMaxDailyLoss)
+	}
+
+	// Price band relative to the mark price.
+	if err := m.checkPriceBandLocked(&o, mark); err != nil {
+		return err
+	}
+
+	// Fat finger.
+	if maxQty, ok := m.limits.MaxOrderQuantity[o.Symbol]; ok && maxQty > 0 && o.Quantity > maxQty {
+		return fmt.Errorf("quantity %v > %v: %w", o.Quantity, maxQty, ErrFatFinger)
+	}
+	orderNotional := o.Quantity * orderPrice(&o, mark)
+	if m.limits.MaxOrderNotional > 0 && orderNotional > m.limits.MaxOrderNotional {
+		return fmt.Errorf("order notional %.2f > %.2f: %w", orderNotional, m.limits.MaxOrderNotional, ErrFatFinger)
+	}
+
+	// Open orders.
+	if m.limits.MaxOpenOrders > 0 && o.Type != common.OrderType_MARKET {
+		total := 0
+		for _, n := range m.openOrders {
+			total += n
+		}
+		if total+1 > m.limits.MaxOpenOrders {
+			return fmt.Errorf("%d open orders: %w", total, ErrMaxOpenOrders)
+		}
+	}
+
+	if reducing {
+		return nil
+	}
+
+	// Notional per symbol.
+	if maxNotional := m.limits.maxNotional(o.Symbol); maxNotional > 0 {
+		if n := newPos.Notional(mark); n > maxNotional {
+			return fmt.Errorf("symbol %q notional %.2f > %.2f: %w", o.Symbol, n, maxNotional, ErrMaxNotional)
+		}
+	}
+
+	// Account leverage.
+	if m.limits.MaxLeverage > 0 {
+		if m.equity <= 0 {
+			return fmt.Errorf("equity %.2f: %w", m.equity, ErrMaxLeverage)
+		}
+		total := newPos.Notional(mark)
+		for symbol, p := range m.positions {
+			if symbol == o.Symbol {
+				continue
+			}
+			total += p.Notional(m.markPriceLocked(symbol, p.EntryPrice))
+		}
+		if lev := total / m.equity; lev > m.limits.MaxLeverage {
+			return fmt.Errorf("leverage %.2f > %.2f: %w", lev, m.limits.MaxLeverage, ErrMaxLeverage)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) checkPriceBandLocked(o *orders.Order, mark float64) error {
+	if m.limits.PriceBandRatio <= 0 {
+		return nil
+	}
+	for _, p := range []float64{o.Price, o.StopPrice} {
+		if p == 0 {
+			continue
+		}
+		if dev := math.Abs(p-mark) / mark; dev > m.limits.PriceBandRatio {
+			return fmt.Errorf("price %v deviates %.2f%% from mark %v: %w", p, dev*100, mark, ErrPriceBand)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) markPriceLocked(symbol string, fallback float64) float64 {
+	if p, ok := m.markPrices[symbol]; ok {
+		return p
+	}
+	return fallback
+}
+
+// orderPrice is the price used to value an order: the limit price if any, otherwise the mark.
+func orderPrice(o *orders.Order, mark float64) float64 {
+	if o.Price > 0 {
+		return o.Price
+	}
+	return mark
+}
+
+// Tripped reports whether the kill switch is active and why.
+func (m *Manager) Tripped() (bool, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tripped, m.tripReason
+}
+
+// Trip activates the kill switch: every following Check fails, all open orders are
+// cancelled and all positions are flattened. Errors from the executor are joined so
+// that one failing symbol does not prevent unwinding the others.
+func (m *Manager) Trip(ctx context.Context, reason string) error {
+	m.mu.Lock()
+	m.tripped = true
+	m.tripReason = reason
+	symbols := map[string]bool{}
+	for symbol := range m.openOrders {
+		symbols[symbol] = true
+	}
+	positions := make([]orders.Position, 0, len(m.positions))
+	for _, p := range m.positions {
+		positions = append(positions, p)
+	}
+	m.mu.Unlock()
+
+	if m.exec == nil {
+		return fmt.Errorf("%s: no executor: %w", reason, ErrKillSwitchTripped)
+	}
+	var errs []error
+	for _, p := range positions {
+		symbols[p.Symbol] = true
+	}
+	for symbol := range symbols {
+		if err := m.exec.CancelAllOpenOrders(ctx, symbol); err != nil {
+			errs = append(errs, fmt.Errorf("CancelAllOpenOrders(%q): %w", symbol, err))
+		}
+	}
+	for _, p := range positions {
+		if err := m.exec.ClosePosition(ctx, p); err != nil {
+			errs = append(errs, fmt.Errorf("ClosePosition(%q): %w", p.Symbol, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// State is what the manager keeps across restarts: the kill switch and the daily PnL.
+type State struct {
+	Tripped    bool
+	TripReason string
+	Day        time.Time // UTC midnight of the day DailyPnL belongs to.
+	DailyPnL   float64
+}
+
+// State returns the manager's state to persist.
+func (m *Manager) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return State{Tripped: m.tripped, TripReason: m.tripReason, Day: m.day, DailyPnL: m.dailyPnL}
+}
+
+// Restore brings back a persisted state. The daily PnL of a past day is dropped
+// once the clock is read.
+func (m *Manager) Restore(s State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tripped, m.tripReason = s.Tripped, s.TripReason
+	m.day, m.dailyPnL = utcDay(s.Day), s.DailyPnL
+}
+
+// Reset re-arms the kill switch. Today's PnL is kept, so the daily loss limit still
+// blocks new exposure until the next UTC day.
+func (m *Manager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tripped = false
+	m.tripReason = ""
+}
@@ -0,0 +1,189 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/orders"
+)
+
+// fakeExecutor records the calls of the kill switch.
+type fakeExecutor struct {
+	cancelled []string
+	closed    []orders.Position
+}
+
+func (e *fakeExecutor) CancelAllOpenOrders(ctx context.Context, symbol string) error {
+	e.cancelled = append(e.cancelled, symbol)
+	return nil
+}
+
+func (e *fakeExecutor) ClosePosition(ctx context.Context, pos orders.Position) error {
+	e.closed = append(e.closed, pos)
+	return nil
+}
+
+func buy(qty float64) orders.Order {
+	return orders.Order{Symbol: "BTCUSDT", Side: common.OrderSide_BUY, Type: common.OrderType_MARKET, Quantity: qty}
+}
+
+func sell(qty float64) orders.Order {
+	return orders.Order{Symbol: "BTCUSDT", Side: common.OrderSide_SELL, Type: common.OrderType_MARKET, Quantity: qty}
+}
+
+func limit(o orders.Order, price float64) orders.Order {
+	o.Type, o.Price = common.OrderType_LIMIT, price
+	return o
+}
+
+func reduceOnly(o orders.Order) orders.Order {
+	o.ReduceOnly = true
+	return o
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     Limits
+		position   float64 // Of BTCUSDT, marked at 100.
+		other      float64 // Position of ETHUSDT, marked at 10.
+		openOrders int
+		dailyPnL   float64
+		order      orders.Order
+		wantErr    error
+	}{
+		{name: "no limits", order: buy(1000)},
+		{name: "no quantity", order: buy(0), wantErr: ErrInvalidOrder},
+		{name: "no mark price", order: orders.Order{Symbol: "XRPUSDT", Side: common.OrderSide_BUY, Quantity: 1}, wantErr: ErrNoMarkPrice},
+		{name: "notional", limits: Limits{DefaultMaxNotional: 500}, position: 4, order: buy(2), wantErr: ErrMaxNotional},
+		{name: "notional at the limit", limits: Limits{DefaultMaxNotional: 500}, position: 4, order: buy(1)},
+		{name: "notional per symbol", limits: Limits{DefaultMaxNotional: 500, MaxNotionalPerSymbol: map[string]float64{"BTCUSDT": 1000}}, position: 4, order: buy(2)},
+		{name: "notional reduced", limits: Limits{DefaultMaxNotional: 500}, position: 10, order: sell(2)},
+		{name: "notional of a flip", limits: Limits{DefaultMaxNotional: 500}, position: 4, order: sell(10), wantErr: ErrMaxNotional},
+		{name: "leverage", limits: Limits{MaxLeverage: 2}, other: 100, order: buy(11), wantErr: ErrMaxLeverage},
+		{name: "leverage within", limits: Limits{MaxLeverage: 2}, other: 100, order: buy(9)},
+		{name: "open orders", limits: Limits{MaxOpenOrders: 2}, openOrders: 2, order: limit(buy(1), 100), wantErr: ErrMaxOpenOrders},
+		{name: "open orders skip market orders", limits: Limits{MaxOpenOrders: 2}, openOrders: 2, order: buy(1)},
+		{name: "price band", limits: Limits{PriceBandRatio: 0.05}, order: limit(buy(1), 94), wantErr: ErrPriceBand},
+		{name: "price band within", limits: Limits{PriceBandRatio: 0.05}, order: limit(buy(1), 96)},
+		{name: "fat finger quantity", limits: Limits{MaxOrderQuantity: map[string]float64{"BTCUSDT": 5}}, order: buy(6), wantErr: ErrFatFinger},
+		{name: "fat finger notional", limits: Limits{MaxOrderNotional: 500}, order: limit(buy(5), 101), wantErr: ErrFatFinger},
+		{name: "daily loss", limits: Limits{MaxDailyLoss: 100}, dailyPnL: -100, order: buy(1), wantErr: ErrMaxDailyLoss},
+		{name: "daily loss allows closing", limits: Limits{MaxDailyLoss: 100}, position: 3, dailyPnL: -100, order: sell(3)},
+		{name: "daily loss allows reducing", limits: Limits{MaxDailyLoss: 100}, position: -3, dailyPnL: -100, order: buy(1)},
+		{name: "daily loss blocks an equal size flip", limits: Limits{MaxDailyLoss: 100}, position: 3, dailyPnL: -100, order: sell(6), wantErr: ErrMaxDailyLoss},
+		{name: "daily loss blocks a smaller flip", limits: Limits{MaxDailyLoss: 100}, position: 3, dailyPnL: -100, order: sell(4), wantErr: ErrMaxDailyLoss},
+		{name: "reduce only", position: 3, order: reduceOnly(sell(2))},
+		{name: "reduce only growing", position: 3, order: reduceOnly(buy(1)), wantErr: ErrInvalidOrder},
+		{name: "reduce only flipping", position: 3, order: reduceOnly(sell(6)), wantErr: ErrInvalidOrder},
+		{name: "reduce only when flat", order: reduceOnly(sell(1)), wantErr: ErrInvalidOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			m := NewManager(tt.limits, nil)
+			m.SetClock(func() time.Time { return now })
+			m.Restore(State{Day: now, DailyPnL: tt.dailyPnL}) // A loss past the limit without the trip.
+			m.SetEquity(1000)
+			m.SetMarkPrice("BTCUSDT", 100)
+			m.SetMarkPrice("ETHUSDT", 10)
+			m.SetPosition(orders.Position{Symbol: "BTCUSDT", Quantity: tt.position, EntryPrice: 100})
+			m.SetPosition(orders.Position{Symbol: "ETHUSDT", Quantity: tt.other, EntryPrice: 10})
+			m.SetOpenOrders("ETHUSDT", tt.openOrders)
+			if err := m.Check(tt.order); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check(%+v) = %v, want %v", tt.order, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	ctx := context.Background()
+	exec := &fakeExecutor{}
+	m := NewManager(Limits{}, exec)
+	m.SetMarkPrice("BTCUSDT", 100)
+	m.SetPosition(orders.Position{Symbol: "BTCUSDT", Quantity: 2})
+	m.SetOpenOrders("ETHUSDT", 1)
+
+	if err := m.Trip(ctx, "manual"); err != nil {
+		t.Fatalf("Trip: %v", err)
+	}
+	if tripped, reason := m.Tripped(); !tripped || reason != "manual" {
+		t.Errorf("Tripped() = %v, %q, want true, manual", tripped, reason)
+	}
+	slices.Sort(exec.cancelled)
+	if want := []string{"BTCUSDT", "ETHUSDT"}; !slices.Equal(exec.cancelled, want) {
+		t.Errorf("cancelled orders of %v, want %v", exec.cancelled, want)
+	}
+	if len(exec.closed) != 1 || exec.closed[0].Quantity != 2 {
+		t.Errorf("closed %v, want the BTCUSDT position", exec.closed)
+	}
+	// Even closing orders wait for the reset, the executor already flattened.
+	for _, o := range []orders.Order{buy(1), sell(2)} {
+		if err := m.Check(o); !errors.Is(err, ErrKillSwitchTripped) {
+			t.Errorf("Check(%+v) = %v, want %v", o, err, ErrKillSwitchTripped)
+		}
+	}
+	m.Reset()
+	if tripped, _ := m.Tripped(); tripped {
+		t.Errorf("tripped after Reset")
+	}
+	if err := m.Check(buy(1)); err != nil {
+		t.Errorf("Check after Reset: %v", err)
+	}
+
+	if err := NewManager(Limits{}, nil).Trip(ctx, "manual"); !errors.Is(err, ErrKillSwitchTripped) {
+		t.Errorf("Trip without executor = %v, want %v", err, ErrKillSwitchTripped)
+	}
+}
+
+func TestRecordPnL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	exec := &fakeExecutor{}
+	m := NewManager(Limits{MaxDailyLoss: 100}, exec)
+	m.SetClock(func() time.Time { return now })
+	m.SetMarkPrice("BTCUSDT", 100)
+
+	steps := []struct {
+		at          time.Duration // After the start.
+		pnl         float64
+		wantPnL     float64
+		wantTripped bool
+	}{
+		{at: 0, pnl: -60, wantPnL: -60},
+		{at: time.Hour, pnl: 30, wantPnL: -30},
+		{at: 2 * time.Hour, pnl: -69, wantPnL: -99},
+		{at: 14 * time.Hour, pnl: -50, wantPnL: -50}, // The next UTC day starts afresh.
+		{at: 15 * time.Hour, pnl: -50, wantPnL: -100, wantTripped: true},
+		{at: 16 * time.Hour, pnl: 500, wantPnL: 400, wantTripped: true}, // Stays tripped.
+	}
+	start := now
+	for idx, s := range steps {
+		now = start.Add(s.at)
+		if err := m.RecordPnL(ctx, s.pnl); err != nil {
+			t.Fatalf("step %d: RecordPnL: %v", idx, err)
+		}
+		st := m.State()
+		if st.DailyPnL != s.wantPnL || !st.Day.Equal(utcDay(now)) {
+			t.Errorf("step %d: daily pnl %v of %v, want %v of %v", idx, st.DailyPnL, st.Day, s.wantPnL, utcDay(now))
+		}
+		if tripped, reason := m.Tripped(); tripped != s.wantTripped {
+			t.Errorf("step %d: tripped %v (%s), want %v", idx, tripped, reason, s.wantTripped)
+		}
+	}
+	if err := m.Check(buy(1)); !errors.Is(err, ErrKillSwitchTripped) {
+		t.Errorf("Check = %v, want %v", err, ErrKillSwitchTripped)
+	}
+	// The reset re-arms the switch but the day's loss still blocks new exposure.
+	m.Reset()
+	m.RecordPnL(ctx, -500)
+	m.Reset()
+	if err := m.Check(buy(1)); !errors.Is(err, ErrMaxDailyLoss) {
+		t.Errorf("Check after Reset = %v, want %v", err, ErrMaxDailyLoss)
+	}
+}
@@ -0,0 +1,111 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/funding"
+)
+
+// UpsertFundingRates stores the rates, overwriting those of the same symbol and
+// funding time.
+func (s *Store) UpsertFundingRates(rates []funding.FundingRate) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO funding_rates (symbol, funding_time, funding_rate, mark_price)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (symbol, funding_time) DO UPDATE SET
+				funding_rate = excluded.funding_rate, mark_price = excluded.mark_price`)
+		if err != nil {
+			return fmt.Errorf("prepare upsert: %w", err)
+		}
+		defer stmt.Close()
+		for idx := range rates {
+			r := &rates[idx]
+			if _, err := stmt.Exec(r.Symbol, r.FundingTime.UnixMilli(), r.FundingRate, r.MarkPrice); err != nil {
+				return fmt.Errorf("upsert funding rate %s %v: %w", r.Symbol, r.FundingTime, err)
+			}
+		}
+		return nil
+	})
+}
+
+// FundingRates returns symbol's funding rates within [from, to] in chronological
+// order, a zero time leaving that side open.
+func (s *Store) FundingRates(symbol string, from, to time.Time) ([]funding.FundingRate, error) {
+	rows, err := s.db.Query(`SELECT funding_time, funding_rate, mark_price FROM funding_rates
+		WHERE symbol = ? AND funding_time BETWEEN ? AND ? ORDER BY funding_time`,
+		symbol, toMillis(from, math.MinInt64), toMillis(to, math.MaxInt64))
+	if err != nil {
+		return nil, fmt.Errorf("query funding rates: %w", err)
+	}
+	defer rows.Close()
+	var res []funding.FundingRate
+	for rows.Next() {
+		r := funding.FundingRate{Symbol: symbol}
+		var fundingTime int64
+		if err := rows.Scan(&fundingTime, &r.FundingRate, &r.MarkPrice); err != nil {
+			return nil, fmt.Errorf("scan funding rate: %w", err)
+		}
+		r.FundingTime = time.UnixMilli(fundingTime)
+		res = append(res, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query funding rates: %w", err)
+	}
+	return res, nil
+}
+
+// UpsertAggTrades stores symbol's trades, overwriting those of the same ID.
+func (s *Store) UpsertAggTrades(symbol string, trades []aggtrades.AggTrade) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO agg_trades
+			(symbol, id, price, quantity, first_trade_id, last_trade_id, time, buyer_maker)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (symbol, id) DO UPDATE SET
+				price = excluded.price, quantity = excluded.quantity,
+				first_trade_id = excluded.first_trade_id, last_trade_id = excluded.last_trade_id,
+				time = excluded.time, buyer_maker = excluded.buyer_maker`)
+		if err != nil {
+			return fmt.Errorf("prepare upsert: %w", err)
+		}
+		defer stmt.Close()
+		for idx := range trades {
+			t := &trades[idx]
+			if _, err := stmt.Exec(symbol, t.ID, t.Price, t.Quantity, t.FirstTradeID, t.LastTradeID,
+				t.Time.UnixMilli(), t.BuyerMaker); err != nil {
+				return fmt.Errorf("upsert agg trade %s %d: %w", symbol, t.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// AggTrades returns symbol's trades made within [from, to] ordered by ID, a zero
+// time leaving that side open.
+func (s *Store) AggTrades(symbol string, from, to time.Time) ([]aggtrades.AggTrade, error) {
+	rows, err := s.db.Query(`SELECT id, price, quantity, first_trade_id, last_trade_id, time, buyer_maker
+		FROM agg_trades WHERE symbol = ? AND time BETWEEN ? AND ? ORDER BY id`,
+		symbol, toMillis(from, math.MinInt64), toMillis(to, math.MaxInt64))
+	if err != nil {
+		return nil, fmt.Errorf("query agg trades: %w", err)
+	}
+	defer rows.Close()
+	var res []aggtrades.AggTrade
+	for rows.Next() {
+		var t aggtrades.AggTrade
+		var tradeTime int64
+		if err := rows.Scan(&t.ID, &t.Price, &t.Quantity, &t.FirstTradeID, &t.LastTradeID,
+			&tradeTime, &t.BuyerMaker); err != nil {
+			return nil, fmt.Errorf("scan agg trade: %w", err)
+		}
+		t.Time = time.UnixMilli(tradeTime)
+		res = append(res, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query agg trades: %w", err)
+	}
+	return res, nil
+}
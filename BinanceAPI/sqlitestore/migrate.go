@@ -0,0 +1,78 @@
+package sqlitestore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+// MigrateReport counts the rows MigrateCSV read from the CSV tree.
+type MigrateReport struct {
+	Series       int
+	KLines       int
+	FundingRates int
+	AggTrades    int
+}
+
+// MigrateCSV copies the KLines, funding rates and aggregated trades of the CSV
+// tree under root into the database. Rows are upserted so it can be rerun after
+// the tree grew.
+func (s *Store) MigrateCSV(root string) (MigrateReport, error) {
+	var rep MigrateReport
+	all, err := storage.ListSeries(root, ".csv")
+	if err != nil {
+		return rep, fmt.Errorf("ListSeries(%q): %w", root, err)
+	}
+	src := storage.NewCSVStore(root)
+	symbols := map[string]bool{}
+	for _, series := range all {
+		bars, err := src.Range(series.Symbol, series.Interval, time.Time{}, time.Time{})
+		if err != nil {
+			return rep, fmt.Errorf("Range(%s %s): %w", series.Symbol, series.Interval, err)
+		}
+		if err := s.UpsertKLines(series.Symbol, series.Interval, bars); err != nil {
+			return rep, fmt.Errorf("UpsertKLines(%s %s): %w", series.Symbol, series.Interval, err)
+		}
+		rep.Series++
+		rep.KLines += len(bars)
+		symbols[series.Symbol] = true
+	}
+
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return rep, fmt.Errorf("read dir: %w", err)
+	}
+	for _, dir := range dirs {
+		symbol := dir.Name()
+		if !dir.IsDir() {
+			continue
+		}
+		rates, err := storage.LoadFundingRatesCSV(root, symbol)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return rep, fmt.Errorf("LoadFundingRatesCSV(%s): %w", symbol, err)
+		default:
+			if err := s.UpsertFundingRates(rates); err != nil {
+				return rep, fmt.Errorf("UpsertFundingRates(%s): %w", symbol, err)
+			}
+			rep.FundingRates += len(rates)
+		}
+		trades, err := storage.LoadAggTradesCSV(root, symbol)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return rep, fmt.Errorf("LoadAggTradesCSV(%s): %w", symbol, err)
+		default:
+			if err := s.UpsertAggTrades(symbol, trades); err != nil {
+				return rep, fmt.Errorf("UpsertAggTrades(%s): %w", symbol, err)
+			}
+			rep.AggTrades += len(trades)
+		}
+	}
+	return rep, nil
+}
@@ -0,0 +1,245 @@
+// Package sqlitestore keeps market data in one SQLite file, with a table per data
+// type, so history can be queried with SQL. It uses a pure Go driver and builds
+// without cgo.
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+	_ "modernc.org/sqlite"
+)
+
+// Times are stored as unix milliseconds, like in the CSVs.
+const schema = `
+CREATE TABLE IF NOT EXISTS klines (
+	symbol             TEXT    NOT NULL,
+	interval           TEXT    NOT NULL,
+	open_time          INTEGER NOT NULL,
+	close_time         INTEGER NOT NULL,
+	open_price         REAL    NOT NULL,
+	close_price        REAL    NOT NULL,
+	high_price         REAL    NOT NULL,
+	low_price          REAL    NOT NULL,
+	volume             REAL    NOT NULL,
+	quote_asset_volume REAL    NOT NULL,
+	trade_num          REAL    NOT NULL,
+	synthetic          INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (symbol, interval, open_time)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS funding_rates (
+	symbol       TEXT    NOT NULL,
+	funding_time INTEGER NOT NULL,
+	funding_rate REAL    NOT NULL,
+	mark_price   REAL    NOT NULL,
+	PRIMARY KEY (symbol, funding_time)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS agg_trades (
+	symbol         TEXT    NOT NULL,
+	id             INTEGER NOT NULL,
+	price          REAL    NOT NULL,
+	quantity       REAL    NOT NULL,
+	first_trade_id INTEGER NOT NULL,
+	last_trade_id  INTEGER NOT NULL,
+	time           INTEGER NOT NULL,
+	buyer_maker    INTEGER NOT NULL,
+	PRIMARY KEY (symbol, id)
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS agg_trades_time ON agg_trades (symbol, time);
+`
+
+const klineColumns = `open_time, close_time, open_price, close_price, high_price, low_price,
+	volume, quote_asset_volume, trade_num, synthetic`
+
+// Store is a storage.KLineStore in SQLite which also keeps funding rates and
+// aggregated trades. It is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+}
+
+var _ storage.KLineStore = (*Store)(nil)
+
+// Open opens the database at path, creating it and the tables if needed.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("sql.Open(%q): %w", path, err)
+	}
+	// SQLite takes one writer at a time; one connection avoids busy errors.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create tables: %w", err)
+	}
+	if err := addSyntheticColumn(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// addSyntheticColumn upgrades a database created before klines had the
+// synthetic column.
+func addSyntheticColumn(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('klines') WHERE name = 'synthetic'`).Scan(&n); err != nil {
+		return fmt.Errorf("query klines columns: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := db.Exec(`ALTER TABLE klines ADD COLUMN synthetic INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return fmt.Errorf("add synthetic column: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// DB gives access to the database for ad hoc queries.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+func toMillis(t time.Time, zero int64) int64 {
+	if t.IsZero() {
+		return zero
+	}
+	return t.UnixMilli()
+}
+
+// inTx runs f in a transaction, committing it when f succeeds.
+func (s *Store) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func upsertKLines(tx *sql.Tx, symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	stmt, err := tx.Prepare(`INSERT INTO klines (symbol, interval, ` + klineColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (symbol, interval, open_time) DO UPDATE SET
+			close_time = excluded.close_time, open_price = excluded.open_price,
+			close_price = excluded.close_price, high_price = excluded.high_price,
+			low_price = excluded.low_price, volume = excluded.volume,
+			quote_asset_volume = excluded.quote_asset_volume, trade_num = excluded.trade_num,
+			synthetic = excluded.synthetic`)
+	if err != nil {
+		return fmt.Errorf("prepare upsert: %w", err)
+	}
+	defer stmt.Close()
+	for idx := range bars {
+		l := &bars[idx]
+		if _, err := stmt.Exec(symbol, string(interval), l.OpenTime.UnixMilli(), l.CloseTime.UnixMilli(),
+			l.OpenPrice, l.ClosePrice, l.HighPrice, l.LowPrice, l.Volume, l.QuoteAssetVolume, l.TradeNum, l.Synthetic); err != nil {
+			return fmt.Errorf("upsert kline %v: %w", l.OpenTime, err)
+		}
+	}
+	return nil
+}
+
+// UpsertKLines inserts the bars, overwriting stored ones of the same open time and
+// leaving the others alone, so downloading a range again changes nothing.
+func (s *Store) UpsertKLines(symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return upsertKLines(tx, symbol, interval, bars)
+	})
+}
+
+// Append implements storage.KLineStore: unlike UpsertKLines it also deletes the
+// stored bars opening after bars[0] that the bars do not overwrite.
+func (s *Store) Append(symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	if len(bars) == 0 {
+		return nil
+	}
+	for idx := 1; idx < len(bars); idx++ {
+		if !bars[idx].OpenTime.After(bars[idx-1].OpenTime) {
+			return fmt.Errorf("bar %d opens at %v, not after %v: %w",
+				idx, bars[idx].OpenTime, bars[idx-1].OpenTime, storage.ErrNotInOrder)
+		}
+	}
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM klines WHERE symbol = ? AND interval = ? AND open_time >= ?`,
+			symbol, string(interval), bars[0].OpenTime.UnixMilli()); err != nil {
+			return fmt.Errorf("delete replaced klines: %w", err)
+		}
+		return upsertKLines(tx, symbol, interval, bars)
+	})
+}
+
+func scanKLines(rows *sql.Rows) ([]klines.KLine, error) {
+	defer rows.Close()
+	var res []klines.KLine
+	for rows.Next() {
+		var l klines.KLine
+		var openTime, closeTime int64
+		if err := rows.Scan(&openTime, &closeTime, &l.OpenPrice, &l.ClosePrice, &l.HighPrice, &l.LowPrice,
+			&l.Volume, &l.QuoteAssetVolume, &l.TradeNum, &l.Synthetic); err != nil {
+			return nil, fmt.Errorf("scan kline: %w", err)
+		}
+		l.OpenTime, l.CloseTime = time.UnixMilli(openTime), time.UnixMilli(closeTime)
+		res = append(res, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query klines: %w", err)
+	}
+	return res, nil
+}
+
+func (s *Store) Range(symbol string, interval common.ListKLinesInterval, from, to time.Time) ([]klines.KLine, error) {
+	rows, err := s.db.Query(`SELECT `+klineColumns+` FROM klines
+		WHERE symbol = ? AND interval = ? AND open_time BETWEEN ? AND ? ORDER BY open_time`,
+		symbol, string(interval), toMillis(from, math.MinInt64), toMillis(to, math.MaxInt64))
+	if err != nil {
+		return nil, fmt.Errorf("query klines: %w", err)
+	}
+	return scanKLines(rows)
+}
+
+func (s *Store) Last(symbol string, interval common.ListKLinesInterval) (klines.KLine, bool, error) {
+	rows, err := s.db.Query(`SELECT `+klineColumns+` FROM klines
+		WHERE symbol = ? AND interval = ? ORDER BY open_time DESC LIMIT 1`, symbol, string(interval))
+	if err != nil {
+		return klines.KLine{}, false, fmt.Errorf("query klines: %w", err)
+	}
+	bars, err := scanKLines(rows)
+	if err != nil || len(bars) == 0 {
+		return klines.KLine{}, false, err
+	}
+	return bars[0], true, nil
+}
+
+// Stats implements storage.KLineStore. SizeBytes is left 0 as series share the file.
+func (s *Store) Stats(symbol string, interval common.ListKLinesInterval) (storage.SeriesStats, error) {
+	var st storage.SeriesStats
+	var first, last sql.NullInt64
+	if err := s.db.QueryRow(`SELECT COUNT(*), MIN(open_time), MAX(open_time) FROM klines
+		WHERE symbol = ? AND interval = ?`, symbol, string(interval)).Scan(&st.Count, &first, &last); err != nil {
+		return storage.SeriesStats{}, fmt.Errorf("query klines: %w", err)
+	}
+	if st.Count == 0 {
+		return st, nil
+	}
+	st.First, st.Last = time.UnixMilli(first.Int64), time.UnixMilli(last.Int64)
+	st.Missing = common.BarsBetween(interval, st.First, st.Last) - st.Count
+	return st, nil
+}
@@ -0,0 +1,80 @@
+package sqlitestore_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/sqlitestore"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storagetest"
+)
+
+func openStore(t *testing.T) *sqlitestore.Store {
+	s, err := sqlitestore.Open(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.KLineStore { return openStore(t) })
+}
+
+// TestUpsertKLines checks that upserts only touch the bars they carry, unlike
+// Append which deletes the stored bars after bars[0].
+func TestUpsertKLines(t *testing.T) {
+	const symbol, interval = "BTCUSDT", common.ListKLinesInterval_1h
+	tests := []struct {
+		name    string
+		upserts [][]klines.KLine
+		append  []klines.KLine
+		want    []klines.KLine
+	}{
+		{
+			name:    "same bars twice",
+			upserts: [][]klines.KLine{storagetest.Bars(0, 0, 1, 2), storagetest.Bars(0, 0, 1, 2)},
+			want:    storagetest.Bars(0, 0, 1, 2),
+		},
+		{
+			name:    "overwrite in the middle",
+			upserts: [][]klines.KLine{storagetest.Bars(0, 0, 1, 2, 3), storagetest.Bars(1, 1)},
+			want:    append(append(storagetest.Bars(0, 0), storagetest.Bars(1, 1)...), storagetest.Bars(0, 2, 3)...),
+		},
+		{
+			name:    "fill a gap",
+			upserts: [][]klines.KLine{storagetest.Bars(0, 0, 3), storagetest.Bars(0, 1, 2)},
+			want:    storagetest.Bars(0, 0, 1, 2, 3),
+		},
+		{
+			name:    "append deletes the tail",
+			upserts: [][]klines.KLine{storagetest.Bars(0, 0, 1, 2, 3)},
+			append:  storagetest.Bars(1, 1),
+			want:    append(storagetest.Bars(0, 0), storagetest.Bars(1, 1)...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t)
+			for _, bars := range tt.upserts {
+				if err := s.UpsertKLines(symbol, interval, bars); err != nil {
+					t.Fatalf("UpsertKLines: %v", err)
+				}
+			}
+			if err := s.Append(symbol, interval, tt.append); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			got, err := s.Range(symbol, interval, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatalf("Range: %v", err)
+			}
+			if !storagetest.Equal(got, tt.want) {
+				t.Errorf("Range = %s, want %s", storagetest.Format(got), storagetest.Format(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+)
+
+var (
+	AggTradeCSVHeader = []string{
+		"ID",
+		"Price",
+		"Quantity",
+		"FirstTradeID",
+		"LastTradeID",
+		"Time",
+		"BuyerMaker",
+	}
+)
+
+// AggTradeCSVPath returns the path of the CSV storing symbol's aggregated trades
+// under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_aggTrades.csv".
+func AggTradeCSVPath(root, symbol string) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_aggTrades.csv", symbol))
+}
+
+// Prices and quantities are written in full precision since the fill models match
+// orders against exact trade prices.
+func AggTradeToCSVRecord(t *aggtrades.AggTrade) []string {
+	return []string{
+		strconv.FormatInt(t.ID, 10),
+		strconv.FormatFloat(t.Price, 'g', -1, 64),
+		strconv.FormatFloat(t.Quantity, 'g', -1, 64),
+		strconv.FormatInt(t.FirstTradeID, 10),
+		strconv.FormatInt(t.LastTradeID, 10),
+		timeToCSVRepr(t.Time),
+		strconv.FormatBool(t.BuyerMaker),
+	}
+}
+
+func AggTradeFromCSVRecord(record []string, dst *aggtrades.AggTrade) error {
+	if len(record) != len(AggTradeCSVHeader) {
+		return fmt.Errorf("expect %d column but get %d", len(AggTradeCSVHeader), len(record))
+	}
+	var err error
+	if dst.ID, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+		return fmt.Errorf("parse id column %q: %w", record[0], err)
+	}
+	if dst.Price, err = floatFromCSVRepr(record[1]); err != nil {
+		return fmt.Errorf("parse price column %q: %w", record[1], err)
+	}
+	if dst.Quantity, err = floatFromCSVRepr(record[2]); err != nil {
+		return fmt.Errorf("parse quantity column %q: %w", record[2], err)
+	}
+	if dst.FirstTradeID, err = strconv.ParseInt(record[3], 10, 64); err != nil {
+		return fmt.Errorf("parse first trade id column %q: %w", record[3], err)
+	}
+	if dst.LastTradeID, err = strconv.ParseInt(record[4], 10, 64); err != nil {
+		return fmt.Errorf("parse last trade id column %q: %w", record[4], err)
+	}
+	if dst.Time, err = timeFromCSVRepr(record[5]); err != nil {
+		return fmt.Errorf("parse time column %q: %w", record[5], err)
+	}
+	if dst.BuyerMaker, err = strconv.ParseBool(record[6]); err != nil {
+		return fmt.Errorf("parse buyer maker column %q: %w", record[6], err)
+	}
+	return nil
+}
+
+// ReadAggTradesCSV reads all trades from a CSV whose first line is AggTradeCSVHeader.
+func ReadAggTradesCSV(r io.Reader) ([]aggtrades.AggTrade, error) {
+	cr := csv.NewReader(r)
+	if _, err := cr.Read(); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	var trades []aggtrades.AggTrade
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read one CSV record: %w", err)
+		}
+		var t aggtrades.AggTrade
+		if err := AggTradeFromCSVRecord(record, &t); err != nil {
+			return nil, fmt.Errorf("AggTradeFromCSVRecord(%v): %w", record, err)
+		}
+		trades = append(trades, t)
+	}
+	return trades, nil
+}
+
+// LoadAggTradesCSV reads symbol's aggregated trades stored under root.
+func LoadAggTradesCSV(root, symbol string) ([]aggtrades.AggTrade, error) {
+	path := AggTradeCSVPath(root, symbol)
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	return ReadAggTradesCSV(fp)
+}
+
+// WriteAggTradesCSV writes the header followed by all trades.
+func WriteAggTradesCSV(w io.Writer, trades []aggtrades.AggTrade) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(AggTradeCSVHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for idx := range trades {
+		if err := cw.Write(AggTradeToCSVRecord(&trades[idx])); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// MergeAggTradesCSV adds the trades not stored yet, by ID, to symbol's
+// aggregated trades under root and returns how many were added. Trades past the
+// last stored one are appended; the CSV is only rewritten, streaming it through
+// a temporary file, when older trades are missing from it.
+func MergeAggTradesCSV(root, symbol string, trades []aggtrades.AggTrade) (int, error) {
+	if len(trades) == 0 {
+		return 0, nil
+	}
+	if !slices.IsSortedFunc(trades, func(a, b aggtrades.AggTrade) int { return cmp.Compare(a.ID, b.ID) }) {
+		trades = slices.Clone(trades)
+		slices.SortFunc(trades, func(a, b aggtrades.AggTrade) int { return cmp.Compare(a.ID, b.ID) })
+	}
+	trades = slices.CompactFunc(slices.Clip(trades), func(a, b aggtrades.AggTrade) bool { return a.ID == b.ID })
+
+	path := AggTradeCSVPath(root, symbol)
+	fp, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := replaceFile(path, func(w io.Writer) error {
+			return WriteAggTradesCSV(w, trades)
+		}); err != nil {
+			return 0, fmt.Errorf("write %s agg trades: %w", symbol, err)
+		}
+		return len(trades), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+
+	// Find the last stored trade, past a torn last line.
+	info, err := fp.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat file: %w", err)
+	}
+	end := info.Size()
+	var last aggtrades.AggTrade
+	stored := false
+	if err := scanBack(fp, func(record []string, offset int64) (bool, error) {
+		if record == nil {
+			end = offset
+			return true, nil
+		}
+		if err := AggTradeFromCSVRecord(record, &last); err != nil {
+			return false, fmt.Errorf("AggTradeFromCSVRecord(%v): %w", record, err)
+		}
+		stored = true
+		return false, nil
+	}); err != nil {
+		return 0, fmt.Errorf("scan %q: %w", path, err)
+	}
+	newer := 0
+	if stored {
+		newer, _ = slices.BinarySearchFunc(trades, last.ID+1, func(t aggtrades.AggTrade, id int64) int {
+			return cmp.Compare(t.ID, id)
+		})
+	}
+	if newer > 0 {
+		missing, err := mergeAggTrades(io.NewSectionReader(fp, 0, end), trades[:newer], nil)
+		if err != nil {
+			return 0, fmt.Errorf("read %q: %w", path, err)
+		}
+		if missing > 0 {
+			var added int
+			if err := replaceFile(path, func(w io.Writer) error {
+				cw := csv.NewWriter(w)
+				if added, err = mergeAggTrades(io.NewSectionReader(fp, 0, end), trades, cw); err != nil {
+					return err
+				}
+				cw.Flush()
+				return cw.Error()
+			}); err != nil {
+				return 0, fmt.Errorf("write %s agg trades: %w", symbol, err)
+			}
+			return added, nil
+		}
+	}
+	if newer == len(trades) {
+		return 0, nil
+	}
+	if err := appendAggTrades(fp, end, trades[newer:]); err != nil {
+		return 0, fmt.Errorf("append to %q: %w", path, err)
+	}
+	return len(trades) - newer, nil
+}
+
+// mergeAggTrades reads the stored CSV from r and counts the trades, sorted by
+// ID, it lacks. With w, the header, the stored trades and the lacking ones are
+// written to it in ID order.
+func mergeAggTrades(r io.Reader, trades []aggtrades.AggTrade, w *csv.Writer) (int, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	if _, err := cr.Read(); err != nil {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+	if w != nil {
+		if err := w.Write(AggTradeCSVHeader); err != nil {
+			return 0, fmt.Errorf("write header: %w", err)
+		}
+	}
+	added, jdx := 0, 0
+	add := func(t *aggtrades.AggTrade) error {
+		added++
+		if w == nil {
+			return nil
+		}
+		if err := w.Write(AggTradeToCSVRecord(t)); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+		return nil
+	}
+	var t aggtrades.AggTrade
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read one CSV record: %w", err)
+		}
+		if err := AggTradeFromCSVRecord(record, &t); err != nil {
+			return 0, fmt.Errorf("AggTradeFromCSVRecord(%v): %w", record, err)
+		}
+		for ; jdx < len(trades) && trades[jdx].ID < t.ID; jdx++ {
+			if err := add(&trades[jdx]); err != nil {
+				return 0, err
+			}
+		}
+		if jdx < len(trades) && trades[jdx].ID == t.ID {
+			jdx++
+		}
+		if w != nil {
+			if err := w.Write(record); err != nil {
+				return 0, fmt.Errorf("write record: %w", err)
+			}
+		}
+	}
+	for ; jdx < len(trades); jdx++ {
+		if err := add(&trades[jdx]); err != nil {
+			return 0, err
+		}
+	}
+	return added, nil
+}
+
+// appendAggTrades writes trades at offset end of fp, cutting off what follows,
+// e.g. a torn last line.
+func appendAggTrades(fp *os.File, end int64, trades []aggtrades.AggTrade) error {
+	if err := fp.Truncate(end); err != nil {
+		return fmt.Errorf("Truncate(%d): %w", end, err)
+	}
+	if _, err := fp.Seek(end, io.SeekStart); err != nil {
+		return fmt.Errorf("seek file end: %w", err)
+	}
+	bw := bufio.NewWriter(fp)
+	cw := csv.NewWriter(bw)
+	for idx := range trades {
+		if err := cw.Write(AggTradeToCSVRecord(&trades[idx])); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("flush records: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write records: %w", err)
+	}
+	if err := fp.Sync(); err != nil {
+		return fmt.Errorf("sync file: %w", err)
+	}
+	return nil
+}
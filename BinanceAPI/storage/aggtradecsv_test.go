@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+)
+
+func testAggTrades(ids ...int64) []aggtrades.AggTrade {
+	var res []aggtrades.AggTrade
+	for _, id := range ids {
+		res = append(res, aggtrades.AggTrade{
+			ID: id, Price: 100 + float64(id)/10, Quantity: 1, FirstTradeID: id * 2, LastTradeID: id*2 + 1,
+			Time: time.UnixMilli(1704067200000 + id*1000), BuyerMaker: id%2 == 0,
+		})
+	}
+	return res
+}
+
+func TestMergeAggTradesCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    []int64
+		torn      bool // Whether the stored CSV ends with a torn line.
+		trades    []int64
+		wantAdded int
+		want      []int64
+		rewritten bool // Whether a stored CSV is replaced rather than appended to.
+	}{
+		{name: "new file", trades: []int64{3, 1, 2, 2}, wantAdded: 3, want: []int64{1, 2, 3}},
+		{name: "append", stored: []int64{1, 2}, trades: []int64{2, 3, 4}, wantAdded: 2, want: []int64{1, 2, 3, 4}},
+		{name: "append past torn line", stored: []int64{1, 2}, torn: true, trades: []int64{3}, wantAdded: 1, want: []int64{1, 2, 3}},
+		{name: "all stored", stored: []int64{1, 2, 3}, trades: []int64{1, 3}, want: []int64{1, 2, 3}},
+		{name: "fill hole", stored: []int64{1, 4}, trades: []int64{2, 3, 5}, wantAdded: 3, want: []int64{1, 2, 3, 4, 5}, rewritten: true},
+		{name: "before first", stored: []int64{3, 4}, trades: []int64{1, 2}, wantAdded: 2, want: []int64{1, 2, 3, 4}, rewritten: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			path := AggTradeCSVPath(root, "BTCUSDT")
+			var before os.FileInfo
+			if tt.stored != nil {
+				if _, err := MergeAggTradesCSV(root, "BTCUSDT", testAggTrades(tt.stored...)); err != nil {
+					t.Fatalf("store: %v", err)
+				}
+				if tt.torn {
+					fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+					if err != nil {
+						t.Fatal(err)
+					}
+					fp.WriteString("9,100,1,18")
+					fp.Close()
+				}
+				var err error
+				if before, err = os.Stat(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			added, err := MergeAggTradesCSV(root, "BTCUSDT", testAggTrades(tt.trades...))
+			if err != nil {
+				t.Fatalf("MergeAggTradesCSV: %v", err)
+			}
+			if added != tt.wantAdded {
+				t.Errorf("added %d, want %d", added, tt.wantAdded)
+			}
+			got, err := LoadAggTradesCSV(root, "BTCUSDT")
+			if err != nil {
+				t.Fatalf("LoadAggTradesCSV: %v", err)
+			}
+			want := testAggTrades(tt.want...)
+			if !slices.EqualFunc(got, want, func(a, b aggtrades.AggTrade) bool {
+				return a.ID == b.ID && a.Price == b.Price && a.Time.Equal(b.Time) && a.BuyerMaker == b.BuyerMaker
+			}) {
+				t.Errorf("stored %+v, want %+v", got, want)
+			}
+			if before != nil {
+				after, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rewritten := !os.SameFile(before, after); rewritten != tt.rewritten {
+					t.Errorf("rewritten %v, want %v", rewritten, tt.rewritten)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/depth"
+)
+
+// Each depth snapshot is stored as one row per price level, so that snapshots of
+// different depths fit in the same file. Side is "BID" or "ASK".
+var (
+	DepthCSVHeader = []string{
+		"Time",
+		"LastUpdateID",
+		"Side",
+		"Price",
+		"Quantity",
+	}
+)
+
+const (
+	depthSideBid = "BID"
+	depthSideAsk = "ASK"
+)
+
+// DepthCSVPath returns the path of the CSV storing symbol's order book snapshots
+// under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_depth.csv".
+func DepthCSVPath(root, symbol string) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_depth.csv", symbol))
+}
+
+func DepthSnapshotToCSVRecords(s *depth.Snapshot) [][]string {
+	records := make([][]string, 0, len(s.Bids)+len(s.Asks))
+	add := func(side string, levels []depth.PriceLevel) {
+		for _, l := range levels {
+			records = append(records, []string{
+				timeToCSVRepr(s.Time),
+				strconv.FormatInt(s.LastUpdateID, 10),
+				side,
+				strconv.FormatFloat(l.Price, 'g', -1, 64),
+				strconv.FormatFloat(l.Quantity, 'g', -1, 64),
+			})
+		}
+	}
+	add(depthSideBid, s.Bids)
+	add(depthSideAsk, s.Asks)
+	return records
+}
+
+// ReadDepthSnapshotsCSV groups the level rows back into snapshots by LastUpdateID.
+// Levels keep the order they were written in.
+func ReadDepthSnapshotsCSV(r io.Reader) ([]depth.Snapshot, error) {
+	cr := csv.NewReader(r)
+	if _, err := cr.Read(); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	var snapshots []depth.Snapshot
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read one CSV record: %w", err)
+		}
+		if len(record) != len(DepthCSVHeader) {
+			return nil, fmt.Errorf("expect %d column but get %d", len(DepthCSVHeader), len(record))
+		}
+		t, err := timeFromCSVRepr(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse time column %q: %w", record[0], err)
+		}
+		updateID, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse last update id column %q: %w", record[1], err)
+		}
+		var level depth.PriceLevel
+		if level.Price, err = floatFromCSVRepr(record[3]); err != nil {
+			return nil, fmt.Errorf("parse price column %q: %w", record[3], err)
+		}
+		if level.Quantity, err = floatFromCSVRepr(record[4]); err != nil {
+			return nil, fmt.Errorf("parse quantity column %q: %w", record[4], err)
+		}
+		if n := len(snapshots); n == 0 || snapshots[n-1].LastUpdateID != updateID {
+			snapshots = append(snapshots, depth.Snapshot{LastUpdateID: updateID, Time: t})
+		}
+		s := &snapshots[len(snapshots)-1]
+		switch record[2] {
+		case depthSideBid:
+			s.Bids = append(s.Bids, level)
+		case depthSideAsk:
+			s.Asks = append(s.Asks, level)
+		default:
+			return nil, fmt.Errorf("unknown side column %q", record[2])
+		}
+	}
+	return snapshots, nil
+}
+
+// LoadDepthSnapshotsCSV reads symbol's order book snapshots stored under root.
+func LoadDepthSnapshotsCSV(root, symbol string) ([]depth.Snapshot, error) {
+	path := DepthCSVPath(root, symbol)
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	return ReadDepthSnapshotsCSV(fp)
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/funding"
+)
+
+var (
+	FundingRateCSVHeader = []string{
+		"FundingTime",
+		"FundingRate",
+		"MarkPrice",
+	}
+)
+
+// FundingRateCSVPath returns the path of the CSV storing symbol's funding rate
+// history under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_funding.csv".
+func FundingRateCSVPath(root, symbol string) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_funding.csv", symbol))
+}
+
+func FundingRateToCSVRecord(r *funding.FundingRate) []string {
+	return []string{
+		timeToCSVRepr(r.FundingTime),
+		floatToCSVRepr(r.FundingRate),
+		floatToCSVRepr(r.MarkPrice),
+	}
+}
+
+// FundingRateFromCSVRecord fills everything but the symbol, which is implied by the file.
+func FundingRateFromCSVRecord(record []string, dst *funding.FundingRate) error {
+	if len(record) != len(FundingRateCSVHeader) {
+		return fmt.Errorf("expect %d column but get %d", len(FundingRateCSVHeader), len(record))
+	}
+	var err error
+	if dst.FundingTime, err = timeFromCSVRepr(record[0]); err != nil {
+		return fmt.Errorf("parse funding time column %q: %w", record[0], err)
+	}
+	if dst.FundingRate, err = floatFromCSVRepr(record[1]); err != nil {
+		return fmt.Errorf("parse funding rate column %q: %w", record[1], err)
+	}
+	if dst.MarkPrice, err = floatFromCSVRepr(record[2]); err != nil {
+		return fmt.Errorf("parse mark price column %q: %w", record[2], err)
+	}
+	return nil
+}
+
+// ReadFundingRatesCSV reads all funding rates from a CSV whose first line is FundingRateCSVHeader.
+func ReadFundingRatesCSV(r io.Reader, symbol string) ([]funding.FundingRate, error) {
+	cr := csv.NewReader(r)
+	if _, err := cr.Read(); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	var rates []funding.FundingRate
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read one CSV record: %w", err)
+		}
+		rate := funding.FundingRate{Symbol: symbol}
+		if err := FundingRateFromCSVRecord(record, &rate); err != nil {
+			return nil, fmt.Errorf("FundingRateFromCSVRecord(%v): %w", record, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// LoadFundingRatesCSV reads symbol's funding rate history stored under root.
+func LoadFundingRatesCSV(root, symbol string) ([]funding.FundingRate, error) {
+	path := FundingRateCSVPath(root, symbol)
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	return ReadFundingRatesCSV(fp, symbol)
+}
+
+// WriteFundingRatesCSV writes the header followed by all funding rates.
+func WriteFundingRatesCSV(w io.Writer, rates []funding.FundingRate) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(FundingRateCSVHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for idx := range rates {
+		if err := cw.Write(FundingRateToCSVRecord(&rates[idx])); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// MergeFundingRatesCSV adds the rates not stored yet to symbol's funding rate
+// history under root, keeping the stored rates on overlaps, and returns how many
+// were added.
+func MergeFundingRatesCSV(root, symbol string, rates []funding.FundingRate) (int, error) {
+	stored, err := LoadFundingRatesCSV(root, symbol)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("LoadFundingRatesCSV: %w", err)
+	}
+	seen := make(map[int64]bool, len(stored))
+	for idx := range stored {
+		seen[stored[idx].FundingTime.UnixMilli()] = true
+	}
+	merged := stored
+	for _, r := range rates {
+		if t := r.FundingTime.UnixMilli(); !seen[t] {
+			seen[t] = true
+			merged = append(merged, r)
+		}
+	}
+	added := len(merged) - len(stored)
+	if added == 0 {
+		return 0, nil
+	}
+	slices.SortFunc(merged, func(a, b funding.FundingRate) int {
+		return a.FundingTime.Compare(b.FundingTime)
+	})
+	if err := replaceFile(FundingRateCSVPath(root, symbol), func(w io.Writer) error {
+		return WriteFundingRatesCSV(w, merged)
+	}); err != nil {
+		return 0, fmt.Errorf("write %s funding rates: %w", symbol, err)
+	}
+	return added, nil
+}
@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// The binary KLine file is a 64 byte header followed by one fixed width row per
+// KLine, all little endian:
+//
+//	header: magic "KLNB" | version u16 | compression u8 | 0 u8 | row size u16 |
+//	        6 zero bytes | interval [16]byte | symbol [32]byte
+//	row:    OpenTime ms i64 | CloseTime ms i64 | OpenPrice | ClosePrice |
+//	        HighPrice | LowPrice | Volume | QuoteAssetVolume | TradeNum f64 |
+//	        flags u64, bit 0 set for a synthetic bar
+//
+// Version 1 files, whose rows had no flags, are rejected; convert them again from
+// the CSVs.
+//
+// Uncompressed rows are memory mapped and binary searched on open time, so a
+// range query only decodes the rows it returns. With Compression_FLATE the rows
+// form one flate stream, which is smaller on disk but read entirely.
+const (
+	klineBinaryMagic      = "KLNB"
+	klineBinaryVersion    = 2
+	klineBinaryHeaderSize = 64
+	klineBinaryRowSize    = 80
+
+	klineBinaryFlagSynthetic = 1 << 0
+)
+
+var (
+	ErrBadFormat = errors.New("bad binary kline file")
+)
+
+type Compression uint8
+
+const (
+	Compression_NONE  Compression = 0
+	Compression_FLATE Compression = 1
+)
+
+// KLineBinaryPath returns the path of the binary file storing symbol's KLines of
+// the interval under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_<interval>.klb".
+func KLineBinaryPath(root, symbol string, interval common.ListKLinesInterval) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_%s.klb", symbol, interval))
+}
+
+type klineBinaryHeader struct {
+	symbol      string
+	interval    common.ListKLinesInterval
+	compression Compression
+}
+
+func (h *klineBinaryHeader) encode() []byte {
+	b := make([]byte, klineBinaryHeaderSize)
+	copy(b[0:4], klineBinaryMagic)
+	binary.LittleEndian.PutUint16(b[4:6], klineBinaryVersion)
+	b[6] = byte(h.compression)
+	binary.LittleEndian.PutUint16(b[8:10], klineBinaryRowSize)
+	copy(b[16:32], h.interval)
+	copy(b[32:64], h.symbol)
+	return b
+}
+
+func decodeKLineBinaryHeader(b []byte) (klineBinaryHeader, error) {
+	if len(b) < klineBinaryHeaderSize || string(b[0:4]) != klineBinaryMagic {
+		return klineBinaryHeader{}, fmt.Errorf("no header: %w", ErrBadFormat)
+	}
+	if v := binary.LittleEndian.Uint16(b[4:6]); v != klineBinaryVersion {
+		return klineBinaryHeader{}, fmt.Errorf("version %d, want %d: %w", v, klineBinaryVersion, ErrBadFormat)
+	}
+	if n := binary.LittleEndian.Uint16(b[8:10]); n != klineBinaryRowSize {
+		return klineBinaryHeader{}, fmt.Errorf("row size %d, want %d: %w", n, klineBinaryRowSize, ErrBadFormat)
+	}
+	h := klineBinaryHeader{
+		symbol:      string(bytes.TrimRight(b[32:64], "\x00")),
+		interval:    common.ListKLinesInterval(bytes.TrimRight(b[16:32], "\x00")),
+		compression: Compression(b[6]),
+	}
+	if h.compression != Compression_NONE && h.compression != Compression_FLATE {
+		return klineBinaryHeader{}, fmt.Errorf("compression %d: %w", h.compression, ErrBadFormat)
+	}
+	return h, nil
+}
+
+func putKLineRow(b []byte, l *klines.KLine) {
+	binary.LittleEndian.PutUint64(b[0:], uint64(l.OpenTime.UnixMilli()))
+	binary.LittleEndian.PutUint64(b[8:], uint64(l.CloseTime.UnixMilli()))
+	for idx, f := range []float64{l.OpenPrice, l.ClosePrice, l.HighPrice, l.LowPrice, l.Volume, l.QuoteAssetVolume, l.TradeNum} {
+		binary.LittleEndian.PutUint64(b[16+8*idx:], math.Float64bits(f))
+	}
+	var flags uint64
+	if l.Synthetic {
+		flags |= klineBinaryFlagSynthetic
+	}
+	binary.LittleEndian.PutUint64(b[72:], flags)
+}
+
+func encodeKLineRows(bars []klines.KLine) []byte {
+	b := make([]byte, len(bars)*klineBinaryRowSize)
+	for idx := range bars {
+		putKLineRow(b[idx*klineBinaryRowSize:], &bars[idx])
+	}
+	return b
+}
+
+// KLineView gives access to the rows of a binary KLine file without decoding
+// them upfront. It must be closed, and stays valid while the series is appended to.
+type KLineView struct {
+	rows    []byte
+	release func() error
+}
+
+func (v *KLineView) Len() int {
+	return len(v.rows) / klineBinaryRowSize
+}
+
+func (v *KLineView) row(idx int) []byte {
+	return v.rows[idx*klineBinaryRowSize : (idx+1)*klineBinaryRowSize]
+}
+
+func (v *KLineView) OpenTime(idx int) time.Time {
+	return time.UnixMilli(int64(binary.LittleEndian.Uint64(v.row(idx))))
+}
+
+func (v *KLineView) At(idx int) klines.KLine {
+	b := v.row(idx)
+	f := func(i int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b[16+8*i:])) }
+	return klines.KLine{
+		OpenTime:         time.UnixMilli(int64(binary.LittleEndian.Uint64(b[0:]))),
+		CloseTime:        time.UnixMilli(int64(binary.LittleEndian.Uint64(b[8:]))),
+		OpenPrice:        f(0),
+		ClosePrice:       f(1),
+		HighPrice:        f(2),
+		LowPrice:         f(3),
+		Volume:           f(4),
+		QuoteAssetVolume: f(5),
+		TradeNum:         f(6),
+		Synthetic:        binary.LittleEndian.Uint64(b[72:])&klineBinaryFlagSynthetic != 0,
+	}
+}
+
+// Search returns the index of the first row opening at or after t.
+func (v *KLineView) Search(t time.Time) int {
+	return sort.Search(v.Len(), func(idx int) bool { return !v.OpenTime(idx).Before(t) })
+}
+
+// Slice decodes the rows opening within [from, to], a zero time leaving that side
+// unbounded.
+func (v *KLineView) Slice(from, to time.Time) []klines.KLine {
+	lo, hi := 0, v.Len()
+	if !from.IsZero() {
+		lo = v.Search(from)
+	}
+	if !to.IsZero() {
+		hi = v.Search(to.Add(time.Millisecond))
+	}
+	if lo >= hi {
+		return nil
+	}
+	res := make([]klines.KLine, 0, hi-lo)
+	for idx := lo; idx < hi; idx++ {
+		res = append(res, v.At(idx))
+	}
+	return res
+}
+
+func (v *KLineView) Close() error {
+	if v.release == nil {
+		return nil
+	}
+	release := v.release
+	v.rows, v.release = nil, nil
+	return release()
+}
+
+// BinaryStore is a KLineStore keeping each series in the binary file at
+// KLineBinaryPath under root.
+type BinaryStore struct {
+	root        string
+	compression Compression // Of the files it creates.
+	mu          sync.RWMutex
+}
+
+var _ KLineStore = (*BinaryStore)(nil)
+
+func NewBinaryStore(root string, compression Compression) *BinaryStore {
+	return &BinaryStore{root: root, compression: compression}
+}
+
+func (s *BinaryStore) Root() string {
+	return s.root
+}
+
+// OpenView opens the rows of a series, which has none if it was never stored.
+func (s *BinaryStore) OpenView(symbol string, interval common.ListKLinesInterval) (*KLineView, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, _, err := s.openView(symbol, interval)
+	return v, err
+}
+
+func (s *BinaryStore) openView(symbol string, interval common.ListKLinesInterval) (*KLineView, *klineBinaryHeader, error) {
+	path := KLineBinaryPath(s.root, symbol, interval)
+	fp, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &KLineView{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("stat file: %w", err)
+	}
+	b := make([]byte, klineBinaryHeaderSize)
+	if _, err := fp.ReadAt(b, 0); err != nil {
+		return nil, nil, fmt.Errorf("read header of %q: %w", path, ErrBadFormat)
+	}
+	h, err := decodeKLineBinaryHeader(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: %w", path, err)
+	}
+	if h.symbol != symbol || h.interval != interval {
+		return nil, nil, fmt.Errorf("%q holds %s %s: %w", path, h.symbol, h.interval, ErrBadFormat)
+	}
+
+	var rows []byte
+	var release func() error
+	switch {
+	case h.compression == Compression_FLATE:
+		zr := flate.NewReader(io.NewSectionReader(fp, klineBinaryHeaderSize, info.Size()-klineBinaryHeaderSize))
+		defer zr.Close()
+		if rows, err = io.ReadAll(zr); err != nil {
+			return nil, nil, fmt.Errorf("decompress %q: %w", path, err)
+		}
+	case info.Size() > klineBinaryHeaderSize:
+		data, unmap, err := mapFile(fp, info.Size())
+		if err != nil {
+			return nil, nil, fmt.Errorf("mapFile(%q): %w", path, err)
+		}
+		rows, release = data[klineBinaryHeaderSize:], unmap
+	}
+	// A torn write may leave a partial last row.
+	rows = rows[:len(rows)/klineBinaryRowSize*klineBinaryRowSize]
+	return &KLineView{rows: rows, release: release}, &h, nil
+}
+
+// writeKLineBinary writes a whole file to a temporary path first, so views of the
+// replaced file stay valid and a crash never leaves a partial header.
+func writeKLineBinary(path string, h *klineBinaryHeader, rows ...[]byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	tmpPath := path + "_tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer fp.Close()
+	if _, err := fp.Write(h.encode()); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	var w io.Writer = fp
+	var zw *flate.Writer
+	if h.compression == Compression_FLATE {
+		if zw, err = flate.NewWriter(fp, flate.DefaultCompression); err != nil {
+			return fmt.Errorf("flate.NewWriter: %w", err)
+		}
+		w = zw
+	}
+	for _, r := range rows {
+		if _, err := w.Write(r); err != nil {
+			return fmt.Errorf("write rows: %w", err)
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("close flate stream: %w", err)
+		}
+	}
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+// Append appends the rows in place when nothing is replaced and the file is not
+// compressed, and rewrites the file otherwise.
+func (s *BinaryStore) Append(symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	if len(bars) == 0 {
+		return nil
+	}
+	if err := checkOrder(bars); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := KLineBinaryPath(s.root, symbol, interval)
+	v, h, err := s.openView(symbol, interval)
+	if err != nil {
+		return err
+	}
+	defer v.Close()
+	rows := encodeKLineRows(bars)
+	if h == nil {
+		h = &klineBinaryHeader{symbol: symbol, interval: interval, compression: s.compression}
+		return writeKLineBinary(path, h, rows)
+	}
+	keep := v.Search(bars[0].OpenTime)
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+	aligned := info.Size() == klineBinaryHeaderSize+int64(len(v.rows))
+	if h.compression != Compression_NONE || keep < v.Len() || !aligned {
+		return writeKLineBinary(path, h, v.rows[:keep*klineBinaryRowSize], rows)
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	if _, err := fp.Write(rows); err != nil {
+		return fmt.Errorf("write rows: %w", err)
+	}
+	return fp.Close()
+}
+
+func (s *BinaryStore) Range(symbol string, interval common.ListKLinesInterval, from, to time.Time) ([]klines.KLine, error) {
+	v, err := s.OpenView(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer v.Close()
+	return v.Slice(from, to), nil
+}
+
+func (s *BinaryStore) Last(symbol string, interval common.ListKLinesInterval) (klines.KLine, bool, error) {
+	v, err := s.OpenView(symbol, interval)
+	if err != nil {
+		return klines.KLine{}, false, err
+	}
+	defer v.Close()
+	if v.Len() == 0 {
+		return klines.KLine{}, false, nil
+	}
+	return v.At(v.Len() - 1), true, nil
+}
+
+func (s *BinaryStore) Stats(symbol string, interval common.ListKLinesInterval) (SeriesStats, error) {
+	v, err := s.OpenView(symbol, interval)
+	if err != nil {
+		return SeriesStats{}, err
+	}
+	defer v.Close()
+	var st SeriesStats
+	if st.Count = v.Len(); st.Count > 0 {
+		st.First, st.Last = v.OpenTime(0), v.OpenTime(st.Count-1)
+	}
+	if info, err := os.Stat(KLineBinaryPath(s.root, symbol, interval)); err == nil {
+		st.SizeBytes = info.Size()
+	}
+	st.Missing = missingBars(st.First, st.Last, st.Count, interval)
+	return st, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// TestBinaryStoreRecovery appends to binary files left with a partial last row by
+// a torn write.
+func TestBinaryStoreRecovery(t *testing.T) {
+	bars := testBars(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 8)
+	tests := []struct {
+		name   string
+		torn   int // Bytes of a partial row after the stored bars.
+		append []klines.KLine
+		want   []klines.KLine
+	}{
+		{name: "aligned", append: bars[3:5], want: bars[:5]},
+		{name: "partial row", torn: 30, append: bars[3:5], want: bars[:5]},
+		{name: "partial row replaced", torn: klineBinaryRowSize - 1, append: bars[1:2], want: bars[:2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewBinaryStore(t.TempDir(), Compression_NONE)
+			if err := s.Append("BTCUSDT", common.ListKLinesInterval_1h, bars[:3]); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			path := KLineBinaryPath(s.Root(), "BTCUSDT", common.ListKLinesInterval_1h)
+			fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fp.Write(encodeKLineRows(bars[3:4])[:tt.torn])
+			if err := fp.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			last, ok, err := s.Last("BTCUSDT", common.ListKLinesInterval_1h)
+			if err != nil || !ok || !sameKLines([]klines.KLine{last}, bars[2:3]) {
+				t.Errorf("Last = %+v, %v, %v, want %+v", last, ok, err, bars[2])
+			}
+			if err := s.Append("BTCUSDT", common.ListKLinesInterval_1h, tt.append); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			got, err := s.Range("BTCUSDT", common.ListKLinesInterval_1h, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatalf("Range: %v", err)
+			}
+			if !sameKLines(got, tt.want) {
+				t.Errorf("stored %v\nwant %v", got, tt.want)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(klineBinaryHeaderSize + len(tt.want)*klineBinaryRowSize); info.Size() != want {
+				t.Errorf("file of %d bytes, want %d", info.Size(), want)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"encoding/csv"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
 	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
 )
 
@@ -19,9 +24,14 @@ var (
 		"Volume",
 		"QuoteAssetVolume",
 		"TradeNum",
+		"Synthetic",
 	}
 )
 
+// legacyKLineCSVColumns is the number of columns of the CSVs written before the
+// Synthetic column was added, which are still read with every bar real.
+const legacyKLineCSVColumns = 9
+
 func timeToCSVRepr(t time.Time) string {
 	return strconv.FormatInt(t.UnixMilli(), 10)
 }
@@ -46,6 +56,23 @@ func floatFromCSVRepr(repr string) (float64, error) {
 	return f, nil
 }
 
+func boolToCSVRepr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func boolFromCSVRepr(repr string) (bool, error) {
+	switch repr {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("want 0 or 1")
+}
+
 func KLineToCSVRecord(l *klines.KLine) []string {
 	return []string{
 		timeToCSVRepr(l.OpenTime),
@@ -57,11 +84,12 @@ func KLineToCSVRecord(l *klines.KLine) []string {
 		floatToCSVRepr(l.Volume),
 		floatToCSVRepr(l.QuoteAssetVolume),
 		floatToCSVRepr(l.TradeNum),
+		boolToCSVRepr(l.Synthetic),
 	}
 }
 
 func KLineFromCSVRecord(record []string, dst *klines.KLine) error {
-	if len(record) != len(KLineCSVHeader) {
+	if len(record) != len(KLineCSVHeader) && len(record) != legacyKLineCSVColumns {
 		return fmt.Errorf("expect %d column but get %d", len(KLineCSVHeader), len(record))
 	}
 	var err error
@@ -92,5 +120,59 @@ func KLineFromCSVRecord(record []string, dst *klines.KLine) error {
 	if dst.TradeNum, err = floatFromCSVRepr(record[8]); err != nil {
 		return fmt.Errorf("parse trade num column %q: %w", record[8], err)
 	}
+	dst.Synthetic = false
+	if len(record) > legacyKLineCSVColumns {
+		if dst.Synthetic, err = boolFromCSVRepr(record[9]); err != nil {
+			return fmt.Errorf("parse synthetic column %q: %w", record[9], err)
+		}
+	}
 	return nil
 }
+
+// KLineCSVPath returns the path of the CSV storing symbol's KLines of the interval
+// under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_<interval>.csv".
+func KLineCSVPath(root, symbol string, interval common.ListKLinesInterval) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_%s.csv", symbol, interval))
+}
+
+// ReadKLinesCSV reads all KLines from a CSV whose first line is KLineCSVHeader,
+// or the header of a legacy CSV without the Synthetic column.
+func ReadKLinesCSV(r io.Reader) ([]klines.KLine, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if len(header) != len(KLineCSVHeader) && len(header) != legacyKLineCSVColumns {
+		return nil, fmt.Errorf("header %v does not match %v", header, KLineCSVHeader)
+	}
+	var lines []klines.KLine
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read one CSV record: %w", err)
+		}
+		var l klines.KLine
+		if err := KLineFromCSVRecord(record, &l); err != nil {
+			return nil, fmt.Errorf("KLineFromCSVRecord(%v): %w", record, err)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// LoadKLinesCSV reads all KLines from the CSV file at path.
+func LoadKLinesCSV(path string) ([]klines.KLine, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	return ReadKLinesCSV(fp)
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// CSVStore is a KLineStore keeping each series in the CSV at KLineCSVPath under
+// root, e.g. "price_data/XRPUSDT/XRPUSDT_1h.csv".
+type CSVStore struct {
+	root string
+	mu   sync.RWMutex
+}
+
+var _ KLineStore = (*CSVStore)(nil)
+
+func NewCSVStore(root string) *CSVStore {
+	return &CSVStore{root: root}
+}
+
+func (s *CSVStore) Root() string {
+	return s.root
+}
+
+// createKLineCSV writes the header and bars to a temporary file first so the CSV
+// never exists without the header.
+func createKLineCSV(path string, bars []klines.KLine) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	tmpPath := path + "_tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	w := csv.NewWriter(fp)
+	if err := w.Write(KLineCSVHeader); err != nil {
+		fp.Close()
+		return fmt.Errorf("write header: %w", err)
+	}
+	for idx := range bars {
+		if err := w.Write(KLineToCSVRecord(&bars[idx])); err != nil {
+			fp.Close()
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fp.Close()
+		return fmt.Errorf("flush records: %w", err)
+	}
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+// scanBack visits the records of a CSV from the last one backwards together
+// with the offset each starts at, until visit returns false or the header is
+// reached. An incomplete last line, as left by a torn write, is visited with a
+// nil record. Only the tail is read when visit stops early.
+func scanBack(f *os.File, visit func(record []string, offset int64) (bool, error)) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+	size := info.Size()
+	pos := size // Everything from pos on has been visited.
+	for chunk := int64(64 << 10); pos > 0; chunk *= 2 {
+		start := max(pos-chunk, 0)
+		buf := make([]byte, pos-start)
+		if _, err := f.ReadAt(buf, start); err != nil {
+			return fmt.Errorf("read file: %w", err)
+		}
+		cut := 0
+		if start > 0 {
+			// The first line may begin before start; read it with a bigger chunk.
+			idx := bytes.IndexByte(buf, '\n')
+			if idx < 0 {
+				continue
+			}
+			cut = idx + 1
+		}
+		lines := bytes.SplitAfter(buf[cut:], []byte("\n"))
+		end := pos
+		for idx := len(lines) - 1; idx >= 0; idx-- {
+			line := lines[idx]
+			if len(line) == 0 {
+				continue
+			}
+			offset := end - int64(len(line))
+			end = offset
+			if offset == 0 {
+				return nil // The header.
+			}
+			var record []string
+			if line[len(line)-1] == '\n' {
+				if record, err = csv.NewReader(bytes.NewReader(line)).Read(); err != nil {
+					return fmt.Errorf("parse record at offset %d: %w", offset, err)
+				}
+			} else if offset+int64(len(line)) != size {
+				return fmt.Errorf("record at offset %d has no line end", offset)
+			}
+			if ok, err := visit(record, offset); err != nil || !ok {
+				return err
+			}
+		}
+		pos = start + int64(cut)
+	}
+	return nil
+}
+
+func (s *CSVStore) Append(symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	if len(bars) == 0 {
+		return nil
+	}
+	if err := checkOrder(bars); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := KLineCSVPath(s.root, symbol, interval)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := createKLineCSV(path, nil); err != nil {
+			return fmt.Errorf("createKLineCSV(%q): %w", path, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+	fp, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	header, err := csv.NewReader(fp).Read()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+
+	// Cut off the bars being replaced and a torn last line.
+	var cut int64 = -1
+	var l klines.KLine
+	if err := scanBack(fp, func(record []string, offset int64) (bool, error) {
+		if record != nil {
+			if err := KLineFromCSVRecord(record, &l); err != nil {
+				return false, fmt.Errorf("KLineFromCSVRecord(%v): %w", record, err)
+			}
+			if l.OpenTime.Before(bars[0].OpenTime) {
+				return false, nil
+			}
+		}
+		cut = offset
+		return true, nil
+	}); err != nil {
+		return fmt.Errorf("scan %q: %w", path, err)
+	}
+	if cut >= 0 {
+		if err := fp.Truncate(cut); err != nil {
+			return fmt.Errorf("Truncate(%d): %w", cut, err)
+		}
+	}
+	if len(header) == legacyKLineCSVColumns {
+		// Rewrite the stored bars with the Synthetic column too, so every line
+		// matches the header.
+		stored, err := ReadKLinesCSV(io.NewSectionReader(fp, 0, math.MaxInt64))
+		if err != nil {
+			return fmt.Errorf("ReadKLinesCSV(%q): %w", path, err)
+		}
+		if err := createKLineCSV(path, append(stored, bars...)); err != nil {
+			return fmt.Errorf("createKLineCSV(%q): %w", path, err)
+		}
+		return nil
+	}
+	if _, err := fp.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek file end: %w", err)
+	}
+	// Write the bars at once so a crash tears the last line at most, which the
+	// next Append cuts off.
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for idx := range bars {
+		if err := w.Write(KLineToCSVRecord(&bars[idx])); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("flush records: %w", err)
+	}
+	if _, err := fp.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write records: %w", err)
+	}
+	if err := fp.Sync(); err != nil {
+		return fmt.Errorf("sync file: %w", err)
+	}
+	return nil
+}
+
+// open returns nil for a series never stored.
+func (s *CSVStore) open(symbol string, interval common.ListKLinesInterval) (*os.File, error) {
+	fp, err := os.Open(KLineCSVPath(s.root, symbol, interval))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	return fp, nil
+}
+
+// each calls visit with the stored bars in order until it returns false.
+func (s *CSVStore) each(symbol string, interval common.ListKLinesInterval, visit func(l *klines.KLine) bool) error {
+	fp, err := s.open(symbol, interval)
+	if fp == nil {
+		return err
+	}
+	defer fp.Close()
+	cr := csv.NewReader(fp)
+	cr.ReuseRecord = true
+	if _, err := cr.Read(); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	var l klines.KLine
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read one CSV record: %w", err)
+		}
+		if err := KLineFromCSVRecord(record, &l); err != nil {
+			return fmt.Errorf("KLineFromCSVRecord(%v): %w", record, err)
+		}
+		if !visit(&l) {
+			return nil
+		}
+	}
+}
+
+func (s *CSVStore) Range(symbol string, interval common.ListKLinesInterval, from, to time.Time) ([]klines.KLine, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []klines.KLine
+	err := s.each(symbol, interval, func(l *klines.KLine) bool {
+		if !to.IsZero() && l.OpenTime.After(to) {
+			return false
+		}
+		if from.IsZero() || !l.OpenTime.Before(from) {
+			res = append(res, *l)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *CSVStore) Last(symbol string, interval common.ListKLinesInterval) (klines.KLine, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fp, err := s.open(symbol, interval)
+	if fp == nil {
+		return klines.KLine{}, false, err
+	}
+	defer fp.Close()
+	var l klines.KLine
+	found := false
+	err = scanBack(fp, func(record []string, offset int64) (bool, error) {
+		if record == nil {
+			return true, nil // Torn write.
+		}
+		if err := KLineFromCSVRecord(record, &l); err != nil {
+			return false, fmt.Errorf("KLineFromCSVRecord(%v): %w", record, err)
+		}
+		found = true
+		return false, nil
+	})
+	if err != nil {
+		return klines.KLine{}, false, err
+	}
+	return l, found, nil
+}
+
+func (s *CSVStore) Stats(symbol string, interval common.ListKLinesInterval) (SeriesStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var st SeriesStats
+	err := s.each(symbol, interval, func(l *klines.KLine) bool {
+		if st.Count == 0 {
+			st.First = l.OpenTime
+		}
+		st.Last = l.OpenTime
+		st.Count++
+		return true
+	})
+	if err != nil {
+		return SeriesStats{}, err
+	}
+	if info, err := os.Stat(KLineCSVPath(s.root, symbol, interval)); err == nil {
+		st.SizeBytes = info.Size()
+	}
+	st.Missing = missingBars(st.First, st.Last, st.Count, interval)
+	return st, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// TestCSVStoreRecovery appends to CSVs left behind by a torn write or written
+// before the Synthetic column.
+func TestCSVStoreRecovery(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bars := testBars(t0, 8)
+	legacy := func(bars []klines.KLine) []klines.KLine {
+		res := make([]klines.KLine, len(bars))
+		for idx, l := range bars {
+			l.Synthetic = false
+			res[idx] = l
+		}
+		return res
+	}
+	line := func(l klines.KLine) string { return strings.Join(KLineToCSVRecord(&l), ",") + "\n" }
+	legacyLine := func(l klines.KLine) string {
+		return strings.Join(KLineToCSVRecord(&l)[:legacyKLineCSVColumns], ",") + "\n"
+	}
+	header := strings.Join(KLineCSVHeader, ",") + "\n"
+	torn := line(bars[5])[:20]
+	tests := []struct {
+		name     string
+		content  string
+		wantLast klines.KLine
+		append   []klines.KLine
+		want     []klines.KLine
+	}{
+		{
+			name:     "torn last line",
+			content:  header + line(bars[0]) + line(bars[1]) + line(bars[2]) + torn,
+			wantLast: bars[2],
+			append:   bars[3:5],
+			want:     bars[:5],
+		},
+		{
+			name:     "torn last line replaced",
+			content:  header + line(bars[0]) + line(bars[1]) + line(bars[2]) + torn,
+			wantLast: bars[2],
+			append:   bars[1:3],
+			want:     bars[:3],
+		},
+		{
+			name:     "legacy columns",
+			content:  strings.Join(KLineCSVHeader[:legacyKLineCSVColumns], ",") + "\n" + legacyLine(bars[0]) + legacyLine(bars[1]) + legacyLine(bars[2]) + legacyLine(bars[3]),
+			wantLast: legacy(bars[3:4])[0],
+			append:   bars[3:6],
+			want:     append(legacy(bars[:3]), bars[3:6]...),
+		},
+		{
+			name:     "legacy columns with a torn last line",
+			content:  strings.Join(KLineCSVHeader[:legacyKLineCSVColumns], ",") + "\n" + legacyLine(bars[0]) + legacyLine(bars[1])[:15],
+			wantLast: legacy(bars[0:1])[0],
+			append:   bars[1:3],
+			want:     append(legacy(bars[:1]), bars[1:3]...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewCSVStore(t.TempDir())
+			path := KLineCSVPath(s.Root(), "BTCUSDT", common.ListKLinesInterval_1h)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			last, ok, err := s.Last("BTCUSDT", common.ListKLinesInterval_1h)
+			if err != nil || !ok || !sameKLines([]klines.KLine{last}, []klines.KLine{tt.wantLast}) {
+				t.Errorf("Last = %+v, %v, %v, want %+v", last, ok, err, tt.wantLast)
+			}
+			if err := s.Append("BTCUSDT", common.ListKLinesInterval_1h, tt.append); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			got, err := LoadKLinesCSV(path)
+			if err != nil {
+				t.Fatalf("LoadKLinesCSV: %v", err)
+			}
+			if !sameKLines(got, tt.want) {
+				t.Errorf("stored %v\nwant %v", got, tt.want)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(b), header) {
+				t.Errorf("file starts with %q, want the header %q", strings.SplitN(string(b), "\n", 2)[0], header)
+			}
+			rep, err := VerifyKLineCSV(path, "BTCUSDT", common.ListKLinesInterval_1h)
+			if err != nil {
+				t.Fatalf("VerifyKLineCSV: %v", err)
+			}
+			if rep.Failed(true) {
+				t.Errorf("VerifyKLineCSV: %+v", rep)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+var (
+	KLineGapCSVHeader = []string{
+		"FromOpenTime",
+		"ToOpenTime",
+		"Bars",
+	}
+)
+
+// KLineGap is a run of consecutive synthetic bars, i.e. a stretch the exchange
+// returned no KLines for.
+type KLineGap struct {
+	From time.Time // Open time of the first synthetic bar.
+	To   time.Time // Open time of the last synthetic bar.
+	Bars int
+}
+
+// Contains reports whether t falls in a bar of the gap.
+func (g *KLineGap) Contains(t time.Time, interval common.ListKLinesInterval) bool {
+	return !t.Before(g.From) && t.Before(common.NextOpenTime(interval, g.To))
+}
+
+// KLineGapsPath returns the path of the sidecar CSV listing the gaps of symbol's
+// KLines of the interval under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_<interval>_gaps.csv".
+func KLineGapsPath(root, symbol string, interval common.ListKLinesInterval) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_%s_gaps.csv", symbol, interval))
+}
+
+// FindKLineGaps returns the runs of synthetic bars in bars, which are in order.
+func FindKLineGaps(bars []klines.KLine) []KLineGap {
+	var gaps []KLineGap
+	for idx := range bars {
+		l := &bars[idx]
+		if !l.Synthetic {
+			continue
+		}
+		if idx > 0 && bars[idx-1].Synthetic {
+			g := &gaps[len(gaps)-1]
+			g.To = l.OpenTime
+			g.Bars++
+			continue
+		}
+		gaps = append(gaps, KLineGap{From: l.OpenTime, To: l.OpenTime, Bars: 1})
+	}
+	return gaps
+}
+
+// WriteKLineGaps replaces the sidecar of the series with gaps, through a
+// temporary file so readers never see it partially written.
+func WriteKLineGaps(root, symbol string, interval common.ListKLinesInterval, gaps []KLineGap) error {
+	path := KLineGapsPath(root, symbol, interval)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	tmpPath := path + "_tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer fp.Close()
+	w := csv.NewWriter(fp)
+	if err := w.Write(KLineGapCSVHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, g := range gaps {
+		if err := w.Write([]string{
+			timeToCSVRepr(g.From), timeToCSVRepr(g.To), strconv.Itoa(g.Bars),
+		}); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("flush records: %w", err)
+	}
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+// LoadKLineGaps reads the sidecar of the series, which has no gaps if there is
+// none.
+func LoadKLineGaps(root, symbol string, interval common.ListKLinesInterval) ([]KLineGap, error) {
+	fp, err := os.Open(KLineGapsPath(root, symbol, interval))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	cr := csv.NewReader(fp)
+	if _, err := cr.Read(); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	var gaps []KLineGap
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return gaps, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read one CSV record: %w", err)
+		}
+		if len(record) != len(KLineGapCSVHeader) {
+			return nil, fmt.Errorf("expect %d column but get %d", len(KLineGapCSVHeader), len(record))
+		}
+		var g KLineGap
+		if g.From, err = timeFromCSVRepr(record[0]); err != nil {
+			return nil, fmt.Errorf("parse from column %q: %w", record[0], err)
+		}
+		if g.To, err = timeFromCSVRepr(record[1]); err != nil {
+			return nil, fmt.Errorf("parse to column %q: %w", record[1], err)
+		}
+		if g.Bars, err = strconv.Atoi(record[2]); err != nil {
+			return nil, fmt.Errorf("parse bars column %q: %w", record[2], err)
+		}
+		gaps = append(gaps, g)
+	}
+}
+
+// UpdateKLineGaps updates the sidecar of the series after bars, in order, were
+// appended to it: the gaps from bars[0] on are replaced by those of bars, one
+// ending right before bars[0] running on into them. Only the sidecar is read, and
+// it is only written when it changes.
+func UpdateKLineGaps(root, symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	if len(bars) == 0 {
+		return nil
+	}
+	stored, err := LoadKLineGaps(root, symbol, interval)
+	if err != nil {
+		return fmt.Errorf("LoadKLineGaps: %w", err)
+	}
+	from := bars[0].OpenTime
+	gaps := slices.Clone(stored)
+	for len(gaps) > 0 && !gaps[len(gaps)-1].From.Before(from) {
+		gaps = gaps[:len(gaps)-1]
+	}
+	if n := len(gaps); n > 0 && !gaps[n-1].To.Before(from) {
+		g := &gaps[n-1]
+		g.To = common.AddBars(interval, from, -1)
+		g.Bars = common.BarsBetween(interval, g.From, g.To)
+	}
+	added := FindKLineGaps(bars)
+	if n := len(gaps); n > 0 && len(added) > 0 && added[0].From.Equal(from) &&
+		common.NextOpenTime(interval, gaps[n-1].To).Equal(from) {
+		gaps[n-1].To = added[0].To
+		gaps[n-1].Bars += added[0].Bars
+		added = added[1:]
+	}
+	gaps = append(gaps, added...)
+	if slices.EqualFunc(gaps, stored, func(a, b KLineGap) bool {
+		return a.From.Equal(b.From) && a.To.Equal(b.To) && a.Bars == b.Bars
+	}) {
+		return nil
+	}
+	return WriteKLineGaps(root, symbol, interval, gaps)
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+func TestUpdateKLineGaps(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// bars returns the hourly bars from hour from on, "s" marking a synthetic one.
+	bars := func(from int, kinds string) []klines.KLine {
+		var res []klines.KLine
+		for idx, k := range kinds {
+			l := testBar(t0.Add(time.Duration(from+idx) * time.Hour))
+			l.Synthetic = k == 's'
+			res = append(res, l)
+		}
+		return res
+	}
+	format := func(gaps []KLineGap) string {
+		var s string
+		for _, g := range gaps {
+			s += fmt.Sprintf("[%d-%d %d]", int(g.From.Sub(t0).Hours()), int(g.To.Sub(t0).Hours()), g.Bars)
+		}
+		return s
+	}
+	tests := []struct {
+		name      string
+		stored    []klines.KLine // Appended first, or none.
+		appended  []klines.KLine
+		want      string
+		unwritten bool // Whether the sidecar is left as is.
+	}{
+		{name: "no gap", appended: bars(0, "rrr"), unwritten: true},
+		{name: "new gaps", appended: bars(0, "rssrs"), want: "[1-2 2][4-4 1]"},
+		{name: "after a gap", stored: bars(0, "rsr"), appended: bars(3, "rrsr"), want: "[1-1 1][5-5 1]"},
+		{name: "gap runs on", stored: bars(0, "rss"), appended: bars(3, "srr"), want: "[1-3 3]"},
+		{name: "nothing new", stored: bars(0, "rsr"), appended: bars(3, "rr"), want: "[1-1 1]", unwritten: true},
+		{name: "rebuilt gap filled", stored: bars(0, "rrss"), appended: bars(3, "rr"), want: "[2-2 1]"},
+		{name: "rebuilt gap dropped", stored: bars(0, "rrrs"), appended: bars(3, "r"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			path := KLineGapsPath(root, "BTCUSDT", common.ListKLinesInterval_1h)
+			if tt.stored != nil {
+				if err := UpdateKLineGaps(root, "BTCUSDT", common.ListKLinesInterval_1h, tt.stored); err != nil {
+					t.Fatalf("UpdateKLineGaps(stored): %v", err)
+				}
+			}
+			before, _ := os.Stat(path)
+			if err := UpdateKLineGaps(root, "BTCUSDT", common.ListKLinesInterval_1h, tt.appended); err != nil {
+				t.Fatalf("UpdateKLineGaps: %v", err)
+			}
+			gaps, err := LoadKLineGaps(root, "BTCUSDT", common.ListKLinesInterval_1h)
+			if err != nil {
+				t.Fatalf("LoadKLineGaps: %v", err)
+			}
+			if got := format(gaps); got != tt.want {
+				t.Errorf("gaps %s, want %s", got, tt.want)
+			}
+			after, _ := os.Stat(path)
+			if unwritten := (before == nil && after == nil) || (before != nil && after != nil && os.SameFile(before, after)); unwritten != tt.unwritten {
+				t.Errorf("sidecar left as is %v, want %v", unwritten, tt.unwritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,621 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// KLines are written to Parquet as one flat row per KLine with the columns of
+// KLineCSVHeader: the times as INT64 timestamps in UTC milliseconds, Synthetic as
+// BOOLEAN and the rest as DOUBLE, PLAIN encoded in GZIP compressed pages. Files
+// without the Synthetic column are read with every bar real. The reader also takes
+// OPTIONAL columns without nulls, microsecond or nanosecond timestamps and
+// uncompressed pages, so pandas files load only when written with
+// to_parquet(compression=None or "gzip", use_dictionary=False): its default
+// snappy codec and dictionary encoding are not supported.
+
+var (
+	ErrBadParquet         = errors.New("bad parquet file")
+	ErrUnsupportedParquet = errors.New("unsupported parquet feature")
+)
+
+const (
+	parquetMagic        = "PAR1"
+	parquetRowGroupSize = 1 << 17
+
+	// Enum values of the Parquet format.
+	parquetTypeBoolean      = 0
+	parquetTypeInt64        = 2
+	parquetTypeDouble       = 5
+	parquetRequired         = 0
+	parquetOptional         = 1
+	parquetEncodingPlain    = 0
+	parquetEncodingRLE      = 3
+	parquetCodecNone        = 0
+	parquetCodecGzip        = 2
+	parquetPageData         = 0
+	parquetTimestampMillis  = 9 // Converted type.
+	parquetTimestampMicros  = 10
+	parquetKLineTimeColumns = 2 // OpenTime and CloseTime come first.
+	parquetKLineFlagColumn  = 9 // Synthetic comes last.
+)
+
+// ParquetMeta is the key value metadata of a KLine Parquet file.
+type ParquetMeta struct {
+	Symbol   string
+	Interval common.ListKLinesInterval
+	Source   string // Where the KLines came from, e.g. klines.ListKLinesEndpoint.
+}
+
+// KLineParquetPath returns the path of the Parquet file storing symbol's KLines
+// of the interval under root, i.e. "<root>/<SYMBOL>/<SYMBOL>_<interval>.parquet".
+func KLineParquetPath(root, symbol string, interval common.ListKLinesInterval) string {
+	return filepath.Join(root, symbol, fmt.Sprintf("%s_%s.parquet", symbol, interval))
+}
+
+// klineColumn returns the value of a column as the 8 bytes Parquet stores.
+func klineColumn(l *klines.KLine, col int) uint64 {
+	switch col {
+	case 0:
+		return uint64(l.OpenTime.UnixMilli())
+	case 1:
+		return uint64(l.CloseTime.UnixMilli())
+	case 2:
+		return math.Float64bits(l.OpenPrice)
+	case 3:
+		return math.Float64bits(l.ClosePrice)
+	case 4:
+		return math.Float64bits(l.HighPrice)
+	case 5:
+		return math.Float64bits(l.LowPrice)
+	case 6:
+		return math.Float64bits(l.Volume)
+	case 7:
+		return math.Float64bits(l.QuoteAssetVolume)
+	case 8:
+		return math.Float64bits(l.TradeNum)
+	}
+	if l.Synthetic {
+		return 1
+	}
+	return 0
+}
+
+func setKLineColumn(l *klines.KLine, col int, v uint64) {
+	f := math.Float64frombits(v)
+	switch col {
+	case 0:
+		l.OpenTime = time.UnixMilli(int64(v))
+	case 1:
+		l.CloseTime = time.UnixMilli(int64(v))
+	case 2:
+		l.OpenPrice = f
+	case 3:
+		l.ClosePrice = f
+	case 4:
+		l.HighPrice = f
+	case 5:
+		l.LowPrice = f
+	case 6:
+		l.Volume = f
+	case 7:
+		l.QuoteAssetVolume = f
+	case 8:
+		l.TradeNum = f
+	default:
+		l.Synthetic = v != 0
+	}
+}
+
+func parquetColumnType(col int) int32 {
+	switch {
+	case col < parquetKLineTimeColumns:
+		return parquetTypeInt64
+	case col == parquetKLineFlagColumn:
+		return parquetTypeBoolean
+	}
+	return parquetTypeDouble
+}
+
+func parquetSchema() []any {
+	schema := []any{thriftStruct{{4, "schema"}, {5, int32(len(KLineCSVHeader))}}}
+	for col, name := range KLineCSVHeader {
+		if col < parquetKLineTimeColumns {
+			timestamp := thriftStruct{{1, true}, {2, thriftStruct{{1, thriftStruct{}}}}} // UTC, MILLIS.
+			schema = append(schema, thriftStruct{
+				{1, int32(parquetTypeInt64)}, {3, int32(parquetRequired)}, {4, name},
+				{6, int32(parquetTimestampMillis)}, {10, thriftStruct{{8, timestamp}}},
+			})
+			continue
+		}
+		schema = append(schema, thriftStruct{{1, parquetColumnType(col)}, {3, int32(parquetRequired)}, {4, name}})
+	}
+	return schema
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteKLinesParquet writes the bars as one Parquet file.
+func WriteKLinesParquet(w io.Writer, meta ParquetMeta, bars []klines.KLine) error {
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, parquetMagic); err != nil {
+		return fmt.Errorf("write magic: %w", err)
+	}
+	var rowGroups []any
+	for start := 0; start < len(bars); start += parquetRowGroupSize {
+		group := bars[start:min(start+parquetRowGroupSize, len(bars))]
+		var chunks []any
+		var groupSize int64
+		for col, name := range KLineCSVHeader {
+			var plain []byte
+			if col == parquetKLineFlagColumn {
+				plain = make([]byte, (len(group)+7)/8) // Bit packed, LSB first.
+				for idx := range group {
+					plain[idx/8] |= byte(klineColumn(&group[idx], col)) << (idx % 8)
+				}
+			} else {
+				plain = make([]byte, 8*len(group))
+				for idx := range group {
+					binary.LittleEndian.PutUint64(plain[8*idx:], klineColumn(&group[idx], col))
+				}
+			}
+			var zbuf bytes.Buffer
+			zw := gzip.NewWriter(&zbuf)
+			zw.Write(plain)
+			if err := zw.Close(); err != nil {
+				return fmt.Errorf("gzip column %s: %w", name, err)
+			}
+			header := appendThriftValue(nil, thriftStruct{
+				{1, int32(parquetPageData)}, {2, int32(len(plain))}, {3, int32(zbuf.Len())},
+				{5, thriftStruct{
+					{1, int32(len(group))}, {2, int32(parquetEncodingPlain)},
+					{3, int32(parquetEncodingRLE)}, {4, int32(parquetEncodingRLE)},
+				}},
+			})
+			offset := cw.n
+			if _, err := cw.Write(header); err != nil {
+				return fmt.Errorf("write page header: %w", err)
+			}
+			if _, err := cw.Write(zbuf.Bytes()); err != nil {
+				return fmt.Errorf("write page: %w", err)
+			}
+			typ := parquetColumnType(col)
+			uncompressed := int64(len(header) + len(plain))
+			groupSize += uncompressed
+			chunks = append(chunks, thriftStruct{
+				{2, offset},
+				{3, thriftStruct{
+					{1, typ},
+					{2, thriftList{thriftTypeI32, []any{int32(parquetEncodingPlain), int32(parquetEncodingRLE)}}},
+					{3, thriftList{thriftTypeBinary, []any{name}}},
+					{4, int32(parquetCodecGzip)},
+					{5, int64(len(group))},
+					{6, uncompressed},
+					{7, int64(len(header) + zbuf.Len())},
+					{9, offset},
+				}},
+			})
+		}
+		rowGroups = append(rowGroups, thriftStruct{
+			{1, thriftList{thriftTypeStruct, chunks}}, {2, groupSize}, {3, int64(len(group))},
+		})
+	}
+	var kv []any
+	for _, p := range [][2]string{{"symbol", meta.Symbol}, {"interval", string(meta.Interval)}, {"source", meta.Source}} {
+		kv = append(kv, thriftStruct{{1, p[0]}, {2, p[1]}})
+	}
+	footer := appendThriftValue(nil, thriftStruct{
+		{1, int32(1)},
+		{2, thriftList{thriftTypeStruct, parquetSchema()}},
+		{3, int64(len(bars))},
+		{4, thriftList{thriftTypeStruct, rowGroups}},
+		{5, thriftList{thriftTypeStruct, kv}},
+		{6, "BinanceTrader"},
+	})
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	if _, err := cw.Write(append(footer, parquetMagic...)); err != nil {
+		return fmt.Errorf("write footer: %w", err)
+	}
+	return nil
+}
+
+// parquetColumn is how a KLine column is stored in a file being read.
+type parquetColumn struct {
+	optional bool
+	scale    int64 // Divides timestamps into milliseconds.
+}
+
+func parquetColumns(schema []any) ([]parquetColumn, error) {
+	cols := make([]parquetColumn, len(KLineCSVHeader))
+	found := make([]bool, len(KLineCSVHeader))
+	for idx, item := range schema {
+		el, _ := item.(map[int16]any)
+		if idx == 0 {
+			continue // The root.
+		}
+		if n, _ := thriftInt(el, 5); n > 0 {
+			return nil, fmt.Errorf("nested column %q: %w", thriftString(el, 4), ErrUnsupportedParquet)
+		}
+		col := slices.Index(KLineCSVHeader, thriftString(el, 4))
+		if col < 0 {
+			continue
+		}
+		typ, _ := thriftInt(el, 1)
+		rep, _ := thriftInt(el, 3)
+		c := parquetColumn{optional: rep == parquetOptional, scale: 1}
+		if rep != parquetRequired && rep != parquetOptional {
+			return nil, fmt.Errorf("repeated column %s: %w", KLineCSVHeader[col], ErrUnsupportedParquet)
+		}
+		if col < parquetKLineTimeColumns {
+			if typ != parquetTypeInt64 {
+				return nil, fmt.Errorf("column %s of type %d, want INT64: %w", KLineCSVHeader[col], typ, ErrUnsupportedParquet)
+			}
+			if unit := thriftSub(thriftSub(thriftSub(el, 10), 8), 2); unit != nil {
+				switch {
+				case unit[2] != nil:
+					c.scale = 1000
+				case unit[3] != nil:
+					c.scale = 1000_000
+				}
+			} else if conv, _ := thriftInt(el, 6); conv == parquetTimestampMicros {
+				c.scale = 1000
+			}
+		} else if want := parquetColumnType(col); typ != int64(want) {
+			return nil, fmt.Errorf("column %s of type %d, want %d: %w", KLineCSVHeader[col], typ, want, ErrUnsupportedParquet)
+		}
+		cols[col], found[col] = c, true
+	}
+	for col, ok := range found {
+		if !ok && col != parquetKLineFlagColumn {
+			return nil, fmt.Errorf("no column %s: %w", KLineCSVHeader[col], ErrBadParquet)
+		}
+	}
+	return cols, nil
+}
+
+// countNulls decodes the definition levels of an OPTIONAL column, an RLE/bit
+// packed hybrid of width 1, and returns how many of the n values are null.
+func countNulls(levels []byte, n int) (int, error) {
+	nulls, seen := 0, 0
+	for pos := 0; seen < n; {
+		h, k := binary.Uvarint(levels[pos:])
+		if k <= 0 {
+			return 0, fmt.Errorf("bad definition levels: %w", ErrBadParquet)
+		}
+		pos += k
+		if h&1 == 0 { // A run of one value.
+			if pos >= len(levels) {
+				return 0, fmt.Errorf("bad definition levels: %w", ErrBadParquet)
+			}
+			run := min(int(h>>1), n-seen)
+			if levels[pos] == 0 {
+				nulls += run
+			}
+			seen += run
+			pos++
+			continue
+		}
+		groups := int(h >> 1) // Of 8 bit packed values.
+		if pos+groups > len(levels) {
+			return 0, fmt.Errorf("bad definition levels: %w", ErrBadParquet)
+		}
+		for _, b := range levels[pos : pos+groups] {
+			for bit := 0; bit < 8 && seen < n; bit++ {
+				if b>>bit&1 == 0 {
+					nulls++
+				}
+				seen++
+			}
+		}
+		pos += groups
+	}
+	return nulls, nil
+}
+
+// readParquetColumn reads the values of one column chunk into rows.
+func readParquetColumn(r io.ReaderAt, meta map[int16]any, c parquetColumn, col int, rows []klines.KLine) error {
+	offset, _ := thriftInt(meta, 9)
+	if dict, ok := thriftInt(meta, 11); ok && dict > 0 {
+		return fmt.Errorf("dictionary page: %w", ErrUnsupportedParquet)
+	}
+	size, _ := thriftInt(meta, 7)
+	codec, _ := thriftInt(meta, 4)
+	if codec != parquetCodecNone && codec != parquetCodecGzip {
+		return fmt.Errorf("codec %d: %w", codec, ErrUnsupportedParquet)
+	}
+	if offset < 0 || size < 0 || size > 1<<31 {
+		return fmt.Errorf("column chunk at %d of %d bytes: %w", offset, size, ErrBadParquet)
+	}
+	chunk := make([]byte, size)
+	if _, err := r.ReadAt(chunk, offset); err != nil {
+		return fmt.Errorf("read column chunk: %w", err)
+	}
+	done := 0
+	for done < len(rows) {
+		header, n, err := readThriftStruct(chunk)
+		if err != nil {
+			return fmt.Errorf("page header: %w: %w", ErrBadParquet, err)
+		}
+		chunk = chunk[n:]
+		if typ, _ := thriftInt(header, 1); typ != parquetPageData {
+			return fmt.Errorf("page type %d: %w", typ, ErrUnsupportedParquet)
+		}
+		pageSize, _ := thriftInt(header, 3)
+		if pageSize < 0 || pageSize > int64(len(chunk)) {
+			return fmt.Errorf("page of %d bytes past the chunk: %w", pageSize, ErrBadParquet)
+		}
+		page := chunk[:pageSize]
+		chunk = chunk[pageSize:]
+		if codec == parquetCodecGzip {
+			zr, err := gzip.NewReader(bytes.NewReader(page))
+			if err != nil {
+				return fmt.Errorf("gzip page: %w", err)
+			}
+			if page, err = io.ReadAll(zr); err != nil {
+				return fmt.Errorf("gunzip page: %w", err)
+			}
+		}
+		dataHeader := thriftSub(header, 5)
+		if enc, _ := thriftInt(dataHeader, 2); enc != parquetEncodingPlain {
+			return fmt.Errorf("encoding %d: %w", enc, ErrUnsupportedParquet)
+		}
+		num, _ := thriftInt(dataHeader, 1)
+		if num < 0 || num > int64(len(rows)-done) {
+			return fmt.Errorf("page of %d values, %d left: %w", num, len(rows)-done, ErrBadParquet)
+		}
+		if c.optional {
+			if len(page) < 4 {
+				return fmt.Errorf("no definition levels: %w", ErrBadParquet)
+			}
+			n := int(binary.LittleEndian.Uint32(page))
+			if n > len(page)-4 {
+				return fmt.Errorf("definition levels past the page: %w", ErrBadParquet)
+			}
+			nulls, err := countNulls(page[4:4+n], int(num))
+			if err != nil {
+				return err
+			}
+			if nulls > 0 {
+				return fmt.Errorf("%d nulls in column %s: %w", nulls, KLineCSVHeader[col], ErrBadParquet)
+			}
+			page = page[4+n:]
+		}
+		if col == parquetKLineFlagColumn {
+			if len(page) < (int(num)+7)/8 {
+				return fmt.Errorf("page of %d values has %d bytes: %w", num, len(page), ErrBadParquet)
+			}
+			for idx := range int(num) {
+				setKLineColumn(&rows[done+idx], col, uint64(page[idx/8]>>(idx%8)&1))
+			}
+			done += int(num)
+			continue
+		}
+		if len(page) < 8*int(num) {
+			return fmt.Errorf("page of %d values has %d bytes: %w", num, len(page), ErrBadParquet)
+		}
+		for idx := range int(num) {
+			v := binary.LittleEndian.Uint64(page[8*idx:])
+			if c.scale > 1 {
+				v = uint64(int64(v) / c.scale)
+			}
+			setKLineColumn(&rows[done+idx], col, v)
+		}
+		done += int(num)
+	}
+	return nil
+}
+
+// ReadKLinesParquet reads a KLine Parquet file of size bytes.
+func ReadKLinesParquet(r io.ReaderAt, size int64) (ParquetMeta, []klines.KLine, error) {
+	var meta ParquetMeta
+	tail := make([]byte, 8)
+	if size < 12 {
+		return meta, nil, fmt.Errorf("%d bytes: %w", size, ErrBadParquet)
+	}
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return meta, nil, fmt.Errorf("read footer length: %w", err)
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if string(tail[4:]) != parquetMagic || footerSize > size-12 {
+		return meta, nil, fmt.Errorf("no footer: %w", ErrBadParquet)
+	}
+	b := make([]byte, footerSize)
+	if _, err := r.ReadAt(b, size-8-footerSize); err != nil {
+		return meta, nil, fmt.Errorf("read footer: %w", err)
+	}
+	footer, _, err := readThriftStruct(b)
+	if err != nil {
+		return meta, nil, fmt.Errorf("footer: %w: %w", ErrBadParquet, err)
+	}
+	for _, item := range thriftItems(footer, 5) {
+		kv, _ := item.(map[int16]any)
+		switch v := thriftString(kv, 2); thriftString(kv, 1) {
+		case "symbol":
+			meta.Symbol = v
+		case "interval":
+			meta.Interval = common.ListKLinesInterval(v)
+		case "source":
+			meta.Source = v
+		}
+	}
+	cols, err := parquetColumns(thriftItems(footer, 2))
+	if err != nil {
+		return meta, nil, err
+	}
+	var bars []klines.KLine
+	for _, item := range thriftItems(footer, 4) {
+		group, _ := item.(map[int16]any)
+		num, _ := thriftInt(group, 3)
+		if num < 0 || num > size { // Values take at least a bit each.
+			return meta, nil, fmt.Errorf("row group of %d rows: %w", num, ErrBadParquet)
+		}
+		rows := make([]klines.KLine, num)
+		for _, item := range thriftItems(group, 1) {
+			chunk, _ := item.(map[int16]any)
+			colMeta := thriftSub(chunk, 3)
+			path := thriftItems(colMeta, 3)
+			if len(path) != 1 {
+				continue
+			}
+			name, _ := path[0].([]byte)
+			col := slices.Index(KLineCSVHeader, string(name))
+			if col < 0 {
+				continue
+			}
+			if err := readParquetColumn(r, colMeta, cols[col], col, rows); err != nil {
+				return meta, nil, fmt.Errorf("column %s: %w", name, err)
+			}
+		}
+		bars = append(bars, rows...)
+	}
+	return meta, bars, nil
+}
+
+// ParquetStore is a KLineStore keeping each series in the Parquet file at
+// KLineParquetPath under root. Every Append rewrites the file, so it suits
+// exports rather than incremental downloads.
+type ParquetStore struct {
+	root   string
+	source string // Recorded in the files it creates.
+	mu     sync.RWMutex
+}
+
+var _ KLineStore = (*ParquetStore)(nil)
+
+func NewParquetStore(root, source string) *ParquetStore {
+	return &ParquetStore{root: root, source: source}
+}
+
+func (s *ParquetStore) Root() string {
+	return s.root
+}
+
+// load returns a nil meta for a series never stored.
+func (s *ParquetStore) load(symbol string, interval common.ListKLinesInterval) (*ParquetMeta, []klines.KLine, error) {
+	path := KLineParquetPath(s.root, symbol, interval)
+	fp, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("stat file: %w", err)
+	}
+	meta, bars, err := ReadKLinesParquet(fp, info.Size())
+	if err != nil {
+		return nil, nil, fmt.Errorf("ReadKLinesParquet(%q): %w", path, err)
+	}
+	if meta.Symbol != symbol || meta.Interval != interval {
+		return nil, nil, fmt.Errorf("%q holds %s %s: %w", path, meta.Symbol, meta.Interval, ErrBadParquet)
+	}
+	return &meta, bars, nil
+}
+
+func (s *ParquetStore) Append(symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error {
+	if len(bars) == 0 {
+		return nil
+	}
+	if err := checkOrder(bars); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, stored, err := s.load(symbol, interval)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		meta = &ParquetMeta{Symbol: symbol, Interval: interval, Source: s.source}
+	}
+	keep, _ := slices.BinarySearchFunc(stored, bars[0].OpenTime, func(l klines.KLine, t time.Time) int {
+		return l.OpenTime.Compare(t)
+	})
+	stored = append(stored[:keep], bars...)
+
+	path := KLineParquetPath(s.root, symbol, interval)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	tmpPath := path + "_tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer fp.Close()
+	if err := WriteKLinesParquet(fp, *meta, stored); err != nil {
+		return fmt.Errorf("WriteKLinesParquet: %w", err)
+	}
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+func (s *ParquetStore) Range(symbol string, interval common.ListKLinesInterval, from, to time.Time) ([]klines.KLine, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, bars, err := s.load(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(bars, func(l klines.KLine) bool {
+		return (!from.IsZero() && l.OpenTime.Before(from)) || (!to.IsZero() && l.OpenTime.After(to))
+	}), nil
+}
+
+func (s *ParquetStore) Last(symbol string, interval common.ListKLinesInterval) (klines.KLine, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, bars, err := s.load(symbol, interval)
+	if err != nil || len(bars) == 0 {
+		return klines.KLine{}, false, err
+	}
+	return bars[len(bars)-1], true, nil
+}
+
+func (s *ParquetStore) Stats(symbol string, interval common.ListKLinesInterval) (SeriesStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, bars, err := s.load(symbol, interval)
+	if err != nil {
+		return SeriesStats{}, err
+	}
+	st := SeriesStats{Count: len(bars)}
+	if len(bars) > 0 {
+		st.First, st.Last = bars[0].OpenTime, bars[len(bars)-1].OpenTime
+	}
+	if info, err := os.Stat(KLineParquetPath(s.root, symbol, interval)); err == nil {
+		st.SizeBytes = info.Size()
+	}
+	st.Missing = missingBars(st.First, st.Last, st.Count, interval)
+	return st, nil
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// testBars returns n hourly bars from t0 with distinct prices, every third one
+// synthetic.
+func testBars(t0 time.Time, n int) []klines.KLine {
+	bars := make([]klines.KLine, n)
+	for idx := range bars {
+		l := testBar(t0.Add(time.Duration(idx) * time.Hour))
+		l.OpenPrice += float64(idx)
+		l.ClosePrice += float64(idx)
+		l.HighPrice += float64(idx)
+		l.LowPrice += float64(idx)
+		l.QuoteAssetVolume = float64(idx) * 1.5
+		l.TradeNum = float64(idx % 7)
+		l.Synthetic = idx%3 == 2
+		bars[idx] = l
+	}
+	return bars
+}
+
+func sameKLines(a, b []klines.KLine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		x, y := a[idx], b[idx]
+		if !x.OpenTime.Equal(y.OpenTime) || !x.CloseTime.Equal(y.CloseTime) {
+			return false
+		}
+		x.OpenTime, x.CloseTime, y.OpenTime, y.CloseTime = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKLineParquetRoundTrip(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta := ParquetMeta{Symbol: "BTCUSDT", Interval: common.ListKLinesInterval_1h, Source: "test"}
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{name: "empty", n: 0},
+		{name: "one bar", n: 1},
+		{name: "partial flag byte", n: 13},
+		{name: "row groups", n: 2*parquetRowGroupSize + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bars := testBars(t0, tt.n)
+			var buf bytes.Buffer
+			if err := WriteKLinesParquet(&buf, meta, bars); err != nil {
+				t.Fatalf("WriteKLinesParquet: %v", err)
+			}
+			gotMeta, got, err := ReadKLinesParquet(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("ReadKLinesParquet: %v", err)
+			}
+			if gotMeta != meta {
+				t.Errorf("meta %+v, want %+v", gotMeta, meta)
+			}
+			if !sameKLines(got, bars) {
+				t.Errorf("read %d bars, want the %d written", len(got), len(bars))
+			}
+		})
+	}
+}
+
+// foreignParquet describes a KLine Parquet file written by another tool.
+type foreignParquet struct {
+	columns  []string // Stored columns, in KLineCSVHeader order.
+	optional bool     // OPTIONAL columns with definition levels, as pandas writes.
+	micros   bool     // Timestamps in microseconds.
+	pages    int      // Data pages per column chunk.
+	codec    int32    // Only recorded, the pages are never compressed.
+}
+
+func (f foreignParquet) write(bars []klines.KLine) []byte {
+	b := []byte(parquetMagic)
+	var chunks []any
+	for col, name := range KLineCSVHeader {
+		if !slices.Contains(f.columns, name) {
+			continue
+		}
+		offset := int64(len(b))
+		per := (len(bars) + f.pages - 1) / f.pages
+		for start := 0; start < len(bars); start += per {
+			page := bars[start:min(start+per, len(bars))]
+			var plain []byte
+			if f.optional { // One RLE run of defined values.
+				levels := append(binary.AppendUvarint(nil, uint64(len(page))<<1), 1)
+				plain = binary.LittleEndian.AppendUint32(plain, uint32(len(levels)))
+				plain = append(plain, levels...)
+			}
+			if col == parquetKLineFlagColumn {
+				flags := make([]byte, (len(page)+7)/8)
+				for idx := range page {
+					flags[idx/8] |= byte(klineColumn(&page[idx], col)) << (idx % 8)
+				}
+				plain = append(plain, flags...)
+			} else {
+				for idx := range page {
+					v := klineColumn(&page[idx], col)
+					if f.micros && col < parquetKLineTimeColumns {
+						v *= 1000
+					}
+					plain = binary.LittleEndian.AppendUint64(plain, v)
+				}
+			}
+			b = appendThriftValue(b, thriftStruct{
+				{1, int32(parquetPageData)}, {2, int32(len(plain))}, {3, int32(len(plain))},
+				{5, thriftStruct{
+					{1, int32(len(page))}, {2, int32(parquetEncodingPlain)},
+					{3, int32(parquetEncodingRLE)}, {4, int32(parquetEncodingRLE)},
+				}},
+			})
+			b = append(b, plain...)
+		}
+		chunks = append(chunks, thriftStruct{
+			{2, offset},
+			{3, thriftStruct{
+				{1, parquetColumnType(col)},
+				{2, thriftList{thriftTypeI32, []any{int32(parquetEncodingPlain)}}},
+				{3, thriftList{thriftTypeBinary, []any{name}}},
+				{4, f.codec},
+				{5, int64(len(bars))},
+				{6, int64(len(b)) - offset},
+				{7, int64(len(b)) - offset},
+				{9, offset},
+			}},
+		})
+	}
+	rep := int32(parquetRequired)
+	if f.optional {
+		rep = parquetOptional
+	}
+	schema := []any{thriftStruct{{4, "schema"}, {5, int32(len(f.columns))}}}
+	for col, name := range KLineCSVHeader {
+		if !slices.Contains(f.columns, name) {
+			continue
+		}
+		el := thriftStruct{{1, parquetColumnType(col)}, {3, rep}, {4, name}}
+		if col < parquetKLineTimeColumns {
+			unit := thriftStruct{{1, thriftStruct{}}}
+			if f.micros {
+				unit = thriftStruct{{2, thriftStruct{}}}
+			}
+			el = append(el, thriftField{10, thriftStruct{{8, thriftStruct{{1, true}, {2, unit}}}}})
+		}
+		schema = append(schema, el)
+	}
+	footer := appendThriftValue(nil, thriftStruct{
+		{1, int32(1)},
+		{2, thriftList{thriftTypeStruct, schema}},
+		{3, int64(len(bars))},
+		{4, thriftList{thriftTypeStruct, []any{thriftStruct{
+			{1, thriftList{thriftTypeStruct, chunks}}, {2, int64(len(b))}, {3, int64(len(bars))},
+		}}}},
+		{5, thriftList{thriftTypeStruct, []any{thriftStruct{{1, "symbol"}, {2, "BTCUSDT"}}}}},
+	})
+	b = append(b, footer...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(footer)))
+	return append(b, parquetMagic...)
+}
+
+// TestReadForeignKLinesParquet reads files in the layouts other writers produce.
+func TestReadForeignKLinesParquet(t *testing.T) {
+	bars := testBars(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 20)
+	allReal := make([]klines.KLine, len(bars))
+	for idx, l := range bars {
+		l.Synthetic = false
+		allReal[idx] = l
+	}
+	legacy := KLineCSVHeader[:parquetKLineFlagColumn]
+	tests := []struct {
+		name    string
+		file    foreignParquet
+		want    []klines.KLine
+		wantErr error
+	}{
+		{
+			name: "legacy without Synthetic",
+			file: foreignParquet{columns: legacy, pages: 1},
+			want: allReal,
+		},
+		{
+			name: "optional columns in microseconds",
+			file: foreignParquet{columns: KLineCSVHeader, optional: true, micros: true, pages: 1},
+			want: bars,
+		},
+		{
+			name: "several pages",
+			file: foreignParquet{columns: KLineCSVHeader, optional: true, pages: 3},
+			want: bars,
+		},
+		{
+			name:    "snappy",
+			file:    foreignParquet{columns: KLineCSVHeader, pages: 1, codec: 1},
+			wantErr: ErrUnsupportedParquet,
+		},
+		{
+			name:    "missing column",
+			file:    foreignParquet{columns: KLineCSVHeader[1:], pages: 1},
+			wantErr: ErrBadParquet,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.file.write(bars)
+			_, got, err := ReadKLinesParquet(bytes.NewReader(b), int64(len(b)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadKLinesParquet: %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !sameKLines(got, tt.want) {
+				t.Errorf("read %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	items := make([]any, 20) // Past the short list header.
+	for idx := range items {
+		items[idx] = int64(idx - 10)
+	}
+	in := thriftStruct{
+		{1, true},
+		{2, false},
+		{3, int32(-7)},
+		{20, int64(math.MinInt64)}, // Past the short field delta.
+		{21, "text"},
+		{22, thriftList{thriftTypeI64, items}},
+		{23, thriftStruct{{1, thriftStruct{}}}},
+	}
+	b := appendThriftValue(nil, in)
+	got, n, err := readThriftStruct(append(b, 0xff))
+	if err != nil {
+		t.Fatalf("readThriftStruct: %v", err)
+	}
+	if n != len(b) {
+		t.Errorf("read %d bytes, want %d", n, len(b))
+	}
+	if got[1] != true || got[2] != false {
+		t.Errorf("booleans %v %v", got[1], got[2])
+	}
+	if v, _ := thriftInt(got, 3); v != -7 {
+		t.Errorf("field 3 = %d", v)
+	}
+	if v, _ := thriftInt(got, 20); v != math.MinInt64 {
+		t.Errorf("field 20 = %d", v)
+	}
+	if s := thriftString(got, 21); s != "text" {
+		t.Errorf("field 21 = %q", s)
+	}
+	if list := thriftItems(got, 22); len(list) != len(items) || list[0] != int64(-10) || list[19] != int64(9) {
+		t.Errorf("field 22 = %v", list)
+	}
+	if sub := thriftSub(thriftSub(got, 23), 1); sub == nil {
+		t.Errorf("field 23 = %v", got[23])
+	}
+	for cut := range len(b) {
+		if _, _, err := readThriftStruct(b[:cut]); !errors.Is(err, errThrift) {
+			t.Fatalf("readThriftStruct of %d/%d bytes: %v, want %v", cut, len(b), err, errThrift)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// mapFile reads the first size bytes of f where mmap is not available.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return nil, nil, fmt.Errorf("read file: %w", err)
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+var (
+	ErrNotInOrder = errors.New("klines not in open time order")
+)
+
+// KLineStore stores one series of KLines per symbol and interval, in open time
+// order. Implementations are safe for concurrent use.
+type KLineStore interface {
+	// Append stores the bars, which must be in increasing open time order. Stored
+	// bars opening at or after bars[0] are replaced, so a bar stored while it was
+	// still open can be overwritten by its final version.
+	Append(symbol string, interval common.ListKLinesInterval, bars []klines.KLine) error
+	// Range returns the stored bars opening within [from, to]. A zero from or to
+	// leaves that side unbounded. An unknown series has no bars.
+	Range(symbol string, interval common.ListKLinesInterval, from, to time.Time) ([]klines.KLine, error)
+	// Last returns the latest stored bar, or false if there is none.
+	Last(symbol string, interval common.ListKLinesInterval) (klines.KLine, bool, error)
+	Stats(symbol string, interval common.ListKLinesInterval) (SeriesStats, error)
+}
+
+// FileStore is a KLineStore keeping its series in files under Root, where the
+// sidecars of the series, such as KLineGapsPath, go too.
+type FileStore interface {
+	KLineStore
+	Root() string
+}
+
+var (
+	_ FileStore = (*CSVStore)(nil)
+	_ FileStore = (*BinaryStore)(nil)
+	_ FileStore = (*ParquetStore)(nil)
+)
+
+// Series identifies the KLines of one symbol at one interval.
+type Series struct {
+	Symbol   string
+	Interval common.ListKLinesInterval
+}
+
+// ListSeries lists the KLine series stored under root in files with the
+// extension, i.e. "<root>/<SYMBOL>/<SYMBOL>_<interval><ext>", ordered by symbol.
+func ListSeries(root, ext string) ([]Series, error) {
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+	var res []Series
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		symbol := dir.Name()
+		files, err := os.ReadDir(filepath.Join(root, symbol))
+		if err != nil {
+			return nil, fmt.Errorf("read dir: %w", err)
+		}
+		for _, f := range files {
+			name, ok := strings.CutPrefix(f.Name(), symbol+"_")
+			if !ok {
+				continue
+			}
+			interval, ok := strings.CutSuffix(name, ext)
+			if !ok || common.IntervalDuration(common.ListKLinesInterval(interval)) == 0 {
+				continue // Trades, funding rates or depth.
+			}
+			res = append(res, Series{Symbol: symbol, Interval: common.ListKLinesInterval(interval)})
+		}
+	}
+	return res, nil
+}
+
+// SeriesStats summarizes a stored series.
+type SeriesStats struct {
+	Count     int
+	First     time.Time // Open time of the first bar.
+	Last      time.Time // Open time of the last bar.
+	Missing   int       // Bars missing between First and Last at the interval.
+	SizeBytes int64     // On disk.
+}
+
+// checkOrder returns an error unless the bars open in strictly increasing order.
+func checkOrder(bars []klines.KLine) error {
+	for idx := 1; idx < len(bars); idx++ {
+		if !bars[idx].OpenTime.After(bars[idx-1].OpenTime) {
+			return fmt.Errorf("bar %d opens at %v, not after %v: %w",
+				idx, bars[idx].OpenTime, bars[idx-1].OpenTime, ErrNotInOrder)
+		}
+	}
+	return nil
+}
+
+// missingBars counts the bars of the interval missing between first and last for
+// a series of count bars.
+func missingBars(first, last time.Time, count int, interval common.ListKLinesInterval) int {
+	if count == 0 {
+		return 0
+	}
+	return common.BarsBetween(interval, first, last) - count
+}
+
+// Copy copies a whole series from src to dst, e.g. to convert the CSVs into
+// another format, and returns the number of bars copied.
+func Copy(dst, src KLineStore, symbol string, interval common.ListKLinesInterval) (int, error) {
+	bars, err := src.Range(symbol, interval, time.Time{}, time.Time{})
+	if err != nil {
+		return 0, fmt.Errorf("Range: %w", err)
+	}
+	if err := dst.Append(symbol, interval, bars); err != nil {
+		return 0, fmt.Errorf("Append: %w", err)
+	}
+	return len(bars), nil
+}
+
+// Merge stores bars, in increasing open time order, among the stored ones rather
+// than replacing the later ones as Append does, e.g. to import an older month. On
+// overlaps the stored bar is kept unless it is synthetic and the new one is not.
+// It returns how many bars were added or replaced.
+func Merge(store KLineStore, symbol string, interval common.ListKLinesInterval, bars []klines.KLine) (int, error) {
+	if len(bars) == 0 {
+		return 0, nil
+	}
+	if err := checkOrder(bars); err != nil {
+		return 0, err
+	}
+	stored, err := store.Range(symbol, interval, bars[0].OpenTime, time.Time{})
+	if err != nil {
+		return 0, fmt.Errorf("Range: %w", err)
+	}
+	merged := make([]klines.KLine, 0, len(stored)+len(bars))
+	added := 0
+	idx, jdx := 0, 0
+	for idx < len(stored) || jdx < len(bars) {
+		switch {
+		case jdx == len(bars) || (idx < len(stored) && stored[idx].OpenTime.Before(bars[jdx].OpenTime)):
+			merged = append(merged, stored[idx])
+			idx++
+		case idx == len(stored) || bars[jdx].OpenTime.Before(stored[idx].OpenTime):
+			merged = append(merged, bars[jdx])
+			added++
+			jdx++
+		default:
+			if stored[idx].Synthetic && !bars[jdx].Synthetic {
+				merged = append(merged, bars[jdx])
+				added++
+			} else {
+				merged = append(merged, stored[idx])
+			}
+			idx++
+			jdx++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	if err := store.Append(symbol, interval, merged); err != nil {
+		return 0, fmt.Errorf("Append: %w", err)
+	}
+	return added, nil
+}
+
+// replaceFile writes path through a temporary file so readers never see it
+// partially written.
+func replaceFile(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	tmpPath := path + "_tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer fp.Close()
+	if err := write(fp); err != nil {
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
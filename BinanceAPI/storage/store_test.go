@@ -0,0 +1,24 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storagetest"
+)
+
+func TestKLineStoreConformance(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		newStore func(root string) storage.KLineStore
+	}{
+		{"CSV", func(root string) storage.KLineStore { return storage.NewCSVStore(root) }},
+		{"Binary", func(root string) storage.KLineStore { return storage.NewBinaryStore(root, storage.Compression_NONE) }},
+		{"BinaryFlate", func(root string) storage.KLineStore { return storage.NewBinaryStore(root, storage.Compression_FLATE) }},
+		{"Parquet", func(root string) storage.KLineStore { return storage.NewParquetStore(root, "test") }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storage.KLineStore { return tt.newStore(t.TempDir()) })
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A minimal Thrift compact protocol codec, enough for the Parquet metadata.
+//
+// Values are written from bool, int32, int64, string, []byte, thriftStruct and
+// thriftList, and read back generically: integers as int64, binaries as []byte,
+// lists as []any and structs as map[int16]any keyed by field ID.
+
+var (
+	errThrift = errors.New("bad thrift compact encoding")
+)
+
+const (
+	thriftTypeBoolTrue  = 1
+	thriftTypeBoolFalse = 2
+	thriftTypeByte      = 3
+	thriftTypeI16       = 4
+	thriftTypeI32       = 5
+	thriftTypeI64       = 6
+	thriftTypeDouble    = 7
+	thriftTypeBinary    = 8
+	thriftTypeList      = 9
+	thriftTypeSet       = 10
+	thriftTypeMap       = 11
+	thriftTypeStruct    = 12
+)
+
+type thriftField struct {
+	id    int16
+	value any
+}
+
+// thriftStruct lists its fields in increasing ID order.
+type thriftStruct []thriftField
+
+type thriftList struct {
+	elemType byte
+	items    []any
+}
+
+func thriftTypeOf(v any) byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return thriftTypeBoolTrue
+		}
+		return thriftTypeBoolFalse
+	case int32:
+		return thriftTypeI32
+	case int64:
+		return thriftTypeI64
+	case string, []byte:
+		return thriftTypeBinary
+	case thriftList:
+		return thriftTypeList
+	case thriftStruct:
+		return thriftTypeStruct
+	}
+	panic(fmt.Sprintf("no thrift type for %T", v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func appendThriftValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case int32:
+		return binary.AppendUvarint(b, zigzag(int64(v)))
+	case int64:
+		return binary.AppendUvarint(b, zigzag(v))
+	case string:
+		return append(binary.AppendUvarint(b, uint64(len(v))), v...)
+	case []byte:
+		return append(binary.AppendUvarint(b, uint64(len(v))), v...)
+	case thriftList:
+		if n := len(v.items); n < 15 {
+			b = append(b, byte(n)<<4|v.elemType)
+		} else {
+			b = binary.AppendUvarint(append(b, 0xf0|v.elemType), uint64(n))
+		}
+		for _, item := range v.items {
+			b = appendThriftValue(b, item)
+		}
+		return b
+	case thriftStruct:
+		var last int16
+		for _, f := range v {
+			t := thriftTypeOf(f.value)
+			if d := f.id - last; d > 0 && d <= 15 {
+				b = append(b, byte(d)<<4|t)
+			} else {
+				b = binary.AppendUvarint(append(b, t), zigzag(int64(f.id)))
+			}
+			last = f.id
+			if _, ok := f.value.(bool); !ok { // Booleans live in the field type.
+				b = appendThriftValue(b, f.value)
+			}
+		}
+		return append(b, 0)
+	}
+	panic(fmt.Sprintf("no thrift encoding for %T", v))
+}
+
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, fmt.Errorf("unexpected end: %w", errThrift)
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	u, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("bad varint at %d: %w", r.pos, errThrift)
+	}
+	r.pos += n
+	return u, nil
+}
+
+func (r *thriftReader) value(t byte) (any, error) {
+	switch t {
+	case thriftTypeBoolTrue, thriftTypeBoolFalse: // Only inside lists.
+		b, err := r.byte()
+		return b == 1, err
+	case thriftTypeByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftTypeI16, thriftTypeI32, thriftTypeI64:
+		u, err := r.uvarint()
+		return unzigzag(u), err
+	case thriftTypeDouble:
+		if r.pos+8 > len(r.b) {
+			return nil, fmt.Errorf("unexpected end: %w", errThrift)
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:])), nil
+	case thriftTypeBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.b)-r.pos) {
+			return nil, fmt.Errorf("binary of %d bytes past the end: %w", n, errThrift)
+		}
+		r.pos += int(n)
+		return r.b[r.pos-int(n) : r.pos], nil
+	case thriftTypeList, thriftTypeSet:
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(r.b)-r.pos) { // Every item takes at least a byte.
+			return nil, fmt.Errorf("list of %d items past the end: %w", n, errThrift)
+		}
+		items := make([]any, 0, n)
+		for range n {
+			item, err := r.value(h & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case thriftTypeMap: // Skipped.
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		kv, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for range n {
+			if _, err := r.value(kv >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := r.value(kv & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftTypeStruct:
+		return r.structure()
+	}
+	return nil, fmt.Errorf("type %d: %w", t, errThrift)
+}
+
+func (r *thriftReader) structure() (map[int16]any, error) {
+	res := map[int16]any{}
+	var last int16
+	for {
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if h == 0 {
+			return res, nil
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			u, err := r.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(unzigzag(u))
+		}
+		last = id
+		switch t := h & 0x0f; t {
+		case thriftTypeBoolTrue, thriftTypeBoolFalse:
+			res[id] = t == thriftTypeBoolTrue
+		default:
+			if res[id], err = r.value(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readThriftStruct decodes a struct at the start of b and returns it with its
+// length.
+func readThriftStruct(b []byte) (map[int16]any, int, error) {
+	r := &thriftReader{b: b}
+	s, err := r.structure()
+	return s, r.pos, err
+}
+
+func thriftInt(s map[int16]any, id int16) (int64, bool) {
+	v, ok := s[id].(int64)
+	return v, ok
+}
+
+func thriftString(s map[int16]any, id int16) string {
+	v, _ := s[id].([]byte)
+	return string(bytes.Clone(v))
+}
+
+func thriftItems(s map[int16]any, id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+func thriftSub(s map[int16]any, id int16) map[int16]any {
+	v, _ := s[id].(map[int16]any)
+	return v
+}
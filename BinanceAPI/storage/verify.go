@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+type IssueKind string
+
+const (
+	IssueKind_HEADER     IssueKind = "HEADER"     // Missing or unexpected header.
+	IssueKind_PARSE      IssueKind = "PARSE"      // A record or file that does not parse.
+	IssueKind_ORDER      IssueKind = "ORDER"      // Opens before the previous bar.
+	IssueKind_DUPLICATE  IssueKind = "DUPLICATE"  // Opens at the same time as an earlier bar.
+	IssueKind_MISALIGNED IssueKind = "MISALIGNED" // Opens off the interval boundaries.
+	IssueKind_CLOSE_TIME IssueKind = "CLOSE_TIME" // Does not close 1ms before the next bar opens.
+	IssueKind_OHLC       IssueKind = "OHLC"       // Opens or closes outside [low, high].
+	IssueKind_VOLUME     IssueKind = "VOLUME"     // Negative volume or trade count.
+	IssueKind_GAP        IssueKind = "GAP"        // Bars missing before this one.
+)
+
+// maxIssuesPerKind bounds the issues listed in a report, the rest being counted only.
+const maxIssuesPerKind = 100
+
+// Issue is one problem found in a stored series.
+type Issue struct {
+	Kind     IssueKind
+	Line     int       `json:",omitempty"` // In the CSV, the header being line 1.
+	OpenTime time.Time // Of the bar, zero when it did not parse.
+	Detail   string
+}
+
+// VerifyReport describes one verified file.
+type VerifyReport struct {
+	Path      string
+	Symbol    string
+	Interval  common.ListKLinesInterval
+	Bars      int
+	Synthetic int
+	First     time.Time
+	Last      time.Time
+	Missing   int               // Bars missing within [First, Last].
+	Counts    map[IssueKind]int `json:",omitempty"`
+	Issues    []Issue           `json:",omitempty"`
+}
+
+// Failed reports whether the file has issues other than gaps, or gaps too when
+// strict.
+func (r *VerifyReport) Failed(strict bool) bool {
+	for kind, n := range r.Counts {
+		if n > 0 && (strict || kind != IssueKind_GAP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VerifyReport) add(kind IssueKind, line int, openTime time.Time, format string, args ...any) {
+	if r.Counts == nil {
+		r.Counts = map[IssueKind]int{}
+	}
+	r.Counts[kind]++
+	if r.Counts[kind] <= maxIssuesPerKind {
+		r.Issues = append(r.Issues, Issue{Kind: kind, Line: line, OpenTime: openTime, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+// barVerifier checks bars one at a time, in the order they are stored.
+type barVerifier struct {
+	rep   *VerifyReport
+	known bool // Whether the interval is, so bar times can be checked.
+	seen  map[int64]bool
+	prev  *klines.KLine
+}
+
+func newBarVerifier(rep *VerifyReport) *barVerifier {
+	return &barVerifier{rep: rep, known: common.IntervalDuration(rep.Interval) > 0, seen: map[int64]bool{}}
+}
+
+func (v *barVerifier) check(line int, l *klines.KLine) {
+	rep, interval, t := v.rep, v.rep.Interval, l.OpenTime
+	rep.Bars++
+	if l.Synthetic {
+		rep.Synthetic++
+	}
+	if rep.First.IsZero() || t.Before(rep.First) {
+		rep.First = t
+	}
+	if t.After(rep.Last) {
+		rep.Last = t
+	}
+
+	if v.seen[t.UnixMilli()] {
+		rep.add(IssueKind_DUPLICATE, line, t, "open time seen before")
+	} else if v.prev != nil && t.Before(v.prev.OpenTime) {
+		rep.add(IssueKind_ORDER, line, t, "opens before the previous bar at %v", v.prev.OpenTime)
+	} else if v.prev != nil && v.known && common.NextOpenTime(interval, v.prev.OpenTime).Before(t) {
+		n := common.BarsBetween(interval, v.prev.OpenTime, t) - 2
+		rep.add(IssueKind_GAP, line, t, "%d bars missing after %v", n, v.prev.OpenTime)
+	}
+	v.seen[t.UnixMilli()] = true
+	if v.prev == nil || t.After(v.prev.OpenTime) {
+		v.prev = l
+	}
+
+	if v.known {
+		if !common.IsAligned(interval, t) {
+			rep.add(IssueKind_MISALIGNED, line, t, "not on a %s boundary", interval)
+		}
+		if want := common.NextOpenTime(interval, t).Add(-time.Millisecond); !l.CloseTime.Equal(want) {
+			rep.add(IssueKind_CLOSE_TIME, line, t, "closes at %v, want %v", l.CloseTime, want)
+		}
+	}
+	// Written so NaNs fail too.
+	if !(l.LowPrice <= l.OpenPrice && l.OpenPrice <= l.HighPrice && l.LowPrice <= l.ClosePrice && l.ClosePrice <= l.HighPrice) {
+		rep.add(IssueKind_OHLC, line, t, "open %v close %v outside low %v high %v", l.OpenPrice, l.ClosePrice, l.LowPrice, l.HighPrice)
+	}
+	if !(l.Volume >= 0 && l.QuoteAssetVolume >= 0 && l.TradeNum >= 0) {
+		rep.add(IssueKind_VOLUME, line, t, "volume %v quote asset volume %v trade num %v", l.Volume, l.QuoteAssetVolume, l.TradeNum)
+	}
+}
+
+func (v *barVerifier) finish() {
+	v.rep.Missing = max(missingBars(v.rep.First, v.rep.Last, len(v.seen), v.rep.Interval), 0)
+}
+
+// VerifyKLines checks bars as stored in path, e.g. loaded from a binary or Parquet
+// file, which failed to load when loadErr is not nil.
+func VerifyKLines(path, symbol string, interval common.ListKLinesInterval, bars []klines.KLine, loadErr error) *VerifyReport {
+	rep := &VerifyReport{Path: path, Symbol: symbol, Interval: interval}
+	if loadErr != nil {
+		rep.add(IssueKind_PARSE, 0, time.Time{}, "%v", loadErr)
+		return rep
+	}
+	v := newBarVerifier(rep)
+	for idx := range bars {
+		v.check(0, &bars[idx])
+	}
+	v.finish()
+	return rep
+}
+
+// VerifyKLineCSV checks the KLine CSV at path record by record, so that records
+// which do not parse are reported with their line rather than stopping the scan.
+// It only fails when the file cannot be read.
+func VerifyKLineCSV(path, symbol string, interval common.ListKLinesInterval) (*VerifyReport, error) {
+	rep := &VerifyReport{Path: path, Symbol: symbol, Interval: interval}
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer fp.Close()
+	cr := csv.NewReader(fp)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		rep.add(IssueKind_HEADER, 1, time.Time{}, "empty file")
+		return rep, nil
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		rep.add(IssueKind_HEADER, 1, time.Time{}, "%v", err)
+		return rep, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns := len(header)
+	if !slices.Equal(header, KLineCSVHeader) && !slices.Equal(header, KLineCSVHeader[:legacyKLineCSVColumns]) {
+		rep.add(IssueKind_HEADER, 1, time.Time{}, "header %v, want %v", header, KLineCSVHeader)
+		columns = len(KLineCSVHeader)
+	}
+
+	v := newBarVerifier(rep)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if errors.As(err, &parseErr) {
+			rep.add(IssueKind_PARSE, parseErr.StartLine, time.Time{}, "%v", err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read one CSV record: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) != columns {
+			rep.add(IssueKind_PARSE, line, time.Time{}, "%d columns, want %d", len(record), columns)
+			continue
+		}
+		l := &klines.KLine{}
+		if err := KLineFromCSVRecord(record, l); err != nil {
+			rep.add(IssueKind_PARSE, line, time.Time{}, "%v", err)
+			continue
+		}
+		v.check(line, l)
+	}
+	v.finish()
+	return rep, nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+func testBar(openTime time.Time) klines.KLine {
+	return klines.KLine{
+		OpenTime:   openTime,
+		CloseTime:  openTime.Add(time.Hour - time.Millisecond),
+		OpenPrice:  100,
+		ClosePrice: 101,
+		HighPrice:  102,
+		LowPrice:   99,
+		Volume:     1,
+	}
+}
+
+func TestVerifyKLineCSVMalformedRecords(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	good := func(idx int) string {
+		l := testBar(t0.Add(time.Duration(idx) * time.Hour))
+		return strings.Join(KLineToCSVRecord(&l), ",")
+	}
+	header := strings.Join(KLineCSVHeader, ",")
+	tests := []struct {
+		name     string
+		lines    []string
+		wantLine int
+	}{
+		{
+			name:     "bare quote",
+			lines:    []string{header, good(0), `1"704070800000,1704074399999,100,101,102,99,1,0,0,0`, good(2)},
+			wantLine: 3,
+		},
+		{
+			name:     "extraneous quote",
+			lines:    []string{header, good(0), `"1704070800000"x,1704074399999,100,101,102,99,1,0,0,0`, good(2)},
+			wantLine: 3,
+		},
+		{
+			name:     "wrong field count",
+			lines:    []string{header, good(0), good(1), "1704074400000,1704077999999,100", good(3)},
+			wantLine: 4,
+		},
+		{
+			name:     "bad field",
+			lines:    []string{header, good(0), "x" + good(1)},
+			wantLine: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "BTCUSDT_1h.csv")
+			if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rep, err := VerifyKLineCSV(path, "BTCUSDT", common.ListKLinesInterval_1h)
+			if err != nil {
+				t.Fatalf("VerifyKLineCSV: %v", err)
+			}
+			if rep.Counts[IssueKind_PARSE] != 1 {
+				t.Fatalf("PARSE issues = %d, want 1: %+v", rep.Counts[IssueKind_PARSE], rep.Issues)
+			}
+			for _, issue := range rep.Issues {
+				if issue.Kind == IssueKind_PARSE && issue.Line != tt.wantLine {
+					t.Errorf("PARSE issue on line %d, want %d: %s", issue.Line, tt.wantLine, issue.Detail)
+				}
+			}
+		})
+	}
+}
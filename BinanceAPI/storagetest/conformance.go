@@ -0,0 +1,252 @@
+// Package storagetest is the conformance suite every storage.KLineStore
+// implementation must pass. Call Run from a test of the implementation:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.KLineStore { ... })
+//	}
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+const (
+	symbol   = "BTCUSDT"
+	interval = common.ListKLinesInterval_1h
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Bars returns hourly bars opening idx hours after 2024-01-01 for each idx, with
+// prices derived from idx and version so that a replaced bar differs. The values
+// survive the 7 significant digits of the CSVs.
+func Bars(version int, idxs ...int) []klines.KLine {
+	res := make([]klines.KLine, len(idxs))
+	for jdx, idx := range idxs {
+		open := t0.Add(time.Duration(idx) * time.Hour)
+		price := 100 + float64(idx) + float64(version)/4
+		res[jdx] = klines.KLine{
+			OpenTime:         open,
+			CloseTime:        open.Add(time.Hour - time.Millisecond),
+			OpenPrice:        price,
+			ClosePrice:       price + 0.5,
+			HighPrice:        price + 1,
+			LowPrice:         price - 1,
+			Volume:           float64(idx + 1),
+			QuoteAssetVolume: float64(idx+1) * price,
+			TradeNum:         float64(idx % 5),
+			Synthetic:        idx%4 == 3,
+		}
+	}
+	return res
+}
+
+// seq returns the ints in [from, to).
+func seq(from, to int) []int {
+	res := make([]int, 0, to-from)
+	for idx := from; idx < to; idx++ {
+		res = append(res, idx)
+	}
+	return res
+}
+
+// Equal reports whether the bars are the same, comparing times as instants.
+func Equal(a, b []klines.KLine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		x, y := a[idx], b[idx]
+		if !x.OpenTime.Equal(y.OpenTime) || !x.CloseTime.Equal(y.CloseTime) {
+			return false
+		}
+		x.OpenTime, x.CloseTime, y.OpenTime, y.CloseTime = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// Format lists the open times and versions of the bars for test failures.
+func Format(bars []klines.KLine) string {
+	res := "["
+	for idx, l := range bars {
+		if idx > 0 {
+			res += " "
+		}
+		res += fmt.Sprintf("%d:%.2f", int(l.OpenTime.Sub(t0)/time.Hour), l.OpenPrice)
+	}
+	return res + "]"
+}
+
+// Run runs every case as a subtest with a fresh, empty store.
+func Run(t *testing.T, newStore func(t *testing.T) storage.KLineStore) {
+	tests := []struct {
+		name    string
+		appends [][]klines.KLine // Appended in turn.
+		want    []klines.KLine
+	}{
+		{
+			name: "nothing stored",
+		},
+		{
+			name:    "one append",
+			appends: [][]klines.KLine{Bars(0, seq(0, 10)...)},
+			want:    Bars(0, seq(0, 10)...),
+		},
+		{
+			name:    "appends continue the series",
+			appends: [][]klines.KLine{Bars(0, seq(0, 5)...), Bars(0, seq(5, 10)...), Bars(0, 10)},
+			want:    Bars(0, seq(0, 11)...),
+		},
+		{
+			name:    "replace the last bar",
+			appends: [][]klines.KLine{Bars(0, seq(0, 10)...), Bars(1, 9, 10)},
+			want:    append(Bars(0, seq(0, 9)...), Bars(1, 9, 10)...),
+		},
+		{
+			name:    "replace from bars[0] drops the later stored bars",
+			appends: [][]klines.KLine{Bars(0, seq(0, 10)...), Bars(1, 4, 5)},
+			want:    append(Bars(0, seq(0, 4)...), Bars(1, 4, 5)...),
+		},
+		{
+			name:    "replace everything",
+			appends: [][]klines.KLine{Bars(0, seq(5, 10)...), Bars(1, seq(0, 3)...)},
+			want:    Bars(1, seq(0, 3)...),
+		},
+		{
+			name:    "bars after a gap",
+			appends: [][]klines.KLine{Bars(0, 0, 1, 2), Bars(0, 6, 7)},
+			want:    Bars(0, 0, 1, 2, 6, 7),
+		},
+		{
+			name:    "empty append",
+			appends: [][]klines.KLine{Bars(0, 0, 1), nil},
+			want:    Bars(0, 0, 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			for idx, bars := range tt.appends {
+				if err := s.Append(symbol, interval, bars); err != nil {
+					t.Fatalf("Append #%d: %v", idx, err)
+				}
+			}
+			checkSeries(t, s, tt.want)
+		})
+	}
+
+	t.Run("range bounds", func(t *testing.T) { testRangeBounds(t, newStore(t)) })
+	t.Run("not in order", func(t *testing.T) { testNotInOrder(t, newStore(t)) })
+	t.Run("separate series", func(t *testing.T) { testSeparateSeries(t, newStore(t)) })
+}
+
+// checkSeries checks everything the store reports about the series against want.
+func checkSeries(t *testing.T, s storage.KLineStore, want []klines.KLine) {
+	t.Helper()
+	got, err := s.Range(symbol, interval, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	if !Equal(got, want) {
+		t.Errorf("Range = %s, want %s", Format(got), Format(want))
+	}
+	last, ok, err := s.Last(symbol, interval)
+	if err != nil {
+		t.Fatalf("Last: %v", err)
+	}
+	if ok != (len(want) > 0) {
+		t.Errorf("Last found %v, want %v", ok, len(want) > 0)
+	} else if ok && !Equal([]klines.KLine{last}, want[len(want)-1:]) {
+		t.Errorf("Last = %s, want %s", Format([]klines.KLine{last}), Format(want[len(want)-1:]))
+	}
+	st, err := s.Stats(symbol, interval)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	var wantStats storage.SeriesStats
+	if n := len(want); n > 0 {
+		wantStats = storage.SeriesStats{
+			Count:   n,
+			First:   want[0].OpenTime,
+			Last:    want[n-1].OpenTime,
+			Missing: int(want[n-1].OpenTime.Sub(want[0].OpenTime)/time.Hour) + 1 - n,
+		}
+	}
+	if st.Count != wantStats.Count || !st.First.Equal(wantStats.First) || !st.Last.Equal(wantStats.Last) || st.Missing != wantStats.Missing {
+		t.Errorf("Stats = %+v, want %+v", st, wantStats)
+	}
+}
+
+func testRangeBounds(t *testing.T, s storage.KLineStore) {
+	if err := s.Append(symbol, interval, Bars(0, seq(0, 10)...)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	at := func(idx int) time.Time { return t0.Add(time.Duration(idx) * time.Hour) }
+	for _, c := range []struct {
+		from, to time.Time
+		want     []int
+	}{
+		{from: at(3), to: at(5), want: seq(3, 6)}, // Both bounds inclusive.
+		{from: at(3), want: seq(3, 10)},
+		{to: at(2), want: seq(0, 3)},
+		{from: at(2).Add(time.Minute), to: at(4).Add(time.Minute), want: seq(3, 5)},
+		{from: at(-5), to: at(-1)},
+		{from: at(10)},
+		{from: at(5), to: at(5), want: []int{5}},
+		{from: at(6), to: at(5)},
+	} {
+		got, err := s.Range(symbol, interval, c.from, c.to)
+		if err != nil {
+			t.Fatalf("Range(%v, %v): %v", c.from, c.to, err)
+		}
+		if want := Bars(0, c.want...); !Equal(got, want) {
+			t.Errorf("Range(%v, %v) = %s, want %s", c.from, c.to, Format(got), Format(want))
+		}
+	}
+}
+
+func testNotInOrder(t *testing.T, s storage.KLineStore) {
+	stored := Bars(0, 0, 1, 2)
+	if err := s.Append(symbol, interval, stored); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	for _, bars := range [][]klines.KLine{Bars(1, 3, 5, 4), Bars(1, 3, 3)} {
+		if err := s.Append(symbol, interval, bars); !errors.Is(err, storage.ErrNotInOrder) {
+			t.Errorf("Append(%s) = %v, want %v", Format(bars), err, storage.ErrNotInOrder)
+		}
+	}
+	checkSeries(t, s, stored)
+}
+
+func testSeparateSeries(t *testing.T, s storage.KLineStore) {
+	if err := s.Append(symbol, interval, Bars(0, 0, 1, 2)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Append("ETHUSDT", interval, Bars(1, 0, 1)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Append(symbol, common.ListKLinesInterval_1d, Bars(2, 0)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	checkSeries(t, s, Bars(0, 0, 1, 2))
+	got, err := s.Range("ETHUSDT", interval, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	if want := Bars(1, 0, 1); !Equal(got, want) {
+		t.Errorf("Range(ETHUSDT) = %s, want %s", Format(got), Format(want))
+	}
+	if _, ok, err := s.Last("XRPUSDT", interval); ok || err != nil {
+		t.Errorf("Last(XRPUSDT) = %v, %v, want nothing", ok, err)
+	}
+}
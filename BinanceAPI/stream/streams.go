@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/aggtrades"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/depth"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+)
+
+// Valid level counts of the partial book depth stream.
+var DepthStreamLevels = []int{5, 10, 20}
+
+// KLineEvent is one update of the current bar. Closed is set on the last update
+// of the bar, after which the bar is final.
+type KLineEvent struct {
+	Symbol   string
+	Interval common.ListKLinesInterval
+	KLine    klines.KLine
+	Closed   bool
+}
+
+type klineMsg struct {
+	Symbol string `json:"s"`
+	K      struct {
+		OpenTime         int64  `json:"t"`
+		CloseTime        int64  `json:"T"`
+		Interval         string `json:"i"`
+		OpenPrice        string `json:"o"`
+		ClosePrice       string `json:"c"`
+		HighPrice        string `json:"h"`
+		LowPrice         string `json:"l"`
+		Volume           string `json:"v"`
+		TradeNum         int64  `json:"n"`
+		Closed           bool   `json:"x"`
+		QuoteAssetVolume string `json:"q"`
+	} `json:"k"`
+}
+
+// StreamKLines calls handle with every update of the symbol's current bar until
+// ctx is done, the connection fails or handle returns an error.
+func StreamKLines(
+	ctx context.Context, symbol string, interval common.ListKLinesInterval,
+	handle func(KLineEvent) error,
+) error {
+	return run(ctx, fmt.Sprintf("%s@kline_%s", strings.ToLower(symbol), interval), func(raw []byte) error {
+		var msg klineMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return fmt.Errorf("json unmarshal %q: %w", raw, err)
+		}
+		ev := KLineEvent{
+			Symbol:   msg.Symbol,
+			Interval: common.ListKLinesInterval(msg.K.Interval),
+			Closed:   msg.K.Closed,
+			KLine: klines.KLine{
+				OpenTime:  time.UnixMilli(msg.K.OpenTime),
+				CloseTime: time.UnixMilli(msg.K.CloseTime),
+				TradeNum:  float64(msg.K.TradeNum),
+			},
+		}
+		for _, f := range []struct {
+			name string
+			src  string
+			dst  *float64
+		}{
+			{"open price", msg.K.OpenPrice, &ev.KLine.OpenPrice},
+			{"close price", msg.K.ClosePrice, &ev.KLine.ClosePrice},
+			{"high price", msg.K.HighPrice, &ev.KLine.HighPrice},
+			{"low price", msg.K.LowPrice, &ev.KLine.LowPrice},
+			{"volume", msg.K.Volume, &ev.KLine.Volume},
+			{"quote asset volume", msg.K.QuoteAssetVolume, &ev.KLine.QuoteAssetVolume},
+		} {
+			v, err := common.ParseFloat64FromAnyString(f.src)
+			if err != nil {
+				return fmt.Errorf("parse %s field: %w", f.name, err)
+			}
+			*f.dst = v
+		}
+		return handle(ev)
+	})
+}
+
+type aggTradeMsg struct {
+	ID           int64  `json:"a"`
+	Price        string `json:"p"`
+	Quantity     string `json:"q"`
+	FirstTradeID int64  `json:"f"`
+	LastTradeID  int64  `json:"l"`
+	Time         int64  `json:"T"`
+	BuyerMaker   bool   `json:"m"`
+}
+
+// StreamAggTrades calls handle with every aggregated trade of the symbol.
+func StreamAggTrades(ctx context.Context, symbol string, handle func(aggtrades.AggTrade) error) error {
+	return run(ctx, fmt.Sprintf("%s@aggTrade", strings.ToLower(symbol)), func(raw []byte) error {
+		var msg aggTradeMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return fmt.Errorf("json unmarshal %q: %w", raw, err)
+		}
+		price, err := common.ParseFloat64FromAnyString(msg.Price)
+		if err != nil {
+			return fmt.Errorf("parse price field: %w", err)
+		}
+		qty, err := common.ParseFloat64FromAnyString(msg.Quantity)
+		if err != nil {
+			return fmt.Errorf("parse quantity field: %w", err)
+		}
+		return handle(aggtrades.AggTrade{
+			ID:           msg.ID,
+			Price:        price,
+			Quantity:     qty,
+			FirstTradeID: msg.FirstTradeID,
+			LastTradeID:  msg.LastTradeID,
+			Time:         time.UnixMilli(msg.Time),
+			BuyerMaker:   msg.BuyerMaker,
+		})
+	})
+}
+
+type depthMsg struct {
+	TransactionTime int64      `json:"T"`
+	LastUpdateID    int64      `json:"u"`
+	Bids            [][]string `json:"b"`
+	Asks            [][]string `json:"a"`
+}
+
+// StreamDepth calls handle with the top levels of the symbol's order book every
+// 100ms. Levels must be one of DepthStreamLevels.
+func StreamDepth(ctx context.Context, symbol string, levels int, handle func(*depth.Snapshot) error) error {
+	return run(ctx, fmt.Sprintf("%s@depth%d@100ms", strings.ToLower(symbol), levels), func(raw []byte) error {
+		var msg depthMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return fmt.Errorf("json unmarshal %q: %w", raw, err)
+		}
+		s := &depth.Snapshot{
+			LastUpdateID: msg.LastUpdateID,
+			Time:         time.UnixMilli(msg.TransactionTime),
+		}
+		var err error
+		if s.Bids, err = depth.ParsePriceLevels(msg.Bids); err != nil {
+			return fmt.Errorf("parse bids: %w", err)
+		}
+		if s.Asks, err = depth.ParsePriceLevels(msg.Asks); err != nil {
+			return fmt.Errorf("parse asks: %w", err)
+		}
+		return handle(s)
+	})
+}
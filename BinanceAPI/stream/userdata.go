@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+// OrderUpdate is an ORDER_TRADE_UPDATE event of the user data stream. A TRADE
+// execution type carries one fill in the Last* fields.
+type OrderUpdate struct {
+	Symbol             string
+	ClientOrderID      string
+	OrderID            int64
+	Side               common.OrderSide
+	Type               common.OrderType
+	ExecutionType      string // NEW, CANCELED, CALCULATED, EXPIRED, TRADE or AMENDMENT.
+	Status             common.OrderStatus
+	LastFilledQuantity float64
+	LastFilledPrice    float64
+	FilledQuantity     float64
+	Commission         float64
+	RealizedPnL        float64
+	Maker              bool
+	TradeTime          time.Time
+}
+
+type userDataMsg struct {
+	Event string `json:"e"`
+	Order struct {
+		Symbol             string `json:"s"`
+		ClientOrderID      string `json:"c"`
+		Side               string `json:"S"`
+		Type               string `json:"o"`
+		ExecutionType      string `json:"x"`
+		Status             string `json:"X"`
+		OrderID            int64  `json:"i"`
+		LastFilledQuantity string `json:"l"`
+		FilledQuantity     string `json:"z"`
+		LastFilledPrice    string `json:"L"`
+		Commission         string `json:"n"`
+		TradeTime          int64  `json:"T"`
+		Maker              bool   `json:"m"`
+		RealizedPnL        string `json:"rp"`
+	} `json:"o"`
+}
+
+// StreamOrderUpdates calls handle with every order update of the account behind
+// the listen key (see orders.StartUserDataStream). Other user data events are
+// skipped.
+func StreamOrderUpdates(ctx context.Context, listenKey string, handle func(OrderUpdate) error) error {
+	return run(ctx, listenKey, func(raw []byte) error {
+		var msg userDataMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return fmt.Errorf("json unmarshal %q: %w", raw, err)
+		}
+		if msg.Event != "ORDER_TRADE_UPDATE" {
+			return nil
+		}
+		o := &msg.Order
+		u := OrderUpdate{
+			Symbol:        o.Symbol,
+			ClientOrderID: o.ClientOrderID,
+			OrderID:       o.OrderID,
+			Side:          common.OrderSide(o.Side),
+			Type:          common.OrderType(o.Type),
+			ExecutionType: o.ExecutionType,
+			Status:        common.OrderStatus(o.Status),
+			Maker:         o.Maker,
+			TradeTime:     time.UnixMilli(o.TradeTime),
+		}
+		for _, f := range []struct {
+			name string
+			src  string
+			dst  *float64
+		}{
+			{"last filled quantity", o.LastFilledQuantity, &u.LastFilledQuantity},
+			{"last filled price", o.LastFilledPrice, &u.LastFilledPrice},
+			{"filled quantity", o.FilledQuantity, &u.FilledQuantity},
+			{"commission", o.Commission, &u.Commission},
+			{"realized PnL", o.RealizedPnL, &u.RealizedPnL},
+		} {
+			if f.src == "" {
+				continue // Commission is omitted until the order trades.
+			}
+			v, err := common.ParseFloat64FromAnyString(f.src)
+			if err != nil {
+				return fmt.Errorf("parse %s field: %w", f.name, err)
+			}
+			*f.dst = v
+		}
+		return handle(u)
+	})
+}
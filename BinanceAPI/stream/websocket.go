@@ -0,0 +1,262 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+var (
+	ErrClosed = errors.New("websocket closed")
+)
+
+// Opcodes of RFC 6455.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const maxMessageSize = 16 << 20
+
+// conn is a minimal client side WebSocket connection: it reads (possibly
+// fragmented) data messages, answers pings and writes masked control frames.
+// Binance market streams never need the client to send data messages.
+type conn struct {
+	nc net.Conn
+	br *bufio.Reader
+
+	writeMu sync.Mutex
+}
+
+// dial opens a WebSocket connection to rawURL ("wss://..." or "ws://...").
+func dial(ctx context.Context, rawURL string) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url %q: %w", rawURL, err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("dial %q: %w", host, err)
+	}
+	if u.Scheme == "wss" {
+		tc := tls.Client(nc, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("tls handshake: %w", err)
+		}
+		nc = tc
+	}
+
+	// Close the connection if ctx is cancelled during the HTTP upgrade.
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(nc); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("write upgrade request: %w", err)
+	}
+	br := bufio.NewReader(nc)
+	rsp, err := http.ReadResponse(br, req)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("read upgrade response: %w", err)
+	}
+	if rsp.StatusCode != http.StatusSwitchingProtocols {
+		nc.Close()
+		return nil, fmt.Errorf("http status code(%d) status(%q)", rsp.StatusCode, rsp.Status)
+	}
+	if got, want := rsp.Header.Get("Sec-WebSocket-Accept"), acceptKey(key); got != want {
+		nc.Close()
+		return nil, fmt.Errorf("Sec-WebSocket-Accept %q want %q", got, want)
+	}
+	return &conn{nc: nc, br: br}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func (c *conn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.nc.Close()
+}
+
+// writeFrame writes one final, masked frame as required for client frames.
+func (c *conn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xffff:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return fmt.Errorf("generate mask: %w", err)
+	}
+	header = append(header, mask[:]...)
+	masked := make([]byte, len(payload))
+	for idx, b := range payload {
+		masked[idx] = b ^ mask[idx%4]
+	}
+	if _, err := c.nc.Write(append(header, masked...)); err != nil {
+		return fmt.Errorf("write frame: %w", err)
+	}
+	return nil
+}
+
+// readFrame reads one frame and returns its fin flag, opcode and payload.
+func (c *conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessageSize {
+		return false, 0, nil, fmt.Errorf("frame of %d bytes too large", n)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for idx := range payload {
+			payload[idx] ^= mask[idx%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// ReadMessage returns the next data message, answering pings on the way. It
+// returns ErrClosed once the server closes the connection.
+func (c *conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, fmt.Errorf("read frame: %w", err)
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, fmt.Errorf("pong: %w", err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, ErrClosed
+		case opText, opBinary, opContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > maxMessageSize {
+				return nil, fmt.Errorf("message of %d bytes too large", len(msg))
+			}
+		default:
+			return nil, fmt.Errorf("unknown opcode %#x", op)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// run dials the stream, whose name is case sensitive, and calls handle with every message until ctx is done, the
+// connection fails or handle returns an error.
+func run(ctx context.Context, streamName string, handle func(msg []byte) error) error {
+	rawURL := common.RootStreamEndPoint + "/ws/" + streamName
+	c, err := dial(ctx, rawURL)
+	if err != nil {
+		return fmt.Errorf("dial %q: %w", rawURL, err)
+	}
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.nc.Close() })
+	defer stop()
+
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		if err := handle(msg); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,402 @@
+// get_historical_klines_main downloads KLines into the price_data tree:
+//
+//	get_historical_klines_main download [-symbols XRPUSDT,BTCUSDT | -universe [filters]] [-intervals 5m,1h] [-start 2018-01-01] [-end now] [-out DIR] [-market futures] [-derive] [-workers 4] [-weight_limit N] [-dry_run] [-v]
+//	get_historical_klines_main daemon [download flags but -end] [-delay 3s] [-retry 1m] [-universe_refresh 24h]
+//	get_historical_klines_main derive [-symbols ...] [-from 5m] [-to 15m,1h] [-out DIR] [-market futures] [-workers 4] [-dry_run] [-v]
+//	get_historical_klines_main universe [-market futures] [filters]
+//
+// download fetches the KLines of every symbol and interval opening within
+// [start, end], resuming after the stored ones. Times are UTC dates such as
+// 2018-01-01, date times such as "2018-01-01 08:00", RFC 3339 or "now". With
+// -derive only the shortest interval is downloaded and the others are built
+// from it. KLines still open at the exchange's time are never stored.
+//
+// daemon keeps the series current: it catches up like download, then wakes
+// -delay after every KLine closes to download the newly closed ones. With
+// -universe it resolves the symbols again every -universe_refresh.
+//
+// derive builds the KLines of the -to intervals from the stored ones of the
+// -from interval.
+//
+// universe lists the symbols passing the filters, -quote_asset USDT,
+// -contract_type PERPETUAL, -status TRADING, -min_quote_volume 50e6,
+// -min_listing_age and -top, the most traded first. download -universe
+// downloads them instead of -symbols.
+//
+// The (symbol, interval) jobs run on -workers goroutines sharing one request
+// weight limiter. A failed job does not stop the others; the run ends with a
+// summary table of the bars stored, the gaps filled and the errors, and exits
+// with 1 when a job failed.
+//
+// Spot KLines go to their own tree, <price_data>/spot by default, as the
+// symbols clash with the contracts'.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/resample"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
+)
+
+const (
+	defaultRoot = "../../price_data"
+)
+
+var timeLayouts = []string{
+	time.DateOnly,
+	"2006-01-02 15:04",
+	time.DateTime,
+	time.RFC3339,
+}
+
+// parseTime parses a time flag, "now" being now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q, want \"now\" or one of %s", s, strings.Join(timeLayouts, ", "))
+}
+
+// parseSymbols splits a comma separated symbol flag, upper casing the symbols.
+func parseSymbols(s string) ([]string, error) {
+	var res []string
+	for _, symbol := range strings.Split(s, ",") {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" {
+			continue
+		}
+		if strings.IndexFunc(symbol, func(r rune) bool {
+			return (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+		}) >= 0 {
+			return nil, fmt.Errorf("invalid symbol %q", symbol)
+		}
+		if !slices.Contains(res, symbol) {
+			res = append(res, symbol)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no symbol given")
+	}
+	return res, nil
+}
+
+// parseIntervals splits a comma separated interval flag, shortest first.
+func parseIntervals(s string) ([]common.ListKLinesInterval, error) {
+	var res []common.ListKLinesInterval
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		interval, err := common.ParseInterval(name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(res, interval) {
+			res = append(res, interval)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no interval given")
+	}
+	slices.SortFunc(res, func(a, b common.ListKLinesInterval) int {
+		return slices.Index(common.Intervals(), a) - slices.Index(common.Intervals(), b)
+	})
+	return res, nil
+}
+
+// storeFlags are the flags of every subcommand choosing what to write where.
+type storeFlags struct {
+	symbols string
+	out     string
+	market  string
+	workers int
+	dryRun  bool
+	verbose bool
+}
+
+func (f *storeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.symbols, "symbols", "XRPUSDT", "comma separated symbols")
+	fs.StringVar(&f.out, "out", "", "price_data directory to write, defaults to "+defaultRoot+", or its spot folder for spot")
+	fs.StringVar(&f.market, "market", string(common.MarketType_FUTURES), "market of the symbols: futures or spot")
+	fs.IntVar(&f.workers, "workers", 4, "jobs run concurrently")
+	fs.BoolVar(&f.dryRun, "dry_run", false, "report what would be done without downloading or writing anything")
+	fs.BoolVar(&f.verbose, "v", false, "report every API call and gap")
+}
+
+// parseMarket validates a -market flag.
+func parseMarket(s string) (common.MarketType, error) {
+	market := common.MarketType(s)
+	if market != common.MarketType_FUTURES && market != common.MarketType_SPOT {
+		return "", fmt.Errorf("unknown market %q, want %s or %s", s, common.MarketType_FUTURES, common.MarketType_SPOT)
+	}
+	return market, nil
+}
+
+// downloaders returns a downloader per symbol sharing limiter, validating the
+// flags.
+func (f *storeFlags) downloaders(symbols []string, limiter *ratelimit.Limiter) ([]*downloader, error) {
+	market, err := parseMarket(f.market)
+	if err != nil {
+		return nil, err
+	}
+	if f.workers < 1 {
+		return nil, fmt.Errorf("-workers %d is not positive", f.workers)
+	}
+	out := f.out
+	if out == "" {
+		out = defaultRoot
+		if market == common.MarketType_SPOT {
+			out = filepath.Join(defaultRoot, "spot")
+		}
+	}
+	store := storage.NewCSVStore(out)
+	res := make([]*downloader, 0, len(symbols))
+	for _, symbol := range symbols {
+		res = append(res, &downloader{
+			store:   store,
+			limiter: limiter,
+			market:  market,
+			symbol:  symbol,
+			dryRun:  f.dryRun,
+			verbose: f.verbose,
+		})
+	}
+	return res, nil
+}
+
+// downloadFlags are the flags of the subcommands downloading KLines.
+type downloadFlags struct {
+	storeFlags
+	universe    universeFlags
+	intervals   string
+	start       string
+	derive      bool
+	weightLimit int
+}
+
+func (f *downloadFlags) register(fs *flag.FlagSet) {
+	f.storeFlags.register(fs)
+	f.universe.register(fs)
+	fs.StringVar(&f.intervals, "intervals", "5m,15m,1h,4h,12h,1d", "comma separated intervals")
+	fs.StringVar(&f.start, "start", "2018-01-01", "open time of the first KLine, UTC")
+	fs.BoolVar(&f.derive, "derive", false, "download the shortest interval only and build the others from it")
+	fs.IntVar(&f.weightLimit, "weight_limit", 0, "request weight used per minute at most, defaults to the market's limit")
+}
+
+// downloadPlan is what a downloading subcommand works on.
+type downloadPlan struct {
+	ds         []*downloader
+	intervals  []common.ListKLinesInterval // Shortest first.
+	downloaded []common.ListKLinesInterval // The others are derived from the first one.
+	startTime  time.Time
+	limiter    *ratelimit.Limiter
+	clock      *serverClock
+}
+
+// plan validates the flags, resolving the symbols with -universe.
+func (f *downloadFlags) plan(ctx context.Context) (*downloadPlan, error) {
+	market, err := parseMarket(f.market)
+	if err != nil {
+		return nil, err
+	}
+	if f.weightLimit <= 0 {
+		f.weightLimit = ratelimit.FuturesWeightLimit
+		if market == common.MarketType_SPOT {
+			f.weightLimit = ratelimit.SpotWeightLimit
+		}
+	}
+	p := &downloadPlan{limiter: ratelimit.New(f.weightLimit)}
+	p.clock = &serverClock{market: market, limiter: p.limiter}
+	if p.intervals, err = parseIntervals(f.intervals); err != nil {
+		return nil, err
+	}
+	if p.startTime, err = parseTime(f.start, time.Now()); err != nil {
+		return nil, fmt.Errorf("-start: %w", err)
+	}
+	p.downloaded = p.intervals
+	if f.derive {
+		p.downloaded = p.intervals[:1]
+		for _, interval := range p.intervals[1:] {
+			if err := resample.Validate(p.intervals[0], interval); err != nil {
+				return nil, fmt.Errorf("-derive: %w", err)
+			}
+		}
+	}
+	for _, interval := range p.downloaded {
+		if err := common.CheckAligned(interval, p.startTime); err != nil {
+			return nil, fmt.Errorf("-start: %w", err)
+		}
+	}
+	symbols, err := f.universe.symbols(ctx, market, f.symbols, p.limiter)
+	if err != nil {
+		return nil, err
+	}
+	if p.ds, err = f.downloaders(symbols, p.limiter); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// tasks returns a task per symbol and downloaded interval of intervals, which
+// derives the other intervals too.
+func (p *downloadPlan) tasks(endTime time.Time, intervals []common.ListKLinesInterval) [][]*job {
+	var tasks [][]*job
+	for _, d := range p.ds {
+		for _, interval := range intervals {
+			task := []*job{downloadJob(d, p.clock, p.startTime, endTime, interval)}
+			if len(p.downloaded) < len(p.intervals) {
+				for _, to := range p.intervals[1:] {
+					task = append(task, deriveJob(d, interval, to))
+				}
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+func download(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	var df downloadFlags
+	df.register(fs)
+	endFlag := fs.String("end", "now", "time the last KLine opens by, UTC")
+	fs.Parse(args)
+
+	p, err := df.plan(context.Background())
+	if err != nil {
+		return err
+	}
+	endTime, err := parseTime(*endFlag, time.Now())
+	if err != nil {
+		return fmt.Errorf("-end: %w", err)
+	}
+	if !p.startTime.Before(endTime) {
+		return fmt.Errorf("-start %v is not before -end %v", p.startTime, endTime)
+	}
+	// A dry run plans with the local clock, needing no network.
+	if !df.dryRun {
+		if err := p.clock.sync(context.Background()); err != nil {
+			return err
+		}
+	}
+	return runAndSummarize(p.tasks(endTime, p.downloaded), df.workers, p.limiter)
+}
+
+func downloadJob(d *downloader, clock *serverClock, startTime, endTime time.Time, interval common.ListKLinesInterval) *job {
+	return &job{d: d, interval: interval, run: func(ctx context.Context) (jobStats, error) {
+		d.debugf("Downloading %s %s\n", d.symbol, interval)
+		stats, err := d.downloadOneTimeFrame(ctx, startTime, endTime, clock.now(), interval)
+		if err != nil {
+			return stats, fmt.Errorf("downloadOneTimeFrame: %w", err)
+		}
+		return stats, nil
+	}}
+}
+
+func deriveJob(d *downloader, from, to common.ListKLinesInterval) *job {
+	return &job{d: d, interval: to, from: from, run: func(context.Context) (jobStats, error) {
+		stats, err := d.deriveTimeFrame(from, to)
+		if err != nil {
+			return stats, fmt.Errorf("deriveTimeFrame: %w", err)
+		}
+		return stats, nil
+	}}
+}
+
+// runAndSummarize runs the tasks until done or interrupted, then prints the
+// summary table.
+func runAndSummarize(tasks [][]*job, workers int, limiter *ratelimit.Limiter) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	results := runTasks(ctx, tasks, workers)
+	if failed := printSummary(results, time.Since(start), limiter.Waited()); failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(results))
+	}
+	return nil
+}
+
+func derive(args []string) error {
+	fs := flag.NewFlagSet("derive", flag.ExitOnError)
+	var sf storeFlags
+	sf.register(fs)
+	fromFlag := fs.String("from", "5m", "stored interval to build from")
+	toFlag := fs.String("to", "15m,1h,4h,12h,1d,1w", "comma separated intervals to build")
+	fs.Parse(args)
+
+	symbols, err := parseSymbols(sf.symbols)
+	if err != nil {
+		return err
+	}
+	ds, err := sf.downloaders(symbols, nil)
+	if err != nil {
+		return err
+	}
+	from, err := common.ParseInterval(*fromFlag)
+	if err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	to, err := parseIntervals(*toFlag)
+	if err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+	for _, interval := range to {
+		if err := resample.Validate(from, interval); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+	}
+
+	var tasks [][]*job
+	for _, d := range ds {
+		for _, interval := range to {
+			tasks = append(tasks, []*job{deriveJob(d, from, interval)})
+		}
+	}
+	return runAndSummarize(tasks, sf.workers, nil)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: get_historical_klines_main download|daemon|derive|universe [flags]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "download":
+		err = download(os.Args[2:])
+	case "derive":
+		err = derive(os.Args[2:])
+	case "daemon":
+		err = daemon(os.Args[2:])
+	case "universe":
+		err = listUniverse(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+)
+
+// serverClock follows the exchange's clock, which decides when KLines close and
+// may drift from the local one. It is the local clock until synced.
+type serverClock struct {
+	market  common.MarketType
+	limiter *ratelimit.Limiter
+	offset  time.Duration // Server time minus local time.
+}
+
+// sync measures the offset, assuming the server read its clock halfway through
+// the call.
+func (c *serverClock) sync(ctx context.Context) error {
+	start := time.Now()
+	t, err := klines.ServerTime(ctx, c.market, c.limiter)
+	if err != nil {
+		return fmt.Errorf("ServerTime: %w", err)
+	}
+	rtt := time.Since(start)
+	c.offset = t.Sub(start.Add(rtt / 2))
+	return nil
+}
+
+func (c *serverClock) now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+// sleep waits for d or until ctx is done, returning false in the latter case.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// refreshUniverse resolves the -universe symbols again, so the ones entering it
+// get downloaded and the ones leaving it no longer are. It reports whether the
+// symbols changed.
+func (p *downloadPlan) refreshUniverse(ctx context.Context, f *downloadFlags) (bool, error) {
+	market, err := parseMarket(f.market)
+	if err != nil {
+		return false, err
+	}
+	symbols, err := f.universe.symbols(ctx, market, f.symbols, p.limiter)
+	if err != nil {
+		return false, err
+	}
+	var kept []string
+	for _, d := range p.ds {
+		if slices.Contains(symbols, d.symbol) {
+			kept = append(kept, d.symbol)
+		}
+	}
+	if len(kept) == len(p.ds) && len(kept) == len(symbols) {
+		return false, nil
+	}
+	ds, err := f.downloaders(symbols, p.limiter)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Universe changed: %d symbols added, %d dropped\n", len(symbols)-len(kept), len(p.ds)-len(kept))
+	p.ds = ds
+	return true, nil
+}
+
+func daemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	var df downloadFlags
+	df.register(fs)
+	delay := fs.Duration("delay", 3*time.Second, "wait after a KLine closes before fetching it, giving the exchange time to publish it")
+	retry := fs.Duration("retry", time.Minute, "wait before retrying an interval whose update failed")
+	universeRefresh := fs.Duration("universe_refresh", 24*time.Hour, "with -universe, how often to resolve the symbols again")
+	fs.Parse(args)
+	if df.dryRun {
+		return fmt.Errorf("-dry_run is not supported, use download -dry_run to see what the first update does")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p, err := df.plan(ctx)
+	if err != nil {
+		return err
+	}
+	resolved := time.Now()
+	// Server time each downloaded interval is due at, i.e. the next KLine open
+	// time. Zero catches up first.
+	due := make(map[common.ListKLinesInterval]time.Time)
+	for ctx.Err() == nil {
+		if err := p.clock.sync(ctx); err != nil {
+			fmt.Printf("Sync server time: %v\n", err)
+			sleep(ctx, *retry)
+			continue
+		}
+		if df.universe.enabled && time.Since(resolved) >= *universeRefresh {
+			changed, err := p.refreshUniverse(ctx, &df)
+			if err != nil {
+				fmt.Printf("Refresh universe, keeping the %d symbols: %v\n", len(p.ds), err)
+			} else {
+				resolved = time.Now()
+			}
+			if changed {
+				clear(due) // Catch up on the symbols added.
+			}
+		}
+		now := p.clock.now()
+		var intervals []common.ListKLinesInterval
+		for _, interval := range p.downloaded {
+			if !now.Before(due[interval]) {
+				intervals = append(intervals, interval)
+			}
+		}
+		if len(intervals) > 0 {
+			start := time.Now()
+			results := runTasks(ctx, p.tasks(now, intervals), df.workers)
+			failed := make(map[common.ListKLinesInterval]bool)
+			for _, r := range results {
+				if r.Err == nil {
+					continue
+				}
+				if len(p.downloaded) < len(p.intervals) {
+					failed[p.downloaded[0]] = true // Derived from it.
+				} else {
+					failed[r.Interval] = true
+				}
+			}
+			if len(failed) > 0 || df.verbose {
+				printSummary(results, time.Since(start), p.limiter.Waited())
+			} else {
+				fmt.Printf("%s updated %v for %d symbols\n", now.UTC().Format(time.DateTime), intervals, len(p.ds))
+			}
+			for _, interval := range intervals {
+				due[interval] = common.NextOpenTime(interval, now)
+				if retryAt := now.Add(*retry); failed[interval] && retryAt.Before(due[interval]) {
+					due[interval] = retryAt
+				}
+			}
+		}
+
+		wake := due[p.downloaded[0]]
+		for _, interval := range p.downloaded[1:] {
+			if due[interval].Before(wake) {
+				wake = due[interval]
+			}
+		}
+		if df.verbose {
+			fmt.Printf("Sleeping until %s\n", wake.Add(*delay).UTC().Format(time.DateTime))
+		}
+		sleep(ctx, wake.Add(*delay).Sub(p.clock.now()))
+	}
+	return nil
+}
@@ -1,23 +1,23 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/csv"
 	"errors"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
 	"github.com/Makoto2024/BinanceTrader/BinanceAPI/klines"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/resample"
 	"github.com/Makoto2024/BinanceTrader/BinanceAPI/storage"
 )
 
 const (
-	tickerSymbol = "XRPUSDT"
+	// maxRateLimitedRetries is how many times a call rate limited by the exchange
+	// is retried once the limiter's pause is over.
+	maxRateLimitedRetries = 5
 )
 
 var (
@@ -33,22 +33,25 @@ func formatTime(t time.Time) string {
 type KLineCollector struct {
 	NextOpenTime time.Time
 	LastOpenTime time.Time
-	Interval     time.Duration
+	Interval     common.ListKLinesInterval
 	LastKLine    *klines.KLine // For checking the consecutiveness.
+	Limit        uint32        // KLines per API call, ListKLinesMaxLimit when 0.
 }
 
-// Collect all KLines within [from, to] inclusively.
-func NewKLineCollector(from, to time.Time, dur time.Duration) *KLineCollector {
+// Collect all KLines opening within [from, to] inclusively. from must be the open
+// time of a KLine of the interval.
+func NewKLineCollector(from, to time.Time, interval common.ListKLinesInterval) (*KLineCollector, error) {
+	if err := common.CheckAligned(interval, from); err != nil {
+		return nil, fmt.Errorf("start time: %w", err)
+	}
 	if from.After(to) {
 		to = from
 	}
-	// Find the biggest openTime within [from, to] such that it is "from + N * dur".
-	expectKLineNum := (to.UnixMilli()-from.UnixMilli())/dur.Milliseconds() + 1
 	return &KLineCollector{
 		NextOpenTime: from,
-		LastOpenTime: time.UnixMilli(from.UnixMilli() + (expectKLineNum-1)*dur.Milliseconds()),
-		Interval:     dur,
-	}
+		LastOpenTime: common.AlignDown(interval, to),
+		Interval:     interval,
+	}, nil
 }
 
 // Whether all expected KLines are retrieved.
@@ -61,8 +64,7 @@ func (c *KLineCollector) KLinesLeft() uint64 {
 	if c.Finished() {
 		return 0
 	}
-	spanMS := c.LastOpenTime.Sub(c.NextOpenTime).Milliseconds()
-	return uint64(spanMS/c.Interval.Milliseconds() + 1)
+	return uint64(common.BarsBetween(c.Interval, c.NextOpenTime, c.LastOpenTime))
 }
 
 func (c *KLineCollector) isNextKLine(l *klines.KLine) bool {
@@ -76,7 +78,7 @@ func (c *KLineCollector) StoreKLine(l *klines.KLine) error {
 			formatTime(c.LastOpenTime), ErrAllStoreFinished)
 	}
 	if c.isNextKLine(l) {
-		c.NextOpenTime = c.NextOpenTime.Add(c.Interval)
+		c.NextOpenTime = common.NextOpenTime(c.Interval, c.NextOpenTime)
 		c.LastKLine = l
 		return nil
 	}
@@ -91,11 +93,16 @@ func (c *KLineCollector) StoreKLine(l *klines.KLine) error {
 }
 
 func (c *KLineCollector) NextNextAPIStartTime() time.Time {
-	nextAPIKLineNum := uint64(c.KLinesLeft())
-	if nextAPIKLineNum > uint64(klines.ListKLinesMaxLimit) {
-		nextAPIKLineNum = uint64(klines.ListKLinesMaxLimit)
+	limit := c.Limit
+	if limit == 0 {
+		limit = klines.ListKLinesMaxLimit
 	}
-	return c.NextOpenTime.Add(time.Duration(nextAPIKLineNum) * c.Interval)
+	nextAPIKLineNum := min(c.KLinesLeft(), uint64(limit))
+	t := c.NextOpenTime
+	for range nextAPIKLineNum {
+		t = common.NextOpenTime(c.Interval, t)
+	}
+	return t
 }
 
 // Return the next startTime, endTime API parameters to retrieve the following KLines.
@@ -103,182 +110,114 @@ func (c *KLineCollector) NextAPIStartEndTime() (startTime, endTime time.Time) {
 	return c.NextOpenTime, c.NextNextAPIStartTime().Add(-time.Millisecond)
 }
 
-func createNewCSV(path string) error {
-	tmpPath := fmt.Sprintf("%s_tmp", path)
-	fp, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("create file: %w", err)
-	}
-	w := csv.NewWriter(fp)
-	if err := w.Write(storage.KLineCSVHeader); err != nil {
-		return fmt.Errorf("write header: %w", err)
-	}
-	w.Flush()
-	fp.Close()
-
-	// Rename the file.
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("rename %q to %q: %w", tmpPath, path, err)
-	}
-	return nil
+// downloader keeps the KLines of one symbol of a market up to date in a store.
+// The downloaders of a run share the store and the limiter.
+type downloader struct {
+	store   storage.FileStore
+	limiter *ratelimit.Limiter
+	market  common.MarketType
+	symbol  string
+	dryRun  bool // Report what would be downloaded or derived, writing nothing.
+	verbose bool // Report every API call.
 }
 
-// When continuing from last download, the last CSV record might not be
-// a multiple of the specified inteval. For example, when downloading
-// 1h KLines, the last download might stop at 23:30, which should be 24:00 instead.
-func abandonLastCSVRecord(csvPath string) error {
-	// Open the file for reading and writing.  We need read to count lines, and write to truncate.
-	file, err := os.OpenFile(csvPath, os.O_RDWR, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-	var lastLine string
-	var totalBytes int64
+// jobStats counts the KLines a job stored.
+type jobStats struct {
+	Bars   int // Downloaded or derived.
+	Filled int // Synthetic, filling gaps.
+}
 
-	// Iterate through the file to count offset of the beginning of the last line.
-	for {
-		line, err := reader.ReadString('\n')
-		if len(line) > 0 {
-			totalBytes += int64(len(line))
-			lastLine = line
-		}
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return fmt.Errorf("ReadString('\n'): %w", err)
+// listKLines calls the API, retrying when the exchange rate limits it.
+func (d *downloader) listKLines(ctx context.Context, param klines.ListKLinesParam) ([]klines.KLine, error) {
+	for retry := 0; ; retry++ {
+		lines, err := klines.ListKLines(ctx, param)
+		if err == nil || !errors.Is(err, ratelimit.ErrRateLimited) || retry == maxRateLimitedRetries {
+			return lines, err
 		}
+		d.debugf("%s %s: %v, retrying\n", d.symbol, param.Interval, err)
 	}
-
-	bytesBeforeLastLine := totalBytes - int64(len(lastLine))
-	if bytesBeforeLastLine == 0 {
-		return nil // Empty file, nothing to do.
-	}
-
-	// Truncate the file to the offset of the second to last line.
-	if err = file.Truncate(bytesBeforeLastLine); err != nil {
-		return fmt.Errorf("Truncate(%d): %w", bytesBeforeLastLine, err)
-	}
-	return nil
 }
 
-func continueCollectFromCSV(
-	startTime, endTime time.Time,
-	interval common.ListKLinesInterval, path string,
-) (*KLineCollector, error) {
-	intervalDuration := common.IntervalDuration(interval)
-	if _, err := os.Stat(path); err != nil {
-		if !os.IsNotExist(err) {
-			return nil, fmt.Errorf("stat file: %w", err)
-		}
-		// Create file and start from the beggining.
-		if err := createNewCSV(path); err != nil {
-			return nil, fmt.Errorf("createNewCSV: %w", err)
-		}
-		return NewKLineCollector(startTime, endTime, intervalDuration), nil
+func (d *downloader) debugf(format string, args ...any) {
+	if d.verbose {
+		fmt.Printf(format, args...)
 	}
+}
 
-	// CSV exists, check if all stored data are valid (consecutive) and return a
-	// collector with the continuing state.
-	if err := abandonLastCSVRecord(path); err != nil {
-		return nil, fmt.Errorf("abandonLastCSVRecord: %w", err)
+// continueCollect returns a collector resuming after the last stored KLine. Only
+// the tail of the series is read; the verify command checks the stored KLines
+// are consecutive.
+func (d *downloader) continueCollect(startTime, endTime time.Time, interval common.ListKLinesInterval) (*KLineCollector, error) {
+	c, err := NewKLineCollector(startTime, endTime, interval)
+	if err != nil {
+		return nil, fmt.Errorf("NewKLineCollector: %w", err)
 	}
-
-	fp, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	c.Limit = klines.MaxLimit(d.market)
+	last, ok, err := d.store.Last(d.symbol, interval)
 	if err != nil {
-		return nil, fmt.Errorf("open file: %w", err)
+		return nil, fmt.Errorf("Last: %w", err)
 	}
-	defer fp.Close()
-	r := csv.NewReader(fp)
-	c := NewKLineCollector(startTime, endTime, intervalDuration)
-
-	recordNum := -1 // Start from -1 because the first line is header hence not counted.
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("read one CSV record: %w", err)
-		}
-		recordNum++
-		if recordNum == 0 { // Skip parsing the first line, since it's the CSV header.
-			continue
-		}
-		currKLine := &klines.KLine{}
-		if err := storage.KLineFromCSVRecord(record, currKLine); err != nil {
-			return nil, fmt.Errorf("KLineFromCSVRecord(%v): %w", record, err)
-		}
-		if err := c.StoreKLine(currKLine); err != nil {
-			return nil, fmt.Errorf("StoreKLine(%+v): %w", currKLine, err)
-		}
+	if ok {
+		c.NextOpenTime = common.NextOpenTime(interval, last.OpenTime)
+		c.LastKLine = &last
 	}
 	return c, nil
 }
 
-func downloadOneTimeFrame(
-	ctx context.Context,
-	startTime, endTime time.Time,
-	interval common.ListKLinesInterval,
-) error {
-	intervalDuration := common.IntervalDuration(interval)
-
-	// Create data folder for the symbol is absent.
-	csvDir := filepath.Join("../../price_data", tickerSymbol)
-	if _, err := os.Stat(csvDir); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("stat folder(%q): %w", csvDir, err)
-		}
-		// Create file and start from the beggining.
-		if err := os.MkdirAll(csvDir, 0755); err != nil {
-			return fmt.Errorf("create folder(%q): %w", csvDir, err)
-		}
+// downloadOneTimeFrame downloads the KLines opening within [startTime, endTime]
+// that are closed at now, the exchange's time. KLines still open are never
+// stored, so a later run resumes right after the stored ones.
+func (d *downloader) downloadOneTimeFrame(ctx context.Context, startTime, endTime, now time.Time, interval common.ListKLinesInterval) (jobStats, error) {
+	var stats jobStats
+	// The KLine containing now is still open; stop right before it.
+	if beforeOpen := common.AlignDown(interval, now).Add(-time.Millisecond); beforeOpen.Before(endTime) {
+		endTime = beforeOpen
 	}
-	csvPath := filepath.Join(csvDir, fmt.Sprintf("%s_%s.csv", tickerSymbol, interval))
-
-	// Get all 5m KLines.
-	c, err := continueCollectFromCSV(startTime, endTime, interval, csvPath)
+	if endTime.Before(startTime) {
+		return stats, nil
+	}
+	c, err := d.continueCollect(startTime, endTime, interval)
 	if err != nil {
-		return fmt.Errorf("continueCollectFromCSV(%q): %w", csvPath, err)
+		return stats, fmt.Errorf("continueCollect(%s): %w", interval, err)
 	}
 	hasRecordInCSV := c.LastKLine != nil
-
-	fp, err := os.OpenFile(csvPath, os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return fmt.Errorf("OpenFile(%q): %w", csvPath, err)
+	if d.dryRun {
+		left := c.KLinesLeft()
+		limit := uint64(c.Limit)
+		fmt.Printf("%s %s: would download up to %d KLines from %q in %d API calls\n",
+			d.symbol, interval, left, formatTime(c.NextOpenTime), (left+limit-1)/limit)
+		return stats, nil
 	}
-	defer fp.Close()
-	writer := csv.NewWriter(fp)
-	defer writer.Flush()
 
 	for !c.Finished() {
 		apiStartTime, apiEndTime := c.NextAPIStartEndTime()
-		fmt.Printf("API %q -> %q\n", formatTime(apiStartTime), formatTime(apiEndTime))
-		lines, err := klines.ListKLines(ctx, klines.ListKLinesParam{
-			TickerSymbol: tickerSymbol,
+		d.debugf("API %q -> %q\n", formatTime(apiStartTime), formatTime(apiEndTime))
+		lines, err := d.listKLines(ctx, klines.ListKLinesParam{
+			TickerSymbol: d.symbol,
 			Interval:     interval,
 			StartTime:    apiStartTime,
 			EndTime:      apiEndTime,
+			Market:       d.market,
+			Limiter:      d.limiter,
 		})
 		if err != nil {
-			return fmt.Errorf("ListKLines: %w", err)
+			return stats, fmt.Errorf("ListKLines: %w", err)
 		}
 		if len(lines) == 0 {
 			if hasRecordInCSV {
-				fmt.Printf("No new KLines starting from time %v", apiStartTime)
+				d.debugf("No new KLines starting from time %v\n", apiStartTime)
 				break
 			}
 			// Because the ticker does not exist at this time, jump to the next
 			// possible start time.
 			c.NextOpenTime = c.NextNextAPIStartTime()
-			fmt.Printf("No historical KLine between %q ~ %q, skip to %q",
+			d.debugf("No historical KLine between %q ~ %q, skip to %q\n",
 				formatTime(apiStartTime), formatTime(apiEndTime), formatTime(c.NextOpenTime))
 			continue
 		}
+		var toStore []klines.KLine
 	LOOP:
-		// Update the collector's time and write to CSV.
+		// Update the collector's time and collect what to store.
 		for idx := range lines {
 			line := &lines[idx]
 			for {
@@ -289,49 +228,105 @@ func downloadOneTimeFrame(
 				} else if errors.Is(err, ErrAllStoreFinished) {
 					break LOOP
 				} else if errors.Is(err, ErrNotConsecutive) {
-					lineToWrite = &klines.KLine{}
-					*lineToWrite = *c.LastKLine
-					lineToWrite.OpenTime = c.NextOpenTime
-					lineToWrite.CloseTime = c.NextOpenTime.Add(intervalDuration).
-						Add(-time.Millisecond)
-					fmt.Printf("Expect open time %q but got %q, fill with previous line instead\n",
+					lineToWrite = syntheticKLine(c.LastKLine, c.NextOpenTime, interval)
+					d.debugf("Expect open time %q but got %q, fill with a synthetic line instead\n",
 						formatTime(lineToWrite.OpenTime), formatTime(line.OpenTime))
 					if newErr := c.StoreKLine(lineToWrite); newErr != nil {
-						return fmt.Errorf("recovering %v but failed: %w", err, newErr)
+						return stats, fmt.Errorf("recovering %v but failed: %w", err, newErr)
 					}
+					stats.Filled++
 				} else {
-					return fmt.Errorf("StoreKLine(%+v): %w", line, err)
-				}
-				// Commit to CSV file.
-				if err := writer.Write(storage.KLineToCSVRecord(lineToWrite)); err != nil {
-					return fmt.Errorf("KLineToCSVRecord(%+v): %w", lineToWrite, err)
+					return stats, fmt.Errorf("StoreKLine(%+v): %w", line, err)
 				}
+				toStore = append(toStore, *lineToWrite)
 				if lineToWrite == line {
 					break
 				}
 			}
 		}
+		if err := d.store.Append(d.symbol, interval, toStore); err != nil {
+			return stats, fmt.Errorf("Append: %w", err)
+		}
+		stats.Bars += len(toStore)
+		if slices.ContainsFunc(toStore, func(l klines.KLine) bool { return l.Synthetic }) {
+			if err := d.saveGaps(interval, toStore); err != nil {
+				return stats, err
+			}
+		}
 	}
-	return nil
+	fmt.Printf("%s %s: downloaded %d KLines\n", d.symbol, interval, stats.Bars)
+	return stats, nil
 }
 
-func main() {
-	ctx := context.Background()
+// syntheticKLine fills the missing KLine opening at openTime with a flat bar at
+// prev's close. It has no volume so volume features are not skewed by it.
+func syntheticKLine(prev *klines.KLine, openTime time.Time, interval common.ListKLinesInterval) *klines.KLine {
+	return &klines.KLine{
+		OpenTime:   openTime,
+		CloseTime:  common.NextOpenTime(interval, openTime).Add(-time.Millisecond),
+		OpenPrice:  prev.ClosePrice,
+		ClosePrice: prev.ClosePrice,
+		HighPrice:  prev.ClosePrice,
+		LowPrice:   prev.ClosePrice,
+		Synthetic:  true,
+	}
+}
 
-	startTime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
-	endTime := time.Date(2025, 3, 23, 0, 0, 0, 0, time.UTC)
+// saveGaps records the runs of synthetic KLines among the appended bars in the
+// sidecar next to the stored series so analytics can leave them out.
+func (d *downloader) saveGaps(interval common.ListKLinesInterval, appended []klines.KLine) error {
+	if err := storage.UpdateKLineGaps(d.store.Root(), d.symbol, interval, appended); err != nil {
+		return fmt.Errorf("UpdateKLineGaps: %w", err)
+	}
+	for _, g := range storage.FindKLineGaps(appended) {
+		d.debugf("Gap of %d %s KLines: %q ~ %q\n", g.Bars, interval, formatTime(g.From), formatTime(g.To))
+	}
+	return nil
+}
 
-	for _, interval := range []common.ListKLinesInterval{
-		common.ListKLinesInterval_5m,
-		common.ListKLinesInterval_15m,
-		common.ListKLinesInterval_1h,
-		common.ListKLinesInterval_4h,
-		common.ListKLinesInterval_12h,
-		common.ListKLinesInterval_1d,
-	} {
-		fmt.Printf("\n\nDownloading time frame %s\n", interval)
-		if err := downloadOneTimeFrame(ctx, startTime, endTime, interval); err != nil {
-			panic(fmt.Errorf("downloadOneTimeFrame(%v) failed with err %v", interval, err))
+// deriveTimeFrame builds the KLines of interval to from the stored ones of the
+// finer interval from rather than downloading them. Only the bars from the last
+// stored one on are rebuilt, as it may have been derived before it closed.
+func (d *downloader) deriveTimeFrame(from, to common.ListKLinesInterval) (jobStats, error) {
+	var stats jobStats
+	last, ok, err := d.store.Last(d.symbol, to)
+	if err != nil {
+		return stats, fmt.Errorf("Last: %w", err)
+	}
+	var since time.Time
+	if ok {
+		since = last.OpenTime
+	}
+	bars, err := d.store.Range(d.symbol, from, since, time.Time{})
+	if err != nil {
+		return stats, fmt.Errorf("Range: %w", err)
+	}
+	derived, err := resample.Resample(bars, from, to)
+	if err != nil {
+		return stats, fmt.Errorf("Resample: %w", err)
+	}
+	if len(derived) == 0 {
+		fmt.Printf("%s %s: no new KLines to derive from %s\n", d.symbol, to, from)
+		return stats, nil
+	}
+	verb := "derived"
+	if d.dryRun {
+		verb = "would derive"
+	}
+	fmt.Printf("%s %s: %s %d KLines from %s, %q -> %q\n", d.symbol, to, verb, len(derived), from,
+		formatTime(derived[0].OpenTime), formatTime(derived[len(derived)-1].OpenTime))
+	if d.dryRun {
+		return stats, nil
+	}
+	if err := d.store.Append(d.symbol, to, derived); err != nil {
+		return stats, fmt.Errorf("Append: %w", err)
+	}
+	stats.Bars = len(derived)
+	for idx := range derived {
+		if derived[idx].Synthetic {
+			stats.Filled++
 		}
 	}
+	// The rebuilt bars may have been synthetic before.
+	return stats, d.saveGaps(to, derived)
 }
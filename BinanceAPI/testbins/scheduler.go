@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+)
+
+// job downloads or derives the KLines of one interval of a symbol.
+type job struct {
+	d        *downloader
+	interval common.ListKLinesInterval
+	// from is the stored interval to derive from. The job downloads when empty.
+	from common.ListKLinesInterval
+	run  func(ctx context.Context) (jobStats, error)
+}
+
+func (j *job) source() string {
+	if j.from == "" {
+		return "download"
+	}
+	return "from " + string(j.from)
+}
+
+// jobResult is a row of the summary table.
+type jobResult struct {
+	Symbol   string
+	Interval common.ListKLinesInterval
+	Source   string
+	Stats    jobStats
+	Elapsed  time.Duration
+	Err      error
+}
+
+// runJob runs j, turning a panic into its error so the other jobs carry on.
+func runJob(ctx context.Context, j *job) (stats jobStats, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	return j.run(ctx)
+}
+
+// runTasks runs the tasks on workers goroutines. The jobs of a task run in order
+// on one worker, the ones after a failed job being skipped, e.g. deriving from
+// an interval whose download failed. The results are in the order of the jobs.
+func runTasks(ctx context.Context, tasks [][]*job, workers int) []jobResult {
+	var offsets []int
+	n := 0
+	for _, task := range tasks {
+		offsets = append(offsets, n)
+		n += len(task)
+	}
+	results := make([]jobResult, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				var failed *jobResult
+				for jdx, j := range tasks[idx] {
+					res := &results[offsets[idx]+jdx]
+					*res = jobResult{Symbol: j.d.symbol, Interval: j.interval, Source: j.source()}
+					if failed != nil {
+						res.Err = fmt.Errorf("skipped as %s %s failed", failed.Interval, failed.Source)
+						continue
+					}
+					start := time.Now()
+					res.Stats, res.Err = runJob(ctx, j)
+					res.Elapsed = time.Since(start)
+					if res.Err != nil {
+						fmt.Printf("%s %s: %v\n", res.Symbol, res.Interval, res.Err)
+						failed = res
+					}
+				}
+			}
+		}()
+	}
+	for idx := range tasks {
+		next <- idx
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// printSummary prints a row per job and the totals, returning how many failed.
+func printSummary(results []jobResult, elapsed, throttled time.Duration) int {
+	fmt.Printf("\n%-12s %-8s %-10s %10s %8s %10s  %s\n", "SYMBOL", "INTERVAL", "SOURCE", "BARS", "FILLED", "TIME", "ERROR")
+	var total jobStats
+	failed := 0
+	for _, r := range results {
+		errText := "-"
+		if r.Err != nil {
+			errText = r.Err.Error()
+			failed++
+		}
+		fmt.Printf("%-12s %-8s %-10s %10d %8d %10v  %s\n", r.Symbol, r.Interval, r.Source,
+			r.Stats.Bars, r.Stats.Filled, r.Elapsed.Round(time.Millisecond), errText)
+		total.Bars += r.Stats.Bars
+		total.Filled += r.Stats.Filled
+	}
+	fmt.Printf("%d jobs, %d failed, %d bars with %d filled in %v, throttled for %v\n",
+		len(results), failed, total.Bars, total.Filled, elapsed.Round(time.Millisecond), throttled.Round(time.Millisecond))
+	return failed
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/universe"
+)
+
+// universeFlags select the symbols from the exchange rather than by name.
+type universeFlags struct {
+	enabled bool
+	filter  universe.Filter
+}
+
+func (f *universeFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.enabled, "universe", false, "download the symbols passing the universe filters instead of -symbols")
+	f.registerFilter(fs)
+}
+
+func (f *universeFlags) registerFilter(fs *flag.FlagSet) {
+	fs.StringVar(&f.filter.QuoteAsset, "quote_asset", "USDT", "quote asset of the symbols, any when empty")
+	fs.StringVar(&f.filter.ContractType, "contract_type", universe.ContractType_PERPETUAL, "contract type of the futures symbols, any when empty")
+	fs.StringVar(&f.filter.Status, "status", universe.Status_TRADING, "status of the symbols, any when empty")
+	fs.Float64Var(&f.filter.MinQuoteVolume, "min_quote_volume", 50e6, "24 hours volume in the quote asset at least")
+	fs.DurationVar(&f.filter.MinListingAge, "min_listing_age", 0, "time since listing at least, e.g. 720h")
+	fs.IntVar(&f.filter.Top, "top", 0, "keep the most traded symbols only, all when 0")
+}
+
+// resolve returns the symbols of the market passing the filters.
+func (f *universeFlags) resolve(ctx context.Context, market common.MarketType, limiter *ratelimit.Limiter) ([]universe.Member, error) {
+	filter := f.filter
+	if market == common.MarketType_SPOT {
+		filter.ContractType = "" // Spot symbols have none.
+	}
+	members, err := universe.Resolve(ctx, market, filter, limiter)
+	if err != nil {
+		return nil, fmt.Errorf("universe.Resolve: %w", err)
+	}
+	return members, nil
+}
+
+// symbols returns the symbols to download: the ones passing the filters with
+// -universe, else the -symbols flag.
+func (f *universeFlags) symbols(ctx context.Context, market common.MarketType, symbolsFlag string, limiter *ratelimit.Limiter) ([]string, error) {
+	if !f.enabled {
+		return parseSymbols(symbolsFlag)
+	}
+	// The downloader gets the continuous KLines of perpetual contracts by pair.
+	if market == common.MarketType_FUTURES && f.filter.ContractType != universe.ContractType_PERPETUAL {
+		return nil, fmt.Errorf("-contract_type %q, only %s contracts can be downloaded", f.filter.ContractType, universe.ContractType_PERPETUAL)
+	}
+	members, err := f.resolve(ctx, market, limiter)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no symbol passes the universe filters")
+	}
+	symbols := make([]string, len(members))
+	for idx := range members {
+		symbols[idx] = members[idx].Pair
+	}
+	fmt.Printf("Universe of %d symbols: %v\n", len(symbols), symbols)
+	return symbols, nil
+}
+
+func listUniverse(args []string) error {
+	fs := flag.NewFlagSet("universe", flag.ExitOnError)
+	market := fs.String("market", string(common.MarketType_FUTURES), "market of the symbols: futures or spot")
+	var uf universeFlags
+	uf.registerFilter(fs)
+	fs.Parse(args)
+	m, err := parseMarket(*market)
+	if err != nil {
+		return err
+	}
+
+	members, err := uf.resolve(context.Background(), m, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-16s %-10s %-6s %18s  %s\n", "SYMBOL", "BASE", "QUOTE", "QUOTE_VOLUME_24H", "LISTED")
+	for _, mb := range members {
+		listed := "-"
+		if !mb.OnboardDate.IsZero() {
+			listed = mb.OnboardDate.UTC().Format(time.DateOnly)
+		}
+		fmt.Printf("%-16s %-10s %-6s %18.0f  %s\n", mb.Symbol, mb.BaseAsset, mb.QuoteAsset, mb.QuoteVolume, listed)
+	}
+	fmt.Printf("%d symbols\n", len(members))
+	return nil
+}
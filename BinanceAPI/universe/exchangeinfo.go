@@ -0,0 +1,79 @@
+package universe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+)
+
+const (
+	ContractType_PERPETUAL = "PERPETUAL"
+	Status_TRADING         = "TRADING"
+)
+
+// SymbolInfo is a symbol listed in the exchange info.
+type SymbolInfo struct {
+	Symbol       string
+	Pair         string // The symbol for spot. Perpetual contracts share it too.
+	BaseAsset    string
+	QuoteAsset   string
+	ContractType string    // PERPETUAL, CURRENT_QUARTER, ... Empty for spot.
+	Status       string    // TRADING, SETTLING, ...
+	OnboardDate  time.Time // Zero for spot, which does not report it.
+}
+
+// GetExchangeInfo returns the symbols listed in the market. limiter may be nil.
+func GetExchangeInfo(ctx context.Context, market common.MarketType, limiter *ratelimit.Limiter) ([]SymbolInfo, error) {
+	apiURL, weight := common.RootAPIEndPoint+"/fapi/v1/exchangeInfo", 1
+	if market == common.MarketType_SPOT {
+		apiURL, weight = common.SpotRootAPIEndPoint+"/api/v3/exchangeInfo", 20
+	}
+	body, err := get(ctx, apiURL, weight, limiter)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseExchangeInfoRsp(body)
+}
+
+type exchangeInfoRsp struct {
+	Symbols []struct {
+		Symbol       string `json:"symbol"`
+		Pair         string `json:"pair"`
+		BaseAsset    string `json:"baseAsset"`
+		QuoteAsset   string `json:"quoteAsset"`
+		ContractType string `json:"contractType"`
+		Status       string `json:"status"`
+		OnboardDate  int64  `json:"onboardDate"`
+	} `json:"symbols"`
+}
+
+func parseExchangeInfoRsp(body io.Reader) ([]SymbolInfo, error) {
+	var rsp exchangeInfoRsp
+	if err := json.NewDecoder(body).Decode(&rsp); err != nil {
+		return nil, fmt.Errorf("json decoder decode: %w", err)
+	}
+	infos := make([]SymbolInfo, len(rsp.Symbols))
+	for idx, s := range rsp.Symbols {
+		infos[idx] = SymbolInfo{
+			Symbol:       s.Symbol,
+			Pair:         s.Pair,
+			BaseAsset:    s.BaseAsset,
+			QuoteAsset:   s.QuoteAsset,
+			ContractType: s.ContractType,
+			Status:       s.Status,
+		}
+		if infos[idx].Pair == "" {
+			infos[idx].Pair = s.Symbol
+		}
+		if s.OnboardDate != 0 {
+			infos[idx].OnboardDate = time.UnixMilli(s.OnboardDate)
+		}
+	}
+	return infos, nil
+}
@@ -0,0 +1,67 @@
+package universe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+)
+
+// Ticker24h is the rolling 24 hours statistics of a symbol.
+type Ticker24h struct {
+	Symbol      string
+	LastPrice   float64
+	Volume      float64 // Number of BTC when referring to BTC/USDT.
+	QuoteVolume float64 // Number of USDT when referring to BTC/USDT.
+	TradeNum    int64
+}
+
+// List24hTickers returns the 24 hours statistics of every symbol of the market.
+// limiter may be nil.
+func List24hTickers(ctx context.Context, market common.MarketType, limiter *ratelimit.Limiter) ([]Ticker24h, error) {
+	apiURL, weight := common.RootAPIEndPoint+"/fapi/v1/ticker/24hr", 40
+	if market == common.MarketType_SPOT {
+		apiURL, weight = common.SpotRootAPIEndPoint+"/api/v3/ticker/24hr", 80
+	}
+	body, err := get(ctx, apiURL, weight, limiter)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseList24hTickersRsp(body)
+}
+
+type ticker24hEntry struct {
+	Symbol      string `json:"symbol"`
+	LastPrice   string `json:"lastPrice"`
+	Volume      string `json:"volume"`
+	QuoteVolume string `json:"quoteVolume"`
+	Count       int64  `json:"count"`
+}
+
+func parseList24hTickersRsp(body io.Reader) ([]Ticker24h, error) {
+	var entries []ticker24hEntry
+	if err := json.NewDecoder(body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("json decoder decode: %w", err)
+	}
+	tickers := make([]Ticker24h, len(entries))
+	for idx, entry := range entries {
+		t := &tickers[idx]
+		t.Symbol = entry.Symbol
+		t.TradeNum = entry.Count
+		var err error
+		if t.LastPrice, err = common.ParseFloat64FromAnyString(entry.LastPrice); err != nil {
+			return nil, fmt.Errorf("parse last price field (entry: %+v): %w", entry, err)
+		}
+		if t.Volume, err = common.ParseFloat64FromAnyString(entry.Volume); err != nil {
+			return nil, fmt.Errorf("parse volume field (entry: %+v): %w", entry, err)
+		}
+		if t.QuoteVolume, err = common.ParseFloat64FromAnyString(entry.QuoteVolume); err != nil {
+			return nil, fmt.Errorf("parse quote volume field (entry: %+v): %w", entry, err)
+		}
+	}
+	return tickers, nil
+}
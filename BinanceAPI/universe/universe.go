@@ -0,0 +1,113 @@
+// Package universe resolves the symbols worth tracking, e.g. every USDT
+// perpetual trading more than $50M a day, from the exchange info and the 24
+// hours ticker statistics.
+package universe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/common"
+	"github.com/Makoto2024/BinanceTrader/BinanceAPI/ratelimit"
+)
+
+// Filter selects symbols. A zero value field disables the corresponding check.
+type Filter struct {
+	QuoteAsset     string        // E.g. USDT.
+	ContractType   string        // E.g. PERPETUAL. Spot symbols have none.
+	Status         string        // E.g. TRADING.
+	MinQuoteVolume float64       // Over the last 24 hours, in the quote asset.
+	MinListingAge  time.Duration // Symbols with no listing date, i.e. spot ones, pass.
+	Top            int           // Keeps the symbols with the most quote volume only.
+}
+
+// Member is a symbol selected by a Filter.
+type Member struct {
+	SymbolInfo
+	QuoteVolume float64 // Over the last 24 hours, 0 when the ticker is missing.
+}
+
+// Select returns the symbols of infos passing f at now, the one with the most
+// quote volume first.
+func Select(infos []SymbolInfo, tickers []Ticker24h, f Filter, now time.Time) []Member {
+	quoteVolumes := make(map[string]float64, len(tickers))
+	for _, t := range tickers {
+		quoteVolumes[t.Symbol] = t.QuoteVolume
+	}
+	var res []Member
+	for _, info := range infos {
+		m := Member{SymbolInfo: info, QuoteVolume: quoteVolumes[info.Symbol]}
+		if (f.QuoteAsset != "" && info.QuoteAsset != f.QuoteAsset) ||
+			(f.ContractType != "" && info.ContractType != f.ContractType) ||
+			(f.Status != "" && info.Status != f.Status) ||
+			m.QuoteVolume < f.MinQuoteVolume ||
+			(!info.OnboardDate.IsZero() && now.Sub(info.OnboardDate) < f.MinListingAge) {
+			continue
+		}
+		res = append(res, m)
+	}
+	slices.SortStableFunc(res, func(a, b Member) int {
+		switch {
+		case a.QuoteVolume > b.QuoteVolume:
+			return -1
+		case a.QuoteVolume < b.QuoteVolume:
+			return 1
+		}
+		return 0
+	})
+	if f.Top > 0 && len(res) > f.Top {
+		res = res[:f.Top]
+	}
+	return res
+}
+
+// Resolve fetches the exchange info and tickers of the market and returns the
+// symbols passing f. limiter may be nil.
+func Resolve(ctx context.Context, market common.MarketType, f Filter, limiter *ratelimit.Limiter) ([]Member, error) {
+	infos, err := GetExchangeInfo(ctx, market, limiter)
+	if err != nil {
+		return nil, fmt.Errorf("GetExchangeInfo: %w", err)
+	}
+	tickers, err := List24hTickers(ctx, market, limiter)
+	if err != nil {
+		return nil, fmt.Errorf("List24hTickers: %w", err)
+	}
+	return Select(infos, tickers, f, time.Now()), nil
+}
+
+// get returns the body of a GET of apiURL, which weighs weight.
+func get(ctx context.Context, apiURL string, weight int, limiter *ratelimit.Limiter) (io.ReadCloser, error) {
+	// Prepare request.
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request url %q: %w", apiURL, err)
+	}
+
+	// Execute HTTP request.
+	if err := limiter.Wait(ctx, weight); err != nil {
+		return nil, fmt.Errorf("Limiter.Wait: %w", err)
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get %q: %w", apiURL, err)
+	}
+	if err := limiter.Observe(rsp); err != nil {
+		rsp.Body.Close()
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		defer rsp.Body.Close()
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("http status code(%d) status(%q)", rsp.StatusCode, rsp.Status)
+		}
+		return nil, fmt.Errorf("http status code(%d) status(%q) body(%q)", rsp.StatusCode, rsp.Status, body)
+	}
+	return rsp.Body, nil
+}
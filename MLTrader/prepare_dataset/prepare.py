@@ -3,6 +3,7 @@ import numpy as np
 
 import dataclasses
 import datetime
+import os
 
 
 SPLIT_PER_DAY: int = 23
@@ -51,17 +52,31 @@ def sliding_window_sample_datapoints(df: pd.DataFrame) -> Dataset:
     dataset: Dataset = empty_dataset()
     for start_idx in range(0, len(df) - BAR_PER_DATAPOINT + 1, 3):
         data_point = df.iloc[start_idx:start_idx+BAR_PER_DATAPOINT]
+        if "Synthetic" in data_point and data_point["Synthetic"].any():
+            continue  # Filled in for an exchange outage, not real prices.
         input_data = extract_open_close_high_low(data_point.iloc[:INPUT_BAR_PER_DATAPOINT])
         output_data = extract_open_close_high_low(data_point.iloc[INPUT_BAR_PER_DATAPOINT:])
         dataset = dataset + Dataset(X=input_data, Y=output_data)
     return dataset
 
-def split_train_validation(csv_path: str) -> tuple[Dataset, Dataset]:
-    df = pd.read_csv(csv_path)
-
+def load_klines(path: str) -> pd.DataFrame:
+    if path.endswith(".parquet"):
+        # Written by "price_data_main convert" with UTC timestamp columns. Parquet
+        # files written back for the Go side need to_parquet(compression=None or
+        # "gzip", use_dictionary=False), the only layout BinanceAPI/storage reads.
+        df = pd.read_parquet(path)
+        df["OpenTime"] = df["OpenTime"].dt.tz_localize(None)
+        df["CloseTime"] = df["CloseTime"].dt.tz_localize(None)
+        return df
+    df = pd.read_csv(path)
     # Convert timestamps to datetime objects
     df["OpenTime"] = pd.to_datetime(df["OpenTime"], unit="ms")
     df["CloseTime"] = pd.to_datetime(df["CloseTime"], unit="ms")
+    return df
+
+
+def split_train_validation(kline_path: str) -> tuple[Dataset, Dataset]:
+    df = load_klines(kline_path)
 
     training_data: Dataset = empty_dataset()
     validation_data: Dataset = empty_dataset()
@@ -95,8 +110,10 @@ def split_train_validation(csv_path: str) -> tuple[Dataset, Dataset]:
 
 if __name__ == "__main__":
     symbol = "SOLUSDT"
-    csv_path = f"../../price_data/{symbol}/{symbol}_5m.csv"
-    training_data, validation_data = split_train_validation(csv_path)
+    kline_path = f"../../price_data/{symbol}/{symbol}_5m.parquet"
+    if not os.path.exists(kline_path):
+        kline_path = f"../../price_data/{symbol}/{symbol}_5m.csv"
+    training_data, validation_data = split_train_validation(kline_path)
 
     print("Data preparation complete. Files saved as .npy files.")
     print(np.shape(training_data.X))